@@ -0,0 +1,168 @@
+package codec
+
+import (
+	"github.com/pkg/errors"
+)
+
+/*
+
+AAC AudioSpecificConfig
+
+依次为 audioObjectType(5位，31时再读6位) samplingFrequencyIndex(4位，15时为24位采样率) channelConfiguration(4位)
+HE-AAC 显式信令时对象类型为5(SBR)或29(PS)，之后为扩展采样率与核心对象类型
+隐式信令时 GASpecificConfig 之后为同步扩展 0x2b7，其中有SBR与PS标志
+
+*/
+
+// AAC 对象类型
+const (
+	AACObjectMain = uint8(1)
+	AACObjectLC   = uint8(2)
+	AACObjectSBR  = uint8(5)  // HE-AAC
+	AACObjectPS   = uint8(29) // HE-AAC v2
+)
+
+// aacSampleRates 采样率序号对应的采样率
+var aacSampleRates = []int{96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350}
+
+// AACConfig 解析后的AudioSpecificConfig
+type AACConfig struct {
+	ObjectType          uint8 // 核心对象类型，HE-AAC 为 AAC LC
+	SignaledObjectType  uint8 // 配置中的第一个对象类型，用于RFC 6381的编码名称
+	SampleRateIndex     uint8 // 核心采样率序号，显式采样率时为15
+	SampleRate          int   // 核心采样率
+	Channels            uint8 // 声道配置，0 为由PCE指定
+	SBR                 bool  // 是否有频带复制
+	PS                  bool  // 是否有参数立体声
+	ExtensionSampleRate int   // SBR 的输出采样率，没有SBR时为0
+}
+
+// ParseAACConfig 解析AudioSpecificConfig
+func ParseAACConfig(data []byte) (*AACConfig, error) {
+	r := &bitReader{data: data}
+	config := &AACConfig{}
+	config.ObjectType = readAACObjectType(r)
+	config.SignaledObjectType = config.ObjectType
+	config.SampleRateIndex, config.SampleRate = readAACSampleRate(r)
+	config.Channels = uint8(r.bits(4))
+	if r.err != nil {
+		return nil, errors.New("AAC config too short")
+	}
+	if config.SampleRate == 0 {
+		return nil, errors.Errorf("AAC sample rate index %d invalid", config.SampleRateIndex)
+	}
+
+	if config.ObjectType == AACObjectSBR || config.ObjectType == AACObjectPS {
+		// 显式信令
+		config.SBR = true
+		config.PS = config.ObjectType == AACObjectPS
+		_, config.ExtensionSampleRate = readAACSampleRate(r)
+		config.ObjectType = readAACObjectType(r)
+		if r.err != nil {
+			return nil, errors.New("AAC config too short")
+		}
+		return config, nil
+	}
+
+	// GASpecificConfig，声道配置为0时有PCE，不再查找同步扩展
+	if config.Channels == 0 || !gaObjectType(config.ObjectType) {
+		return config, nil
+	}
+	r.skip(1) // frameLengthFlag
+	if r.flag() {
+		r.skip(14) // coreCoderDelay
+	}
+	r.skip(1) // extensionFlag
+	if r.err != nil || len(data)*8-r.pos < 16 || r.bits(11) != 0x2b7 {
+		return config, nil
+	}
+	if readAACObjectType(r) != AACObjectSBR || !r.flag() {
+		return config, nil
+	}
+	config.SBR = true
+	_, config.ExtensionSampleRate = readAACSampleRate(r)
+	if len(data)*8-r.pos >= 12 && r.bits(11) == 0x548 {
+		config.PS = r.flag()
+	}
+	if r.err != nil {
+		config.PS = false
+	}
+	return config, nil
+}
+
+// gaObjectType 使用GASpecificConfig的对象类型
+func gaObjectType(objectType uint8) bool {
+	switch objectType {
+	case 1, 2, 3, 4, 6, 7, 17, 19, 20, 21, 22, 23:
+		return true
+	}
+	return false
+}
+
+// readAACObjectType 读入对象类型
+func readAACObjectType(r *bitReader) uint8 {
+	objectType := uint8(r.bits(5))
+	if objectType == 31 {
+		objectType = 32 + uint8(r.bits(6))
+	}
+	return objectType
+}
+
+// readAACSampleRate 读入采样率序号与采样率，序号无效时采样率为0
+func readAACSampleRate(r *bitReader) (uint8, int) {
+	index := uint8(r.bits(4))
+	if index == 0x0f {
+		return index, int(r.bits(24))
+	}
+	if int(index) >= len(aacSampleRates) {
+		return index, 0
+	}
+	return index, aacSampleRates[index]
+}
+
+// OutputSampleRate 解码后的采样率，有SBR时为扩展采样率
+func (config *AACConfig) OutputSampleRate() int {
+	if !config.SBR {
+		return config.SampleRate
+	}
+	if config.ExtensionSampleRate > 0 {
+		return config.ExtensionSampleRate
+	}
+	return config.SampleRate * 2
+}
+
+// OutputChannels 解码后的声道数，有参数立体声时为2，声道配置7为7.1声道
+func (config *AACConfig) OutputChannels() int {
+	switch {
+	case config.PS:
+		return 2
+	case config.Channels == 7:
+		return 8
+	}
+	return int(config.Channels)
+}
+
+// ADTS 是否可以使用ADTS头，ADTS只能表示采样率序号
+func (config *AACConfig) ADTS() bool {
+	return int(config.SampleRateIndex) < len(aacSampleRates)
+}
+
+// AppendADTS 为一个AAC原始帧增加ADTS头后追加到buf，HE-AAC 使用核心对象类型与采样率
+func (config *AACConfig) AppendADTS(buf []byte, data []byte) []byte {
+	length := 7 + len(data)
+	profile := config.ObjectType - 1
+	if config.ObjectType == 0 || config.ObjectType > 4 {
+		// ADTS只能表示前四种对象类型，其他类型以AAC LC表示
+		profile = 1
+	}
+	buf = append(buf,
+		0xff,
+		0xf1,
+		profile<<6|config.SampleRateIndex<<2|config.Channels>>2&0x01,
+		config.Channels&0x03<<6|byte(length>>11)&0x03,
+		byte(length>>3),
+		byte(length&0x07)<<5|0x1f,
+		0xfc,
+	)
+	return append(buf, data...)
+}
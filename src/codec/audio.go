@@ -0,0 +1,119 @@
+package codec
+
+import (
+	"fmt"
+
+	"../flv"
+	"github.com/pkg/errors"
+)
+
+/*
+
+音频信息
+
+AAC 与 Opus 从序列头的解码配置中取得，MP3 从每个音频帧的帧头中取得
+其他传统编码使用FLV标签头中的采样率、位数与声道，AAC 标签头中的值是固定的，不能使用
+
+*/
+
+// AudioInfo 音频编码信息
+type AudioInfo struct {
+	Codec      string // Enhanced RTMP 与 AAC MP3 为 FourCC，其他传统编码为格式名称
+	SampleRate int    // 解码后的采样率，未知时为0
+	Channels   int    // 解码后的声道数，未知时为0
+	SampleSize int    // PCM等传统编码的采样位数，压缩编码为0
+	ObjectType uint8  // AAC 的核心对象类型
+	SBR        bool   // AAC 是否有频带复制
+	PS         bool   // AAC 是否有参数立体声
+	Bitrate    int    // MP3 的比特率，单位为kbps
+}
+
+// ParseAudioInfo 从音频序列头或帧中解析音频信息，标签中没有音频信息时返回nil
+func ParseAudioInfo(tag *flv.AudioTag) (*AudioInfo, error) {
+	switch tag.FourCC {
+	case flv.FourCCAAC:
+		if !tag.SequenceHeader() {
+			return nil, nil
+		}
+		config, err := ParseAACConfig(tag.Body)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return &AudioInfo{
+			Codec:      tag.FourCC,
+			SampleRate: config.OutputSampleRate(),
+			Channels:   config.OutputChannels(),
+			ObjectType: config.ObjectType,
+			SBR:        config.SBR,
+			PS:         config.PS,
+		}, nil
+	case flv.FourCCMP3:
+		if !tag.Frame() {
+			return nil, nil
+		}
+		header, err := ParseMP3Header(tag.Body)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return &AudioInfo{
+			Codec:      tag.FourCC,
+			SampleRate: header.SampleRate,
+			Channels:   header.Channels,
+			Bitrate:    header.Bitrate,
+		}, nil
+	case flv.FourCCOpus:
+		if !tag.SequenceHeader() {
+			return nil, nil
+		}
+		config, err := ParseOpusConfig(tag.Body)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return &AudioInfo{
+			Codec:      tag.FourCC,
+			SampleRate: OpusSampleRate,
+			Channels:   int(config.Channels),
+		}, nil
+	}
+	if tag.Enhanced {
+		if !tag.SequenceHeader() {
+			return nil, nil
+		}
+		// FLAC AC-3 等只记录编码
+		return &AudioInfo{Codec: tag.FourCC}, nil
+	}
+	return &AudioInfo{
+		Codec:      tag.FormatName(),
+		SampleRate: tag.SampleRate(),
+		Channels:   tag.Channels(),
+		SampleSize: tag.SampleSize(),
+	}, nil
+}
+
+// String 如 mp4a HE-AAC 44100Hz 2ch、.mp3 128kbps 44100Hz 2ch、pcm 44100Hz 16bit 2ch
+func (info AudioInfo) String() string {
+	s := info.Codec
+	switch {
+	case info.PS:
+		s += " HE-AACv2"
+	case info.SBR:
+		s += " HE-AAC"
+	case info.ObjectType == AACObjectLC:
+		s += " AAC-LC"
+	case info.ObjectType != 0:
+		s += fmt.Sprintf(" AOT %d", info.ObjectType)
+	}
+	if info.Bitrate > 0 {
+		s += fmt.Sprintf(" %dkbps", info.Bitrate)
+	}
+	if info.SampleRate > 0 {
+		s += fmt.Sprintf(" %dHz", info.SampleRate)
+	}
+	if info.SampleSize > 0 {
+		s += fmt.Sprintf(" %dbit", info.SampleSize)
+	}
+	if info.Channels > 0 {
+		s += fmt.Sprintf(" %dch", info.Channels)
+	}
+	return s
+}
@@ -0,0 +1,79 @@
+package codec
+
+import (
+	"testing"
+)
+
+// TestParseAACConfig 测试AudioSpecificConfig的解析
+func TestParseAACConfig(t *testing.T) {
+	var tests = []struct {
+		in       []byte    // input
+		expected AACConfig // expected result
+	}{
+		// AAC LC 44.1kHz 立体声
+		{[]byte{0x12, 0x10}, AACConfig{2, 2, 4, 44100, 2, false, false, 0}},
+		// AAC LC 8kHz 单声道
+		{[]byte{0x15, 0x88}, AACConfig{2, 2, 11, 8000, 1, false, false, 0}},
+		// 显式信令的 HE-AAC，核心为22.05kHz
+		{[]byte{0x2b, 0x92, 0x08, 0x00}, AACConfig{2, 5, 7, 22050, 2, true, false, 44100}},
+		// 隐式信令的 HE-AAC v2，核心为11.025kHz单声道
+		{[]byte{0x15, 0x08, 0x56, 0xe5, 0xbd, 0x48, 0x80}, AACConfig{2, 2, 10, 11025, 1, true, true, 22050}},
+	}
+
+	for _, test := range tests {
+		actual, err := ParseAACConfig(test.in)
+		if err != nil || *actual != test.expected {
+			t.Errorf("[×] in: %x out: %v %v expected: %v\n", test.in, actual, err, test.expected)
+		} else {
+			t.Logf("[√] in: %x out: %v expected: %v\n", test.in, *actual, test.expected)
+		}
+	}
+}
+
+// TestParseMP3Header 测试MPEG音频帧头的解析
+func TestParseMP3Header(t *testing.T) {
+	var tests = []struct {
+		in       []byte    // input
+		expected MP3Header // expected result
+	}{
+		// MPEG-1 层3 128kbps 44.1kHz 立体声
+		{[]byte{0xff, 0xfb, 0x90, 0x64}, MP3Header{MPEGVersion1, 3, 128, 44100, 2, false, 417}},
+		// MPEG-2 层3 8kbps 16kHz 单声道
+		{[]byte{0xff, 0xf3, 0x18, 0xc4}, MP3Header{MPEGVersion2, 3, 8, 16000, 1, false, 36}},
+		// 不是帧头
+		{[]byte{0x49, 0x44, 0x33, 0x03}, MP3Header{}},
+	}
+
+	for _, test := range tests {
+		actual, err := ParseMP3Header(test.in)
+		ok := err != nil && test.expected == MP3Header{}
+		if err == nil {
+			ok = *actual == test.expected
+		}
+		if !ok {
+			t.Errorf("[×] in: %x out: %v %v expected: %v\n", test.in, actual, err, test.expected)
+		} else {
+			t.Logf("[√] in: %x out: %v expected: %v\n", test.in, actual, test.expected)
+		}
+	}
+}
+
+// TestParseOpusConfig 测试OpusHead与dOps的解析
+func TestParseOpusConfig(t *testing.T) {
+	var tests = []struct {
+		in       []byte     // input
+		expected OpusConfig // expected result
+	}{
+		{[]byte{'O', 'p', 'u', 's', 'H', 'e', 'a', 'd', 1, 2, 0x38, 0x01, 0x80, 0xbb, 0, 0, 0, 0, 0}, OpusConfig{2, 312, 48000, 0, 0}},
+		{[]byte{0, 1, 0x01, 0x38, 0, 0, 0x3e, 0x80, 0, 0, 0}, OpusConfig{1, 312, 16000, 0, 0}},
+	}
+
+	for _, test := range tests {
+		actual, err := ParseOpusConfig(test.in)
+		if err != nil || *actual != test.expected {
+			t.Errorf("[×] in: %x out: %v %v expected: %v\n", test.in, actual, err, test.expected)
+		} else {
+			t.Logf("[√] in: %x out: %v expected: %v\n", test.in, *actual, test.expected)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package codec
+
+import (
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+)
+
+/*
+
+H.264 解码配置记录与参数集
+
+AVCDecoderConfigurationRecord 中有 profile level 与长度前缀的SPS PPS
+SPS 中解析分辨率、色度格式、位深与VUI中的帧率，PPS 中解析ID与熵编码方式
+
+*/
+
+// AVCConfig 解析后的AVCDecoderConfigurationRecord
+type AVCConfig struct {
+	Profile       uint8
+	Compatibility uint8 // profile_compatibility，即SPS中的约束标志
+	Level         uint8
+	LengthSize    int      // NALU长度字段的字节数
+	SPS           [][]byte // 不包含长度
+	PPS           [][]byte
+}
+
+// SPS 解析后的序列参数集
+type SPS struct {
+	ID           uint32
+	Profile      uint8
+	Level        uint8
+	ChromaFormat uint8   // 0 单色 1 4:2:0 2 4:2:2 3 4:4:4
+	BitDepth     uint8   // 亮度位深
+	Width        int     // 已去除裁剪区域
+	Height       int     // 已去除裁剪区域
+	FrameRate    float64 // VUI中的帧率，编码器没有写入时为0
+}
+
+// PPS 解析后的图像参数集
+type PPS struct {
+	ID    uint32
+	SPSID uint32
+	CABAC bool // H.264 是否使用CABAC熵编码
+}
+
+// ParseAVCConfig 解析AVCDecoderConfigurationRecord，参数集引用data
+func ParseAVCConfig(data []byte) (*AVCConfig, error) {
+	if len(data) < 6 {
+		return nil, errors.New("AVC config too short")
+	}
+	config := &AVCConfig{
+		Profile:       data[1],
+		Compatibility: data[2],
+		Level:         data[3],
+		LengthSize:    int(data[4]&0x03) + 1,
+	}
+
+	count := int(data[5] & 0x1f)
+	data = data[6:]
+	for i := 0; i < count; i++ {
+		var nalu []byte
+		var err error
+		nalu, data, err = readParameterSet(data)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		config.SPS = append(config.SPS, nalu)
+	}
+
+	if len(data) < 1 {
+		return nil, errors.New("AVC config too short")
+	}
+	count = int(data[0])
+	data = data[1:]
+	for i := 0; i < count; i++ {
+		var nalu []byte
+		var err error
+		nalu, data, err = readParameterSet(data)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		config.PPS = append(config.PPS, nalu)
+	}
+	return config, nil
+}
+
+// ParameterSets 关键帧前需要的参数集，依次为SPS PPS
+func (config *AVCConfig) ParameterSets() [][]byte {
+	params := make([][]byte, 0, len(config.SPS)+len(config.PPS))
+	params = append(params, config.SPS...)
+	return append(params, config.PPS...)
+}
+
+// readParameterSet 读入一个16位长度前缀的参数集，返回参数集与剩余数据
+func readParameterSet(data []byte) ([]byte, []byte, error) {
+	if len(data) < 2 {
+		return nil, nil, errors.New("video config too short")
+	}
+	length := int(binary.BigEndian.Uint16(data))
+	if len(data) < 2+length {
+		return nil, nil, errors.New("video config too short")
+	}
+	return data[2 : 2+length], data[2+length:], nil
+}
+
+// avcHighProfiles SPS中有色度格式与位深的profile
+var avcHighProfiles = map[uint32]bool{100: true, 110: true, 122: true, 244: true, 44: true, 83: true, 86: true, 118: true, 128: true, 138: true, 139: true, 134: true, 135: true}
+
+// ParseAVCSPS 解析H.264 SPS，nalu包含NALU头
+func ParseAVCSPS(nalu []byte) (*SPS, error) {
+	r := &bitReader{data: unescapeRBSP(nalu)}
+	r.skip(8) // NALU头
+	profile := r.bits(8)
+	r.skip(8) // constraint_set_flags
+	sps := &SPS{
+		Profile:      uint8(profile),
+		Level:        uint8(r.bits(8)),
+		ID:           r.ue(),
+		ChromaFormat: 1,
+		BitDepth:     8,
+	}
+
+	if avcHighProfiles[profile] {
+		sps.ChromaFormat = uint8(r.ue())
+		if sps.ChromaFormat == 3 {
+			r.skip(1) // separate_colour_plane_flag
+		}
+		sps.BitDepth = uint8(r.ue() + 8)
+		r.ue()    // bit_depth_chroma_minus8
+		r.skip(1) // qpprime_y_zero_transform_bypass_flag
+		if r.flag() {
+			count := 8
+			if sps.ChromaFormat == 3 {
+				count = 12
+			}
+			for i := 0; i < count; i++ {
+				if r.flag() {
+					size := 16
+					if i >= 6 {
+						size = 64
+					}
+					r.skipScalingList(size)
+				}
+			}
+		}
+	}
+
+	r.ue() // log2_max_frame_num_minus4
+	switch r.ue() {
+	case 0:
+		r.ue() // log2_max_pic_order_cnt_lsb_minus4
+	case 1:
+		r.skip(1) // delta_pic_order_always_zero_flag
+		r.se()    // offset_for_non_ref_pic
+		r.se()    // offset_for_top_to_bottom_field
+		count := r.ue()
+		for i := uint32(0); i < count && r.err == nil; i++ {
+			r.se()
+		}
+	}
+	r.ue()    // max_num_ref_frames
+	r.skip(1) // gaps_in_frame_num_value_allowed_flag
+	widthInMbs := int(r.ue()) + 1
+	heightInMapUnits := int(r.ue()) + 1
+	frameMbsOnly := int(r.bits(1))
+	if frameMbsOnly == 0 {
+		r.skip(1) // mb_adaptive_frame_field_flag
+	}
+	r.skip(1) // direct_8x8_inference_flag
+
+	sps.Width = widthInMbs * 16
+	sps.Height = (2 - frameMbsOnly) * heightInMapUnits * 16
+	if r.flag() {
+		left, right, top, bottom := int(r.ue()), int(r.ue()), int(r.ue()), int(r.ue())
+		cropX, cropY := 1, 2-frameMbsOnly
+		if sps.ChromaFormat == 1 || sps.ChromaFormat == 2 {
+			cropX = 2
+		}
+		if sps.ChromaFormat == 1 {
+			cropY *= 2
+		}
+		sps.Width -= (left + right) * cropX
+		sps.Height -= (top + bottom) * cropY
+	}
+	if r.err != nil {
+		return nil, errors.WithStack(r.err)
+	}
+
+	// VUI 在分辨率之后，解析失败不影响分辨率
+	if r.flag() {
+		sps.FrameRate = parseAVCVUIFrameRate(r)
+	}
+	return sps, nil
+}
+
+// parseAVCVUIFrameRate 解析H.264 VUI中的帧率，每帧为两个场
+func parseAVCVUIFrameRate(r *bitReader) float64 {
+	skipVUIHeader(r)
+	if !r.flag() { // timing_info_present_flag
+		return 0
+	}
+	unitsInTick := r.bits(32)
+	timeScale := r.bits(32)
+	if r.err != nil || unitsInTick == 0 {
+		return 0
+	}
+	return float64(timeScale) / (2 * float64(unitsInTick))
+}
+
+// skipVUIHeader 跳过H.264与H.265 VUI中相同的宽高比、过扫描、视频信号类型与色度位置信息
+func skipVUIHeader(r *bitReader) {
+	if r.flag() { // aspect_ratio_info_present_flag
+		if r.bits(8) == 255 { // Extended_SAR
+			r.skip(32)
+		}
+	}
+	if r.flag() { // overscan_info_present_flag
+		r.skip(1)
+	}
+	if r.flag() { // video_signal_type_present_flag
+		r.skip(4)
+		if r.flag() { // colour_description_present_flag
+			r.skip(24)
+		}
+	}
+	if r.flag() { // chroma_loc_info_present_flag
+		r.ue()
+		r.ue()
+	}
+}
+
+// ParseAVCPPS 解析H.264 PPS，nalu包含NALU头
+func ParseAVCPPS(nalu []byte) (*PPS, error) {
+	r := &bitReader{data: unescapeRBSP(nalu)}
+	r.skip(8) // NALU头
+	pps := &PPS{
+		ID:    r.ue(),
+		SPSID: r.ue(),
+		CABAC: r.flag(),
+	}
+	if r.err != nil {
+		return nil, errors.WithStack(r.err)
+	}
+	return pps, nil
+}
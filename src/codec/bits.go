@@ -0,0 +1,97 @@
+package codec
+
+import (
+	"github.com/pkg/errors"
+)
+
+/*
+
+按位读取去除防竞争字节后的RBSP
+
+*/
+
+// unescapeRBSP 去除NALU中的防竞争字节
+func unescapeRBSP(data []byte) []byte {
+	result := make([]byte, 0, len(data))
+	zeros := 0
+	for _, b := range data {
+		if zeros >= 2 && b == 0x03 {
+			zeros = 0
+			continue
+		}
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		result = append(result, b)
+	}
+	return result
+}
+
+// bitReader 按位读取，读取越界后记录错误并返回0
+type bitReader struct {
+	data []byte
+	pos  int // 已读取的位数
+	err  error
+}
+
+// bits 读取n位无符号整数
+func (r *bitReader) bits(n int) uint32 {
+	var v uint32
+	for i := 0; i < n; i++ {
+		if r.pos >= len(r.data)*8 {
+			r.err = errors.New("RBSP truncated")
+			return 0
+		}
+		bit := r.data[r.pos/8] >> uint(7-r.pos%8) & 0x01
+		v = v<<1 | uint32(bit)
+		r.pos++
+	}
+	return v
+}
+
+// flag 读取1位标志
+func (r *bitReader) flag() bool {
+	return r.bits(1) == 1
+}
+
+// skip 跳过n位
+func (r *bitReader) skip(n int) {
+	r.bits(n)
+}
+
+// ue 读取无符号指数哥伦布编码
+func (r *bitReader) ue() uint32 {
+	zeros := 0
+	for r.bits(1) == 0 {
+		if r.err != nil || zeros >= 31 {
+			r.err = errors.New("RBSP invalid exp-golomb code")
+			return 0
+		}
+		zeros++
+	}
+	return 1<<uint(zeros) - 1 + r.bits(zeros)
+}
+
+// se 读取有符号指数哥伦布编码
+func (r *bitReader) se() int32 {
+	v := r.ue()
+	if v&0x01 == 1 {
+		return int32(v+1) / 2
+	}
+	return -int32(v / 2)
+}
+
+// skipScalingList 跳过H.264的缩放矩阵
+func (r *bitReader) skipScalingList(size int) {
+	last, next := int32(8), int32(8)
+	for i := 0; i < size && r.err == nil; i++ {
+		if next != 0 {
+			next = (last + r.se() + 256) % 256
+		}
+		if next != 0 {
+			last = next
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package codec
+
+import (
+	"testing"
+)
+
+// TestParseAVCSPS 测试从H.264 SPS中解析分辨率与编码信息
+func TestParseAVCSPS(t *testing.T) {
+	var tests = []struct {
+		in       []byte // input
+		expected SPS    // expected result
+	}{
+		// Baseline 1280x720
+		{[]byte{0x67, 0x42, 0x00, 0x1f, 0xe5, 0x40, 0x28, 0x02, 0xdc, 0x80}, SPS{0, 66, 31, 1, 8, 1280, 720, 0}},
+		// High 1920x1088 裁剪为 1920x1080
+		{[]byte{0x67, 0x64, 0x00, 0x1f, 0xac, 0xca, 0x80, 0x78, 0x02, 0x27, 0xe5, 0x40}, SPS{0, 100, 31, 1, 8, 1920, 1080, 0}},
+	}
+
+	for _, test := range tests {
+		actual, err := ParseAVCSPS(test.in)
+		if err != nil || *actual != test.expected {
+			t.Errorf("[×] in: %x out: %v %v expected: %v\n", test.in, actual, err, test.expected)
+		} else {
+			t.Logf("[√] in: %x out: %v expected: %v\n", test.in, *actual, test.expected)
+		}
+	}
+}
+
+// TestParseHEVCSPS 测试从H.265 SPS中解析分辨率与编码信息
+func TestParseHEVCSPS(t *testing.T) {
+	var tests = []struct {
+		in       []byte // input
+		expected SPS    // expected result
+	}{
+		// Main 1920x1088 裁剪为 1920x1080，VUI中为30帧
+		{[]byte{0x42, 0x01, 0x01, 0x01, 0x60, 0x00, 0x00, 0x03, 0x00, 0x90, 0x00, 0x00, 0x03, 0x00, 0x00, 0x03, 0x00, 0x78, 0xa0, 0x03, 0xc0, 0x80, 0x10, 0xe5, 0x96, 0x56, 0x69, 0x24, 0xca, 0xe0, 0x10, 0x00, 0x00, 0x03, 0x00, 0x10, 0x00, 0x00, 0x03, 0x01, 0xe0, 0x80}, SPS{0, 1, 120, 1, 8, 1920, 1080, 30}},
+	}
+
+	for _, test := range tests {
+		actual, err := ParseHEVCSPS(test.in)
+		if err != nil || *actual != test.expected {
+			t.Errorf("[×] in: %x out: %v %v expected: %v\n", test.in, actual, err, test.expected)
+		} else {
+			t.Logf("[√] in: %x out: %v expected: %v\n", test.in, *actual, test.expected)
+		}
+	}
+}
+
+// TestParseFrame 测试遍历视频帧中的NALU
+func TestParseFrame(t *testing.T) {
+	type arg struct {
+		codec string
+		data  []byte
+	}
+	type result struct {
+		idr    bool
+		sei    bool
+		params int
+	}
+	var tests = []struct {
+		in       arg    // input
+		expected result // expected result
+	}{
+		{arg{"avc1", []byte{0, 0, 0, 2, 0x09, 0xf0, 0, 0, 0, 2, 0x41, 0x9a}}, result{false, false, 0}},
+		{arg{"avc1", []byte{0, 0, 0, 2, 0x06, 0x05, 0, 0, 0, 2, 0x67, 0x42, 0, 0, 0, 2, 0x68, 0xce, 0, 0, 0, 2, 0x65, 0x88}}, result{true, true, 2}},
+		{arg{"hvc1", []byte{0, 0, 0, 3, 0x40, 0x01, 0x0c, 0, 0, 0, 3, 0x4e, 0x01, 0x05, 0, 0, 0, 3, 0x26, 0x01, 0xaf}}, result{true, true, 1}},
+		{arg{"hvc1", []byte{0, 0, 0, 3, 0x2a, 0x01, 0xaf}}, result{false, false, 0}},
+	}
+
+	for _, test := range tests {
+		info, err := ParseFrame(test.in.codec, test.in.data, 4)
+		actual := result{info.IDR, info.SEI, len(info.ParameterSets)}
+		if err != nil || actual != test.expected {
+			t.Errorf("[×] in: %v out: %v %v expected: %v\n", test.in, actual, err, test.expected)
+		} else {
+			t.Logf("[√] in: %v out: %v expected: %v\n", test.in, actual, test.expected)
+		}
+	}
+}
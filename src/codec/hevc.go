@@ -0,0 +1,272 @@
+package codec
+
+import (
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+)
+
+/*
+
+H.265 解码配置记录与参数集
+
+HEVCDecoderConfigurationRecord 中有 profile tier level、色度格式、位深、平均帧率与按类型分组的参数集
+SPS 中解析分辨率、色度格式、位深与VUI中的帧率，需要跳过短期参考图像集等变长结构
+
+*/
+
+// HEVCConfig 解析后的HEVCDecoderConfigurationRecord
+type HEVCConfig struct {
+	ProfileSpace uint8
+	Tier         uint8 // 0 Main 1 High
+	Profile      uint8
+	Level        uint8
+	ChromaFormat uint8
+	BitDepth     uint8    // 亮度位深
+	FrameRate    float64  // avgFrameRate，编码器没有写入时为0
+	LengthSize   int      // NALU长度字段的字节数
+	VPS          [][]byte // 不包含长度
+	SPS          [][]byte
+	PPS          [][]byte
+}
+
+// ParseHEVCConfig 解析HEVCDecoderConfigurationRecord，参数集引用data
+func ParseHEVCConfig(data []byte) (*HEVCConfig, error) {
+	if len(data) < 23 {
+		return nil, errors.New("HEVC config too short")
+	}
+	config := &HEVCConfig{
+		ProfileSpace: data[1] >> 6,
+		Tier:         data[1] >> 5 & 0x01,
+		Profile:      data[1] & 0x1f,
+		Level:        data[12],
+		ChromaFormat: data[16] & 0x03,
+		BitDepth:     data[17]&0x07 + 8,
+		FrameRate:    float64(binary.BigEndian.Uint16(data[19:21])) / 256,
+		LengthSize:   int(data[21]&0x03) + 1,
+	}
+
+	arrays := int(data[22])
+	data = data[23:]
+	for i := 0; i < arrays; i++ {
+		if len(data) < 3 {
+			return nil, errors.New("HEVC config too short")
+		}
+		naluType := data[0] & 0x3f
+		count := int(binary.BigEndian.Uint16(data[1:3]))
+		data = data[3:]
+		for j := 0; j < count; j++ {
+			var nalu []byte
+			var err error
+			nalu, data, err = readParameterSet(data)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			switch naluType {
+			case HEVCNALVPS:
+				config.VPS = append(config.VPS, nalu)
+			case HEVCNALSPS:
+				config.SPS = append(config.SPS, nalu)
+			case HEVCNALPPS:
+				config.PPS = append(config.PPS, nalu)
+			}
+		}
+	}
+	return config, nil
+}
+
+// ParameterSets 关键帧前需要的参数集，依次为VPS SPS PPS
+func (config *HEVCConfig) ParameterSets() [][]byte {
+	params := make([][]byte, 0, len(config.VPS)+len(config.SPS)+len(config.PPS))
+	params = append(params, config.VPS...)
+	params = append(params, config.SPS...)
+	return append(params, config.PPS...)
+}
+
+// ParseHEVCSPS 解析H.265 SPS，nalu包含NALU头
+func ParseHEVCSPS(nalu []byte) (*SPS, error) {
+	r := &bitReader{data: unescapeRBSP(nalu)}
+	r.skip(16) // NALU头
+	r.skip(4)  // sps_video_parameter_set_id
+	maxSubLayersMinus1 := int(r.bits(3))
+	r.skip(1) // sps_temporal_id_nesting_flag
+
+	// profile_tier_level
+	r.skip(3) // general_profile_space general_tier_flag
+	sps := &SPS{Profile: uint8(r.bits(5))}
+	r.skip(80) // general_profile_compatibility_flags ... general_inbld_flag
+	sps.Level = uint8(r.bits(8))
+	profilePresent := make([]bool, maxSubLayersMinus1)
+	levelPresent := make([]bool, maxSubLayersMinus1)
+	for i := 0; i < maxSubLayersMinus1; i++ {
+		profilePresent[i] = r.flag()
+		levelPresent[i] = r.flag()
+	}
+	if maxSubLayersMinus1 > 0 {
+		r.skip(2 * (8 - maxSubLayersMinus1)) // reserved_zero_2bits
+	}
+	for i := 0; i < maxSubLayersMinus1; i++ {
+		if profilePresent[i] {
+			r.skip(88)
+		}
+		if levelPresent[i] {
+			r.skip(8)
+		}
+	}
+
+	sps.ID = r.ue()
+	sps.ChromaFormat = uint8(r.ue())
+	if sps.ChromaFormat == 3 {
+		r.skip(1) // separate_colour_plane_flag
+	}
+	sps.Width = int(r.ue())
+	sps.Height = int(r.ue())
+	if r.flag() {
+		left, right, top, bottom := int(r.ue()), int(r.ue()), int(r.ue()), int(r.ue())
+		cropX, cropY := 1, 1
+		if sps.ChromaFormat == 1 || sps.ChromaFormat == 2 {
+			cropX = 2
+		}
+		if sps.ChromaFormat == 1 {
+			cropY = 2
+		}
+		sps.Width -= (left + right) * cropX
+		sps.Height -= (top + bottom) * cropY
+	}
+	sps.BitDepth = uint8(r.ue() + 8)
+	if r.err != nil {
+		return nil, errors.WithStack(r.err)
+	}
+
+	// VUI 在分辨率之后，解析失败不影响分辨率
+	sps.FrameRate = parseHEVCVUIFrameRate(r, maxSubLayersMinus1)
+	return sps, nil
+}
+
+// parseHEVCVUIFrameRate 跳过SPS中VUI之前的字段后解析VUI中的帧率
+func parseHEVCVUIFrameRate(r *bitReader, maxSubLayersMinus1 int) float64 {
+	r.ue() // bit_depth_chroma_minus8
+	log2MaxPOCLsb := int(r.ue()) + 4
+	start := maxSubLayersMinus1
+	if r.flag() { // sps_sub_layer_ordering_info_present_flag
+		start = 0
+	}
+	for i := start; i <= maxSubLayersMinus1; i++ {
+		r.ue() // sps_max_dec_pic_buffering_minus1
+		r.ue() // sps_max_num_reorder_pics
+		r.ue() // sps_max_latency_increase_plus1
+	}
+	for i := 0; i < 6; i++ {
+		r.ue() // 编码块与变换块大小、变换层级深度
+	}
+	if r.flag() { // scaling_list_enabled_flag
+		if r.flag() { // sps_scaling_list_data_present_flag
+			skipHEVCScalingListData(r)
+		}
+	}
+	r.skip(2)     // amp_enabled_flag sample_adaptive_offset_enabled_flag
+	if r.flag() { // pcm_enabled_flag
+		r.skip(8)
+		r.ue()
+		r.ue()
+		r.skip(1)
+	}
+	skipHEVCShortTermRefPicSets(r, int(r.ue()))
+	if r.flag() { // long_term_ref_pics_present_flag
+		count := int(r.ue())
+		for i := 0; i < count && r.err == nil; i++ {
+			r.skip(log2MaxPOCLsb + 1) // lt_ref_pic_poc_lsb_sps used_by_curr_pic_lt_sps_flag
+		}
+	}
+	r.skip(2)      // sps_temporal_mvp_enabled_flag strong_intra_smoothing_enabled_flag
+	if !r.flag() { // vui_parameters_present_flag
+		return 0
+	}
+
+	skipVUIHeader(r)
+	r.skip(3)     // neutral_chroma_indication_flag field_seq_flag frame_field_info_present_flag
+	if r.flag() { // default_display_window_flag
+		r.ue()
+		r.ue()
+		r.ue()
+		r.ue()
+	}
+	if !r.flag() { // vui_timing_info_present_flag
+		return 0
+	}
+	unitsInTick := r.bits(32)
+	timeScale := r.bits(32)
+	if r.err != nil || unitsInTick == 0 {
+		return 0
+	}
+	return float64(timeScale) / float64(unitsInTick)
+}
+
+// skipHEVCScalingListData 跳过H.265的缩放矩阵
+func skipHEVCScalingListData(r *bitReader) {
+	for sizeID := 0; sizeID < 4; sizeID++ {
+		step := 1
+		if sizeID == 3 {
+			step = 3
+		}
+		for matrixID := 0; matrixID < 6 && r.err == nil; matrixID += step {
+			if !r.flag() { // scaling_list_pred_mode_flag
+				r.ue() // scaling_list_pred_matrix_id_delta
+				continue
+			}
+			count := 1 << uint(4+sizeID<<1)
+			if count > 64 {
+				count = 64
+			}
+			if sizeID > 1 {
+				r.se() // scaling_list_dc_coef_minus8
+			}
+			for i := 0; i < count && r.err == nil; i++ {
+				r.se()
+			}
+		}
+	}
+}
+
+// skipHEVCShortTermRefPicSets 跳过SPS中的短期参考图像集
+func skipHEVCShortTermRefPicSets(r *bitReader, count int) {
+	deltaPOCs := make([]int, count)
+	for idx := 0; idx < count && r.err == nil; idx++ {
+		if idx != 0 && r.flag() { // inter_ref_pic_set_prediction_flag
+			// SPS中只能参考前一个参考图像集
+			r.skip(1) // delta_rps_sign
+			r.ue()    // abs_delta_rps_minus1
+			for j := 0; j <= deltaPOCs[idx-1]; j++ {
+				used := r.flag()
+				if used || r.flag() { // use_delta_flag
+					deltaPOCs[idx]++
+				}
+			}
+			continue
+		}
+		negative, positive := int(r.ue()), int(r.ue())
+		if negative > 16 || positive > 16 {
+			r.err = errors.New("SPS short-term ref pic set invalid")
+			return
+		}
+		for i := 0; i < negative+positive; i++ {
+			r.ue()    // delta_poc_minus1
+			r.skip(1) // used_by_curr_pic_flag
+		}
+		deltaPOCs[idx] = negative + positive
+	}
+}
+
+// ParseHEVCPPS 解析H.265 PPS，nalu包含NALU头
+func ParseHEVCPPS(nalu []byte) (*PPS, error) {
+	r := &bitReader{data: unescapeRBSP(nalu)}
+	r.skip(16) // NALU头
+	pps := &PPS{
+		ID:    r.ue(),
+		SPSID: r.ue(),
+	}
+	if r.err != nil {
+		return nil, errors.WithStack(r.err)
+	}
+	return pps, nil
+}
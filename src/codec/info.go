@@ -0,0 +1,108 @@
+package codec
+
+import (
+	"fmt"
+
+	"../flv"
+	"github.com/pkg/errors"
+)
+
+/*
+
+视频信息
+
+从解码配置记录的SPS中得到实际的编码信息，不依赖推流端元数据中声明的宽高与帧率
+
+*/
+
+// VideoInfo 视频编码信息
+type VideoInfo struct {
+	Codec string // avc1 hvc1
+	SPS
+}
+
+// avcProfiles H.264 profile名称
+var avcProfiles = map[uint8]string{
+	66:  "Baseline",
+	77:  "Main",
+	88:  "Extended",
+	100: "High",
+	110: "High 10",
+	122: "High 4:2:2",
+	244: "High 4:4:4",
+}
+
+// hevcProfiles H.265 profile名称
+var hevcProfiles = map[uint8]string{
+	1: "Main",
+	2: "Main 10",
+	3: "Main Still Picture",
+	4: "Range Extensions",
+}
+
+// chromaFormats 色度格式名称
+var chromaFormats = map[uint8]string{
+	0: "4:0:0",
+	1: "4:2:0",
+	2: "4:2:2",
+	3: "4:4:4",
+}
+
+// ParseVideoInfo 解析视频序列头中的解码配置记录，fourCC为avc1或hvc1，使用第一个SPS
+func ParseVideoInfo(fourCC string, record []byte) (*VideoInfo, error) {
+	var sps [][]byte
+	parse := ParseAVCSPS
+	switch fourCC {
+	case flv.FourCCAVC:
+		config, err := ParseAVCConfig(record)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		sps = config.SPS
+	case flv.FourCCHEVC:
+		config, err := ParseHEVCConfig(record)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		sps, parse = config.SPS, ParseHEVCSPS
+	default:
+		return nil, errors.Errorf("video codec %s not supported", fourCC)
+	}
+	if len(sps) == 0 {
+		return nil, errors.New("video config has no SPS")
+	}
+	info, err := parse(sps[0])
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &VideoInfo{Codec: fourCC, SPS: *info}, nil
+}
+
+// ProfileName profile名称，未知的profile返回编号
+func (info VideoInfo) ProfileName() string {
+	names := avcProfiles
+	if info.Codec == flv.FourCCHEVC {
+		names = hevcProfiles
+	}
+	if name, ok := names[info.Profile]; ok {
+		return name
+	}
+	return fmt.Sprintf("Profile %d", info.Profile)
+}
+
+// LevelName level名称，H.264的level为10倍，H.265为30倍
+func (info VideoInfo) LevelName() string {
+	if info.Codec == flv.FourCCHEVC {
+		return fmt.Sprintf("%g", float64(info.Level)/30)
+	}
+	return fmt.Sprintf("%g", float64(info.Level)/10)
+}
+
+// String 如 avc1 High@4.1 1920x1080 4:2:0 8bit 30fps
+func (info VideoInfo) String() string {
+	s := fmt.Sprintf("%s %s@%s %dx%d %s %dbit", info.Codec, info.ProfileName(), info.LevelName(), info.Width, info.Height, chromaFormats[info.ChromaFormat], info.BitDepth)
+	if info.FrameRate > 0 {
+		s += fmt.Sprintf(" %.3gfps", info.FrameRate)
+	}
+	return s
+}
@@ -0,0 +1,97 @@
+package codec
+
+import (
+	"github.com/pkg/errors"
+)
+
+/*
+
+MPEG 音频帧头
+
+FLV中的MP3没有序列头，每个音频帧以4字节的帧头开始
+同步字(11位) 版本(2位) 层(2位) 保护位 比特率序号(4位) 采样率序号(2位) 填充位 私有位 声道模式(2位) ...
+
+*/
+
+// MPEG 音频版本，与帧头中的取值一致
+const (
+	MPEGVersion25 = uint8(0) // MPEG 2.5
+	MPEGVersion2  = uint8(2)
+	MPEGVersion1  = uint8(3)
+)
+
+// mpegBitrates 比特率序号对应的比特率，单位为kbps，依次为 MPEG-1 层1 2 3 与 MPEG-2/2.5 层1 层2/3
+var mpegBitrates = [5][15]int{
+	{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+	{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+	{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+	{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+	{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+}
+
+// mpegSampleRates MPEG-1 的采样率，MPEG-2 为一半，MPEG-2.5 为四分之一
+var mpegSampleRates = [3]int{44100, 48000, 32000}
+
+// MP3Header 解析后的MPEG音频帧头
+type MP3Header struct {
+	Version    uint8
+	Layer      int
+	Bitrate    int // kbps，free格式为0
+	SampleRate int
+	Channels   int
+	Padding    bool
+	FrameSize  int // 包含帧头的帧长度，free格式为0
+}
+
+// ParseMP3Header 解析MPEG音频帧头
+func ParseMP3Header(data []byte) (*MP3Header, error) {
+	if len(data) < 4 {
+		return nil, errors.New("MP3 frame too short")
+	}
+	if data[0] != 0xff || data[1]&0xe0 != 0xe0 {
+		return nil, errors.New("MP3 frame sync not found")
+	}
+	header := &MP3Header{
+		Version: data[1] >> 3 & 0x03,
+		Layer:   4 - int(data[1]>>1&0x03),
+		Padding: data[2]>>1&0x01 == 1,
+	}
+	bitrateIndex := int(data[2] >> 4)
+	rateIndex := int(data[2] >> 2 & 0x03)
+	if header.Version == 1 || header.Layer == 4 || bitrateIndex == 0x0f || rateIndex == 3 {
+		return nil, errors.New("MP3 frame header invalid")
+	}
+
+	table := 3
+	if header.Version == MPEGVersion1 {
+		table = header.Layer - 1
+	} else if header.Layer != 1 {
+		table = 4
+	}
+	header.Bitrate = mpegBitrates[table][bitrateIndex]
+	header.SampleRate = mpegSampleRates[rateIndex]
+	switch header.Version {
+	case MPEGVersion2:
+		header.SampleRate /= 2
+	case MPEGVersion25:
+		header.SampleRate /= 4
+	}
+	header.Channels = 2
+	if data[3]>>6 == 3 {
+		header.Channels = 1
+	}
+
+	padding := 0
+	if header.Padding {
+		padding = 1
+	}
+	switch {
+	case header.Layer == 1:
+		header.FrameSize = (12*header.Bitrate*1000/header.SampleRate + padding) * 4
+	case header.Layer == 3 && header.Version != MPEGVersion1:
+		header.FrameSize = 72*header.Bitrate*1000/header.SampleRate + padding
+	default:
+		header.FrameSize = 144*header.Bitrate*1000/header.SampleRate + padding
+	}
+	return header, nil
+}
@@ -0,0 +1,115 @@
+package codec
+
+import (
+	"../flv"
+	"github.com/pkg/errors"
+)
+
+/*
+
+长度前缀的NALU
+
+FLV与MP4中的H.264/H.265视频帧由若干个NALU组成，每个NALU前为解码配置记录中指定字节数的长度
+遍历视频帧中的NALU可以得到IDR、SEI与码流内新发送的参数集
+
+*/
+
+// H.264 NALU类型
+const (
+	AVCNALIDR = uint8(5)
+	AVCNALSEI = uint8(6)
+	AVCNALSPS = uint8(7)
+	AVCNALPPS = uint8(8)
+	AVCNALAUD = uint8(9)
+)
+
+// H.265 NALU类型
+const (
+	HEVCNALIRAPFirst = uint8(16) // BLA IDR CRA 等随机访问点
+	HEVCNALIDRWRADL  = uint8(19)
+	HEVCNALIDRNLP    = uint8(20)
+	HEVCNALIRAPLast  = uint8(23)
+	HEVCNALVPS       = uint8(32)
+	HEVCNALSPS       = uint8(33)
+	HEVCNALPPS       = uint8(34)
+	HEVCNALAUD       = uint8(35)
+	HEVCNALSEIPrefix = uint8(39)
+	HEVCNALSEISuffix = uint8(40)
+)
+
+// AVCNALType H.264 NALU的类型
+func AVCNALType(nalu []byte) uint8 {
+	if len(nalu) < 1 {
+		return 0
+	}
+	return nalu[0] & 0x1f
+}
+
+// HEVCNALType H.265 NALU的类型
+func HEVCNALType(nalu []byte) uint8 {
+	if len(nalu) < 1 {
+		return 0
+	}
+	return nalu[0] >> 1 & 0x3f
+}
+
+// NALUnits 拆分长度前缀的NALU，lengthSize为长度字段的字节数，返回的NALU引用data
+func NALUnits(data []byte, lengthSize int) ([][]byte, error) {
+	if lengthSize < 1 || lengthSize > 4 {
+		return nil, errors.Errorf("NALU length size %d invalid", lengthSize)
+	}
+	var nalus [][]byte
+	for len(data) > 0 {
+		if len(data) < lengthSize {
+			return nalus, errors.New("NALU length truncated")
+		}
+		length := 0
+		for _, b := range data[:lengthSize] {
+			length = length<<8 | int(b)
+		}
+		data = data[lengthSize:]
+		if length > len(data) {
+			return nalus, errors.New("NALU truncated")
+		}
+		if length > 0 {
+			nalus = append(nalus, data[:length])
+		}
+		data = data[length:]
+	}
+	return nalus, nil
+}
+
+// FrameInfo 视频帧中NALU的解析结果
+type FrameInfo struct {
+	IDR           bool     // 是否包含IDR，H.265为IDR_W_RADL或IDR_N_LP
+	SEI           bool     // 是否包含SEI
+	ParameterSets [][]byte // 码流内的参数集，H.264为SPS PPS，H.265为VPS SPS PPS
+}
+
+// ParseFrame 遍历视频帧中的NALU，codec为avc1或hvc1
+func ParseFrame(codec string, data []byte, lengthSize int) (FrameInfo, error) {
+	var info FrameInfo
+	nalus, err := NALUnits(data, lengthSize)
+	for _, nalu := range nalus {
+		if codec == flv.FourCCHEVC {
+			switch naluType := HEVCNALType(nalu); {
+			case naluType == HEVCNALIDRWRADL || naluType == HEVCNALIDRNLP:
+				info.IDR = true
+			case naluType == HEVCNALSEIPrefix || naluType == HEVCNALSEISuffix:
+				info.SEI = true
+			case naluType >= HEVCNALVPS && naluType <= HEVCNALPPS:
+				info.ParameterSets = append(info.ParameterSets, nalu)
+			}
+			continue
+		}
+		switch AVCNALType(nalu) {
+		case AVCNALIDR:
+			info.IDR = true
+		case AVCNALSEI:
+			info.SEI = true
+		case AVCNALSPS, AVCNALPPS:
+			info.ParameterSets = append(info.ParameterSets, nalu)
+		}
+	}
+	return info, errors.WithStack(err)
+}
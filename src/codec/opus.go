@@ -0,0 +1,51 @@
+package codec
+
+import (
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+)
+
+/*
+
+Opus 解码配置
+
+Enhanced RTMP 的 Opus 序列头为 RFC 7845 的 OpusHead 标识头，小端字节序
+部分推流端发送 MP4 中 dOps 的内容，没有 OpusHead 标记，大端字节序
+
+*/
+
+// OpusSampleRate Opus解码后的采样率固定为48kHz
+const OpusSampleRate = 48000
+
+// OpusConfig 解析后的Opus解码配置
+type OpusConfig struct {
+	Channels        uint8
+	PreSkip         uint16
+	InputSampleRate uint32 // 编码前的采样率，只用于展示
+	OutputGain      int16
+	MappingFamily   uint8
+}
+
+// ParseOpusConfig 解析OpusHead或dOps
+func ParseOpusConfig(data []byte) (*OpusConfig, error) {
+	order := binary.ByteOrder(binary.BigEndian)
+	if len(data) >= 8 && string(data[:8]) == "OpusHead" {
+		order = binary.LittleEndian
+		data = data[8:]
+	}
+	if len(data) < 11 {
+		return nil, errors.New("Opus config too short")
+	}
+	config := &OpusConfig{
+		Channels:        data[1],
+		PreSkip:         order.Uint16(data[2:4]),
+		InputSampleRate: order.Uint32(data[4:8]),
+		OutputGain:      int16(order.Uint16(data[8:10])),
+		MappingFamily:   data[10],
+	}
+	if config.Channels == 0 {
+		return nil, errors.New("Opus config has no channel")
+	}
+	return config, nil
+}
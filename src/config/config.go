@@ -1,6 +1,550 @@
 package config
 
-// GetDatabaseData 获取数据库连接信息
-func GetDatabaseData() string {
-	return "USERNAME:PASSWORD@tcp(URL)/DATABASE"
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+/*
+
+服务配置，使用JSON格式的配置文件
+
+*/
+
+// Config 服务配置
+type Config struct {
+	Listeners    []Listener    `json:"listeners"`    // 监听端口列表
+	Applications []Application `json:"applications"` // 应用列表，为空时允许任意应用
+	Auth         Auth          `json:"auth"`         // 全局鉴权
+	Registry     Registry      `json:"registry"`     // 推流登记后端
+	RTMP         RTMP          `json:"rtmp"`         // RTMP协议参数
+	Timeouts     Timeouts      `json:"timeouts"`     // 超时设置
+	Cache        Cache         `json:"cache"`        // 缓存设置
+	Limits       Limits        `json:"limits"`       // 连接数限制
+	Pull         Pull          `json:"pull"`         // 回源拉流
+	RTMPT        RTMPT         `json:"rtmpt"`        // HTTP隧道
+	WebSocket    WebSocket     `json:"websocket"`    // RTMP over WebSocket
+	Outputs      Outputs       `json:"outputs"`      // 输出设置
+	Stats        Stats         `json:"stats"`        // 服务统计
+}
+
+// Listener 监听端口
+type Listener struct {
+	Name     string `json:"name"`
+	Protocol string `json:"protocol"` // 网络协议，目前仅支持tcp
+	Service  string `json:"service"`  // 提供的服务，rtmp 或 http，为空时为rtmp
+	Address  string `json:"address"`
+	Port     int    `json:"port"`
+	TLS      *TLS   `json:"tls"` // 为空时不使用TLS，rtmp服务使用TLS即为RTMPS
+}
+
+// TLS 监听端口的TLS设置
+type TLS struct {
+	Certificates []Certificate `json:"certificates"` // 证书列表，按客户端SNI选择，没有匹配时使用第一个
+	Reload       Duration      `json:"reload"`       // 检查证书文件变化并重新加载的间隔，为空时不重新加载
+}
+
+// Certificate 证书与私钥文件，PEM格式
+type Certificate struct {
+	Cert string `json:"cert"`
+	Key  string `json:"key"`
+}
+
+// Application 应用配置，对应RTMP connect中的app
+type Application struct {
+	Name    string `json:"name"`
+	Publish *bool  `json:"publish"` // 是否允许推流，默认允许
+	Play    *bool  `json:"play"`    // 是否允许拉流，默认允许
+	Auth    *Auth  `json:"auth"`    // 应用鉴权，为空时使用全局鉴权
+
+	MaxPublishers int `json:"max_publishers"` // 应用内推流端上限，为0时使用全局设置
+	MaxViewers    int `json:"max_viewers"`    // 应用内每条流的拉流端上限，为0时使用全局设置
+
+	MaxBitrate    int    `json:"max_bitrate"`    // 推流码率上限(kbps)，为0时使用全局设置
+	BitrateAction string `json:"bitrate_action"` // 推流超过码率上限时的处理，为空时使用全局设置
+
+	Record *RecordRule `json:"record"` // 录制规则，为空时使用全局设置
+	VOD    string      `json:"vod"`    // 点播文件目录，设置后拉流播放目录中的FLV文件，不接受推流
+
+	Push []PushTarget `json:"push"` // 转推目标，推流开始时转推到每个匹配的目标
+	Pull *PullSource  `json:"pull"` // 回源设置，拉流端请求没有推流端的流时从源站拉流
+}
+
+// Auth 鉴权配置，客户端通过流名称中的 key 参数携带密钥，如 stream?key=xxx
+type Auth struct {
+	Enable      bool     `json:"enable"`
+	PublishKeys []string `json:"publish_keys"` // 推流密钥，为空时不校验推流
+	PlayKeys    []string `json:"play_keys"`    // 拉流密钥，为空时不校验拉流
+}
+
+// Registry 推流登记后端
+type Registry struct {
+	Backend string `json:"backend"` // none 或 mysql
+	DSN     string `json:"dsn"`     // 数据库连接信息
+}
+
+// RTMP RTMP协议参数
+type RTMP struct {
+	ChunkSize         uint32 `json:"chunk_size"`          // 本地发送的最大Chunk长度
+	WindowAckSize     uint32 `json:"window_ack_size"`     // 窗口确认大小
+	PeerBandwidth     uint32 `json:"peer_bandwidth"`      // 对端带宽
+	PeerBandwidthType uint32 `json:"peer_bandwidth_type"` // 对端带宽限制类型 0 hard 1 soft 2 dynamic
+	ShapeOutput       bool   `json:"shape_output"`        // 是否按对端发送的Set Peer Bandwidth限制发送速率
+}
+
+// Timeouts 超时设置，为0时不限制
+type Timeouts struct {
+	Handshake Duration `json:"handshake"` // 握手超时
+	Command   Duration `json:"command"`   // 握手完成后到开始推流或拉流前的读超时
+	Media     Duration `json:"media"`     // 推流端两条消息之间的最长间隔
+	Idle      Duration `json:"idle"`      // 拉流端的读超时
+	Write     Duration `json:"write"`     // 每批数据的写超时
+	Drain     Duration `json:"drain"`     // 关闭服务时等待连接断开的时间，超时后强制断开
+	Finish    Duration `json:"finish"`    // 推流端全部断开后等待HLS、录制等输出写完的时间
+}
+
+// Cache 缓存设置
+type Cache struct {
+	ReceiverQueue int `json:"receiver_queue"` // 每个拉流端待发送的消息队列长度
+}
+
+// Limits 连接数限制，为0时不限制
+type Limits struct {
+	MaxConnections      int `json:"max_connections"`        // 所有监听端口的总连接数
+	MaxConnectionsPerIP int `json:"max_connections_per_ip"` // 每个来源IP的连接数
+	MaxPublishers       int `json:"max_publishers"`         // 每个应用的推流端数
+	MaxViewers          int `json:"max_viewers"`            // 每条流的拉流端数
+
+	MaxBitrate    int    `json:"max_bitrate"`    // 推流码率上限(kbps)
+	BitrateAction string `json:"bitrate_action"` // 推流超过码率上限时的处理，warn 告警 disconnect 断开
+}
+
+// RTMPT 通过HTTP隧道传输RTMP，通过http监听端口提供 POST /open /send /idle /close 接口
+type RTMPT struct {
+	Enable      bool     `json:"enable"`
+	IdleTimeout Duration `json:"idle_timeout"` // 会话两次请求之间的最长间隔，超时后关闭会话
+	MaxBuffer   int      `json:"max_buffer"`   // 每个会话等待客户端取走的最大字节数，超出时阻塞写出，也是请求体与等待服务端读取的上限，超出时关闭会话
+}
+
+// WebSocket 通过WebSocket传输RTMP，通过http监听端口提供，如 ws://host/rtmp
+type WebSocket struct {
+	Enable bool   `json:"enable"`
+	Path   string `json:"path"` // 升级请求的路径
+}
+
+// Stats 服务统计，包括连接驱逐、拒绝与告警次数以及每条流的音视频信息
+type Stats struct {
+	Enable   bool     `json:"enable"`   // 是否通过http监听端口提供统计接口，如 GET /stats
+	Prefix   string   `json:"prefix"`   // 统计接口的路径
+	Interval Duration `json:"interval"` // 周期性输出统计日志的间隔，为空时不输出
+}
+
+// Pull 回源拉流，边缘节点在拉流端请求没有推流端的流时从源站拉流，作为该流的推流端分发给本地拉流端
+type Pull struct {
+	Enable   bool     `json:"enable"`
+	Timeout  Duration `json:"timeout"`  // 建立连接与等待命令响应的超时
+	Retry    Duration `json:"retry"`    // 回源失败后仍有拉流端等待时的重试间隔
+	Linger   Duration `json:"linger"`   // 最后一个拉流端离开后保持回源连接的时间
+	Insecure bool     `json:"insecure"` // 源站为rtmps时不校验证书，仅用于测试
+}
+
+// PullSource 应用的回源设置
+type PullSource struct {
+	URL     string   `json:"url"`     // 源站地址模板，如 rtmp://origin/{app}/{stream}，支持rtmps，可使用 {app} {stream}
+	Streams []string `json:"streams"` // 回源的流名称，支持 * ? [] 通配符，为空时应用内所有流都回源
+}
+
+// Outputs 输出设置
+type Outputs struct {
+	RTMP    RTMPOutput    `json:"rtmp"`
+	HTTPFLV HTTPFLVOutput `json:"http_flv"`
+	HLS     HLSOutput     `json:"hls"`
+	DASH    DASHOutput    `json:"dash"`
+	Record  RecordOutput  `json:"record"`
+	Push    PushOutput    `json:"push"`
+}
+
+// RTMPOutput RTMP拉流输出
+type RTMPOutput struct {
+	Enable bool `json:"enable"`
+}
+
+// HTTPFLVOutput HTTP-FLV拉流输出，通过http监听端口提供，如 GET /live/app/stream.flv
+type HTTPFLVOutput struct {
+	Enable bool   `json:"enable"`
+	Prefix string `json:"prefix"` // 路径前缀
+	Queue  int    `json:"queue"`  // 每个拉流端待发送的音视频帧队列长度，队列满时丢弃至下一个关键帧
+	CORS   CORS   `json:"cors"`
+
+	WebSocket bool `json:"websocket"` // 是否接受WebSocket升级，如 ws://host/live/app/stream.flv，每个二进制帧为一个FLV tag
+}
+
+// HLSOutput HLS输出，通过http监听端口提供，如 GET /hls/app/stream/index.m3u8
+type HLSOutput struct {
+	Enable         bool     `json:"enable"`
+	Prefix         string   `json:"prefix"`          // 路径前缀
+	Format         string   `json:"format"`          // 分片封装格式，ts MPEG-TS fmp4 CMAF分片MP4
+	TargetDuration Duration `json:"target_duration"` // 目标分片时长，在此之后的第一个关键帧处切分
+	PartDuration   Duration `json:"part_duration"`   // 低延迟HLS的部分分片目标时长，为空时不使用低延迟模式，仅支持fmp4
+	Window         int      `json:"window"`          // 播放列表中的分片数
+	Retention      int      `json:"retention"`       // 移出播放列表后继续保留的分片数
+	Cleanup        Duration `json:"cleanup"`         // 推流结束后删除分片的延迟，为空时不删除
+	Storage        string   `json:"storage"`         // 分片存储位置，memory 内存 disk 磁盘，DASH输出共用
+	Path           string   `json:"path"`            // 磁盘存储的目录，DASH输出共用
+	Queue          int      `json:"queue"`           // 分片器待处理的音视频帧队列长度
+	CORS           CORS     `json:"cors"`
+}
+
+// DASHOutput DASH输出，通过http监听端口提供，如 GET /dash/app/stream/index.mpd，分片存储与HLS输出共用
+type DASHOutput struct {
+	Enable         bool     `json:"enable"`
+	Prefix         string   `json:"prefix"`          // 路径前缀
+	TargetDuration Duration `json:"target_duration"` // 目标分片时长，在此之后的第一个关键帧处切分
+	Window         int      `json:"window"`          // MPD时间线中的分片数
+	Retention      int      `json:"retention"`       // 移出时间线后继续保留的分片数
+	Cleanup        Duration `json:"cleanup"`         // 推流结束后删除分片的延迟，为空时不删除
+	Queue          int      `json:"queue"`           // 分片器待处理的音视频帧队列长度
+	CORS           CORS     `json:"cors"`
+}
+
+// RecordOutput 录制，推流结束时完成文件
+type RecordOutput struct {
+	Enable      bool       `json:"enable"`
+	Rule        RecordRule `json:"rule"`         // 默认录制规则，应用可单独设置
+	Format      string     `json:"format"`       // 文件格式，flv，mp4 结束时将moov移到文件开头，fmp4 分片MP4，异常退出时已写出的部分仍可播放
+	Path        string     `json:"path"`         // 录制文件的根目录
+	Filename    string     `json:"filename"`     // 文件名模板，可使用 {app} {stream} {time} {unix} {index}，没有扩展名时按格式添加
+	MaxDuration Duration   `json:"max_duration"` // 单个文件的最长时长，在此之后的第一个关键帧处切分，为空时不按时长切分
+	MaxSize     int64      `json:"max_size"`     // 单个文件的最大字节数，为0时不按大小切分
+	Prefix      string     `json:"prefix"`       // 手动录制接口的路径前缀，通过http监听端口提供
+	Queue       int        `json:"queue"`        // 录制器待写出的音视频帧队列长度，磁盘写出跟不上时丢弃至下一个关键帧并告警
+}
+
+// RecordRule 录制规则
+type RecordRule struct {
+	Mode    string   `json:"mode"`    // all 录制所有流 match 录制名称匹配的流 manual 通过接口开始与停止 none 不录制
+	Streams []string `json:"streams"` // match模式下匹配的流名称，支持 * ? [] 通配符
+}
+
+// PushOutput 转推，推流开始时按应用的转推目标建立出站RTMP连接
+type PushOutput struct {
+	Enable   bool     `json:"enable"`
+	Timeout  Duration `json:"timeout"`   // 建立连接与等待命令响应的超时
+	RetryMin Duration `json:"retry_min"` // 断开后第一次重连前的等待时间，每次失败后加倍
+	RetryMax Duration `json:"retry_max"` // 重连等待时间的上限
+	Prefix   string   `json:"prefix"`    // 转推状态接口的路径前缀，通过http监听端口提供
+	Queue    int      `json:"queue"`     // 每个转推连接待发送的音视频帧队列长度，队列满时丢弃至下一个关键帧
+	Insecure bool     `json:"insecure"`  // 目标为rtmps时不校验证书，仅用于测试
+}
+
+// PushTarget 转推目标
+type PushTarget struct {
+	URL     string   `json:"url"`     // 目标地址模板，如 rtmp://host/app/{stream} 或 rtmps://host/app/{stream}，可使用 {app} {stream}
+	Streams []string `json:"streams"` // 转推的流名称，支持 * ? [] 通配符，为空时转推应用内所有流
+}
+
+// CORS 跨域设置
+type CORS struct {
+	Enable       bool     `json:"enable"`
+	AllowOrigins []string `json:"allow_origins"` // 允许的来源，* 表示任意来源
+}
+
+// Default 默认配置
+func Default() *Config {
+	return &Config{
+		Listeners: []Listener{
+			{Name: "rtmp", Protocol: "tcp", Address: "0.0.0.0", Port: 19356},
+		},
+		Applications: []Application{},
+		Registry: Registry{
+			Backend: "none",
+		},
+		RTMP: RTMP{
+			ChunkSize:         512,
+			WindowAckSize:     524288,
+			PeerBandwidth:     524288,
+			PeerBandwidthType: 2,
+			ShapeOutput:       true,
+		},
+		Timeouts: Timeouts{
+			Handshake: "10s",
+			Command:   "30s",
+			Media:     "30s",
+			Idle:      "",
+			Write:     "10s",
+			Drain:     "10s",
+			Finish:    "10s",
+		},
+		Cache: Cache{
+			ReceiverQueue: 8,
+		},
+		Limits: Limits{
+			BitrateAction: "warn",
+		},
+		RTMPT: RTMPT{
+			Enable:      false,
+			IdleTimeout: "30s",
+			MaxBuffer:   1024 * 1024,
+		},
+		WebSocket: WebSocket{
+			Enable: false,
+			Path:   "/rtmp",
+		},
+		Pull: Pull{
+			Enable:  false,
+			Timeout: "10s",
+			Retry:   "3s",
+			Linger:  "10s",
+		},
+		Outputs: Outputs{
+			RTMP: RTMPOutput{Enable: true},
+			HTTPFLV: HTTPFLVOutput{
+				Enable: false,
+				Prefix: "/live",
+				Queue:  256,
+				CORS: CORS{
+					Enable:       true,
+					AllowOrigins: []string{"*"},
+				},
+			},
+			HLS: HLSOutput{
+				Enable:         false,
+				Prefix:         "/hls",
+				Format:         "ts",
+				TargetDuration: "2s",
+				PartDuration:   "",
+				Window:         6,
+				Retention:      2,
+				Cleanup:        "30s",
+				Storage:        "memory",
+				Path:           "hls",
+				Queue:          1024,
+				CORS: CORS{
+					Enable:       true,
+					AllowOrigins: []string{"*"},
+				},
+			},
+			DASH: DASHOutput{
+				Enable:         false,
+				Prefix:         "/dash",
+				TargetDuration: "2s",
+				Window:         6,
+				Retention:      2,
+				Cleanup:        "30s",
+				Queue:          1024,
+				CORS: CORS{
+					Enable:       true,
+					AllowOrigins: []string{"*"},
+				},
+			},
+			Record: RecordOutput{
+				Enable:      false,
+				Rule:        RecordRule{Mode: "all"},
+				Format:      "flv",
+				Path:        "record",
+				Filename:    "{app}/{stream}/{time}",
+				MaxDuration: "",
+				MaxSize:     0,
+				Prefix:      "/record",
+				Queue:       8192,
+			},
+			Push: PushOutput{
+				Enable:   false,
+				Timeout:  "10s",
+				RetryMin: "1s",
+				RetryMax: "30s",
+				Prefix:   "/push",
+				Queue:    1024,
+			},
+		},
+		Stats: Stats{
+			Enable:   false,
+			Prefix:   "/stats",
+			Interval: "",
+		},
+	}
+}
+
+// Load 读入配置文件，未填写的字段使用默认值
+func Load(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	cfg, err := Parse(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "%s", path)
+	}
+	return cfg, nil
+}
+
+// Parse 从字节流解析配置并校验
+func Parse(data []byte) (*Config, error) {
+	cfg := Default()
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(cfg); err != nil {
+		return nil, decodeError(data, err)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// HasService 是否有提供对应服务的监听端口
+func (cfg *Config) HasService(service string) bool {
+	for _, listener := range cfg.Listeners {
+		if listener.ServiceName() == service {
+			return true
+		}
+	}
+	return false
+}
+
+// ServiceName 监听端口提供的服务
+func (listener *Listener) ServiceName() string {
+	if listener.Service == "" {
+		return "rtmp"
+	}
+	return listener.Service
+}
+
+// Application 按名称查找应用配置，未配置应用列表时返回默认应用
+func (cfg *Config) Application(name string) (*Application, bool) {
+	if len(cfg.Applications) == 0 {
+		return &Application{Name: name}, true
+	}
+	for idx := range cfg.Applications {
+		if cfg.Applications[idx].Name == name {
+			return &cfg.Applications[idx], true
+		}
+	}
+	return nil, false
+}
+
+// AllowPublish 是否允许推流，点播应用不允许推流
+func (app *Application) AllowPublish() bool {
+	return app.VOD == "" && (app.Publish == nil || *app.Publish)
+}
+
+// AllowPlay 是否允许拉流
+func (app *Application) AllowPlay() bool {
+	return app.Play == nil || *app.Play
+}
+
+// PublisherLimit 应用内推流端上限，为0时不限制
+func (app *Application) PublisherLimit(limits Limits) int {
+	if app.MaxPublishers > 0 {
+		return app.MaxPublishers
+	}
+	return limits.MaxPublishers
+}
+
+// ViewerLimit 应用内每条流的拉流端上限，为0时不限制
+func (app *Application) ViewerLimit(limits Limits) int {
+	if app.MaxViewers > 0 {
+		return app.MaxViewers
+	}
+	return limits.MaxViewers
+}
+
+// BitrateLimit 推流码率上限(kbps)与超过上限时的处理，码率为0时不限制
+func (app *Application) BitrateLimit(limits Limits) (int, string) {
+	bitrate, action := limits.MaxBitrate, limits.BitrateAction
+	if app.MaxBitrate > 0 {
+		bitrate = app.MaxBitrate
+	}
+	if app.BitrateAction != "" {
+		action = app.BitrateAction
+	}
+	return bitrate, action
+}
+
+// PullStream 流是否从源站回源，没有回源设置或流名称不匹配时返回false
+func (app *Application) PullStream(streamName string) bool {
+	if app.Pull == nil {
+		return false
+	}
+	if len(app.Pull.Streams) == 0 {
+		return true
+	}
+	for _, pattern := range app.Pull.Streams {
+		if ok, _ := path.Match(pattern, streamName); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// RecordRule 应用的录制规则
+func (app *Application) RecordRule(rule RecordRule) RecordRule {
+	if app.Record != nil {
+		return *app.Record
+	}
+	return rule
+}
+
+// Error 配置错误，指出出错的配置项
+type Error struct {
+	Key     string
+	Message string
+}
+
+func (err *Error) Error() string {
+	if err.Key == "" {
+		return fmt.Sprintf("config: %s", err.Message)
+	}
+	return fmt.Sprintf("config: %s: %s", err.Key, err.Message)
+}
+
+// decodeError 将JSON解析错误转换为带位置信息的配置错误
+func decodeError(data []byte, err error) error {
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		line, column := position(data, e.Offset)
+		return &Error{Message: fmt.Sprintf("line %d column %d: %s", line, column, e.Error())}
+	case *json.UnmarshalTypeError:
+		return &Error{Key: e.Field, Message: fmt.Sprintf("cannot use %s as %s", e.Value, e.Type.String())}
+	}
+	return &Error{Message: err.Error()}
+}
+
+// position 计算字节偏移对应的行列号
+func position(data []byte, offset int64) (int, int) {
+	line, column := 1, 1
+	for idx := int64(0); idx < offset && idx < int64(len(data)); idx++ {
+		if data[idx] == '\n' {
+			line++
+			column = 1
+		} else {
+			column++
+		}
+	}
+	return line, column
+}
+
+// Duration 时间长度，使用 "10s" 格式的字符串，空字符串表示0
+type Duration string
+
+// Duration 转换为 time.Duration，格式错误时返回0
+func (d Duration) Duration() time.Duration {
+	value, _ := d.parse()
+	return value
+}
+
+// parse 解析时间长度
+func (d Duration) parse() (time.Duration, error) {
+	if d == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(string(d))
 }
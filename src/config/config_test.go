@@ -0,0 +1,79 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+// TestParse 测试配置解析
+func TestParse(t *testing.T) {
+	cfg, err := Parse([]byte(`{
+		"listeners": [{"name": "rtmp", "protocol": "tcp", "address": "127.0.0.1", "port": 1935}],
+		"applications": [{"name": "live", "play": false}],
+		"timeouts": {"handshake": "5s", "idle": "30s"}
+	}`))
+	if err != nil {
+		t.Fatalf("[×] unexpected error %v\n", err)
+	}
+	app, ok := cfg.Application("live")
+	if !ok || !app.AllowPublish() || app.AllowPlay() {
+		t.Errorf("[×] application live: %+v %v\n", app, ok)
+	}
+	if _, ok := cfg.Application("other"); ok {
+		t.Errorf("[×] application other should not exist\n")
+	}
+	if cfg.Timeouts.Handshake.Duration() != 5*time.Second || cfg.Timeouts.Idle.Duration() != 30*time.Second {
+		t.Errorf("[×] timeouts: %+v\n", cfg.Timeouts)
+	}
+	if cfg.RTMP.ChunkSize != 512 || cfg.Cache.ReceiverQueue != 8 {
+		t.Errorf("[×] defaults not applied: %+v %+v\n", cfg.RTMP, cfg.Cache)
+	}
+}
+
+// TestParseError 测试配置错误信息
+func TestParseError(t *testing.T) {
+	var tests = []struct {
+		in       string // input
+		expected string // expected error
+	}{
+		{`{"listeners": [{"protocol": "tcp", "port": 0}]}`, "config: listeners[0].port: port 0 out of range 1-65535"},
+		{`{"listeners": [{"protocol": "udp", "port": 1935}]}`, `config: listeners[0].protocol: unsupported protocol "udp"`},
+		{`{"listeners": [{"protocol": "tcp", "port": 443, "tls": {"certificates": [{"cert": "cert.pem"}]}}]}`, "config: listeners[0].tls.certificates[0]: cert and key are required"},
+		{`{"listeners": [{"protocol": "tcp", "service": "http", "port": 8080}], "rtmpt": {"enable": true}, "outputs": {"hls": {"enable": true, "prefix": "/send"}}}`, `config: outputs.hls.prefix: prefix "/send" is already used by rtmpt`},
+		{`{"listeners": [{"protocol": "tcp", "service": "http", "port": 8080}], "websocket": {"enable": true, "path": "/live"}, "outputs": {"http_flv": {"enable": true}}}`, `config: outputs.http_flv.prefix: prefix "/live" is already used by websocket`},
+		{`{"outputs": {"http_flv": {"enable": true}}}`, "config: outputs.http_flv: an http listener is required"},
+		{`{"listeners": [{"protocol": "tcp", "service": "http", "port": 8080}], "outputs": {"hls": {"enable": true, "storage": "s3"}}}`, `config: outputs.hls.storage: unsupported storage "s3"`},
+		{`{"listeners": [{"protocol": "tcp", "service": "http", "port": 8080}], "outputs": {"hls": {"enable": true, "part_duration": "200ms"}}}`, "config: outputs.hls.part_duration: low-latency HLS requires fmp4 format"},
+		{`{"listeners": [{"protocol": "tcp", "service": "http", "port": 8080}], "outputs": {"hls": {"enable": true}, "dash": {"enable": true, "prefix": "/hls"}}}`, `config: outputs.dash.prefix: prefix "/hls" is already used by hls`},
+		{`{"applications": [{"name": "live", "record": {"mode": "match"}}], "outputs": {"record": {"enable": true}}}`, "config: applications[0].record.streams: streams are required for match mode"},
+		{`{"outputs": {"record": {"enable": true, "rule": {"mode": "manual"}}}}`, "config: outputs.record: manual recording requires an http listener"},
+		{`{"stats": {"enable": true}}`, "config: stats: an http listener is required"},
+		{`{"listeners": [{"protocol": "tcp", "service": "http", "port": 8080}], "stats": {"enable": true, "prefix": "/live"}, "outputs": {"http_flv": {"enable": true}}}`, `config: stats.prefix: prefix "/live" is already used by http_flv`},
+		{`{"stats": {"interval": "1 minute"}}`, `config: stats.interval: invalid duration "1 minute"`},
+		{`{"applications": [{"name": "vod", "vod": "record/live", "publish": true}]}`, "config: applications[0].publish: vod application does not accept publishing"},
+		{`{"applications": [{"name": "live", "push": [{"url": "http://example.com/live/{stream}"}]}], "outputs": {"push": {"enable": true}}}`, "config: applications[0].push[0].url: unsupported scheme \"http\""},
+		{`{"applications": [{"name": "live", "push": [{"url": "rtmp://example.com/live"}]}], "outputs": {"push": {"enable": true}}}`, "config: applications[0].push[0].url: url \"rtmp://example.com/live\" must contain an application and a stream name"},
+		{`{"applications": [{"name": "edge", "pull": {"url": "rtmp://origin/{app}/{stream}"}}, {"name": "vod", "vod": "record/live", "pull": {"url": "rtmp://origin/live/{stream}"}}], "pull": {"enable": true}}`, "config: applications[1].pull: vod application does not pull from an origin"},
+		{`{"pull": {"enable": true, "retry": "0s"}}`, "config: pull.retry: duration must be positive"},
+		{`{"applications": [{"name": "live"}, {"name": "live"}]}`, `config: applications[1].name: name "live" already used by applications[0]`},
+		{`{"registry": {"backend": "mysql"}}`, "config: registry.dsn: dsn is required for mysql backend"},
+		{`{"rtmp": {"chunk_size": 64}}`, "config: rtmp.chunk_size: chunk size 64 out of range 128-16777215"},
+		{`{"rtmp": {"chunk_size": "big"}}`, `config: rtmp.chunk_size: cannot use string as uint32`},
+		{`{"timeouts": {"idle": "forever"}}`, `config: timeouts.idle: invalid duration "forever"`},
+		{`{"cache": {"receiver_queue": 0}}`, "config: cache.receiver_queue: queue length must be positive"},
+		{`{"limits": {"max_connections_per_ip": -1}}`, "config: limits.max_connections_per_ip: limit must not be negative"},
+		{`{"applications": [{"name": "live", "bitrate_action": "drop"}]}`, `config: applications[0].bitrate_action: unsupported action "drop"`},
+		{`{"timeouts": {"idle": 30}}`, "config: timeouts.idle: cannot use number as config.Duration"},
+		{"{\n\"rtmp\": {,}}", "config: line 2 column 11: invalid character ',' looking for beginning of object key string"},
+		{`{"unknown": 1}`, `config: json: unknown field "unknown"`},
+	}
+
+	for _, test := range tests {
+		_, err := Parse([]byte(test.in))
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("[×] in: %s out: %v expected: %s\n", test.in, err, test.expected)
+		} else {
+			t.Logf("[√] in: %s out: %v expected: %s\n", test.in, err, test.expected)
+		}
+	}
+}
@@ -0,0 +1,623 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// Validate 校验配置，返回第一个出错的配置项
+func (cfg *Config) Validate() error {
+	validators := []func() error{
+		cfg.validateListeners,
+		cfg.validateApplications,
+		cfg.validateRegistry,
+		cfg.validateRTMP,
+		cfg.validateTimeouts,
+		cfg.validateCache,
+		cfg.validateLimits,
+		cfg.validatePull,
+		cfg.validateRTMPT,
+		cfg.validateWebSocket,
+		cfg.validateStats,
+		cfg.validateOutputs,
+	}
+	for _, validator := range validators {
+		if err := validator(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateListeners 校验监听端口
+func (cfg *Config) validateListeners() error {
+	if len(cfg.Listeners) == 0 {
+		return &Error{"listeners", "at least one listener is required"}
+	}
+	addresses := make(map[string]int)
+	for idx, listener := range cfg.Listeners {
+		key := fmt.Sprintf("listeners[%d]", idx)
+		if listener.Protocol != "tcp" {
+			return &Error{key + ".protocol", fmt.Sprintf("unsupported protocol %q", listener.Protocol)}
+		}
+		if service := listener.ServiceName(); service != "rtmp" && service != "http" {
+			return &Error{key + ".service", fmt.Sprintf("unsupported service %q", listener.Service)}
+		}
+		if listener.Port <= 0 || listener.Port > 65535 {
+			return &Error{key + ".port", fmt.Sprintf("port %d out of range 1-65535", listener.Port)}
+		}
+		if err := validateTLS(key+".tls", listener.TLS); err != nil {
+			return err
+		}
+		address := fmt.Sprintf("%s:%d", listener.Address, listener.Port)
+		if prev, ok := addresses[address]; ok {
+			return &Error{key, fmt.Sprintf("address %s already used by listeners[%d]", address, prev)}
+		}
+		addresses[address] = idx
+	}
+	return nil
+}
+
+// validateTLS 校验监听端口的TLS设置，证书文件在启动时加载
+func validateTLS(key string, t *TLS) error {
+	if t == nil {
+		return nil
+	}
+	if len(t.Certificates) == 0 {
+		return &Error{key + ".certificates", "at least one certificate is required"}
+	}
+	for idx, cert := range t.Certificates {
+		if cert.Cert == "" || cert.Key == "" {
+			return &Error{fmt.Sprintf("%s.certificates[%d]", key, idx), "cert and key are required"}
+		}
+	}
+	return validateDuration(key+".reload", t.Reload)
+}
+
+// validateApplications 校验应用列表
+func (cfg *Config) validateApplications() error {
+	names := make(map[string]int)
+	for idx, app := range cfg.Applications {
+		key := fmt.Sprintf("applications[%d]", idx)
+		if app.Name == "" {
+			return &Error{key + ".name", "name is required"}
+		}
+		if strings.Contains(app.Name, "/") {
+			return &Error{key + ".name", fmt.Sprintf("name %q must not contain '/'", app.Name)}
+		}
+		if app.MaxPublishers < 0 {
+			return &Error{key + ".max_publishers", "limit must not be negative"}
+		}
+		if app.MaxViewers < 0 {
+			return &Error{key + ".max_viewers", "limit must not be negative"}
+		}
+		if app.MaxBitrate < 0 {
+			return &Error{key + ".max_bitrate", "limit must not be negative"}
+		}
+		if app.BitrateAction != "" {
+			if err := validateBitrateAction(key+".bitrate_action", app.BitrateAction); err != nil {
+				return err
+			}
+		}
+		if app.VOD != "" && app.Publish != nil && *app.Publish {
+			return &Error{key + ".publish", "vod application does not accept publishing"}
+		}
+		if prev, ok := names[app.Name]; ok {
+			return &Error{key + ".name", fmt.Sprintf("name %q already used by applications[%d]", app.Name, prev)}
+		}
+		names[app.Name] = idx
+	}
+	return nil
+}
+
+// validateRegistry 校验推流登记后端
+func (cfg *Config) validateRegistry() error {
+	switch cfg.Registry.Backend {
+	case "none":
+	case "mysql":
+		if cfg.Registry.DSN == "" {
+			return &Error{"registry.dsn", "dsn is required for mysql backend"}
+		}
+	default:
+		return &Error{"registry.backend", fmt.Sprintf("unsupported backend %q", cfg.Registry.Backend)}
+	}
+	return nil
+}
+
+// validateRTMP 校验RTMP协议参数
+func (cfg *Config) validateRTMP() error {
+	if cfg.RTMP.ChunkSize < 128 || cfg.RTMP.ChunkSize > 0xffffff {
+		return &Error{"rtmp.chunk_size", fmt.Sprintf("chunk size %d out of range 128-16777215", cfg.RTMP.ChunkSize)}
+	}
+	if cfg.RTMP.WindowAckSize == 0 {
+		return &Error{"rtmp.window_ack_size", "window ack size must be positive"}
+	}
+	if cfg.RTMP.PeerBandwidth == 0 {
+		return &Error{"rtmp.peer_bandwidth", "peer bandwidth must be positive"}
+	}
+	if cfg.RTMP.PeerBandwidthType > 2 {
+		return &Error{"rtmp.peer_bandwidth_type", fmt.Sprintf("limit type %d must be 0, 1 or 2", cfg.RTMP.PeerBandwidthType)}
+	}
+	return nil
+}
+
+// validateTimeouts 校验超时设置
+func (cfg *Config) validateTimeouts() error {
+	timeouts := []struct {
+		key   string
+		value Duration
+	}{
+		{"timeouts.handshake", cfg.Timeouts.Handshake},
+		{"timeouts.command", cfg.Timeouts.Command},
+		{"timeouts.media", cfg.Timeouts.Media},
+		{"timeouts.idle", cfg.Timeouts.Idle},
+		{"timeouts.write", cfg.Timeouts.Write},
+		{"timeouts.drain", cfg.Timeouts.Drain},
+		{"timeouts.finish", cfg.Timeouts.Finish},
+	}
+	for _, timeout := range timeouts {
+		if err := validateDuration(timeout.key, timeout.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateDuration 校验时间长度
+func validateDuration(key string, d Duration) error {
+	value, err := d.parse()
+	if err != nil {
+		return &Error{key, fmt.Sprintf("invalid duration %q", string(d))}
+	}
+	if value < 0 {
+		return &Error{key, "duration must not be negative"}
+	}
+	return nil
+}
+
+// validateCache 校验缓存设置
+func (cfg *Config) validateCache() error {
+	if cfg.Cache.ReceiverQueue <= 0 {
+		return &Error{"cache.receiver_queue", "queue length must be positive"}
+	}
+	return nil
+}
+
+// validateLimits 校验连接数限制
+func (cfg *Config) validateLimits() error {
+	limits := []struct {
+		key   string
+		value int
+	}{
+		{"limits.max_connections", cfg.Limits.MaxConnections},
+		{"limits.max_connections_per_ip", cfg.Limits.MaxConnectionsPerIP},
+		{"limits.max_publishers", cfg.Limits.MaxPublishers},
+		{"limits.max_viewers", cfg.Limits.MaxViewers},
+		{"limits.max_bitrate", cfg.Limits.MaxBitrate},
+	}
+	for _, limit := range limits {
+		if limit.value < 0 {
+			return &Error{limit.key, "limit must not be negative"}
+		}
+	}
+	return validateBitrateAction("limits.bitrate_action", cfg.Limits.BitrateAction)
+}
+
+// validateBitrateAction 校验推流超过码率上限时的处理
+func validateBitrateAction(key string, action string) error {
+	switch action {
+	case "warn", "disconnect":
+		return nil
+	default:
+		return &Error{key, fmt.Sprintf("unsupported action %q", action)}
+	}
+}
+
+// validateOutputs 校验输出设置
+func (cfg *Config) validateOutputs() error {
+	validators := []func() error{
+		cfg.validateHTTPFLV,
+		cfg.validateHLS,
+		cfg.validateDASH,
+		cfg.validateStorage,
+		cfg.validateRecord,
+		cfg.validatePush,
+		cfg.validatePrefixes,
+	}
+	for _, validate := range validators {
+		if err := validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateHTTPFLV 校验HTTP-FLV输出设置
+func (cfg *Config) validateHTTPFLV() error {
+	flv := cfg.Outputs.HTTPFLV
+	if !flv.Enable {
+		return nil
+	}
+	if !cfg.HasService("http") {
+		return &Error{"outputs.http_flv", "an http listener is required"}
+	}
+	if err := validatePrefix("outputs.http_flv.prefix", flv.Prefix); err != nil {
+		return err
+	}
+	if flv.Queue <= 0 {
+		return &Error{"outputs.http_flv.queue", "queue length must be positive"}
+	}
+	return nil
+}
+
+// validateHLS 校验HLS输出设置
+func (cfg *Config) validateHLS() error {
+	hls := cfg.Outputs.HLS
+	if !hls.Enable {
+		return nil
+	}
+	if !cfg.HasService("http") {
+		return &Error{"outputs.hls", "an http listener is required"}
+	}
+	if err := validatePrefix("outputs.hls.prefix", hls.Prefix); err != nil {
+		return err
+	}
+	if err := validateDuration("outputs.hls.target_duration", hls.TargetDuration); err != nil {
+		return err
+	}
+	if hls.TargetDuration.Duration() <= 0 {
+		return &Error{"outputs.hls.target_duration", "duration must be positive"}
+	}
+	if err := validateDuration("outputs.hls.cleanup", hls.Cleanup); err != nil {
+		return err
+	}
+	switch hls.Format {
+	case "ts", "fmp4":
+	default:
+		return &Error{"outputs.hls.format", fmt.Sprintf("unsupported format %q", hls.Format)}
+	}
+	if err := validateDuration("outputs.hls.part_duration", hls.PartDuration); err != nil {
+		return err
+	}
+	if part := hls.PartDuration.Duration(); part > 0 {
+		if hls.Format != "fmp4" {
+			return &Error{"outputs.hls.part_duration", "low-latency HLS requires fmp4 format"}
+		}
+		if part >= hls.TargetDuration.Duration() {
+			return &Error{"outputs.hls.part_duration", "part duration must be less than target duration"}
+		}
+	}
+	if hls.Window <= 0 {
+		return &Error{"outputs.hls.window", "window must be positive"}
+	}
+	if hls.Retention < 0 {
+		return &Error{"outputs.hls.retention", "retention must not be negative"}
+	}
+	if hls.Queue <= 0 {
+		return &Error{"outputs.hls.queue", "queue length must be positive"}
+	}
+	return nil
+}
+
+// validateDASH 校验DASH输出设置
+func (cfg *Config) validateDASH() error {
+	dash := cfg.Outputs.DASH
+	if !dash.Enable {
+		return nil
+	}
+	if !cfg.HasService("http") {
+		return &Error{"outputs.dash", "an http listener is required"}
+	}
+	if err := validatePrefix("outputs.dash.prefix", dash.Prefix); err != nil {
+		return err
+	}
+	if err := validateDuration("outputs.dash.target_duration", dash.TargetDuration); err != nil {
+		return err
+	}
+	if dash.TargetDuration.Duration() <= 0 {
+		return &Error{"outputs.dash.target_duration", "duration must be positive"}
+	}
+	if err := validateDuration("outputs.dash.cleanup", dash.Cleanup); err != nil {
+		return err
+	}
+	if dash.Window <= 0 {
+		return &Error{"outputs.dash.window", "window must be positive"}
+	}
+	if dash.Retention < 0 {
+		return &Error{"outputs.dash.retention", "retention must not be negative"}
+	}
+	if dash.Queue <= 0 {
+		return &Error{"outputs.dash.queue", "queue length must be positive"}
+	}
+	return nil
+}
+
+// validateStorage 校验HLS与DASH共用的分片存储设置
+func (cfg *Config) validateStorage() error {
+	hls := cfg.Outputs.HLS
+	if !hls.Enable && !cfg.Outputs.DASH.Enable {
+		return nil
+	}
+	switch hls.Storage {
+	case "memory":
+	case "disk":
+		if hls.Path == "" {
+			return &Error{"outputs.hls.path", "path is required for disk storage"}
+		}
+	default:
+		return &Error{"outputs.hls.storage", fmt.Sprintf("unsupported storage %q", hls.Storage)}
+	}
+	return nil
+}
+
+// validateRecord 校验录制设置
+func (cfg *Config) validateRecord() error {
+	record := cfg.Outputs.Record
+	if !record.Enable {
+		return nil
+	}
+	manual := record.Rule.Mode == "manual"
+	if err := validateRecordRule("outputs.record.rule", record.Rule); err != nil {
+		return err
+	}
+	for idx, app := range cfg.Applications {
+		if app.Record == nil {
+			continue
+		}
+		if err := validateRecordRule(fmt.Sprintf("applications[%d].record", idx), *app.Record); err != nil {
+			return err
+		}
+		manual = manual || app.Record.Mode == "manual"
+	}
+	switch record.Format {
+	case "flv", "mp4", "fmp4":
+	default:
+		return &Error{"outputs.record.format", fmt.Sprintf("unsupported format %q", record.Format)}
+	}
+	if record.Path == "" {
+		return &Error{"outputs.record.path", "path is required"}
+	}
+	if record.Filename == "" {
+		return &Error{"outputs.record.filename", "filename is required"}
+	}
+	if err := validateDuration("outputs.record.max_duration", record.MaxDuration); err != nil {
+		return err
+	}
+	if record.MaxSize < 0 {
+		return &Error{"outputs.record.max_size", "size must not be negative"}
+	}
+	if cfg.HasService("http") {
+		if err := validatePrefix("outputs.record.prefix", record.Prefix); err != nil {
+			return err
+		}
+	} else if manual {
+		return &Error{"outputs.record", "manual recording requires an http listener"}
+	}
+	if record.Queue <= 0 {
+		return &Error{"outputs.record.queue", "queue length must be positive"}
+	}
+	return nil
+}
+
+// validateRecordRule 校验录制规则
+func validateRecordRule(key string, rule RecordRule) error {
+	switch rule.Mode {
+	case "all", "manual", "none":
+	case "match":
+		if len(rule.Streams) == 0 {
+			return &Error{key + ".streams", "streams are required for match mode"}
+		}
+		for _, pattern := range rule.Streams {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return &Error{key + ".streams", fmt.Sprintf("invalid pattern %q", pattern)}
+			}
+		}
+	default:
+		return &Error{key + ".mode", fmt.Sprintf("unsupported mode %q", rule.Mode)}
+	}
+	return nil
+}
+
+// RTMPTPaths RTMPT使用的固定路径前缀
+var RTMPTPaths = []string{"/open", "/send", "/idle", "/close", "/fcs"}
+
+// validateRTMPT 校验HTTP隧道设置
+func (cfg *Config) validateRTMPT() error {
+	rtmpt := cfg.RTMPT
+	if !rtmpt.Enable {
+		return nil
+	}
+	if !cfg.HasService("http") {
+		return &Error{"rtmpt", "an http listener is required"}
+	}
+	if err := validateDuration("rtmpt.idle_timeout", rtmpt.IdleTimeout); err != nil {
+		return err
+	}
+	if rtmpt.IdleTimeout.Duration() <= 0 {
+		return &Error{"rtmpt.idle_timeout", "duration must be positive"}
+	}
+	if rtmpt.MaxBuffer <= 0 {
+		return &Error{"rtmpt.max_buffer", "buffer size must be positive"}
+	}
+	return nil
+}
+
+// validateWebSocket 校验RTMP over WebSocket设置
+func (cfg *Config) validateWebSocket() error {
+	ws := cfg.WebSocket
+	if !ws.Enable {
+		return nil
+	}
+	if !cfg.HasService("http") {
+		return &Error{"websocket", "an http listener is required"}
+	}
+	return validatePrefix("websocket.path", ws.Path)
+}
+
+// validateStats 校验服务统计设置
+func (cfg *Config) validateStats() error {
+	stats := cfg.Stats
+	if err := validateDuration("stats.interval", stats.Interval); err != nil {
+		return err
+	}
+	if !stats.Enable {
+		return nil
+	}
+	if !cfg.HasService("http") {
+		return &Error{"stats", "an http listener is required"}
+	}
+	return validatePrefix("stats.prefix", stats.Prefix)
+}
+
+// validatePull 校验回源设置与应用的源站
+func (cfg *Config) validatePull() error {
+	pull := cfg.Pull
+	if !pull.Enable {
+		return nil
+	}
+	for _, item := range []struct {
+		key string
+		d   Duration
+	}{{"timeout", pull.Timeout}, {"retry", pull.Retry}} {
+		if err := validateDuration("pull."+item.key, item.d); err != nil {
+			return err
+		}
+		if item.d.Duration() <= 0 {
+			return &Error{"pull." + item.key, "duration must be positive"}
+		}
+	}
+	if err := validateDuration("pull.linger", pull.Linger); err != nil {
+		return err
+	}
+
+	for idx, app := range cfg.Applications {
+		if app.Pull == nil {
+			continue
+		}
+		key := fmt.Sprintf("applications[%d].pull", idx)
+		if app.VOD != "" {
+			return &Error{key, "vod application does not pull from an origin"}
+		}
+		if err := validateRTMPURL(key+".url", app.Pull.URL); err != nil {
+			return err
+		}
+		for _, pattern := range app.Pull.Streams {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return &Error{key + ".streams", fmt.Sprintf("invalid pattern %q", pattern)}
+			}
+		}
+	}
+	return nil
+}
+
+// validatePush 校验转推设置与应用的转推目标
+func (cfg *Config) validatePush() error {
+	push := cfg.Outputs.Push
+	if !push.Enable {
+		return nil
+	}
+	for _, item := range []struct {
+		key string
+		d   Duration
+	}{{"timeout", push.Timeout}, {"retry_min", push.RetryMin}, {"retry_max", push.RetryMax}} {
+		if err := validateDuration("outputs.push."+item.key, item.d); err != nil {
+			return err
+		}
+		if item.d.Duration() <= 0 {
+			return &Error{"outputs.push." + item.key, "duration must be positive"}
+		}
+	}
+	if push.RetryMin.Duration() > push.RetryMax.Duration() {
+		return &Error{"outputs.push.retry_max", "retry_max must not be less than retry_min"}
+	}
+	if cfg.HasService("http") {
+		if err := validatePrefix("outputs.push.prefix", push.Prefix); err != nil {
+			return err
+		}
+	}
+	if push.Queue <= 0 {
+		return &Error{"outputs.push.queue", "queue length must be positive"}
+	}
+
+	for idx, app := range cfg.Applications {
+		for i, target := range app.Push {
+			key := fmt.Sprintf("applications[%d].push[%d]", idx, i)
+			if err := validateRTMPURL(key+".url", target.URL); err != nil {
+				return err
+			}
+			for _, pattern := range target.Streams {
+				if _, err := path.Match(pattern, ""); err != nil {
+					return &Error{key + ".streams", fmt.Sprintf("invalid pattern %q", pattern)}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// validateRTMPURL 校验转推目标或源站的地址模板，需要为 rtmp://host/app/stream 或 rtmps://host/app/stream 格式
+func validateRTMPURL(key string, rawURL string) error {
+	u, err := url.Parse(strings.NewReplacer("{app}", "app", "{stream}", "stream").Replace(rawURL))
+	if err != nil {
+		return &Error{key, fmt.Sprintf("invalid url %q", rawURL)}
+	}
+	if u.Scheme != "rtmp" && u.Scheme != "rtmps" {
+		return &Error{key, fmt.Sprintf("unsupported scheme %q", u.Scheme)}
+	}
+	if u.Hostname() == "" {
+		return &Error{key, "host is required"}
+	}
+	parts := strings.SplitN(strings.TrimPrefix(u.Path, "/"), "/", 2)
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return &Error{key, fmt.Sprintf("url %q must contain an application and a stream name", rawURL)}
+	}
+	return nil
+}
+
+// validatePrefixes 校验开启的HTTP输出使用不同的路径前缀
+func (cfg *Config) validatePrefixes() error {
+	outputs := []struct {
+		key    string
+		enable bool
+		prefix string
+	}{
+		{"outputs.http_flv", cfg.Outputs.HTTPFLV.Enable, cfg.Outputs.HTTPFLV.Prefix},
+		{"outputs.hls", cfg.Outputs.HLS.Enable, cfg.Outputs.HLS.Prefix},
+		{"outputs.dash", cfg.Outputs.DASH.Enable, cfg.Outputs.DASH.Prefix},
+		{"outputs.record", cfg.Outputs.Record.Enable && cfg.HasService("http"), cfg.Outputs.Record.Prefix},
+		{"outputs.push", cfg.Outputs.Push.Enable && cfg.HasService("http"), cfg.Outputs.Push.Prefix},
+		{"stats", cfg.Stats.Enable, cfg.Stats.Prefix},
+	}
+	used := make(map[string]string)
+	if cfg.RTMPT.Enable {
+		for _, prefix := range RTMPTPaths {
+			used[prefix] = "rtmpt"
+		}
+	}
+	if cfg.WebSocket.Enable {
+		if other, ok := used[cfg.WebSocket.Path]; ok {
+			return &Error{"websocket.path", fmt.Sprintf("prefix %q is already used by %s", cfg.WebSocket.Path, other)}
+		}
+		used[cfg.WebSocket.Path] = "websocket"
+	}
+	for _, output := range outputs {
+		if !output.enable {
+			continue
+		}
+		if other, ok := used[output.prefix]; ok {
+			return &Error{output.key + ".prefix", fmt.Sprintf("prefix %q is already used by %s", output.prefix, other)}
+		}
+		used[output.prefix] = strings.TrimPrefix(output.key, "outputs.")
+	}
+	return nil
+}
+
+// validatePrefix 校验HTTP路径前缀
+func validatePrefix(key string, prefix string) error {
+	if !strings.HasPrefix(prefix, "/") || strings.HasSuffix(prefix, "/") {
+		return &Error{key, fmt.Sprintf("prefix %q must start with '/' and not end with '/'", prefix)}
+	}
+	return nil
+}
@@ -0,0 +1,230 @@
+package dash
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"../config"
+	"../hls"
+	c "../lib/colorful"
+	"../rtmp"
+	s "../server"
+	"github.com/pkg/errors"
+)
+
+/*
+
+DASH 输出
+
+推流开始时为流创建分片器，MPD与分片通过HTTP提供，路径为 前缀/应用/流名称/index.mpd
+多轨道的流的其他轨道在同一个MPD中列出
+与HLS共用分片存储
+
+*/
+
+// ManifestName MPD文件名
+const ManifestName = "index.mpd"
+
+// filePattern 可以请求的文件名，包括MPD、初始化分片与分片
+var filePattern = regexp.MustCompile(`^(index\.mpd|[0-9]+-(video|audio)[0-9]*-(init\.mp4|[0-9]+\.m4s))$`)
+
+// templatePattern MPD中分片模板的地址属性
+var templatePattern = regexp.MustCompile(`(initialization|media)="[^"]*"`)
+
+// contentTypes 各类文件的类型
+var contentTypes = map[string]string{
+	".mpd": "application/dash+xml",
+	".m4s": "video/iso.segment",
+	".mp4": "video/mp4",
+}
+
+// Server DASH输出服务
+type Server struct {
+	RTMP    *rtmp.Server
+	Config  config.DASHOutput
+	Storage hls.Storage
+
+	mutex   sync.Mutex
+	active  map[string]*Segmenter // 正在推流的分片器
+	periods map[string]uint64     // 各条流下一次推流的周期序号
+	wg      sync.WaitGroup
+}
+
+// NewServer 新建DASH输出服务，storage与HLS输出共用
+func NewServer(server *rtmp.Server, storage hls.Storage) *Server {
+	return &Server{
+		RTMP:    server,
+		Config:  server.Config.Outputs.DASH,
+		Storage: storage,
+		active:  make(map[string]*Segmenter),
+		periods: make(map[string]uint64),
+	}
+}
+
+// Publish 推流开始时调用，为流增加分片器，推流端已断开时不再增加
+func (server *Server) Publish(stream *rtmp.Stream) {
+	defer server.mutex.Unlock()
+	server.mutex.Lock()
+
+	period := server.periods[stream.Name]
+	seg := newSegmenter(server, stream.Name, period)
+	if !stream.AddOutput(seg.queue) {
+		return
+	}
+	server.periods[stream.Name] = period + 1
+	server.active[stream.Name] = seg
+	server.wg.Add(1)
+
+	log.Println(c.Front("DASH %s started", c.G, stream.Name))
+	go seg.run()
+}
+
+// Shutdown 等待所有分片器写出最后的分片，推流端需先断开
+func (server *Server) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		server.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return errors.WithStack(ctx.Err())
+	}
+}
+
+// putManifest 写出MPD，流已被新的推流接管时不再写出
+func (server *Server) putManifest(seg *Segmenter, manifest []byte) error {
+	defer server.mutex.Unlock()
+	server.mutex.Lock()
+
+	if server.active[seg.Name] != seg {
+		return nil
+	}
+	return errors.WithStack(server.Storage.Put(seg.Name+"/"+ManifestName, manifest))
+}
+
+// finished 分片器结束，按配置延迟删除分片，子分片器随所属的分片器删除
+func (server *Server) finished(seg *Segmenter) {
+	if seg.parent != nil {
+		return
+	}
+	delay := server.Config.Cleanup.Duration()
+	if delay > 0 {
+		time.AfterFunc(delay, func() {
+			server.cleanup(seg)
+		})
+	}
+	server.wg.Done()
+}
+
+// cleanup 删除已结束的分片器生成的分片，流没有重新推流时同时删除MPD
+func (server *Server) cleanup(seg *Segmenter) {
+	defer server.mutex.Unlock()
+	server.mutex.Lock()
+
+	if server.active[seg.Name] == seg {
+		delete(server.active, seg.Name)
+		server.Storage.Delete(seg.Name + "/" + ManifestName)
+	}
+	seg.cleanup()
+}
+
+// targetDuration 目标分片时长
+func (server *Server) targetDuration() time.Duration {
+	return server.Config.TargetDuration.Duration()
+}
+
+// Handler HTTP处理函数，开启跨域时增加跨域响应头
+func (server *Server) Handler() http.Handler {
+	var handler http.Handler = server
+	if server.Config.CORS.Enable {
+		handler = s.CORS(server.Config.CORS.AllowOrigins, handler)
+	}
+	return handler
+}
+
+// ServeHTTP 提供MPD与分片
+func (server *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	appName, streamName, file, ok := server.parsePath(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	cfg := server.RTMP.Config
+	app, ok := cfg.Application(appName)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	query := r.URL.Query()
+	if !app.AllowPlay() || !server.RTMP.Authorize(app, false, query) {
+		http.Error(w, "play denied", http.StatusForbidden)
+		return
+	}
+
+	data, err := server.Storage.Get(appName + "/" + streamName + "/" + file)
+	if err == hls.ErrNotFound {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		log.Println(c.Front("DASH %s: %v", c.R, r.URL.Path, err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	header := w.Header()
+	if file == ManifestName {
+		if key := query.Get("key"); key != "" {
+			data = appendQuery(data, url.Values{"key": []string{key}}.Encode())
+		}
+		header.Set("Cache-Control", "no-cache")
+	} else {
+		header.Set("Cache-Control", "max-age=3600")
+	}
+	header.Set("Content-Type", contentTypes[path.Ext(file)])
+	header.Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodGet {
+		w.Write(data)
+	}
+}
+
+// parsePath 从请求路径中解析应用、流名称与文件名
+func (server *Server) parsePath(urlPath string) (string, string, string, bool) {
+	prefix := server.Config.Prefix + "/"
+	if !strings.HasPrefix(urlPath, prefix) {
+		return "", "", "", false
+	}
+	parts := strings.Split(strings.TrimPrefix(urlPath, prefix), "/")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || !filePattern.MatchString(parts[2]) {
+		return "", "", "", false
+	}
+	return parts[0], parts[1], parts[2], true
+}
+
+// appendQuery 为MPD中的分片模板增加参数，用于传递鉴权密钥
+func appendQuery(manifest []byte, query string) []byte {
+	return templatePattern.ReplaceAllFunc(manifest, func(attr []byte) []byte {
+		result := make([]byte, 0, len(attr)+len(query)+1)
+		result = append(result, attr[:len(attr)-1]...)
+		result = append(result, '?')
+		result = append(result, query...)
+		return append(result, '"')
+	})
+}
@@ -0,0 +1,71 @@
+package dash
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"../config"
+	"../hls"
+	"../rtmp"
+	s "../server"
+)
+
+// TestPublish 测试只为有推流端的流增加分片器，每次推流使用新的周期
+func TestPublish(t *testing.T) {
+	cfg := config.Default()
+	rtmpServer := rtmp.NewServer(cfg, nil)
+	server := NewServer(&rtmpServer, hls.NewMemoryStorage())
+	name := "live/a"
+
+	var tests = []struct {
+		in       string // input: publish 调用推流开始的处理，join 推流端加入，leave 推流端断开
+		expected int    // expected: 当前分片器的周期序号，-1表示没有分片器
+	}{
+		{"publish", -1},
+		{"join", -1},
+		{"publish", 0},
+		{"leave", 0},
+		{"publish", 0},
+		{"join", 0},
+		{"publish", 1},
+		{"leave", 1},
+	}
+
+	var publisher *rtmp.Connect
+	for _, test := range tests {
+		switch test.in {
+		case "publish":
+			server.Publish(rtmpServer.GetStream(name))
+		case "join":
+			local, remote := net.Pipe()
+			defer remote.Close()
+			publisher = rtmp.NewConnect(s.NewConnect(local, 4096), &rtmpServer)
+			rtmpServer.GetStream(name).AddPublisher(publisher)
+		case "leave":
+			rtmpServer.GetStream(name).DelConnect(publisher)
+		}
+
+		server.mutex.Lock()
+		actual := -1
+		if seg, ok := server.active[name]; ok {
+			actual = int(seg.period)
+		}
+		server.mutex.Unlock()
+		if actual != test.expected {
+			t.Errorf("[×] in: %s out: %d expected: %d\n", test.in, actual, test.expected)
+		} else {
+			t.Logf("[√] in: %s out: %d expected: %d\n", test.in, actual, test.expected)
+		}
+	}
+
+	// 推流端全部断开后所有分片器结束
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		t.Errorf("[×] in: shutdown out: %v expected: nil\n", err)
+	} else {
+		t.Logf("[√] in: shutdown out: %v expected: nil\n", err)
+	}
+}
@@ -0,0 +1,136 @@
+package dash
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"../fmp4"
+)
+
+// representation 一个轨道的表示
+type representation struct {
+	id        string // video 或 audio，轨道0之外的轨道带有轨道ID，如 audio1
+	alternate bool   // 是否为轨道0之外的替代轨道
+	track     *fmp4.Track
+	buffer    *fmp4.SampleBuffer
+	offset    uint64  // 第一个分片的开始时间，作为presentationTimeOffset
+	timeline  []entry // 已完成的分片
+}
+
+// entry 时间线中的一个分片
+type entry struct {
+	time     uint64 // 开始时间，单位为轨道的时间单位
+	duration uint64 // 时长
+	size     int    // 分片大小
+}
+
+// name 分片文件名
+func (entry entry) name(period uint64, id string) string {
+	return fmt.Sprintf("%d-%s-%d.m4s", period, id, entry.time)
+}
+
+// bandwidth 按时间线中分片的大小估计码率
+func (rep *representation) bandwidth() int {
+	var size int
+	var duration uint64
+	for _, e := range rep.timeline {
+		size += e.size
+		duration += e.duration
+	}
+	if duration == 0 || rep.track.Timescale == 0 {
+		return 1
+	}
+	bandwidth := int(uint64(size) * 8 * uint64(rep.track.Timescale) / duration)
+	if bandwidth < 1 {
+		return 1
+	}
+	return bandwidth
+}
+
+// manifest 动态MPD
+type manifest struct {
+	period          uint64    // 周期序号，每次推流不同
+	availability    time.Time // 推流开始的时间
+	publish         time.Time // MPD生成的时间
+	target          time.Duration
+	window          int           // 时间线中的分片数
+	ended           bool          // 推流是否已结束
+	duration        time.Duration // 推流结束时的总时长
+	representations []*representation
+}
+
+// mpdTime MPD中的时间格式
+func mpdTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+// mpdDuration MPD中的时长格式
+func mpdDuration(d time.Duration) string {
+	return fmt.Sprintf("PT%.3fS", d.Seconds())
+}
+
+// Bytes 生成MPD
+func (m *manifest) Bytes() []byte {
+	buf := new(bytes.Buffer)
+	buf.WriteString("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+	buf.WriteString("<MPD xmlns=\"urn:mpeg:dash:schema:mpd:2011\" profiles=\"urn:mpeg:dash:profile:isoff-live:2011\" type=\"dynamic\"")
+	fmt.Fprintf(buf, " availabilityStartTime=\"%s\" publishTime=\"%s\"", mpdTime(m.availability), mpdTime(m.publish))
+	if m.ended {
+		// 推流结束后不再更新
+		fmt.Fprintf(buf, " mediaPresentationDuration=\"%s\"", mpdDuration(m.duration))
+	} else {
+		fmt.Fprintf(buf, " minimumUpdatePeriod=\"%s\"", mpdDuration(m.target))
+	}
+	fmt.Fprintf(buf, " minBufferTime=\"%s\" timeShiftBufferDepth=\"%s\" suggestedPresentationDelay=\"%s\">\n",
+		mpdDuration(m.target), mpdDuration(time.Duration(m.window)*m.target), mpdDuration(3*m.target))
+	fmt.Fprintf(buf, "  <Period id=\"%d\" start=\"PT0S\">\n", m.period)
+	for i, rep := range m.representations {
+		m.writeAdaptationSet(buf, i, rep)
+	}
+	buf.WriteString("  </Period>\n")
+	buf.WriteString("</MPD>\n")
+	return buf.Bytes()
+}
+
+// writeAdaptationSet 写出一个轨道的自适应集，替代轨道标记为alternate
+func (m *manifest) writeAdaptationSet(buf *bytes.Buffer, id int, rep *representation) {
+	track := rep.track
+	if track.Type == fmp4.TrackVideo {
+		fmt.Fprintf(buf, "    <AdaptationSet id=\"%d\" contentType=\"video\" mimeType=\"video/mp4\" segmentAlignment=\"true\" startWithSAP=\"1\">\n", id)
+		writeRole(buf, rep)
+		fmt.Fprintf(buf, "      <Representation id=\"%s\" codecs=\"%s\" bandwidth=\"%d\"", rep.id, track.Codec(), rep.bandwidth())
+		if track.Width > 0 && track.Height > 0 {
+			fmt.Fprintf(buf, " width=\"%d\" height=\"%d\"", track.Width, track.Height)
+		}
+		buf.WriteString(">\n")
+	} else {
+		fmt.Fprintf(buf, "    <AdaptationSet id=\"%d\" contentType=\"audio\" mimeType=\"audio/mp4\" segmentAlignment=\"true\" startWithSAP=\"1\">\n", id)
+		writeRole(buf, rep)
+		fmt.Fprintf(buf, "      <Representation id=\"%s\" codecs=\"%s\" bandwidth=\"%d\" audioSamplingRate=\"%d\">\n",
+			rep.id, track.Codec(), rep.bandwidth(), track.SampleRate)
+		fmt.Fprintf(buf, "        <AudioChannelConfiguration schemeIdUri=\"urn:mpeg:dash:23003:3:audio_channel_configuration:2011\" value=\"%d\"/>\n", track.Channels)
+	}
+
+	fmt.Fprintf(buf, "        <SegmentTemplate timescale=\"%d\" presentationTimeOffset=\"%d\"", track.Timescale, rep.offset)
+	fmt.Fprintf(buf, " initialization=\"%d-$RepresentationID$-init.mp4\" media=\"%d-$RepresentationID$-$Time$.m4s\">\n", m.period, m.period)
+	buf.WriteString("          <SegmentTimeline>\n")
+	timeline := rep.timeline
+	if len(timeline) > m.window {
+		timeline = timeline[len(timeline)-m.window:]
+	}
+	for _, e := range timeline {
+		fmt.Fprintf(buf, "            <S t=\"%d\" d=\"%d\"/>\n", e.time, e.duration)
+	}
+	buf.WriteString("          </SegmentTimeline>\n")
+	buf.WriteString("        </SegmentTemplate>\n")
+	buf.WriteString("      </Representation>\n")
+	buf.WriteString("    </AdaptationSet>\n")
+}
+
+// writeRole 替代轨道的自适应集标记为alternate，播放端默认选择轨道0
+func writeRole(buf *bytes.Buffer, rep *representation) {
+	if rep.alternate {
+		buf.WriteString("      <Role schemeIdUri=\"urn:mpeg:dash:role:2011\" value=\"alternate\"/>\n")
+	}
+}
@@ -0,0 +1,65 @@
+package dash
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"../fmp4"
+)
+
+// TestManifest 测试MPD生成
+func TestManifest(t *testing.T) {
+	video := &representation{
+		id:       "video",
+		track:    &fmp4.Track{ID: 1, Type: fmp4.TrackVideo, Timescale: 90000, Width: 1280, Height: 720, Config: []byte{1, 0x64, 0, 0x1f}},
+		offset:   9000,
+		timeline: []entry{{9000, 180000, 225000}, {189000, 180000, 225000}, {369000, 180000, 225000}},
+	}
+	audio := &representation{
+		id:        "audio1",
+		alternate: true,
+		track:     &fmp4.Track{ID: 2, Type: fmp4.TrackAudio, Timescale: 48000, SampleRate: 48000, Channels: 2, Config: []byte{0x11, 0x90}},
+		timeline:  []entry{{0, 96000, 32000}},
+	}
+	start := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	var tests = []struct {
+		in       manifest // input
+		expected []string // expected substrings
+	}{
+		{
+			manifest{period: 3, availability: start, publish: start.Add(6 * time.Second), target: 2 * time.Second, window: 2, representations: []*representation{video}},
+			[]string{
+				`availabilityStartTime="2020-01-02T03:04:05.000Z" publishTime="2020-01-02T03:04:11.000Z" minimumUpdatePeriod="PT2.000S"`,
+				`timeShiftBufferDepth="PT4.000S"`,
+				`<Representation id="video" codecs="avc1.64001f" bandwidth="900000" width="1280" height="720">`,
+				`presentationTimeOffset="9000" initialization="3-$RepresentationID$-init.mp4" media="3-$RepresentationID$-$Time$.m4s"`,
+				"<SegmentTimeline>\n            <S t=\"189000\" d=\"180000\"/>\n            <S t=\"369000\" d=\"180000\"/>\n          </SegmentTimeline>",
+			},
+		},
+		{
+			manifest{period: 3, availability: start, publish: start, target: 2 * time.Second, window: 6, ended: true, duration: 6 * time.Second, representations: []*representation{video}},
+			[]string{`mediaPresentationDuration="PT6.000S" minBufferTime="PT2.000S"`, `<S t="9000" d="180000"/>`},
+		},
+		// 多轨道的替代音频轨道
+		{
+			manifest{period: 3, availability: start, publish: start, target: 2 * time.Second, window: 6, representations: []*representation{video, audio}},
+			[]string{
+				"<AdaptationSet id=\"1\" contentType=\"audio\" mimeType=\"audio/mp4\" segmentAlignment=\"true\" startWithSAP=\"1\">\n      <Role schemeIdUri=\"urn:mpeg:dash:role:2011\" value=\"alternate\"/>",
+				`<Representation id="audio1" codecs="mp4a.40.2" bandwidth="128000" audioSamplingRate="48000">`,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		actual := string(test.in.Bytes())
+		for _, expected := range test.expected {
+			if !strings.Contains(actual, expected) {
+				t.Errorf("[×] out: %q expected: %q\n", actual, expected)
+			} else {
+				t.Logf("[√] expected: %q\n", expected)
+			}
+		}
+	}
+}
@@ -0,0 +1,506 @@
+package dash
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"time"
+
+	"../flv"
+	"../fmp4"
+	c "../lib/colorful"
+	"../rtmp"
+	"../rtmp/amf"
+	"github.com/pkg/errors"
+)
+
+/*
+
+DASH 分片器
+
+作为封装输出订阅推流，将H.264/H.265/AV1/VP9与AAC分别封装为视频与音频的fMP4分片，在达到目标时长后的第一个关键帧处切分
+分片名称带有推流的周期序号，重新推流后不会与上一次推流的分片重名
+分片器封装轨道0，多轨道的流中其他轨道由子分片器封装，在同一个MPD中作为替代的自适应集，表示ID如 audio1 video1
+
+*/
+
+// audioOnlyFrames 没有元数据时，收到多少个音频帧仍没有视频序列头则视为纯音频流
+const audioOnlyFrames = 50
+
+// aacFrameSamples 每个AAC帧的采样数
+const aacFrameSamples = 1024
+
+// 表示的ID
+const (
+	representationVideo = "video"
+	representationAudio = "audio"
+)
+
+// 轨道ID
+const (
+	trackIDVideo = uint32(1)
+	trackIDAudio = uint32(2)
+)
+
+// Segmenter 一条流的DASH分片器
+type Segmenter struct {
+	Name   string // 应用/流名称
+	server *Server
+	queue  *rtmp.Queue
+	period uint64 // 周期序号
+
+	parent     *Segmenter   // 子分片器所属的分片器，子分片器不单独写出MPD
+	suffix     string       // 子分片器表示ID的后缀，即轨道ID
+	videoTrack uint8        // 封装的视频轨道
+	audioTrack uint8        // 封装的音频轨道
+	noVideo    bool         // 不封装视频
+	noAudio    bool         // 不封装音频
+	children   []*Segmenter // 子分片器
+
+	videoCodec   string // 视频编码的FourCC
+	videoConfig  []byte // 视频的解码配置记录
+	audioConfig  []byte // AudioSpecificConfig
+	metadata     bool   // 是否收到元数据
+	metaHasVideo bool   // 元数据中是否有视频
+	audioFrames  int    // 没有视频序列头时收到的音频帧数
+
+	video *representation
+	audio *representation
+
+	started       bool      // 是否已开始第一个分片
+	startTime     time.Time // 第一个分片开始的时间
+	firstStart    uint32    // 第一个分片开始的时间戳
+	segmentStart  uint32    // 当前分片开始的时间戳
+	lastTimestamp uint32    // 最后一帧的时间戳
+	frameDelta    uint32    // 最近两帧的时间戳间隔，用于估计最后一帧的时长
+	sequence      uint32    // moof序号
+}
+
+// newSegmenter 新建分片器
+func newSegmenter(server *Server, name string, period uint64) *Segmenter {
+	return &Segmenter{
+		Name:   name,
+		server: server,
+		queue:  rtmp.NewQueue(server.Config.Queue),
+		period: period,
+	}
+}
+
+// run 处理音视频帧直到推流结束
+func (seg *Segmenter) run() {
+	for {
+		select {
+		case frame := <-seg.queue.Frames:
+			seg.handle(frame)
+		case <-seg.queue.Done():
+			seg.drain()
+			seg.finish()
+			return
+		}
+	}
+}
+
+// drain 处理推流结束前已加入队列的音视频帧
+func (seg *Segmenter) drain() {
+	for {
+		select {
+		case frame := <-seg.queue.Frames:
+			seg.handle(frame)
+		default:
+			return
+		}
+	}
+}
+
+// handle 处理一个音视频帧
+func (seg *Segmenter) handle(frame *rtmp.Frame) {
+	msg := &frame.Message
+	if seg.parent == nil {
+		seg.dispatch(frame)
+	}
+	if !seg.selected(msg) {
+		return
+	}
+
+	var err error
+	switch msg.Type {
+	case rtmp.RTMPTypeAMFData:
+		seg.handleMetadata(msg.Data)
+	case rtmp.RTMPTypeVideoData:
+		err = seg.handleVideo(msg)
+	case rtmp.RTMPTypeAudioData:
+		err = seg.handleAudio(msg)
+	}
+	if err != nil {
+		log.Println(c.Front("DASH %s: %v", c.R, seg.Name, err))
+	}
+}
+
+// selected 消息是否属于封装的轨道
+func (seg *Segmenter) selected(msg *rtmp.Message) bool {
+	switch msg.Type {
+	case rtmp.RTMPTypeVideoData:
+		return !seg.noVideo && msg.TrackID == seg.videoTrack
+	case rtmp.RTMPTypeAudioData:
+		return !seg.noAudio && msg.TrackID == seg.audioTrack
+	}
+	return true
+}
+
+// dispatch 将帧交给子分片器，收到轨道0之外的新轨道时为其增加子分片器
+func (seg *Segmenter) dispatch(frame *rtmp.Frame) {
+	msg := &frame.Message
+	if (msg.Type == rtmp.RTMPTypeVideoData || msg.Type == rtmp.RTMPTypeAudioData) && msg.TrackID != 0 {
+		found := false
+		for _, child := range seg.children {
+			if child.selected(msg) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			child := &Segmenter{
+				Name:   seg.Name,
+				server: seg.server,
+				period: seg.period,
+				parent: seg,
+				suffix: fmt.Sprintf("%d", msg.TrackID),
+			}
+			if msg.Type == rtmp.RTMPTypeVideoData {
+				child.videoTrack, child.noAudio = msg.TrackID, true
+			} else {
+				child.audioTrack, child.noVideo = msg.TrackID, true
+			}
+			seg.children = append(seg.children, child)
+		}
+	}
+	for _, child := range seg.children {
+		child.handle(frame)
+	}
+}
+
+// handleMetadata 从元数据中判断是否有视频
+func (seg *Segmenter) handleMetadata(data []byte) {
+	array, err := amf.ByteToAMFArray(data)
+	if err != nil || len(array) < 2 {
+		return
+	}
+	if name, _ := array[0].Value().(string); name != "onMetaData" {
+		return
+	}
+	if metadata, ok := array[1].Value().(map[string]interface{}); ok {
+		_, seg.metaHasVideo = metadata["videocodecid"]
+		seg.metadata = true
+	}
+}
+
+// handleVideo 处理H.264/H.265/AV1/VP9视频帧
+func (seg *Segmenter) handleVideo(msg *rtmp.Message) error {
+	tag, err := flv.ParseVideoTag(msg.Data)
+	if err != nil || tag.FourCC == "" {
+		return nil
+	}
+	if tag.SequenceHeader() {
+		return errors.WithStack(seg.setVideoConfig(tag.FourCC, tag.Body))
+	}
+	if !tag.Frame() || seg.videoConfig == nil || tag.FourCC != seg.videoCodec {
+		return nil
+	}
+
+	keyFrame := tag.KeyFrame()
+	if keyFrame && (!seg.started || seg.elapsed(msg.Timestamp) >= seg.server.targetDuration()) {
+		if err := seg.cut(msg.Timestamp); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	if !seg.started || seg.video == nil {
+		return nil
+	}
+
+	seg.setTimestamp(msg.Timestamp)
+	seg.video.buffer.Add(uint64(msg.Timestamp)*90, fmp4.Sample{
+		CompositionOffset: tag.CompositionTime * 90,
+		KeyFrame:          keyFrame,
+		Data:              append([]byte{}, tag.Body...),
+	})
+	return nil
+}
+
+// handleAudio 处理AAC音频帧
+func (seg *Segmenter) handleAudio(msg *rtmp.Message) error {
+	data := msg.Data
+	if len(data) < 2 || data[0]>>4 != 10 {
+		// 仅支持AAC
+		return nil
+	}
+	if data[1] == 0 {
+		return errors.WithStack(seg.setAudioConfig(data[2:]))
+	}
+	if seg.audioConfig == nil {
+		return nil
+	}
+	if seg.videoConfig == nil {
+		seg.audioFrames++
+	}
+
+	if seg.audioOnly() && (!seg.started || seg.elapsed(msg.Timestamp) >= seg.server.targetDuration()) {
+		if err := seg.cut(msg.Timestamp); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	if !seg.started || seg.audio == nil {
+		return nil
+	}
+
+	seg.setTimestamp(msg.Timestamp)
+	timescale := uint64(seg.audio.track.Timescale)
+	seg.audio.buffer.Add(uint64(msg.Timestamp)*timescale/1000, fmp4.Sample{
+		Duration: aacFrameSamples,
+		KeyFrame: true,
+		Data:     append([]byte{}, data[2:]...),
+	})
+	return nil
+}
+
+// setVideoConfig 更新视频序列头，开始分片后变化时重新生成初始化分片
+func (seg *Segmenter) setVideoConfig(codec string, record []byte) error {
+	if codec == seg.videoCodec && bytes.Equal(record, seg.videoConfig) {
+		return nil
+	}
+	if _, err := fmp4.NewCodecTrack(trackIDVideo, codec, record); err != nil {
+		return errors.WithStack(err)
+	}
+	seg.videoCodec = codec
+	seg.videoConfig = append([]byte{}, record...)
+	if seg.video == nil {
+		return nil
+	}
+	track, err := fmp4.NewCodecTrack(seg.video.track.ID, codec, record)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	seg.video.track = track
+	seg.video.buffer.Track = track
+	return errors.WithStack(seg.putInit(seg.video))
+}
+
+// setAudioConfig 更新音频序列头，开始分片后变化时重新生成初始化分片
+func (seg *Segmenter) setAudioConfig(config []byte) error {
+	if bytes.Equal(config, seg.audioConfig) {
+		return nil
+	}
+	seg.audioConfig = append([]byte{}, config...)
+	if seg.audio == nil {
+		return nil
+	}
+	track, err := fmp4.NewAudioTrack(seg.audio.track.ID, config)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	seg.audio.track = track
+	seg.audio.buffer.Track = track
+	return errors.WithStack(seg.putInit(seg.audio))
+}
+
+// audioOnly 是否为纯音频流，纯音频流在音频帧处切分
+func (seg *Segmenter) audioOnly() bool {
+	if seg.videoConfig != nil {
+		return false
+	}
+	if seg.noVideo {
+		return true
+	}
+	if seg.metadata {
+		return !seg.metaHasVideo
+	}
+	return seg.audioFrames > audioOnlyFrames
+}
+
+// setTimestamp 记录最后一帧的时间戳
+func (seg *Segmenter) setTimestamp(timestamp uint32) {
+	if timestamp > seg.lastTimestamp {
+		seg.frameDelta = timestamp - seg.lastTimestamp
+	}
+	seg.lastTimestamp = timestamp
+}
+
+// elapsed 当前分片已有的时长
+func (seg *Segmenter) elapsed(timestamp uint32) time.Duration {
+	if timestamp < seg.segmentStart {
+		return 0
+	}
+	return time.Duration(timestamp-seg.segmentStart) * time.Millisecond
+}
+
+// cut 结束当前分片并从timestamp开始新的分片，第一个分片开始时确定周期中的轨道
+func (seg *Segmenter) cut(timestamp uint32) error {
+	if seg.started {
+		if err := seg.finishSegment(timestamp); err != nil {
+			return errors.WithStack(err)
+		}
+		if err := seg.writeManifest(false, timestamp); err != nil {
+			return errors.WithStack(err)
+		}
+	} else {
+		if err := seg.start(timestamp); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	seg.segmentStart = timestamp
+	seg.lastTimestamp = timestamp
+	return nil
+}
+
+// start 开始第一个分片，保存初始化分片
+func (seg *Segmenter) start(timestamp uint32) error {
+	if seg.videoConfig != nil {
+		track, err := fmp4.NewCodecTrack(trackIDVideo, seg.videoCodec, seg.videoConfig)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		seg.video = &representation{
+			id:        representationVideo + seg.suffix,
+			alternate: seg.parent != nil,
+			track:     track,
+			buffer:    fmp4.NewSampleBuffer(track),
+			offset:    uint64(timestamp) * 90,
+		}
+	}
+	if seg.audioConfig != nil {
+		track, err := fmp4.NewAudioTrack(trackIDAudio, seg.audioConfig)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		seg.audio = &representation{
+			id:        representationAudio + seg.suffix,
+			alternate: seg.parent != nil,
+			track:     track,
+			buffer:    fmp4.NewSampleBuffer(track),
+			offset:    uint64(timestamp) * uint64(track.Timescale) / 1000,
+		}
+	}
+	for _, rep := range seg.representations() {
+		if err := seg.putInit(rep); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	seg.started = true
+	seg.startTime = time.Now()
+	seg.firstStart = timestamp
+	return nil
+}
+
+// representations 周期中的轨道
+func (seg *Segmenter) representations() []*representation {
+	var reps []*representation
+	if seg.video != nil {
+		reps = append(reps, seg.video)
+	}
+	if seg.audio != nil {
+		reps = append(reps, seg.audio)
+	}
+	return reps
+}
+
+// allRepresentations 周期中包括子分片器在内的所有轨道
+func (seg *Segmenter) allRepresentations() []*representation {
+	reps := seg.representations()
+	for _, child := range seg.children {
+		reps = append(reps, child.representations()...)
+	}
+	return reps
+}
+
+// putInit 保存轨道的初始化分片
+func (seg *Segmenter) putInit(rep *representation) error {
+	name := fmt.Sprintf("%s/%d-%s-init.mp4", seg.Name, seg.period, rep.id)
+	return errors.WithStack(seg.server.Storage.Put(name, fmp4.InitSegment([]*fmp4.Track{rep.track})))
+}
+
+// finishSegment 保存各轨道的当前分片，end为分片结束的时间戳
+func (seg *Segmenter) finishSegment(end uint32) error {
+	for _, rep := range seg.representations() {
+		if rep.buffer.Len() == 0 {
+			continue
+		}
+		fragment := rep.buffer.Take(uint64(end) * uint64(rep.track.Timescale) / 1000)
+		seg.sequence++
+		data := fmp4.AppendFragment(nil, seg.sequence, []fmp4.Fragment{fragment})
+		e := entry{
+			time:     fragment.BaseTime,
+			duration: fragment.Duration(),
+			size:     len(data),
+		}
+		if err := seg.server.Storage.Put(seg.Name+"/"+e.name(seg.period, rep.id), data); err != nil {
+			return errors.WithStack(err)
+		}
+		rep.timeline = append(rep.timeline, e)
+
+		// 删除超出保留数量的分片
+		keep := seg.server.Config.Window + seg.server.Config.Retention
+		for len(rep.timeline) > keep {
+			seg.server.Storage.Delete(seg.Name + "/" + rep.timeline[0].name(seg.period, rep.id))
+			rep.timeline = rep.timeline[1:]
+		}
+	}
+	return nil
+}
+
+// writeManifest 写出MPD，子分片器的轨道由所属的分片器写出
+func (seg *Segmenter) writeManifest(ended bool, end uint32) error {
+	if seg.parent != nil {
+		return nil
+	}
+	m := &manifest{
+		period:          seg.period,
+		availability:    seg.startTime,
+		publish:         time.Now(),
+		target:          seg.server.targetDuration(),
+		window:          seg.server.Config.Window,
+		ended:           ended,
+		representations: seg.allRepresentations(),
+	}
+	if end > seg.firstStart {
+		m.duration = time.Duration(end-seg.firstStart) * time.Millisecond
+	}
+	return errors.WithStack(seg.server.putManifest(seg, m.Bytes()))
+}
+
+// finish 推流结束，保存最后一个分片并在MPD中标记结束
+func (seg *Segmenter) finish() {
+	defer seg.server.finished(seg)
+
+	for _, child := range seg.children {
+		child.finish()
+	}
+	if !seg.started {
+		return
+	}
+	// 最后一帧的时长按前两帧的间隔估计
+	end := seg.lastTimestamp + seg.frameDelta
+	err := seg.finishSegment(end)
+	if err == nil {
+		err = seg.writeManifest(true, end)
+	}
+	if err != nil {
+		log.Println(c.Front("DASH %s: %v", c.R, seg.Name, err))
+	}
+	if seg.parent == nil {
+		log.Println(c.Front("DASH %s ended", c.G, seg.Name))
+	}
+}
+
+// cleanup 删除分片器与子分片器生成的分片
+func (seg *Segmenter) cleanup() {
+	for _, child := range seg.children {
+		child.cleanup()
+	}
+	for _, rep := range seg.representations() {
+		for _, e := range rep.timeline {
+			seg.server.Storage.Delete(seg.Name + "/" + e.name(seg.period, rep.id))
+		}
+		rep.timeline = nil
+		seg.server.Storage.Delete(fmt.Sprintf("%s/%d-%s-init.mp4", seg.Name, seg.period, rep.id))
+	}
+}
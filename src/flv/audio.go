@@ -0,0 +1,237 @@
+package flv
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+/*
+
+音频标签头
+
+传统标签头为 SoundFormat(4位) SoundRate(2位) SoundSize(1位) SoundType(1位)，AAC之后为 AACPacketType
+Enhanced RTMP 的 SoundFormat 为9，之后为 AudioPacketType(4位) 与 4字节的 FourCC
+	AudioPacketType 为 Multitrack 时，之后为与视频相同的多轨道格式
+两种标签头统一解析为 AudioTag，传统的 AAC 与 MP3 以对应的 FourCC 表示
+
+*/
+
+// 传统标签头中的音频格式
+const (
+	SoundFormatPCM           = uint8(0) // 平台字节序
+	SoundFormatADPCM         = uint8(1)
+	SoundFormatMP3           = uint8(2)
+	SoundFormatPCMLE         = uint8(3)
+	SoundFormatNellymoser16k = uint8(4)
+	SoundFormatNellymoser8k  = uint8(5)
+	SoundFormatNellymoser    = uint8(6)
+	SoundFormatALaw          = uint8(7)
+	SoundFormatMuLaw         = uint8(8)
+	SoundFormatExHeader      = uint8(9) // Enhanced RTMP 标签头
+	SoundFormatAAC           = uint8(10)
+	SoundFormatSpeex         = uint8(11)
+	SoundFormatMP38k         = uint8(14) // 8kHz的MP3
+)
+
+// soundFormatNames 传统音频格式的名称
+var soundFormatNames = map[uint8]string{
+	SoundFormatPCM:           "pcm",
+	SoundFormatADPCM:         "adpcm",
+	SoundFormatMP3:           "mp3",
+	SoundFormatPCMLE:         "pcm",
+	SoundFormatNellymoser16k: "nellymoser",
+	SoundFormatNellymoser8k:  "nellymoser",
+	SoundFormatNellymoser:    "nellymoser",
+	SoundFormatALaw:          "alaw",
+	SoundFormatMuLaw:         "ulaw",
+	SoundFormatAAC:           "aac",
+	SoundFormatSpeex:         "speex",
+	SoundFormatMP38k:         "mp3",
+}
+
+// soundRates SoundRate 对应的采样率
+var soundRates = []int{5512, 11025, 22050, 44100}
+
+// 音频编码的 FourCC
+const (
+	FourCCAAC  = "mp4a"
+	FourCCMP3  = ".mp3"
+	FourCCOpus = "Opus"
+	FourCCFLAC = "fLaC"
+	FourCCAC3  = "ac-3"
+	FourCCEAC3 = "ec-3"
+)
+
+// Enhanced RTMP 的 AudioPacketType，传统标签头的 AACPacketType 0 1 与前两种一致
+const (
+	AudioPacketTypeSequenceStart      = uint8(0) // 序列头，之后为解码配置
+	AudioPacketTypeCodedFrames        = uint8(1) // 音频帧
+	AudioPacketTypeSequenceEnd        = uint8(2) // 序列结束
+	AudioPacketTypeMultichannelConfig = uint8(4) // 声道布局
+	AudioPacketTypeMultitrack         = uint8(5) // 多轨道
+	AudioPacketTypeModEx              = uint8(7) // 扩展标签头
+)
+
+// AudioTag 解析后的音频标签
+type AudioTag struct {
+	SoundFormat uint8
+	SoundRate   uint8
+	SoundSize   uint8
+	SoundType   uint8
+	FourCC      string // 编码的 FourCC，不支持的传统编码为空
+	Enhanced    bool   // 是否为 Enhanced RTMP 标签头
+	PacketType  uint8  // 统一为 Enhanced RTMP 的 AudioPacketType
+	TrackID     uint8  // Multitrack 的轨道ID，其他标签为0
+	Body        []byte // 序列头为解码配置，音频帧为编码后的数据
+}
+
+// IsMultitrackAudio 是否为 Enhanced RTMP 的多轨道音频标签
+func IsMultitrackAudio(data []byte) bool {
+	return len(data) > 0 && data[0]>>4 == SoundFormatExHeader && data[0]&0x0f == AudioPacketTypeMultitrack
+}
+
+// ParseAudioTag 解析音频标签头，Body引用data，多轨道标签使用 ParseAudioTracks
+func ParseAudioTag(data []byte) (AudioTag, error) {
+	var tag AudioTag
+	if len(data) < 1 {
+		return tag, errors.New("audio tag too short")
+	}
+	tag.SoundFormat = data[0] >> 4
+	if tag.SoundFormat == SoundFormatExHeader {
+		if len(data) < 5 {
+			return tag, errors.New("audio tag too short")
+		}
+		tag.Enhanced = true
+		tag.PacketType = data[0] & 0x0f
+		tag.FourCC = string(data[1:5])
+		tag.Body = data[5:]
+		return tag, checkExAudioPacketType(tag.PacketType)
+	}
+
+	tag.SoundRate = data[0] >> 2 & 0x03
+	tag.SoundSize = data[0] >> 1 & 0x01
+	tag.SoundType = data[0] & 0x01
+	tag.PacketType = AudioPacketTypeCodedFrames
+	tag.Body = data[1:]
+	switch tag.SoundFormat {
+	case SoundFormatAAC:
+		if len(data) < 2 {
+			return tag, errors.New("audio tag too short")
+		}
+		tag.FourCC = FourCCAAC
+		tag.PacketType = data[1]
+		tag.Body = data[2:]
+	case SoundFormatMP3, SoundFormatMP38k:
+		tag.FourCC = FourCCMP3
+	}
+	return tag, nil
+}
+
+// checkExAudioPacketType 检查单个轨道的 AudioPacketType 是否支持
+func checkExAudioPacketType(packetType uint8) error {
+	switch packetType {
+	case AudioPacketTypeSequenceStart, AudioPacketTypeCodedFrames, AudioPacketTypeSequenceEnd, AudioPacketTypeMultichannelConfig:
+		return nil
+	case AudioPacketTypeMultitrack:
+		return errors.New("multitrack audio tag")
+	}
+	return errors.Errorf("audio packet type %d not supported", packetType)
+}
+
+// ParseAudioTracks 解析音频标签中的所有轨道，不是多轨道标签时返回轨道0，Body引用data
+func ParseAudioTracks(data []byte) ([]AudioTag, error) {
+	if !IsMultitrackAudio(data) {
+		tag, err := ParseAudioTag(data)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return []AudioTag{tag}, nil
+	}
+	if len(data) < 2 {
+		return nil, errors.New("audio tag too short")
+	}
+	packetType := data[1] & 0x0f
+	if err := checkExAudioPacketType(packetType); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var tags []AudioTag
+	err := parseTracks(data[1:], func(fourCC string, trackID uint8, body []byte) error {
+		tags = append(tags, AudioTag{
+			SoundFormat: SoundFormatExHeader,
+			FourCC:      fourCC,
+			Enhanced:    true,
+			PacketType:  packetType,
+			TrackID:     trackID,
+			Body:        body,
+		})
+		return nil
+	})
+	return tags, errors.WithStack(err)
+}
+
+// AppendAudioTag 将单个轨道的音频标签编码后追加到buf，Enhanced为false时使用传统标签头
+func AppendAudioTag(buf []byte, tag *AudioTag) []byte {
+	if !tag.Enhanced {
+		buf = append(buf, tag.SoundFormat<<4|tag.SoundRate<<2|tag.SoundSize<<1|tag.SoundType)
+		if tag.SoundFormat == SoundFormatAAC {
+			buf = append(buf, tag.PacketType)
+		}
+		return append(buf, tag.Body...)
+	}
+	buf = append(buf, SoundFormatExHeader<<4|tag.PacketType)
+	buf = append(buf, tag.FourCC...)
+	return append(buf, tag.Body...)
+}
+
+// SequenceHeader 是否为支持的编码的序列头
+func (tag *AudioTag) SequenceHeader() bool {
+	return tag.FourCC != "" && tag.PacketType == AudioPacketTypeSequenceStart
+}
+
+// Frame 是否为音频帧
+func (tag *AudioTag) Frame() bool {
+	return tag.PacketType == AudioPacketTypeCodedFrames
+}
+
+// FormatName 音频编码名称，Enhanced RTMP 为 FourCC，传统编码为格式名称
+func (tag *AudioTag) FormatName() string {
+	if tag.Enhanced {
+		return tag.FourCC
+	}
+	if name, ok := soundFormatNames[tag.SoundFormat]; ok {
+		return name
+	}
+	return fmt.Sprintf("format %d", tag.SoundFormat)
+}
+
+// SampleRate 传统标签头中的采样率，部分格式的采样率固定，Enhanced RTMP 标签头为0
+// AAC 的标签头固定为44kHz，实际采样率在 AudioSpecificConfig 中
+func (tag *AudioTag) SampleRate() int {
+	if tag.Enhanced {
+		return 0
+	}
+	switch tag.SoundFormat {
+	case SoundFormatNellymoser16k, SoundFormatSpeex:
+		return 16000
+	case SoundFormatNellymoser8k, SoundFormatALaw, SoundFormatMuLaw, SoundFormatMP38k:
+		return 8000
+	}
+	return soundRates[tag.SoundRate]
+}
+
+// SampleSize 传统标签头中的采样位数，Enhanced RTMP 标签头为0
+func (tag *AudioTag) SampleSize() int {
+	if tag.Enhanced {
+		return 0
+	}
+	return 8 << tag.SoundSize
+}
+
+// Channels 传统标签头中的声道数，Enhanced RTMP 标签头为0
+func (tag *AudioTag) Channels() int {
+	if tag.Enhanced {
+		return 0
+	}
+	return int(tag.SoundType) + 1
+}
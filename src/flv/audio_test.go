@@ -0,0 +1,88 @@
+package flv
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestParseAudioTracks 测试传统、Enhanced RTMP 与多轨道音频标签的解析
+func TestParseAudioTracks(t *testing.T) {
+	type track struct {
+		fourCC         string
+		trackID        uint8
+		sequenceHeader bool
+		body           []byte
+	}
+	var tests = []struct {
+		in       []byte  // input
+		expected []track // expected result
+	}{
+		// 传统的 AAC 序列头与音频帧，MP3
+		{[]byte{0xaf, 0, 0x12, 0x10}, []track{{FourCCAAC, 0, true, []byte{0x12, 0x10}}}},
+		{[]byte{0xaf, 1, 9}, []track{{FourCCAAC, 0, false, []byte{9}}}},
+		{[]byte{0x2f, 9}, []track{{FourCCMP3, 0, false, []byte{9}}}},
+		// Enhanced RTMP 的 Opus 序列头
+		{[]byte{0x90, 'O', 'p', 'u', 's', 1}, []track{{FourCCOpus, 0, true, []byte{1}}}},
+		// ManyTracks 的两个 AAC 轨道
+		{[]byte{0x95, 0x11, 'm', 'p', '4', 'a', 0, 0, 0, 1, 8, 1, 0, 0, 1, 9}, []track{{FourCCAAC, 0, false, []byte{8}}, {FourCCAAC, 1, false, []byte{9}}}},
+		// ManyTracksManyCodecs 的 AAC 与 Opus 序列头
+		{[]byte{0x95, 0x20, 'm', 'p', '4', 'a', 0, 0, 0, 1, 8, 'O', 'p', 'u', 's', 1, 0, 0, 1, 9}, []track{{FourCCAAC, 0, true, []byte{8}}, {FourCCOpus, 1, true, []byte{9}}}},
+		// 不支持的 ModEx
+		{[]byte{0x97, 'O', 'p', 'u', 's', 1}, nil},
+	}
+
+	for _, test := range tests {
+		tags, err := ParseAudioTracks(test.in)
+		var actual []track
+		for _, tag := range tags {
+			actual = append(actual, track{tag.FourCC, tag.TrackID, tag.SequenceHeader(), tag.Body})
+		}
+		ok := (err == nil) == (test.expected != nil) && len(actual) == len(test.expected)
+		for i := 0; ok && i < len(actual); i++ {
+			ok = actual[i].fourCC == test.expected[i].fourCC &&
+				actual[i].trackID == test.expected[i].trackID &&
+				actual[i].sequenceHeader == test.expected[i].sequenceHeader &&
+				bytes.Equal(actual[i].body, test.expected[i].body)
+		}
+		// 拆分出的轨道重新编码后应解析为相同的标签
+		for i := 0; ok && i < len(tags); i++ {
+			tag, err := ParseAudioTag(AppendAudioTag(nil, &tags[i]))
+			ok = err == nil && tag.FourCC == tags[i].FourCC && tag.PacketType == tags[i].PacketType && bytes.Equal(tag.Body, tags[i].Body)
+		}
+		if !ok {
+			t.Errorf("[×] in: %v out: %v %v expected: %v\n", test.in, actual, err, test.expected)
+		} else {
+			t.Logf("[√] in: %v out: %v expected: %v\n", test.in, actual, test.expected)
+		}
+	}
+}
+
+// TestAudioTagFormat 测试传统标签头中的采样率、位数与声道数
+func TestAudioTagFormat(t *testing.T) {
+	type result struct {
+		name       string
+		sampleRate int
+		sampleSize int
+		channels   int
+	}
+	var tests = []struct {
+		in       []byte // input
+		expected result // expected result
+	}{
+		{[]byte{0x2f, 9}, result{"mp3", 44100, 16, 2}},
+		{[]byte{0x22, 9}, result{"mp3", 5512, 16, 1}},
+		{[]byte{0x72, 9}, result{"alaw", 8000, 16, 1}},
+		{[]byte{0xb6, 9}, result{"speex", 16000, 16, 1}},
+		{[]byte{0x90, 'O', 'p', 'u', 's', 1}, result{FourCCOpus, 0, 0, 0}},
+	}
+
+	for _, test := range tests {
+		tag, err := ParseAudioTag(test.in)
+		actual := result{tag.FormatName(), tag.SampleRate(), tag.SampleSize(), tag.Channels()}
+		if err != nil || actual != test.expected {
+			t.Errorf("[×] in: %v out: %v %v expected: %v\n", test.in, actual, err, test.expected)
+		} else {
+			t.Logf("[√] in: %v out: %v expected: %v\n", test.in, actual, test.expected)
+		}
+	}
+}
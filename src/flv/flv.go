@@ -0,0 +1,197 @@
+package flv
+
+import (
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+)
+
+/*
+
+FLV 封装
+
+文件头(9字节)与第一个 PreviousTagSize 之后为连续的标签，每个标签之后为该标签的 PreviousTagSize
+Reader 与 Writer 以流的方式读写，可用于文件、HTTP-FLV 与 WebSocket-FLV
+
+*/
+
+// FLV 标签类型，与RTMP消息类型一致
+const (
+	TagTypeAudio  = uint8(8)
+	TagTypeVideo  = uint8(9)
+	TagTypeScript = uint8(18)
+)
+
+// HeaderSize FLV文件头长度，包含第一个PreviousTagSize
+const HeaderSize = 13
+
+// TagHeaderSize FLV标签头长度
+const TagHeaderSize = 11
+
+// Header 生成FLV文件头，包含第一个PreviousTagSize
+func Header(hasAudio bool, hasVideo bool) []byte {
+	header := []byte{'F', 'L', 'V', 1, 0, 0, 0, 0, 9, 0, 0, 0, 0}
+	if hasAudio {
+		header[4] |= 0x04
+	}
+	if hasVideo {
+		header[4] |= 0x01
+	}
+	return header
+}
+
+// AppendTag 在buf后追加一个FLV标签及其PreviousTagSize
+func AppendTag(buf []byte, tagType uint8, timestamp uint32, data []byte) []byte {
+	buf = appendTagHeader(buf, tagType, timestamp, uint32(len(data)))
+	buf = append(buf, data...)
+	return appendTagSize(buf, uint32(len(data)))
+}
+
+// appendTagHeader 追加FLV标签头，时间戳高8位写入扩展时间戳字段
+func appendTagHeader(buf []byte, tagType uint8, timestamp uint32, dataSize uint32) []byte {
+	return append(buf,
+		tagType,
+		byte(dataSize>>16), byte(dataSize>>8), byte(dataSize),
+		byte(timestamp>>16), byte(timestamp>>8), byte(timestamp), byte(timestamp>>24),
+		0, 0, 0,
+	)
+}
+
+// appendTagSize 追加标签之后的PreviousTagSize
+func appendTagSize(buf []byte, dataSize uint32) []byte {
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], TagHeaderSize+dataSize)
+	return append(buf, size[:]...)
+}
+
+// Writer 向io.Writer写出FLV数据
+type Writer struct {
+	w     io.Writer
+	buf   []byte
+	whole bool // 每个标签通过一次Write写出
+}
+
+// NewWriter 新建FLV写出对象
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// NewTagWriter 新建FLV写出对象，文件头与每个标签各通过一次Write写出，用于WebSocket等按消息分帧的连接
+func NewTagWriter(w io.Writer) *Writer {
+	return &Writer{w: w, whole: true}
+}
+
+// WriteHeader 写出FLV文件头
+func (writer *Writer) WriteHeader(hasAudio bool, hasVideo bool) error {
+	_, err := writer.w.Write(Header(hasAudio, hasVideo))
+	return errors.WithStack(err)
+}
+
+// WriteTag 写出一个FLV标签，标签数据不经过复制直接写出，NewTagWriter新建的对象复制后一次写出
+func (writer *Writer) WriteTag(tagType uint8, timestamp uint32, data []byte) error {
+	writer.buf = appendTagHeader(writer.buf[:0], tagType, timestamp, uint32(len(data)))
+	if writer.whole {
+		writer.buf = append(writer.buf, data...)
+		writer.buf = appendTagSize(writer.buf, uint32(len(data)))
+		_, err := writer.w.Write(writer.buf)
+		return errors.WithStack(err)
+	}
+	if _, err := writer.w.Write(writer.buf); err != nil {
+		return errors.WithStack(err)
+	}
+	if _, err := writer.w.Write(data); err != nil {
+		return errors.WithStack(err)
+	}
+	writer.buf = appendTagSize(writer.buf[:0], uint32(len(data)))
+	_, err := writer.w.Write(writer.buf)
+	return errors.WithStack(err)
+}
+
+// Write 写出一个FLV标签
+func (writer *Writer) Write(tag *Tag) error {
+	return writer.WriteTag(tag.typeByte(), tag.Timestamp, tag.Data)
+}
+
+// WriteScript 写出一个脚本数据标签
+func (writer *Writer) WriteScript(timestamp uint32, script *ScriptData) error {
+	data, err := script.Bytes()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return writer.WriteTag(TagTypeScript, timestamp, data)
+}
+
+// Reader 从io.Reader读入FLV数据
+type Reader struct {
+	Strict bool // 为true时 PreviousTagSize 与标签长度不一致返回错误，异常中断后拼接的文件中可能不一致
+
+	r      io.Reader
+	header [TagHeaderSize]byte
+}
+
+// NewReader 新建FLV读入对象
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: r}
+}
+
+// ReadHeader 读入FLV文件头与第一个PreviousTagSize，返回是否有音频与视频
+func (reader *Reader) ReadHeader() (bool, bool, error) {
+	var header [HeaderSize]byte
+	if _, err := io.ReadFull(reader.r, header[:]); err != nil {
+		return false, false, errors.WithStack(err)
+	}
+	if header[0] != 'F' || header[1] != 'L' || header[2] != 'V' {
+		return false, false, errors.New("not a flv file")
+	}
+	// 文件头长度大于9时跳过多余的部分
+	if offset := binary.BigEndian.Uint32(header[5:9]); offset > 9 {
+		if _, err := io.CopyN(ioutil.Discard, reader.r, int64(offset-9)); err != nil {
+			return false, false, errors.WithStack(err)
+		}
+	}
+	return header[4]&0x04 != 0, header[4]&0x01 != 0, nil
+}
+
+// ReadTagHeader 读入FLV标签头，返回标签类型、时间戳与数据长度
+func (reader *Reader) ReadTagHeader() (uint8, uint32, uint32, error) {
+	if _, err := io.ReadFull(reader.r, reader.header[:]); err != nil {
+		return 0, 0, 0, err
+	}
+	header := reader.header
+	dataSize := uint32(header[1])<<16 | uint32(header[2])<<8 | uint32(header[3])
+	timestamp := uint32(header[7])<<24 | uint32(header[4])<<16 | uint32(header[5])<<8 | uint32(header[6])
+	return header[0], timestamp, dataSize, nil
+}
+
+// ReadTag 读入一个FLV标签及其PreviousTagSize，文件结束时返回io.EOF
+func (reader *Reader) ReadTag() (uint8, uint32, []byte, error) {
+	tag, err := reader.Next()
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	return tag.Type, tag.Timestamp, tag.Data, nil
+}
+
+// Next 读入下一个FLV标签及其PreviousTagSize，文件结束时返回io.EOF
+func (reader *Reader) Next() (*Tag, error) {
+	tagType, timestamp, dataSize, err := reader.ReadTagHeader()
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, dataSize+4)
+	if _, err := io.ReadFull(reader.r, data); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	tag := &Tag{
+		Type:      tagType & 0x1f,
+		Filtered:  tagType&tagFiltered != 0,
+		Timestamp: timestamp,
+		Data:      data[:dataSize],
+	}
+	if size := binary.BigEndian.Uint32(data[dataSize:]); reader.Strict && size != tag.Size() {
+		return nil, errors.Errorf("previous tag size %d mismatch, expected %d", size, tag.Size())
+	}
+	return tag, nil
+}
@@ -0,0 +1,164 @@
+package flv
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+)
+
+// writeRecorder 记录每次Write写出的数据
+type writeRecorder struct {
+	writes [][]byte
+}
+
+// Write 记录一次写出
+func (w *writeRecorder) Write(b []byte) (int, error) {
+	w.writes = append(w.writes, append([]byte{}, b...))
+	return len(b), nil
+}
+
+// TestAppendTag 测试FLV标签封装，NewTagWriter新建的对象每个标签只写出一次
+func TestAppendTag(t *testing.T) {
+	type arg struct {
+		tagType   uint8
+		timestamp uint32
+		data      []byte
+	}
+	var tests = []struct {
+		in       arg    // input
+		expected []byte // expected result
+	}{
+		{arg{TagTypeAudio, 0, []byte{0xaf, 0x00}}, []byte{8, 0, 0, 2, 0, 0, 0, 0, 0, 0, 0, 0xaf, 0x00, 0, 0, 0, 13}},
+		{arg{TagTypeVideo, 0x01020304, []byte{0x17}}, []byte{9, 0, 0, 1, 2, 3, 4, 1, 0, 0, 0, 0x17, 0, 0, 0, 12}},
+		{arg{TagTypeScript, 40, []byte{}}, []byte{18, 0, 0, 0, 0, 0, 40, 0, 0, 0, 0, 0, 0, 0, 11}},
+	}
+
+	for _, test := range tests {
+		actual := AppendTag(nil, test.in.tagType, test.in.timestamp, test.in.data)
+		buf := new(bytes.Buffer)
+		NewWriter(buf).WriteTag(test.in.tagType, test.in.timestamp, test.in.data)
+		writes := new(writeRecorder)
+		NewTagWriter(writes).WriteTag(test.in.tagType, test.in.timestamp, test.in.data)
+		if !bytes.Equal(actual, test.expected) || !bytes.Equal(buf.Bytes(), test.expected) ||
+			len(writes.writes) != 1 || !bytes.Equal(writes.writes[0], test.expected) {
+			t.Errorf("[×] in: %v out: %v %v %v expected: %v\n", test.in, actual, buf.Bytes(), writes.writes, test.expected)
+		} else {
+			t.Logf("[√] in: %v out: %v expected: %v\n", test.in, actual, test.expected)
+		}
+	}
+}
+
+// TestReader 测试读入Writer写出的FLV数据
+func TestReader(t *testing.T) {
+	type tag struct {
+		tagType   uint8
+		timestamp uint32
+		data      []byte
+	}
+	var tests = []struct {
+		in []tag // input
+	}{
+		{[]tag{}},
+		{[]tag{{TagTypeScript, 0, []byte{2, 0, 1, 'a'}}, {TagTypeVideo, 0x01020304, []byte{0x17, 0}}, {TagTypeAudio, 40, []byte{}}}},
+	}
+
+	for _, test := range tests {
+		buf := new(bytes.Buffer)
+		writer := NewWriter(buf)
+		writer.WriteHeader(true, false)
+		for _, tag := range test.in {
+			writer.WriteTag(tag.tagType, tag.timestamp, tag.data)
+		}
+
+		reader := NewReader(buf)
+		hasAudio, hasVideo, err := reader.ReadHeader()
+		ok := err == nil && hasAudio && !hasVideo
+		actual := []tag{}
+		for ok {
+			tagType, timestamp, data, err := reader.ReadTag()
+			if err != nil {
+				ok = err == io.EOF
+				break
+			}
+			actual = append(actual, tag{tagType, timestamp, data})
+		}
+		ok = ok && len(actual) == len(test.in)
+		for i := 0; ok && i < len(actual); i++ {
+			ok = actual[i].tagType == test.in[i].tagType && actual[i].timestamp == test.in[i].timestamp && bytes.Equal(actual[i].data, test.in[i].data)
+		}
+		if !ok {
+			t.Errorf("[×] in: %v out: %v expected: %v\n", test.in, actual, test.in)
+		} else {
+			t.Logf("[√] in: %v out: %v expected: %v\n", test.in, actual, test.in)
+		}
+	}
+}
+
+// TestReaderNext 测试读入加密标志与检查 PreviousTagSize
+func TestReaderNext(t *testing.T) {
+	type result struct {
+		tagType  uint8
+		filtered bool
+		data     []byte
+	}
+	var tests = []struct {
+		in       []byte  // input
+		expected *result // expected result，nil为读入出错
+	}{
+		{[]byte{8, 0, 0, 1, 0, 0, 40, 0, 0, 0, 0, 0xaf, 0, 0, 0, 12}, &result{TagTypeAudio, false, []byte{0xaf}}},
+		{[]byte{0x29, 0, 0, 1, 0, 0, 40, 0, 0, 0, 0, 0x17, 0, 0, 0, 12}, &result{TagTypeVideo, true, []byte{0x17}}},
+		{[]byte{8, 0, 0, 1, 0, 0, 40, 0, 0, 0, 0, 0xaf, 0, 0, 0, 0}, nil},
+		{[]byte{8, 0, 0, 2, 0, 0, 40, 0, 0, 0, 0, 0xaf, 0, 0, 0, 12}, nil},
+	}
+
+	for _, test := range tests {
+		reader := NewReader(bytes.NewReader(test.in))
+		reader.Strict = true
+		tag, err := reader.Next()
+		ok := (err != nil) == (test.expected == nil)
+		var actual *result
+		if err == nil {
+			actual = &result{tag.Type, tag.Filtered, tag.Data}
+			ok = ok && actual.tagType == test.expected.tagType && actual.filtered == test.expected.filtered && bytes.Equal(actual.data, test.expected.data)
+			ok = ok && bytes.Equal(tag.Append(nil), test.in)
+		}
+		if !ok {
+			t.Errorf("[×] in: %v out: %v %v expected: %v\n", test.in, actual, err, test.expected)
+		} else {
+			t.Logf("[√] in: %v out: %v expected: %v\n", test.in, actual, test.expected)
+		}
+	}
+}
+
+// TestScriptData 测试脚本数据的编码与解析
+func TestScriptData(t *testing.T) {
+	var tests = []struct {
+		in ScriptData // input
+	}{
+		{ScriptData{MetadataName, map[string]interface{}{"width": float64(1280), "encoder": "obs"}}},
+		{ScriptData{"onTextData", "hello"}},
+		{ScriptData{"onCuePoint", nil}},
+	}
+
+	for _, test := range tests {
+		buf := new(bytes.Buffer)
+		ok := NewWriter(buf).WriteScript(0, &test.in) == nil
+		var actual *ScriptData
+		if ok {
+			tag, err := NewReader(buf).Next()
+			if ok = err == nil; ok {
+				actual, err = tag.Script()
+				ok = err == nil && actual.Name == test.in.Name && fmt.Sprint(actual.Value) == fmt.Sprint(test.in.Value)
+			}
+		}
+		if _, isMetadata := test.in.Metadata(); ok && isMetadata {
+			_, ok = actual.Metadata()
+		}
+		if !ok {
+			t.Errorf("[×] in: %v out: %v expected: %v\n", test.in, actual, test.in)
+		} else {
+			t.Logf("[√] in: %v out: %v expected: %v\n", test.in, actual, test.in)
+		}
+	}
+}
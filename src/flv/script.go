@@ -0,0 +1,72 @@
+package flv
+
+import (
+	"../rtmp/amf"
+	"github.com/pkg/errors"
+)
+
+/*
+
+FLV 脚本数据
+
+脚本数据标签为两个AMF0值，依次为名称字符串与参数，如 onMetaData 与元数据的 ECMA 数组
+
+*/
+
+// MetadataName 元数据的脚本数据名称
+const MetadataName = "onMetaData"
+
+// ScriptData 解析后的脚本数据
+type ScriptData struct {
+	Name  string
+	Value interface{} // 没有参数时为nil
+}
+
+// ParseScriptData 解析脚本数据标签
+func ParseScriptData(data []byte) (*ScriptData, error) {
+	array, err := amf.ByteToAMFArray(data)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if len(array) < 1 {
+		return nil, errors.New("script data is empty")
+	}
+	name, ok := array[0].Value().(string)
+	if !ok {
+		return nil, errors.New("script data name is not a string")
+	}
+	script := &ScriptData{Name: name}
+	if len(array) > 1 {
+		script.Value = array[1].Value()
+	}
+	return script, nil
+}
+
+// Metadata 元数据，不是 onMetaData 或参数不是对象时返回false
+func (script *ScriptData) Metadata() (map[string]interface{}, bool) {
+	if script.Name != MetadataName {
+		return nil, false
+	}
+	metadata, ok := script.Value.(map[string]interface{})
+	return metadata, ok
+}
+
+// Bytes 编码为脚本数据标签的数据，对象参数编码为 ECMA 数组
+func (script *ScriptData) Bytes() ([]byte, error) {
+	data := amf.NewString(script.Name).Bytes()
+	if script.Value == nil {
+		return data, nil
+	}
+
+	var value amf.AMF
+	var err error
+	if m, ok := script.Value.(map[string]interface{}); ok {
+		value, err = amf.NewECMAArray(m)
+	} else {
+		value, err = amf.MakeAMF(script.Value)
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return append(data, value.Bytes()...), nil
+}
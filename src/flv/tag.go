@@ -0,0 +1,67 @@
+package flv
+
+import (
+	"github.com/pkg/errors"
+)
+
+/*
+
+FLV 标签
+
+标签头为 类型(1字节，第6位为加密标志) 数据长度(3字节) 时间戳(3字节) 扩展时间戳(1字节) StreamID(3字节，总是0)
+标签之后为4字节的 PreviousTagSize，即标签头与数据的总长度
+
+*/
+
+// tagFiltered 标签类型中的加密标志
+const tagFiltered = uint8(0x20)
+
+// Tag FLV标签
+type Tag struct {
+	Type      uint8  // TagTypeAudio TagTypeVideo TagTypeScript，不包含加密标志
+	Filtered  bool   // 是否为加密标签
+	Timestamp uint32 // 毫秒，包含扩展时间戳
+	Data      []byte
+}
+
+// Size 标签头与数据的总长度，与之后的 PreviousTagSize 一致
+func (tag *Tag) Size() uint32 {
+	return TagHeaderSize + uint32(len(tag.Data))
+}
+
+// Append 在buf后追加标签及其PreviousTagSize
+func (tag *Tag) Append(buf []byte) []byte {
+	return AppendTag(buf, tag.typeByte(), tag.Timestamp, tag.Data)
+}
+
+// typeByte 标签头中的类型字节
+func (tag *Tag) typeByte() uint8 {
+	if tag.Filtered {
+		return tag.Type | tagFiltered
+	}
+	return tag.Type
+}
+
+// Audio 解析音频标签，多轨道标签使用 ParseAudioTracks
+func (tag *Tag) Audio() (AudioTag, error) {
+	if tag.Type != TagTypeAudio {
+		return AudioTag{}, errors.Errorf("tag type %d is not audio", tag.Type)
+	}
+	return ParseAudioTag(tag.Data)
+}
+
+// Video 解析视频标签，多轨道标签使用 ParseVideoTracks
+func (tag *Tag) Video() (VideoTag, error) {
+	if tag.Type != TagTypeVideo {
+		return VideoTag{}, errors.Errorf("tag type %d is not video", tag.Type)
+	}
+	return ParseVideoTag(tag.Data)
+}
+
+// Script 解析脚本数据标签
+func (tag *Tag) Script() (*ScriptData, error) {
+	if tag.Type != TagTypeScript {
+		return nil, errors.Errorf("tag type %d is not script data", tag.Type)
+	}
+	return ParseScriptData(tag.Data)
+}
@@ -0,0 +1,70 @@
+package flv
+
+import (
+	"github.com/pkg/errors"
+)
+
+/*
+
+Enhanced RTMP 音视频共用的多轨道格式
+
+data 从 AvMultitrackType 与 PacketType 所在的字节开始
+	OneTrack 与 ManyTracks 之后为所有轨道共用的 FourCC
+	每个轨道为 [FourCC] 轨道ID(8位) [长度(24位)] 轨道数据
+	FourCC 只在 ManyTracksManyCodecs 中出现，长度在 OneTrack 中省略
+
+*/
+
+// parseTracks 逐个解析多轨道数据中的轨道，body引用data
+func parseTracks(data []byte, handle func(fourCC string, trackID uint8, body []byte) error) error {
+	if len(data) < 1 {
+		return errors.New("multitrack tag too short")
+	}
+	multitrackType := data[0] >> 4
+	if multitrackType > MultitrackManyTracksManyCodecs {
+		return errors.Errorf("multitrack type %d not supported", multitrackType)
+	}
+	data = data[1:]
+
+	var fourCC string
+	if multitrackType != MultitrackManyTracksManyCodecs {
+		if len(data) < 4 {
+			return errors.New("multitrack tag too short")
+		}
+		fourCC = string(data[:4])
+		data = data[4:]
+	}
+	for len(data) > 0 {
+		if multitrackType == MultitrackManyTracksManyCodecs {
+			if len(data) < 4 {
+				return errors.New("multitrack tag too short")
+			}
+			fourCC = string(data[:4])
+			data = data[4:]
+		}
+		if len(data) < 1 {
+			return errors.New("multitrack tag too short")
+		}
+		trackID := data[0]
+		data = data[1:]
+
+		body := data
+		if multitrackType == MultitrackOneTrack {
+			data = nil
+		} else {
+			if len(data) < 3 {
+				return errors.New("multitrack tag too short")
+			}
+			size := int(data[0])<<16 | int(data[1])<<8 | int(data[2])
+			if len(data) < 3+size {
+				return errors.New("multitrack tag too short")
+			}
+			body = data[3 : 3+size]
+			data = data[3+size:]
+		}
+		if err := handle(fourCC, trackID, body); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+package flv
+
+import (
+	"github.com/pkg/errors"
+)
+
+/*
+
+视频标签头
+
+传统标签头为 FrameType(4位) CodecID(4位)，H.264/H.265之后为 AVCPacketType 与 24位的 CompositionTime
+Enhanced RTMP 标签头第一位为1，之后为 FrameType(3位) PacketType(4位) 与 4字节的 FourCC
+	hvc1 avc1 的 CodedFrames 之后为 CompositionTime，CodedFramesX 省略 CompositionTime 即为0
+	av01 vp09 没有 CompositionTime
+	FrameType 为命令帧且不是 Metadata 时，之后只有一个字节的命令
+两种标签头统一解析为 VideoTag，传统的 H.264/H.265 以对应的 FourCC 表示
+
+Multitrack 标签在一个消息中携带多个轨道，PacketType 之后为 AvMultitrackType(4位) 与轨道的 PacketType(4位)
+	OneTrack 与 ManyTracks 之后为所有轨道共用的 FourCC，ManyTracksManyCodecs 的 FourCC 在每个轨道之前
+	每个轨道为 轨道ID(8位) 与轨道数据，OneTrack 之外的轨道数据前有24位的长度
+
+*/
+
+// 视频帧类型
+const (
+	FrameTypeKey        = uint8(1) // 关键帧
+	FrameTypeInter      = uint8(2) // 非关键帧
+	FrameTypeDisposable = uint8(3) // 可丢弃的非关键帧
+	FrameTypeGenerated  = uint8(4) // 服务端生成的关键帧
+	FrameTypeCommand    = uint8(5) // 视频信息或命令帧
+)
+
+// 传统标签头中的视频编码ID
+const (
+	CodecIDAVC  = uint8(7)
+	CodecIDHEVC = uint8(12) // 非标准的H.265扩展
+)
+
+// 视频编码的 FourCC，与MP4样本描述类型一致
+const (
+	FourCCAVC  = "avc1"
+	FourCCHEVC = "hvc1"
+	FourCCAV1  = "av01"
+	FourCCVP9  = "vp09"
+)
+
+// VideoFourCCs 支持的 Enhanced RTMP 视频编码
+var VideoFourCCs = []string{FourCCAV1, FourCCVP9, FourCCHEVC, FourCCAVC}
+
+// Enhanced RTMP 的 PacketType，传统标签头的 AVCPacketType 0 1 2 与前三种一致
+const (
+	PacketTypeSequenceStart        = uint8(0) // 序列头，之后为解码配置记录
+	PacketTypeCodedFrames          = uint8(1) // 视频帧
+	PacketTypeSequenceEnd          = uint8(2) // 序列结束
+	PacketTypeCodedFramesX         = uint8(3) // 省略 CompositionTime 的视频帧
+	PacketTypeMetadata             = uint8(4) // 视频元数据，如HDR的colorInfo
+	PacketTypeMPEG2TSSequenceStart = uint8(5) // MPEG-2 TS格式的序列头
+	PacketTypeMultitrack           = uint8(6) // 多轨道
+	PacketTypeModEx                = uint8(7) // 扩展标签头
+)
+
+// Multitrack 标签的 AvMultitrackType，音视频共用
+const (
+	MultitrackOneTrack             = uint8(0) // 只有一个轨道
+	MultitrackManyTracks           = uint8(1) // 多个相同编码的轨道
+	MultitrackManyTracksManyCodecs = uint8(2) // 多个不同编码的轨道
+)
+
+// VideoTag 解析后的视频标签
+type VideoTag struct {
+	FrameType       uint8
+	CodecID         uint8  // 传统标签头的编码ID，Enhanced RTMP 为0
+	FourCC          string // 编码的 FourCC，不支持的传统编码为空
+	Enhanced        bool   // 是否为 Enhanced RTMP 标签头
+	PacketType      uint8  // 统一为 Enhanced RTMP 的 PacketType
+	CompositionTime int32  // 显示时间与解码时间的差，单位为毫秒
+	TrackID         uint8  // Multitrack 的轨道ID，其他标签为0
+	Body            []byte // 序列头为解码配置记录，视频帧为长度前缀的NALU或OBU
+}
+
+// VideoFrameType 由标签的第一个字节取得视频帧类型，兼容两种标签头
+func VideoFrameType(b byte) uint8 {
+	if b&0x80 != 0 {
+		return b >> 4 & 0x07
+	}
+	return b >> 4
+}
+
+// IsMultitrackVideo 是否为 Enhanced RTMP 的多轨道视频标签
+func IsMultitrackVideo(data []byte) bool {
+	return len(data) > 0 && data[0]&0x80 != 0 && data[0]&0x0f == PacketTypeMultitrack
+}
+
+// ParseVideoTag 解析视频标签头，Body引用data，多轨道标签使用 ParseVideoTracks
+func ParseVideoTag(data []byte) (VideoTag, error) {
+	var tag VideoTag
+	if len(data) < 1 {
+		return tag, errors.New("video tag too short")
+	}
+	tag.FrameType = VideoFrameType(data[0])
+	if data[0]&0x80 != 0 {
+		return tag, parseExVideoTag(&tag, data)
+	}
+
+	tag.CodecID = data[0] & 0x0f
+	switch tag.CodecID {
+	case CodecIDAVC:
+		tag.FourCC = FourCCAVC
+	case CodecIDHEVC:
+		tag.FourCC = FourCCHEVC
+	default:
+		// 其他传统编码没有 AVCPacketType
+		tag.PacketType = PacketTypeCodedFrames
+		tag.Body = data[1:]
+		return tag, nil
+	}
+	if len(data) < 5 {
+		return tag, errors.New("video tag too short")
+	}
+	tag.PacketType = data[1]
+	tag.CompositionTime = compositionTime(data[2:5])
+	tag.Body = data[5:]
+	return tag, nil
+}
+
+// parseExVideoTag 解析 Enhanced RTMP 标签头
+func parseExVideoTag(tag *VideoTag, data []byte) error {
+	if len(data) < 5 {
+		return errors.New("video tag too short")
+	}
+	tag.Enhanced = true
+	tag.PacketType = data[0] & 0x0f
+	tag.FourCC = string(data[1:5])
+	tag.Body = data[5:]
+	return parseExVideoBody(tag)
+}
+
+// parseExVideoBody 按 PacketType 解析 Enhanced RTMP 标签头之后的数据
+func parseExVideoBody(tag *VideoTag) error {
+	if tag.FrameType == FrameTypeCommand && tag.PacketType != PacketTypeMetadata {
+		return nil
+	}
+
+	switch tag.PacketType {
+	case PacketTypeSequenceStart, PacketTypeSequenceEnd, PacketTypeCodedFramesX, PacketTypeMetadata, PacketTypeMPEG2TSSequenceStart:
+	case PacketTypeMultitrack:
+		return errors.New("multitrack video tag")
+	case PacketTypeCodedFrames:
+		if tag.FourCC == FourCCAVC || tag.FourCC == FourCCHEVC {
+			if len(tag.Body) < 3 {
+				return errors.New("video tag too short")
+			}
+			tag.CompositionTime = compositionTime(tag.Body[:3])
+			tag.Body = tag.Body[3:]
+		}
+	default:
+		return errors.Errorf("video packet type %d not supported", tag.PacketType)
+	}
+	return nil
+}
+
+// ParseVideoTracks 解析视频标签中的所有轨道，不是多轨道标签时返回轨道0，Body引用data
+func ParseVideoTracks(data []byte) ([]VideoTag, error) {
+	if !IsMultitrackVideo(data) {
+		tag, err := ParseVideoTag(data)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return []VideoTag{tag}, nil
+	}
+	if len(data) < 2 {
+		return nil, errors.New("video tag too short")
+	}
+	frameType := VideoFrameType(data[0])
+	packetType := data[1] & 0x0f
+	var tags []VideoTag
+	err := parseTracks(data[1:], func(fourCC string, trackID uint8, body []byte) error {
+		tag := VideoTag{
+			FrameType:  frameType,
+			FourCC:     fourCC,
+			Enhanced:   true,
+			PacketType: packetType,
+			TrackID:    trackID,
+			Body:       body,
+		}
+		if err := parseExVideoBody(&tag); err != nil {
+			return errors.WithStack(err)
+		}
+		tags = append(tags, tag)
+		return nil
+	})
+	return tags, errors.WithStack(err)
+}
+
+// compositionTime 读入24位有符号的 CompositionTime
+func compositionTime(data []byte) int32 {
+	return int32(uint32(data[0])<<24|uint32(data[1])<<16|uint32(data[2])<<8) >> 8
+}
+
+// AppendVideoTag 将单个轨道的视频标签编码后追加到buf，Enhanced为false时使用传统标签头
+func AppendVideoTag(buf []byte, tag *VideoTag) []byte {
+	if !tag.Enhanced {
+		buf = append(buf, tag.FrameType<<4|tag.CodecID)
+		if tag.CodecID == CodecIDAVC || tag.CodecID == CodecIDHEVC {
+			cts := uint32(tag.CompositionTime)
+			buf = append(buf, tag.PacketType, byte(cts>>16), byte(cts>>8), byte(cts))
+		}
+		return append(buf, tag.Body...)
+	}
+
+	buf = append(buf, 0x80|tag.FrameType<<4|tag.PacketType)
+	buf = append(buf, tag.FourCC...)
+	if tag.Frame() && tag.PacketType == PacketTypeCodedFrames && (tag.FourCC == FourCCAVC || tag.FourCC == FourCCHEVC) {
+		cts := uint32(tag.CompositionTime)
+		buf = append(buf, byte(cts>>16), byte(cts>>8), byte(cts))
+	}
+	return append(buf, tag.Body...)
+}
+
+// KeyFrame 是否为关键帧，不包括序列头
+func (tag *VideoTag) KeyFrame() bool {
+	return tag.Frame() && (tag.FrameType == FrameTypeKey || tag.FrameType == FrameTypeGenerated)
+}
+
+// Frame 是否为视频帧
+func (tag *VideoTag) Frame() bool {
+	if tag.FrameType == FrameTypeCommand {
+		return false
+	}
+	return tag.PacketType == PacketTypeCodedFrames || tag.PacketType == PacketTypeCodedFramesX
+}
+
+// SequenceHeader 是否为支持的编码的序列头
+func (tag *VideoTag) SequenceHeader() bool {
+	return tag.FourCC != "" && tag.FrameType != FrameTypeCommand && tag.PacketType == PacketTypeSequenceStart
+}
+
+// Metadata 是否为 Enhanced RTMP 的视频元数据
+func (tag *VideoTag) Metadata() bool {
+	return tag.Enhanced && tag.PacketType == PacketTypeMetadata
+}
@@ -0,0 +1,104 @@
+package flv
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestParseVideoTag 测试传统与 Enhanced RTMP 视频标签头的解析
+func TestParseVideoTag(t *testing.T) {
+	type result struct {
+		fourCC         string
+		packetType     uint8
+		cts            int32
+		body           []byte
+		keyFrame       bool
+		sequenceHeader bool
+	}
+	var tests = []struct {
+		in       []byte // input
+		expected result // expected result
+	}{
+		// H.264 序列头与带负数CompositionTime的关键帧
+		{[]byte{0x17, 0, 0, 0, 0, 1, 2}, result{FourCCAVC, PacketTypeSequenceStart, 0, []byte{1, 2}, false, true}},
+		{[]byte{0x17, 1, 0xff, 0xff, 0xd8, 9}, result{FourCCAVC, PacketTypeCodedFrames, -40, []byte{9}, true, false}},
+		{[]byte{0x27, 1, 0, 0, 40, 9}, result{FourCCAVC, PacketTypeCodedFrames, 40, []byte{9}, false, false}},
+		// 传统的其他编码
+		{[]byte{0x12, 9}, result{"", PacketTypeCodedFrames, 0, []byte{9}, true, false}},
+		// hvc1 序列头、CodedFrames 与 CodedFramesX
+		{[]byte{0x90, 'h', 'v', 'c', '1', 1}, result{FourCCHEVC, PacketTypeSequenceStart, 0, []byte{1}, false, true}},
+		{[]byte{0x91, 'h', 'v', 'c', '1', 0, 0, 80, 9}, result{FourCCHEVC, PacketTypeCodedFrames, 80, []byte{9}, true, false}},
+		{[]byte{0xa3, 'h', 'v', 'c', '1', 9}, result{FourCCHEVC, PacketTypeCodedFramesX, 0, []byte{9}, false, false}},
+		// av01 vp09 没有 CompositionTime
+		{[]byte{0x91, 'a', 'v', '0', '1', 9}, result{FourCCAV1, PacketTypeCodedFrames, 0, []byte{9}, true, false}},
+		{[]byte{0x90, 'v', 'p', '0', '9', 1}, result{FourCCVP9, PacketTypeSequenceStart, 0, []byte{1}, false, true}},
+		// 元数据与命令帧
+		{[]byte{0xd4, 'h', 'v', 'c', '1', 2}, result{FourCCHEVC, PacketTypeMetadata, 0, []byte{2}, false, false}},
+		{[]byte{0xd0, 'h', 'v', 'c', '1', 1}, result{FourCCHEVC, PacketTypeSequenceStart, 0, []byte{1}, false, false}},
+	}
+
+	for _, test := range tests {
+		tag, err := ParseVideoTag(test.in)
+		actual := result{tag.FourCC, tag.PacketType, tag.CompositionTime, tag.Body, tag.KeyFrame(), tag.SequenceHeader()}
+		ok := err == nil &&
+			actual.fourCC == test.expected.fourCC &&
+			actual.packetType == test.expected.packetType &&
+			actual.cts == test.expected.cts &&
+			bytes.Equal(actual.body, test.expected.body) &&
+			actual.keyFrame == test.expected.keyFrame &&
+			actual.sequenceHeader == test.expected.sequenceHeader
+		if !ok {
+			t.Errorf("[×] in: %v out: %v %v expected: %v\n", test.in, actual, err, test.expected)
+		} else {
+			t.Logf("[√] in: %v out: %v expected: %v\n", test.in, actual, test.expected)
+		}
+	}
+}
+
+// TestParseVideoTracks 测试多轨道视频标签的解析与单轨道标签的编码
+func TestParseVideoTracks(t *testing.T) {
+	type track struct {
+		fourCC  string
+		trackID uint8
+		body    []byte
+	}
+	var tests = []struct {
+		in       []byte  // input
+		expected []track // expected result
+	}{
+		// 非多轨道标签为轨道0
+		{[]byte{0x17, 1, 0, 0, 0, 9}, []track{{FourCCAVC, 0, []byte{9}}}},
+		// OneTrack
+		{[]byte{0x96, 0x01, 'a', 'v', '0', '1', 3, 9, 9}, []track{{FourCCAV1, 3, []byte{9, 9}}}},
+		// ManyTracks 共用 FourCC，CodedFrames 之后为 CompositionTime
+		{[]byte{0x96, 0x11, 'h', 'v', 'c', '1', 0, 0, 0, 4, 0, 0, 0, 9, 1, 0, 0, 4, 0, 0, 0, 8}, []track{{FourCCHEVC, 0, []byte{9}}, {FourCCHEVC, 1, []byte{8}}}},
+		// ManyTracksManyCodecs 每个轨道有各自的 FourCC
+		{[]byte{0x96, 0x20, 'a', 'v', 'c', '1', 0, 0, 0, 1, 1, 'h', 'v', 'c', '1', 2, 0, 0, 1, 2}, []track{{FourCCAVC, 0, []byte{1}}, {FourCCHEVC, 2, []byte{2}}}},
+		// 长度超出数据
+		{[]byte{0x96, 0x11, 'h', 'v', 'c', '1', 0, 0, 0, 9, 0}, nil},
+	}
+
+	for _, test := range tests {
+		tags, err := ParseVideoTracks(test.in)
+		var actual []track
+		for _, tag := range tags {
+			actual = append(actual, track{tag.FourCC, tag.TrackID, tag.Body})
+		}
+		ok := (err == nil) == (test.expected != nil) && len(actual) == len(test.expected)
+		for i := 0; ok && i < len(actual); i++ {
+			ok = actual[i].fourCC == test.expected[i].fourCC &&
+				actual[i].trackID == test.expected[i].trackID &&
+				bytes.Equal(actual[i].body, test.expected[i].body)
+		}
+		// 拆分出的轨道重新编码后应解析为相同的标签
+		for i := 0; ok && i < len(tags); i++ {
+			tag, err := ParseVideoTag(AppendVideoTag(nil, &tags[i]))
+			ok = err == nil && tag.FourCC == tags[i].FourCC && tag.PacketType == tags[i].PacketType && bytes.Equal(tag.Body, tags[i].Body)
+		}
+		if !ok {
+			t.Errorf("[×] in: %v out: %v %v expected: %v\n", test.in, actual, err, test.expected)
+		} else {
+			t.Logf("[√] in: %v out: %v expected: %v\n", test.in, actual, test.expected)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package fmp4
+
+import (
+	"encoding/binary"
+)
+
+// appendBox 追加一个box，content追加box的内容，完成后回填box长度
+func appendBox(buf []byte, boxType string, content func([]byte) []byte) []byte {
+	start := len(buf)
+	buf = append(buf, 0, 0, 0, 0)
+	buf = append(buf, boxType...)
+	buf = content(buf)
+	binary.BigEndian.PutUint32(buf[start:], uint32(len(buf)-start))
+	return buf
+}
+
+// appendFullBox 追加一个带版本与标志的box
+func appendFullBox(buf []byte, boxType string, version uint8, flags uint32, content func([]byte) []byte) []byte {
+	return appendBox(buf, boxType, func(buf []byte) []byte {
+		buf = append(buf, version, byte(flags>>16), byte(flags>>8), byte(flags))
+		return content(buf)
+	})
+}
+
+// appendUint16 追加大端序16位整数
+func appendUint16(buf []byte, v uint16) []byte {
+	return append(buf, byte(v>>8), byte(v))
+}
+
+// appendUint32 追加大端序32位整数
+func appendUint32(buf []byte, v uint32) []byte {
+	return append(buf, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+// appendUint64 追加大端序64位整数
+func appendUint64(buf []byte, v uint64) []byte {
+	return appendUint32(appendUint32(buf, uint32(v>>32)), uint32(v))
+}
+
+// appendZeros 追加n个0字节
+func appendZeros(buf []byte, n int) []byte {
+	for i := 0; i < n; i++ {
+		buf = append(buf, 0)
+	}
+	return buf
+}
+
+// appendMatrix 追加单位变换矩阵
+func appendMatrix(buf []byte) []byte {
+	for _, v := range []uint32{0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000} {
+		buf = appendUint32(buf, v)
+	}
+	return buf
+}
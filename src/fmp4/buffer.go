@@ -0,0 +1,57 @@
+package fmp4
+
+// SampleBuffer 一个轨道待写出的样本
+//
+// 视频样本的时长由下一个样本的解码时间确定，取出时以下一帧的解码时间确定最后一个样本的时长
+// 音频样本的时长由调用者给出
+type SampleBuffer struct {
+	Track     *Track
+	samples   []Sample
+	baseTime  uint64 // 第一个待写出样本的解码时间
+	lastTime  uint64 // 最后一个样本的解码时间
+	lastDelta uint32 // 上一个样本的时长
+}
+
+// NewSampleBuffer 新建轨道的样本缓冲
+func NewSampleBuffer(track *Track) *SampleBuffer {
+	return &SampleBuffer{Track: track}
+}
+
+// Add 加入样本，decodeTime单位为轨道的时间单位
+func (buffer *SampleBuffer) Add(decodeTime uint64, sample Sample) {
+	if len(buffer.samples) == 0 {
+		buffer.baseTime = decodeTime
+	} else if buffer.Track.Type == TrackVideo {
+		buffer.setLastDuration(decodeTime)
+	}
+	buffer.samples = append(buffer.samples, sample)
+	buffer.lastTime = decodeTime
+}
+
+// Len 待写出的样本数
+func (buffer *SampleBuffer) Len() int {
+	return len(buffer.samples)
+}
+
+// Take 取出待写出的样本，next为下一帧的解码时间
+func (buffer *SampleBuffer) Take(next uint64) Fragment {
+	if len(buffer.samples) > 0 && buffer.Track.Type == TrackVideo {
+		buffer.setLastDuration(next)
+	}
+	fragment := Fragment{
+		Track:    buffer.Track,
+		BaseTime: buffer.baseTime,
+		Samples:  buffer.samples,
+	}
+	buffer.samples = nil
+	return fragment
+}
+
+// setLastDuration 设置最后一个样本的时长，解码时间不递增时沿用上一个样本的时长
+func (buffer *SampleBuffer) setLastDuration(next uint64) {
+	last := &buffer.samples[len(buffer.samples)-1]
+	if next > buffer.lastTime {
+		buffer.lastDelta = uint32(next - buffer.lastTime)
+	}
+	last.Duration = buffer.lastDelta
+}
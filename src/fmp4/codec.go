@@ -0,0 +1,188 @@
+package fmp4
+
+import (
+	"fmt"
+
+	"../codec"
+	"github.com/pkg/errors"
+)
+
+/*
+
+从序列头中取得样本描述需要的参数
+
+*/
+
+// NewVideoTrack 由AVCDecoderConfigurationRecord新建H.264视频轨道，时间单位为90kHz
+func NewVideoTrack(id uint32, record []byte) (*Track, error) {
+	if len(record) < 8 {
+		return nil, errors.New("AVC config too short")
+	}
+	track := &Track{
+		ID:        id,
+		Type:      TrackVideo,
+		Timescale: 90000,
+		Config:    append([]byte{}, record...),
+	}
+
+	// 第一个SPS，无法解析时分辨率为0，由解码器从码流中取得
+	if config, err := codec.ParseAVCConfig(record); err == nil && len(config.SPS) > 0 {
+		if sps, err := codec.ParseAVCSPS(config.SPS[0]); err == nil {
+			track.Width, track.Height = uint16(sps.Width), uint16(sps.Height)
+		}
+	}
+	return track, nil
+}
+
+// NewHEVCTrack 由HEVCDecoderConfigurationRecord新建H.265视频轨道，时间单位为90kHz
+func NewHEVCTrack(id uint32, record []byte) (*Track, error) {
+	if len(record) < 23 {
+		return nil, errors.New("HEVC config too short")
+	}
+	track := &Track{
+		ID:        id,
+		Type:      TrackVideo,
+		Entry:     EntryHEVC,
+		Timescale: 90000,
+		Config:    append([]byte{}, record...),
+	}
+
+	// 第一个SPS，无法解析时分辨率为0，由解码器从码流中取得
+	if config, err := codec.ParseHEVCConfig(record); err == nil && len(config.SPS) > 0 {
+		if sps, err := codec.ParseHEVCSPS(config.SPS[0]); err == nil {
+			track.Width, track.Height = uint16(sps.Width), uint16(sps.Height)
+		}
+	}
+	return track, nil
+}
+
+// NewAV1Track 由AV1CodecConfigurationRecord新建AV1视频轨道，时间单位为90kHz
+// 分辨率在序列头OBU中，不解析时为0，由解码器从码流中取得
+func NewAV1Track(id uint32, record []byte) (*Track, error) {
+	if len(record) < 4 || record[0] != 0x81 {
+		return nil, errors.New("AV1 config invalid")
+	}
+	return &Track{
+		ID:        id,
+		Type:      TrackVideo,
+		Entry:     EntryAV1,
+		Timescale: 90000,
+		Config:    append([]byte{}, record...),
+	}, nil
+}
+
+// NewVP9Track 由VPCodecConfigurationRecord新建VP9视频轨道，时间单位为90kHz，分辨率由解码器从码流中取得
+func NewVP9Track(id uint32, record []byte) (*Track, error) {
+	if len(record) < 8 {
+		return nil, errors.New("VP9 config too short")
+	}
+	return &Track{
+		ID:        id,
+		Type:      TrackVideo,
+		Entry:     EntryVP9,
+		Timescale: 90000,
+		Config:    append([]byte{}, record...),
+	}, nil
+}
+
+// NewCodecTrack 按样本描述类型由解码配置记录新建视频轨道，entry与FLV中的FourCC一致
+func NewCodecTrack(id uint32, entry string, record []byte) (*Track, error) {
+	switch entry {
+	case EntryAVC:
+		return NewVideoTrack(id, record)
+	case EntryHEVC:
+		return NewHEVCTrack(id, record)
+	case EntryAV1:
+		return NewAV1Track(id, record)
+	case EntryVP9:
+		return NewVP9Track(id, record)
+	}
+	return nil, errors.Errorf("video codec %q not supported by mp4", entry)
+}
+
+// NewAudioTrack 由AudioSpecificConfig新建AAC音频轨道，时间单位为采样率
+// HE-AAC 的样本描述使用核心采样率，解码器从配置中取得SBR与PS
+func NewAudioTrack(id uint32, config []byte) (*Track, error) {
+	aac, err := codec.ParseAACConfig(config)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	rate := uint32(aac.SampleRate)
+	return &Track{
+		ID:         id,
+		Type:       TrackAudio,
+		Timescale:  rate,
+		SampleRate: rate,
+		Channels:   uint16(aac.OutputChannels()),
+		Config:     append([]byte{}, config...),
+	}, nil
+}
+
+// Codec RFC 6381格式的编码名称，如 avc1.64001f、hvc1.1.6.L93.B0、av01.0.08M.08、vp09.00.31.08、mp4a.40.2
+func (track *Track) Codec() string {
+	switch track.Entry {
+	case EntryHEVC:
+		return hevcCodec(track.Config)
+	case EntryAV1:
+		return av1Codec(track.Config)
+	case EntryVP9:
+		return fmt.Sprintf("vp09.%02d.%02d.%02d", track.Config[0], track.Config[1], track.Config[2]>>4)
+	}
+	if track.Type == TrackVideo {
+		if len(track.Config) < 4 {
+			return "avc1"
+		}
+		return fmt.Sprintf("avc1.%02x%02x%02x", track.Config[1], track.Config[2], track.Config[3])
+	}
+	aac, err := codec.ParseAACConfig(track.Config)
+	if err != nil {
+		return "mp4a.40.2"
+	}
+	return fmt.Sprintf("mp4a.40.%d", aac.SignaledObjectType)
+}
+
+// hevcCodec 由HEVCDecoderConfigurationRecord生成编码名称
+func hevcCodec(record []byte) string {
+	if len(record) < 13 {
+		return "hvc1"
+	}
+	space := []string{"", "A", "B", "C"}[record[1]>>6]
+	tier := "L"
+	if record[1]&0x20 != 0 {
+		tier = "H"
+	}
+	// 兼容标志按位逆序
+	var compatibility, flags uint32
+	for i := 0; i < 4; i++ {
+		compatibility = compatibility<<8 | uint32(record[2+i])
+	}
+	for i := 0; i < 32; i++ {
+		flags = flags<<1 | compatibility>>uint(i)&1
+	}
+	codec := fmt.Sprintf("hvc1.%s%d.%X.%s%d", space, record[1]&0x1f, flags, tier, record[12])
+	// 约束标志省略末尾的0字节
+	constraints := record[6:12]
+	for len(constraints) > 0 && constraints[len(constraints)-1] == 0 {
+		constraints = constraints[:len(constraints)-1]
+	}
+	for _, b := range constraints {
+		codec += fmt.Sprintf(".%X", b)
+	}
+	return codec
+}
+
+// av1Codec 由AV1CodecConfigurationRecord生成编码名称
+func av1Codec(record []byte) string {
+	tier := "M"
+	if record[2]&0x80 != 0 {
+		tier = "H"
+	}
+	bitDepth := 8
+	if record[2]&0x40 != 0 {
+		bitDepth = 10
+		if record[2]&0x20 != 0 {
+			bitDepth = 12
+		}
+	}
+	return fmt.Sprintf("av01.%d.%02d%s.%02d", record[1]>>5, record[1]&0x1f, tier, bitDepth)
+}
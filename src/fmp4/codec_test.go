@@ -0,0 +1,38 @@
+package fmp4
+
+import (
+	"testing"
+)
+
+// TestCodec 测试由解码配置记录生成的编码名称
+func TestCodec(t *testing.T) {
+	type arg struct {
+		entry  string
+		record []byte
+	}
+	var tests = []struct {
+		in       arg    // input
+		expected string // expected result
+	}{
+		{arg{EntryAVC, []byte{1, 0x64, 0x00, 0x1f, 0xff, 0xe0, 0x00, 0x00}}, "avc1.64001f"},
+		// Main profile, level 4.0, Main tier, 8 bit
+		{arg{EntryAV1, []byte{0x81, 0x08, 0x0c, 0x00}}, "av01.0.08M.08"},
+		// Main profile, level 5.1, High tier, 10 bit
+		{arg{EntryAV1, []byte{0x81, 0x0d, 0xcc, 0x00}}, "av01.0.13H.10"},
+		// Profile 0, level 3.1, 8 bit
+		{arg{EntryVP9, []byte{0x00, 0x1f, 0x82, 0x02, 0x02, 0x02, 0x00, 0x00}}, "vp09.00.31.08"},
+	}
+
+	for _, test := range tests {
+		track, err := NewCodecTrack(1, test.in.entry, test.in.record)
+		actual := ""
+		if err == nil {
+			actual = track.Codec()
+		}
+		if actual != test.expected {
+			t.Errorf("[×] in: %v out: %v %v expected: %v\n", test.in, actual, err, test.expected)
+		} else {
+			t.Logf("[√] in: %v out: %v expected: %v\n", test.in, actual, test.expected)
+		}
+	}
+}
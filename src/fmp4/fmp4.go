@@ -0,0 +1,375 @@
+package fmp4
+
+import (
+	"encoding/binary"
+)
+
+/*
+
+CMAF 分片MP4封装，用于低延迟HLS、DASH与MP4录制
+
+初始化分片包含ftyp与moov，媒体分片由若干moof+mdat组成
+视频为H.264或H.265(长度前缀的NALU)、AV1(OBU)或VP9，音频为AAC原始帧
+
+*/
+
+// 轨道类型
+const (
+	TrackVideo = "vide"
+	TrackAudio = "soun"
+)
+
+// 样本描述类型，Track.Entry为空时视频为avc1，音频为mp4a
+const (
+	EntryAVC  = "avc1"
+	EntryHEVC = "hvc1"
+	EntryAV1  = "av01"
+	EntryVP9  = "vp09"
+	EntryAAC  = "mp4a"
+)
+
+// 样本标志
+const (
+	sampleFlagsSync    = uint32(0x02000000) // 不依赖其他样本
+	sampleFlagsNonSync = uint32(0x01010000) // 依赖其他样本，非同步样本
+)
+
+// Track 轨道信息
+type Track struct {
+	ID        uint32
+	Type      string // TrackVideo 或 TrackAudio
+	Entry     string // 样本描述类型
+	Timescale uint32 // 每秒的时间单位数
+
+	Width  uint16 // 视频宽度
+	Height uint16 // 视频高度
+
+	SampleRate uint32 // 音频采样率
+	Channels   uint16 // 音频声道数
+
+	Config []byte // 视频为解码配置记录，如AVC/HEVCDecoderConfigurationRecord，音频为AudioSpecificConfig
+}
+
+// Sample 一个音视频样本
+type Sample struct {
+	Duration          uint32 // 时长，单位为轨道的时间单位
+	CompositionOffset int32  // 显示时间与解码时间的差
+	KeyFrame          bool
+	Data              []byte
+}
+
+// Fragment 一个轨道在一个分段中的样本
+type Fragment struct {
+	Track    *Track
+	BaseTime uint64 // 第一个样本的解码时间
+	Samples  []Sample
+}
+
+// Duration 样本的总时长
+func (fragment *Fragment) Duration() uint64 {
+	var duration uint64
+	for _, sample := range fragment.Samples {
+		duration += uint64(sample.Duration)
+	}
+	return duration
+}
+
+// InitSegment 生成初始化分片
+func InitSegment(tracks []*Track) []byte {
+	buf := appendBox(nil, "ftyp", func(buf []byte) []byte {
+		buf = append(buf, "iso6"...)
+		buf = appendUint32(buf, 0)
+		return append(buf, "iso6cmfcmp41"...)
+	})
+	return appendBox(buf, "moov", func(buf []byte) []byte {
+		buf = appendMVHD(buf, nextTrackID(tracks), 0)
+		for _, track := range tracks {
+			buf = appendTRAK(buf, track, nil)
+		}
+		return appendBox(buf, "mvex", func(buf []byte) []byte {
+			for _, track := range tracks {
+				buf = appendFullBox(buf, "trex", 0, 0, func(buf []byte) []byte {
+					buf = appendUint32(buf, track.ID)
+					buf = appendUint32(buf, 1) // default_sample_description_index
+					return appendZeros(buf, 12)
+				})
+			}
+			return buf
+		})
+	})
+}
+
+// AppendFragment 追加一个moof+mdat分段，sequence为分段序号
+func AppendFragment(buf []byte, sequence uint32, fragments []Fragment) []byte {
+	start := len(buf)
+	offsets := make([]int, len(fragments)) // 各轨道trun中data_offset字段的位置
+	buf = appendBox(buf, "moof", func(buf []byte) []byte {
+		buf = appendFullBox(buf, "mfhd", 0, 0, func(buf []byte) []byte {
+			return appendUint32(buf, sequence)
+		})
+		for i, fragment := range fragments {
+			buf = appendTRAF(buf, fragment, &offsets[i])
+		}
+		return buf
+	})
+
+	// 回填各轨道数据在mdat中相对moof开始的偏移
+	dataOffset := len(buf) - start + 8
+	for i, fragment := range fragments {
+		binary.BigEndian.PutUint32(buf[offsets[i]:], uint32(dataOffset))
+		for _, sample := range fragment.Samples {
+			dataOffset += len(sample.Data)
+		}
+	}
+
+	return appendBox(buf, "mdat", func(buf []byte) []byte {
+		for _, fragment := range fragments {
+			for _, sample := range fragment.Samples {
+				buf = append(buf, sample.Data...)
+			}
+		}
+		return buf
+	})
+}
+
+// nextTrackID 大于所有轨道ID的下一个轨道ID
+func nextTrackID(tracks []*Track) uint32 {
+	next := uint32(1)
+	for _, track := range tracks {
+		if track.ID >= next {
+			next = track.ID + 1
+		}
+	}
+	return next
+}
+
+// appendMVHD 追加影片头，duration的时间单位为movieTimescale
+func appendMVHD(buf []byte, nextTrackID uint32, duration uint32) []byte {
+	return appendFullBox(buf, "mvhd", 0, 0, func(buf []byte) []byte {
+		buf = appendZeros(buf, 8) // creation_time modification_time
+		buf = appendUint32(buf, movieTimescale)
+		buf = appendUint32(buf, duration)
+		buf = appendUint32(buf, 0x00010000) // rate
+		buf = appendUint16(buf, 0x0100)     // volume
+		buf = appendZeros(buf, 10)
+		buf = appendMatrix(buf)
+		buf = appendZeros(buf, 24) // pre_defined
+		return appendUint32(buf, nextTrackID)
+	})
+}
+
+// appendTRAK 追加轨道，table为空时样本表为空，用于分片MP4
+func appendTRAK(buf []byte, track *Track, table *sampleTable) []byte {
+	var duration uint64
+	if table != nil {
+		duration = table.duration()
+	}
+	return appendBox(buf, "trak", func(buf []byte) []byte {
+		buf = appendFullBox(buf, "tkhd", 0, 0x000003, func(buf []byte) []byte {
+			buf = appendZeros(buf, 8) // creation_time modification_time
+			buf = appendUint32(buf, track.ID)
+			buf = appendZeros(buf, 4)
+			buf = appendUint32(buf, movieDuration(duration, track.Timescale))
+			buf = appendZeros(buf, 8)
+			buf = appendUint16(buf, 0) // layer
+			buf = appendUint16(buf, 0) // alternate_group
+			if track.Type == TrackAudio {
+				buf = appendUint16(buf, 0x0100)
+			} else {
+				buf = appendUint16(buf, 0)
+			}
+			buf = appendZeros(buf, 2)
+			buf = appendMatrix(buf)
+			buf = appendUint32(buf, uint32(track.Width)<<16)
+			return appendUint32(buf, uint32(track.Height)<<16)
+		})
+		if table != nil {
+			buf = table.appendEDTS(buf, track)
+		}
+		return appendBox(buf, "mdia", func(buf []byte) []byte {
+			buf = appendFullBox(buf, "mdhd", 0, 0, func(buf []byte) []byte {
+				buf = appendZeros(buf, 8) // creation_time modification_time
+				buf = appendUint32(buf, track.Timescale)
+				buf = appendUint32(buf, uint32(duration))
+				buf = appendUint16(buf, 0x55c4) // und
+				return appendUint16(buf, 0)
+			})
+			buf = appendFullBox(buf, "hdlr", 0, 0, func(buf []byte) []byte {
+				buf = appendUint32(buf, 0)
+				buf = append(buf, track.Type...)
+				buf = appendZeros(buf, 12)
+				if track.Type == TrackVideo {
+					buf = append(buf, "VideoHandler"...)
+				} else {
+					buf = append(buf, "SoundHandler"...)
+				}
+				return append(buf, 0)
+			})
+			return appendMINF(buf, track, table)
+		})
+	})
+}
+
+// appendMINF 追加媒体信息
+func appendMINF(buf []byte, track *Track, table *sampleTable) []byte {
+	return appendBox(buf, "minf", func(buf []byte) []byte {
+		if track.Type == TrackVideo {
+			buf = appendFullBox(buf, "vmhd", 0, 1, func(buf []byte) []byte {
+				return appendZeros(buf, 8)
+			})
+		} else {
+			buf = appendFullBox(buf, "smhd", 0, 0, func(buf []byte) []byte {
+				return appendZeros(buf, 4)
+			})
+		}
+		buf = appendBox(buf, "dinf", func(buf []byte) []byte {
+			return appendFullBox(buf, "dref", 0, 0, func(buf []byte) []byte {
+				buf = appendUint32(buf, 1)
+				return appendFullBox(buf, "url ", 0, 1, func(buf []byte) []byte {
+					return buf
+				})
+			})
+		})
+		return appendBox(buf, "stbl", func(buf []byte) []byte {
+			buf = appendFullBox(buf, "stsd", 0, 0, func(buf []byte) []byte {
+				buf = appendUint32(buf, 1)
+				if track.Type != TrackVideo {
+					return appendMP4A(buf, track)
+				}
+				switch track.Entry {
+				case EntryHEVC:
+					return appendVisualEntry(buf, track, "hvc1", "hvcC")
+				case EntryAV1:
+					return appendVisualEntry(buf, track, "av01", "av1C")
+				case EntryVP9:
+					return appendVisualEntry(buf, track, "vp09", "vpcC")
+				}
+				return appendVisualEntry(buf, track, "avc1", "avcC")
+			})
+			if table != nil {
+				return table.append(buf, track)
+			}
+			// 分片MP4的样本表为空
+			for _, boxType := range []string{"stts", "stsc", "stco"} {
+				buf = appendFullBox(buf, boxType, 0, 0, func(buf []byte) []byte {
+					return appendUint32(buf, 0)
+				})
+			}
+			return appendFullBox(buf, "stsz", 0, 0, func(buf []byte) []byte {
+				return appendZeros(buf, 8)
+			})
+		})
+	})
+}
+
+// appendVisualEntry 追加视频样本描述，configType为解码配置box的类型
+func appendVisualEntry(buf []byte, track *Track, entryType string, configType string) []byte {
+	return appendBox(buf, entryType, func(buf []byte) []byte {
+		buf = appendZeros(buf, 6)
+		buf = appendUint16(buf, 1) // data_reference_index
+		buf = appendZeros(buf, 16)
+		buf = appendUint16(buf, track.Width)
+		buf = appendUint16(buf, track.Height)
+		buf = appendUint32(buf, 0x00480000) // 72 dpi
+		buf = appendUint32(buf, 0x00480000)
+		buf = appendZeros(buf, 4)
+		buf = appendUint16(buf, 1) // frame_count
+		buf = appendZeros(buf, 32) // compressorname
+		buf = appendUint16(buf, 0x0018)
+		buf = appendUint16(buf, 0xffff)
+		if configType == "vpcC" {
+			// vpcC 为 FullBox，FLV中的序列头不包含版本与标志
+			return appendFullBox(buf, configType, 1, 0, func(buf []byte) []byte {
+				return append(buf, track.Config...)
+			})
+		}
+		return appendBox(buf, configType, func(buf []byte) []byte {
+			return append(buf, track.Config...)
+		})
+	})
+}
+
+// appendMP4A 追加AAC样本描述
+func appendMP4A(buf []byte, track *Track) []byte {
+	return appendBox(buf, "mp4a", func(buf []byte) []byte {
+		buf = appendZeros(buf, 6)
+		buf = appendUint16(buf, 1) // data_reference_index
+		buf = appendZeros(buf, 8)
+		buf = appendUint16(buf, track.Channels)
+		buf = appendUint16(buf, 16) // samplesize
+		buf = appendZeros(buf, 4)
+		buf = appendUint32(buf, track.SampleRate<<16)
+		return appendFullBox(buf, "esds", 0, 0, func(buf []byte) []byte {
+			return appendESDescriptor(buf, track.Config)
+		})
+	})
+}
+
+// appendESDescriptor 追加ES描述符，config为AudioSpecificConfig
+func appendESDescriptor(buf []byte, config []byte) []byte {
+	decoderSpecific := appendDescriptor(nil, 0x05, config)
+	decoderConfig := []byte{
+		0x40,    // objectTypeIndication: MPEG-4 Audio
+		0x15,    // streamType: audio
+		0, 0, 0, // bufferSizeDB
+		0, 0, 0, 0, // maxBitrate
+		0, 0, 0, 0, // avgBitrate
+	}
+	decoderConfig = append(decoderConfig, decoderSpecific...)
+
+	es := []byte{0, 0, 0} // ES_ID flags
+	es = appendDescriptor(es, 0x04, decoderConfig)
+	es = appendDescriptor(es, 0x06, []byte{0x02}) // SLConfigDescriptor
+	return appendDescriptor(buf, 0x03, es)
+}
+
+// appendDescriptor 追加MPEG-4描述符，长度使用可变长编码
+func appendDescriptor(buf []byte, tag byte, data []byte) []byte {
+	buf = append(buf, tag)
+	length := len(data)
+	for shift := 21; shift > 0; shift -= 7 {
+		if length>>uint(shift) > 0 {
+			buf = append(buf, byte(length>>uint(shift))&0x7f|0x80)
+		}
+	}
+	buf = append(buf, byte(length)&0x7f)
+	return append(buf, data...)
+}
+
+// appendTRAF 追加一个轨道的分段信息，offset记录data_offset字段的位置
+func appendTRAF(buf []byte, fragment Fragment, offset *int) []byte {
+	track := fragment.Track
+	return appendBox(buf, "traf", func(buf []byte) []byte {
+		// default-base-is-moof
+		buf = appendFullBox(buf, "tfhd", 0, 0x020000, func(buf []byte) []byte {
+			return appendUint32(buf, track.ID)
+		})
+		buf = appendFullBox(buf, "tfdt", 1, 0, func(buf []byte) []byte {
+			return appendUint64(buf, fragment.BaseTime)
+		})
+
+		// data-offset duration size flags
+		flags := uint32(0x000001 | 0x000100 | 0x000200 | 0x000400)
+		if track.Type == TrackVideo {
+			flags |= 0x000800 // composition-time-offset
+		}
+		return appendFullBox(buf, "trun", 1, flags, func(buf []byte) []byte {
+			buf = appendUint32(buf, uint32(len(fragment.Samples)))
+			*offset = len(buf)
+			buf = appendUint32(buf, 0)
+			for _, sample := range fragment.Samples {
+				buf = appendUint32(buf, sample.Duration)
+				buf = appendUint32(buf, uint32(len(sample.Data)))
+				if sample.KeyFrame || track.Type == TrackAudio {
+					buf = appendUint32(buf, sampleFlagsSync)
+				} else {
+					buf = appendUint32(buf, sampleFlagsNonSync)
+				}
+				if track.Type == TrackVideo {
+					buf = appendUint32(buf, uint32(sample.CompositionOffset))
+				}
+			}
+			return buf
+		})
+	})
+}
@@ -0,0 +1,94 @@
+package fmp4
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// boxTypes 返回数据中顶层box的类型，长度不正确时返回nil
+func boxTypes(data []byte) []string {
+	var types []string
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return nil
+		}
+		size := int(binary.BigEndian.Uint32(data))
+		if size < 8 || size > len(data) {
+			return nil
+		}
+		types = append(types, string(data[4:8]))
+		data = data[size:]
+	}
+	return types
+}
+
+// TestInitSegment 测试初始化分片的box结构
+func TestInitSegment(t *testing.T) {
+	tracks := []*Track{
+		{ID: 1, Type: TrackVideo, Timescale: 90000, Width: 1280, Height: 720, Config: []byte{1, 0x64, 0, 0x1f, 0xff, 0xe0, 0}},
+		{ID: 2, Type: TrackAudio, Timescale: 44100, SampleRate: 44100, Channels: 2, Config: []byte{0x12, 0x10}},
+	}
+	data := InitSegment(tracks)
+	actual := boxTypes(data)
+	if len(actual) != 2 || actual[0] != "ftyp" || actual[1] != "moov" {
+		t.Errorf("[×] out: %v expected: [ftyp moov]\n", actual)
+		return
+	}
+	moov := data[binary.BigEndian.Uint32(data)+8:]
+	actual = boxTypes(moov)
+	expected := []string{"mvhd", "trak", "trak", "mvex"}
+	if len(actual) != len(expected) {
+		t.Errorf("[×] out: %v expected: %v\n", actual, expected)
+		return
+	}
+	for i := range expected {
+		if actual[i] != expected[i] {
+			t.Errorf("[×] out: %v expected: %v\n", actual, expected)
+			return
+		}
+	}
+	t.Logf("[√] out: %v expected: %v\n", actual, expected)
+}
+
+// TestAppendFragment 测试分段中样本数据的偏移
+func TestAppendFragment(t *testing.T) {
+	video := &Track{ID: 1, Type: TrackVideo, Timescale: 90000}
+	audio := &Track{ID: 2, Type: TrackAudio, Timescale: 44100}
+	fragments := []Fragment{
+		{video, 0, []Sample{{3600, 0, true, []byte{1, 2, 3}}, {3600, 3600, false, []byte{4, 5}}}},
+		{audio, 0, []Sample{{1024, 0, true, []byte{6, 7, 8, 9}}}},
+	}
+	data := AppendFragment(nil, 1, fragments)
+	if types := boxTypes(data); len(types) != 2 || types[0] != "moof" || types[1] != "mdat" {
+		t.Errorf("[×] out: %v expected: [moof mdat]\n", types)
+		return
+	}
+
+	// 按trun中的data_offset读取每个轨道的第一个样本
+	moof := data[:binary.BigEndian.Uint32(data)]
+	var offsets []int
+	for i := 0; i+12 <= len(moof); i++ {
+		if string(moof[i+4:i+8]) == "trun" {
+			offsets = append(offsets, int(binary.BigEndian.Uint32(moof[i+16:])))
+		}
+	}
+	var tests = []struct {
+		in       int  // input
+		expected byte // expected result
+	}{
+		{0, 1},
+		{1, 6},
+	}
+	if len(offsets) != len(tests) {
+		t.Errorf("[×] trun count: %d expected: %d\n", len(offsets), len(tests))
+		return
+	}
+	for _, test := range tests {
+		actual := data[offsets[test.in]]
+		if actual != test.expected {
+			t.Errorf("[×] in: %v out: %v expected: %v\n", test.in, actual, test.expected)
+		} else {
+			t.Logf("[√] in: %v out: %v expected: %v\n", test.in, actual, test.expected)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package fmp4
+
+import (
+	"encoding/binary"
+)
+
+/*
+
+非分片MP4封装，用于MP4录制
+
+媒体数据先写入mdat并记录每个样本的位置，结束时生成包含完整样本表的moov
+同一轨道在mdat中连续存放的样本合并为一个chunk
+
+*/
+
+// movieTimescale 影片头与轨道头的时间单位
+const movieTimescale = 1000
+
+// movieDuration 轨道时长转换为影片的时间单位
+func movieDuration(duration uint64, timescale uint32) uint32 {
+	if timescale == 0 {
+		return 0
+	}
+	return uint32(duration * movieTimescale / uint64(timescale))
+}
+
+// FileType 非分片MP4的ftyp
+func FileType() []byte {
+	return appendBox(nil, "ftyp", func(buf []byte) []byte {
+		buf = append(buf, "isom"...)
+		buf = appendUint32(buf, 512)
+		return append(buf, "isomiso2mp41"...)
+	})
+}
+
+// Movie 非分片MP4的样本表
+type Movie struct {
+	Tracks []*Track
+	tables []*sampleTable
+}
+
+// NewMovie 新建样本表
+func NewMovie(tracks []*Track) *Movie {
+	movie := &Movie{Tracks: tracks}
+	for range tracks {
+		movie.tables = append(movie.tables, &sampleTable{})
+	}
+	return movie
+}
+
+// AddSample 记录第index个轨道的一个样本，offset为样本数据在文件中的位置
+// 视频样本的时长由下一个样本的解码时间确定，最后一个样本的时长为sample.Duration，为0时沿用上一个样本的时长
+func (movie *Movie) AddSample(index int, decodeTime uint64, offset uint64, sample Sample) {
+	movie.tables[index].add(decodeTime, offset, sample)
+}
+
+// Moov 生成moov，shift为mdat相对记录时的位置移动的字节数，用于将moov移动到mdat之前
+func (movie *Movie) Moov(shift uint64) []byte {
+	var duration uint32
+	for i, track := range movie.Tracks {
+		if d := movieDuration(movie.tables[i].duration(), track.Timescale); d > duration {
+			duration = d
+		}
+	}
+	return appendBox(nil, "moov", func(buf []byte) []byte {
+		buf = appendMVHD(buf, nextTrackID(movie.Tracks), duration)
+		for i, track := range movie.Tracks {
+			movie.tables[i].shift = shift
+			buf = appendTRAK(buf, track, movie.tables[i])
+		}
+		return buf
+	})
+}
+
+// FastStart 生成放在mdat之前的moov，chunk位置按moov的长度后移
+func (movie *Movie) FastStart() []byte {
+	moov := movie.Moov(0)
+	for {
+		// chunk位置超过32位时stco改为co64，moov长度随之变化
+		next := movie.Moov(uint64(len(moov)))
+		if len(next) == len(moov) {
+			return next
+		}
+		moov = next
+	}
+}
+
+// chunk 同一轨道连续存放的样本
+type chunk struct {
+	offset  uint64
+	samples uint32
+}
+
+// sampleTable 一个轨道的样本表
+type sampleTable struct {
+	decodeTimes  []uint64
+	lastDuration uint32 // 最后一个样本给出的时长
+	sizes        []uint32
+	offsets      []int32  // 显示时间与解码时间的差
+	sync         []uint32 // 同步样本的序号，从1开始
+	chunks       []chunk
+	chunkEnd     uint64 // 最后一个chunk结束的位置
+	shift        uint64 // 生成moov时chunk位置的偏移
+}
+
+// add 记录一个样本，解码时间不递增时沿用上一个样本的解码时间
+func (table *sampleTable) add(decodeTime uint64, offset uint64, sample Sample) {
+	if n := len(table.decodeTimes); n > 0 && decodeTime < table.decodeTimes[n-1] {
+		decodeTime = table.decodeTimes[n-1]
+	}
+	table.decodeTimes = append(table.decodeTimes, decodeTime)
+	table.lastDuration = sample.Duration
+	table.sizes = append(table.sizes, uint32(len(sample.Data)))
+	table.offsets = append(table.offsets, sample.CompositionOffset)
+	if sample.KeyFrame {
+		table.sync = append(table.sync, uint32(len(table.sizes)))
+	}
+
+	if len(table.chunks) > 0 && offset == table.chunkEnd {
+		table.chunks[len(table.chunks)-1].samples++
+	} else {
+		table.chunks = append(table.chunks, chunk{offset, 1})
+	}
+	table.chunkEnd = offset + uint64(len(sample.Data))
+}
+
+// durations 各样本的时长
+func (table *sampleTable) durations() []uint32 {
+	n := len(table.decodeTimes)
+	durations := make([]uint32, n)
+	for i := 0; i+1 < n; i++ {
+		durations[i] = uint32(table.decodeTimes[i+1] - table.decodeTimes[i])
+	}
+	if n > 0 {
+		durations[n-1] = table.lastDuration
+		if durations[n-1] == 0 && n > 1 {
+			durations[n-1] = durations[n-2]
+		}
+	}
+	return durations
+}
+
+// duration 轨道时长
+func (table *sampleTable) duration() uint64 {
+	var duration uint64
+	for _, d := range table.durations() {
+		duration += uint64(d)
+	}
+	return duration
+}
+
+// appendEDTS 第一个样本的显示时间晚于解码时间时，追加编辑列表使显示从0开始
+func (table *sampleTable) appendEDTS(buf []byte, track *Track) []byte {
+	if len(table.offsets) == 0 || table.offsets[0] <= 0 {
+		return buf
+	}
+	return appendBox(buf, "edts", func(buf []byte) []byte {
+		return appendFullBox(buf, "elst", 0, 0, func(buf []byte) []byte {
+			buf = appendUint32(buf, 1)
+			buf = appendUint32(buf, movieDuration(table.duration(), track.Timescale))
+			buf = appendUint32(buf, uint32(table.offsets[0])) // media_time
+			return appendUint32(buf, 0x00010000)              // media_rate
+		})
+	})
+}
+
+// append 追加stsd之后的样本表
+func (table *sampleTable) append(buf []byte, track *Track) []byte {
+	buf = appendRuns(buf, "stts", 0, table.durations())
+
+	var hasOffsets, negative bool
+	offsets := make([]uint32, len(table.offsets))
+	for i, offset := range table.offsets {
+		hasOffsets = hasOffsets || offset != 0
+		negative = negative || offset < 0
+		offsets[i] = uint32(offset)
+	}
+	if hasOffsets {
+		var version uint8
+		if negative {
+			version = 1
+		}
+		buf = appendRuns(buf, "ctts", version, offsets)
+	}
+
+	if track.Type == TrackVideo && len(table.sync) < len(table.sizes) {
+		buf = appendFullBox(buf, "stss", 0, 0, func(buf []byte) []byte {
+			buf = appendUint32(buf, uint32(len(table.sync)))
+			for _, index := range table.sync {
+				buf = appendUint32(buf, index)
+			}
+			return buf
+		})
+	}
+
+	buf = appendFullBox(buf, "stsc", 0, 0, func(buf []byte) []byte {
+		countPos := len(buf)
+		buf = appendUint32(buf, 0)
+		var entries, last uint32
+		for i, c := range table.chunks {
+			if i == 0 || c.samples != last {
+				buf = appendUint32(buf, uint32(i+1))
+				buf = appendUint32(buf, c.samples)
+				buf = appendUint32(buf, 1) // sample_description_index
+				entries++
+				last = c.samples
+			}
+		}
+		binary.BigEndian.PutUint32(buf[countPos:], entries)
+		return buf
+	})
+
+	buf = appendFullBox(buf, "stsz", 0, 0, func(buf []byte) []byte {
+		buf = appendUint32(buf, 0) // sample_size
+		buf = appendUint32(buf, uint32(len(table.sizes)))
+		for _, size := range table.sizes {
+			buf = appendUint32(buf, size)
+		}
+		return buf
+	})
+
+	large := len(table.chunks) > 0 && table.chunks[len(table.chunks)-1].offset+table.shift > 0xffffffff
+	if large {
+		return appendFullBox(buf, "co64", 0, 0, func(buf []byte) []byte {
+			buf = appendUint32(buf, uint32(len(table.chunks)))
+			for _, c := range table.chunks {
+				buf = appendUint64(buf, c.offset+table.shift)
+			}
+			return buf
+		})
+	}
+	return appendFullBox(buf, "stco", 0, 0, func(buf []byte) []byte {
+		buf = appendUint32(buf, uint32(len(table.chunks)))
+		for _, c := range table.chunks {
+			buf = appendUint32(buf, uint32(c.offset+table.shift))
+		}
+		return buf
+	})
+}
+
+// appendRuns 追加按游程编码的 样本数+值 表，用于stts与ctts
+func appendRuns(buf []byte, boxType string, version uint8, values []uint32) []byte {
+	return appendFullBox(buf, boxType, version, 0, func(buf []byte) []byte {
+		countPos := len(buf)
+		buf = appendUint32(buf, 0)
+		var entries uint32
+		for i := 0; i < len(values); {
+			j := i + 1
+			for j < len(values) && values[j] == values[i] {
+				j++
+			}
+			buf = appendUint32(buf, uint32(j-i))
+			buf = appendUint32(buf, values[i])
+			entries++
+			i = j
+		}
+		binary.BigEndian.PutUint32(buf[countPos:], entries)
+		return buf
+	})
+}
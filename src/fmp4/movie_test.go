@@ -0,0 +1,60 @@
+package fmp4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// findBox 在moov中查找指定类型的box内容
+func findBox(data []byte, boxType string) []byte {
+	idx := bytes.Index(data, []byte(boxType))
+	if idx < 4 {
+		return nil
+	}
+	size := int(binary.BigEndian.Uint32(data[idx-4:]))
+	return data[idx+4 : idx-4+size]
+}
+
+// TestMovie 测试非分片MP4的样本表
+func TestMovie(t *testing.T) {
+	video := &Track{ID: 1, Type: TrackVideo, Timescale: 90000, Config: []byte{1, 0x64, 0, 0x1f, 0xff, 0xe0, 0}}
+	audio := &Track{ID: 2, Type: TrackAudio, Timescale: 48000, SampleRate: 48000, Channels: 2, Config: []byte{0x11, 0x90}}
+	movie := NewMovie([]*Track{video, audio})
+	// I P B，视频两个样本连续存放为一个chunk
+	movie.AddSample(0, 0, 100, Sample{CompositionOffset: 6000, KeyFrame: true, Data: make([]byte, 10)})
+	movie.AddSample(0, 3000, 110, Sample{CompositionOffset: 9000, Data: make([]byte, 10)})
+	movie.AddSample(1, 0, 120, Sample{Duration: 1024, KeyFrame: true, Data: make([]byte, 5)})
+	movie.AddSample(0, 6000, 125, Sample{CompositionOffset: -3000, Data: make([]byte, 10)})
+
+	moov := movie.FastStart()
+	shift := uint32(len(moov))
+	var tests = []struct {
+		box      string // input
+		expected []uint32
+	}{
+		{"stts", []uint32{0, 1, 3, 3000}},
+		{"ctts", []uint32{0x01000000, 3, 1, 6000, 1, 9000, 1, 0xfffff448}},
+		{"stss", []uint32{0, 1, 1}},
+		{"stsc", []uint32{0, 2, 1, 2, 1, 2, 1, 1}},
+		{"stco", []uint32{0, 2, 100 + shift, 125 + shift}},
+		{"elst", []uint32{0, 1, 100, 6000, 0x00010000}},
+	}
+
+	for _, test := range tests {
+		data := findBox(moov, test.box)
+		actual := make([]uint32, len(data)/4)
+		for i := range actual {
+			actual[i] = binary.BigEndian.Uint32(data[i*4:])
+		}
+		ok := len(actual) == len(test.expected)
+		for i := 0; ok && i < len(actual); i++ {
+			ok = actual[i] == test.expected[i]
+		}
+		if !ok {
+			t.Errorf("[×] in: %s out: %v expected: %v\n", test.box, actual, test.expected)
+		} else {
+			t.Logf("[√] in: %s out: %v expected: %v\n", test.box, actual, test.expected)
+		}
+	}
+}
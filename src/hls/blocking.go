@@ -0,0 +1,142 @@
+package hls
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+/*
+
+低延迟HLS的阻塞式请求
+
+播放列表请求携带 _HLS_msn 与 _HLS_part 时，等待到对应的分片或部分分片生成后再返回
+请求预加载提示中的部分分片时，等待到该部分分片生成后再返回
+
+*/
+
+// blockingWaits 阻塞请求最多等待的目标分片时长倍数
+const blockingWaits = 3
+
+// waitResult 阻塞请求的等待结果
+type waitResult int
+
+// 阻塞请求的等待结果
+const (
+	waitReady   waitResult = iota // 已生成
+	waitTooFar                    // 请求的分片超出了下一个分片
+	waitTimeout                   // 等待超时
+)
+
+// playlistState 播放列表的最新状态
+type playlistState struct {
+	sequence  uint64        // 正在生成的分片序列号
+	parts     int           // 正在生成的分片中已完成的部分分片数
+	ended     bool          // 推流是否已结束
+	bandwidth int           // 分片的最高码率，用于主播放列表
+	updated   chan struct{} // 播放列表更新时关闭
+}
+
+// update 更新状态并唤醒等待的请求
+func (state *playlistState) update(sequence uint64, parts int, ended bool) {
+	state.sequence = sequence
+	state.parts = parts
+	state.ended = ended
+	close(state.updated)
+	state.updated = make(chan struct{})
+}
+
+// ready 播放列表中是否已包含msn分片的第part个部分分片，part为负数时要求msn分片已完成
+func (state *playlistState) ready(msn uint64, part int) bool {
+	if state.ended || msn < state.sequence {
+		return true
+	}
+	return msn == state.sequence && part >= 0 && part < state.parts
+}
+
+// blockingTimeout 阻塞请求的最长等待时间
+func (server *Server) blockingTimeout() time.Duration {
+	return blockingWaits * server.targetDuration()
+}
+
+// waitPlaylist 等待播放列表中包含msn分片的第part个部分分片
+func (server *Server) waitPlaylist(ctx context.Context, name string, msn uint64, part int) waitResult {
+	timer := time.NewTimer(server.blockingTimeout())
+	defer timer.Stop()
+
+	for {
+		server.mutex.Lock()
+		state, ok := server.states[name]
+		if !ok {
+			server.mutex.Unlock()
+			return waitTimeout
+		}
+		if state.ready(msn, part) {
+			server.mutex.Unlock()
+			return waitReady
+		}
+		if msn > state.sequence+1 {
+			server.mutex.Unlock()
+			return waitTooFar
+		}
+		updated := state.updated
+		server.mutex.Unlock()
+
+		select {
+		case <-updated:
+		case <-timer.C:
+			return waitTimeout
+		case <-ctx.Done():
+			return waitTimeout
+		}
+	}
+}
+
+// waitPart 请求的部分分片为下一个将生成的部分分片时等待其生成，返回是否需要重新读取
+func (server *Server) waitPart(ctx context.Context, name string, file string) bool {
+	fields := strings.Split(file, ".")
+	if len(fields) != 3 {
+		return false
+	}
+	msn, err := strconv.ParseUint(fields[0], 10, 64)
+	if err != nil {
+		return false
+	}
+	part, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return false
+	}
+
+	server.mutex.Lock()
+	state, ok := server.states[name]
+	next := ok && !state.ended && (msn == state.sequence && part == state.parts || msn == state.sequence+1 && part == 0)
+	server.mutex.Unlock()
+	if !next {
+		return false
+	}
+	return server.waitPlaylist(ctx, name, msn, part) == waitReady
+}
+
+// parseBlockingQuery 解析阻塞式请求参数，没有 _HLS_msn 时blocking为false，没有 _HLS_part 时part为-1
+func parseBlockingQuery(query url.Values) (msn uint64, part int, blocking bool, err error) {
+	value := query.Get("_HLS_msn")
+	if value == "" {
+		return 0, -1, false, nil
+	}
+	msn, err = strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, -1, false, errors.Errorf("invalid _HLS_msn %q", value)
+	}
+	part = -1
+	if value := query.Get("_HLS_part"); value != "" {
+		part, err = strconv.Atoi(value)
+		if err != nil || part < 0 {
+			return 0, -1, false, errors.Errorf("invalid _HLS_part %q", value)
+		}
+	}
+	return msn, part, true, nil
+}
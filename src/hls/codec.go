@@ -0,0 +1,145 @@
+package hls
+
+import (
+	"../codec"
+	"../flv"
+	"github.com/pkg/errors"
+)
+
+/*
+
+FLV 音视频负载转换为MPEG-TS使用的格式
+
+H.264/H.265 由长度前缀的NALU转换为Annex B，AAC 增加ADTS头
+AV1/VP9 只能封装为fMP4
+fMP4 直接使用FLV中的负载与序列头
+
+*/
+
+// startCode Annex B起始码
+var startCode = []byte{0x00, 0x00, 0x00, 0x01}
+
+// audNALU H.264访问单元分隔符
+var audNALU = []byte{0x00, 0x00, 0x00, 0x01, 0x09, 0xf0}
+
+// hevcAUDNALU H.265访问单元分隔符
+var hevcAUDNALU = []byte{0x00, 0x00, 0x00, 0x01, 0x46, 0x01, 0x50}
+
+// videoConfig 视频序列头中的参数
+type videoConfig struct {
+	codec      string   // FLV中的FourCC
+	record     []byte   // 原始的解码配置记录
+	lengthSize int      // NALU长度字段的字节数，AV1/VP9为0
+	params     [][]byte // 关键帧前补充的参数集，H.264为SPS PPS，H.265为VPS SPS PPS
+}
+
+// parseVideoConfig 按编码解析序列头中的解码配置记录
+func parseVideoConfig(fourCC string, data []byte) (*videoConfig, error) {
+	switch fourCC {
+	case flv.FourCCAVC:
+		return parseAVCConfig(data)
+	case flv.FourCCHEVC:
+		return parseHEVCConfig(data)
+	case flv.FourCCAV1, flv.FourCCVP9:
+		return &videoConfig{codec: fourCC, record: append([]byte{}, data...)}, nil
+	}
+	return nil, errors.Errorf("video codec %q not supported", fourCC)
+}
+
+// parseAVCConfig 解析AVCDecoderConfigurationRecord
+func parseAVCConfig(data []byte) (*videoConfig, error) {
+	record, err := codec.ParseAVCConfig(data)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &videoConfig{
+		codec:      flv.FourCCAVC,
+		record:     append([]byte{}, data...),
+		lengthSize: record.LengthSize,
+		params:     record.ParameterSets(),
+	}, nil
+}
+
+// parseHEVCConfig 解析HEVCDecoderConfigurationRecord
+func parseHEVCConfig(data []byte) (*videoConfig, error) {
+	record, err := codec.ParseHEVCConfig(data)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &videoConfig{
+		codec:      flv.FourCCHEVC,
+		record:     append([]byte{}, data...),
+		lengthSize: record.LengthSize,
+		params:     record.ParameterSets(),
+	}, nil
+}
+
+// annexB 是否可以转换为Annex B封装到MPEG-TS
+func (config *videoConfig) annexB() bool {
+	return config.lengthSize > 0
+}
+
+// appendAnnexB 将长度前缀的NALU转换为Annex B格式追加到buf，关键帧前补充参数集
+func (config *videoConfig) appendAnnexB(buf []byte, data []byte, keyFrame bool) ([]byte, error) {
+	hevc := config.codec == flv.FourCCHEVC
+	if hevc {
+		buf = append(buf, hevcAUDNALU...)
+	} else {
+		buf = append(buf, audNALU...)
+	}
+	nalus, err := codec.NALUnits(data, config.lengthSize)
+	wroteParams := false
+	for _, nalu := range nalus {
+		var aud, param, random bool
+		if hevc {
+			naluType := codec.HEVCNALType(nalu)
+			aud = naluType == codec.HEVCNALAUD
+			param = naluType >= codec.HEVCNALVPS && naluType <= codec.HEVCNALPPS
+			random = naluType >= codec.HEVCNALIRAPFirst && naluType <= codec.HEVCNALIRAPLast
+		} else {
+			naluType := codec.AVCNALType(nalu)
+			aud = naluType == codec.AVCNALAUD
+			param = naluType == codec.AVCNALSPS || naluType == codec.AVCNALPPS
+			random = naluType == codec.AVCNALIDR
+		}
+		switch {
+		case aud:
+			continue
+		case param:
+			wroteParams = true
+		case random && keyFrame && !wroteParams:
+			buf = config.appendParameterSets(buf)
+			wroteParams = true
+		}
+		buf = append(buf, startCode...)
+		buf = append(buf, nalu...)
+	}
+	return buf, errors.WithStack(err)
+}
+
+// appendParameterSets 追加Annex B格式的参数集
+func (config *videoConfig) appendParameterSets(buf []byte) []byte {
+	for _, param := range config.params {
+		buf = append(buf, startCode...)
+		buf = append(buf, param...)
+	}
+	return buf
+}
+
+// aacConfig AudioSpecificConfig中的参数
+type aacConfig struct {
+	record []byte // 原始的AudioSpecificConfig
+	*codec.AACConfig
+}
+
+// parseAACConfig 解析AudioSpecificConfig，MPEG-TS中的ADTS头只能表示采样率序号
+func parseAACConfig(data []byte) (*aacConfig, error) {
+	config, err := codec.ParseAACConfig(data)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if !config.ADTS() {
+		return nil, errors.Errorf("AAC sample rate %d not supported", config.SampleRate)
+	}
+	return &aacConfig{append([]byte{}, data...), config}, nil
+}
@@ -0,0 +1,114 @@
+package hls
+
+import (
+	"../fmp4"
+	"github.com/pkg/errors"
+)
+
+// aacFrameSamples 每个AAC帧的采样数
+const aacFrameSamples = 1024
+
+// 轨道ID
+const (
+	trackIDVideo = uint32(1)
+	trackIDAudio = uint32(2)
+)
+
+// fmp4Packager CMAF分片MP4封装，音视频在同一个分片中
+type fmp4Packager struct {
+	videoConfig *videoConfig
+	aac         *aacConfig
+	init        []byte
+	video       *fmp4.SampleBuffer
+	audio       *fmp4.SampleBuffer
+	sequence    uint32 // moof序号
+}
+
+// supports fMP4可以封装所有支持的视频编码
+func (p *fmp4Packager) supports(video *videoConfig) bool {
+	return true
+}
+
+// begin 开始新的分片，编码参数变化时重新生成初始化分片
+func (p *fmp4Packager) begin(video *videoConfig, aac *aacConfig) error {
+	if p.init != nil && video == p.videoConfig && aac == p.aac {
+		return nil
+	}
+	p.videoConfig, p.aac = video, aac
+	p.video, p.audio = nil, nil
+
+	var tracks []*fmp4.Track
+	if video != nil {
+		track, err := fmp4.NewCodecTrack(trackIDVideo, video.codec, video.record)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		p.video = fmp4.NewSampleBuffer(track)
+		tracks = append(tracks, track)
+	}
+	if aac != nil {
+		track, err := fmp4.NewAudioTrack(trackIDAudio, aac.record)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		p.audio = fmp4.NewSampleBuffer(track)
+		tracks = append(tracks, track)
+	}
+	if len(tracks) == 0 {
+		return errors.New("no track to package")
+	}
+	p.init = fmp4.InitSegment(tracks)
+	return nil
+}
+
+// writeVideo 加入视频样本
+func (p *fmp4Packager) writeVideo(timestamp uint32, cts int32, data []byte, keyFrame bool) error {
+	if p.video == nil {
+		return nil
+	}
+	p.video.Add(uint64(timestamp)*90, fmp4.Sample{
+		CompositionOffset: cts * 90,
+		KeyFrame:          keyFrame,
+		Data:              append([]byte{}, data...),
+	})
+	return nil
+}
+
+// writeAudio 加入音频样本，AAC帧的时长固定
+func (p *fmp4Packager) writeAudio(timestamp uint32, data []byte) error {
+	if p.audio == nil {
+		return nil
+	}
+	p.audio.Add(uint64(timestamp)*uint64(p.audio.Track.Timescale)/1000, fmp4.Sample{
+		Duration: aacFrameSamples,
+		KeyFrame: true,
+		Data:     append([]byte{}, data...),
+	})
+	return nil
+}
+
+// flush 将待写出的样本封装为一个moof+mdat
+func (p *fmp4Packager) flush(end uint32) ([]byte, error) {
+	var fragments []fmp4.Fragment
+	if p.video != nil && p.video.Len() > 0 {
+		fragments = append(fragments, p.video.Take(uint64(end)*90))
+	}
+	if p.audio != nil && p.audio.Len() > 0 {
+		fragments = append(fragments, p.audio.Take(0))
+	}
+	if len(fragments) == 0 {
+		return nil, nil
+	}
+	p.sequence++
+	return fmp4.AppendFragment(nil, p.sequence, fragments), nil
+}
+
+// initSegment 初始化分片
+func (p *fmp4Packager) initSegment() []byte {
+	return p.init
+}
+
+// extension 分片文件扩展名
+func (p *fmp4Packager) extension() string {
+	return ".m4s"
+}
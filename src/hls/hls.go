@@ -0,0 +1,353 @@
+package hls
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"../config"
+	c "../lib/colorful"
+	"../rtmp"
+	s "../server"
+	"github.com/pkg/errors"
+)
+
+/*
+
+HLS 输出
+
+推流开始时为流创建分片器，播放列表与分片通过HTTP提供，路径为 前缀/应用/流名称/index.m3u8
+多轨道的流的替代轨道路径为 前缀/应用/流名称/audio1/index.m3u8，主播放列表 master.m3u8 列出所有轨道
+
+*/
+
+// PlaylistName 播放列表文件名
+const PlaylistName = "index.m3u8"
+
+// MasterPlaylistName 列出替代轨道的主播放列表文件名
+const MasterPlaylistName = "master.m3u8"
+
+// filePattern 可以请求的文件名，包括播放列表、初始化分片、分片与部分分片
+var filePattern = regexp.MustCompile(`^(index\.m3u8|init-[0-9]+\.mp4|[0-9]+(\.[0-9]+)?\.(ts|m4s))$`)
+
+// renditionPattern 替代轨道的目录名
+var renditionPattern = regexp.MustCompile(`^(audio|video)[0-9]+$`)
+
+// uriPattern 播放列表标签中的地址属性
+var uriPattern = regexp.MustCompile(`URI="[^"]*"`)
+
+// contentTypes 各类分片文件的类型
+var contentTypes = map[string]string{
+	".ts":  "video/mp2t",
+	".m4s": "video/iso.segment",
+	".mp4": "video/mp4",
+}
+
+// Server HLS输出服务
+type Server struct {
+	RTMP    *rtmp.Server
+	Config  config.HLSOutput
+	Storage Storage
+
+	mutex     sync.Mutex
+	active    map[string]*Segmenter // 正在推流的分片器
+	sequences map[string]uint64     // 各条流下一个分片的序列号，推流重新开始后继续递增
+	states    map[string]*playlistState
+	wg        sync.WaitGroup
+}
+
+// NewServer 新建HLS输出服务，storage可与DASH等输出共用
+func NewServer(server *rtmp.Server, storage Storage) *Server {
+	return &Server{
+		RTMP:      server,
+		Config:    server.Config.Outputs.HLS,
+		Storage:   storage,
+		active:    make(map[string]*Segmenter),
+		sequences: make(map[string]uint64),
+		states:    make(map[string]*playlistState),
+	}
+}
+
+// Publish 推流开始时调用，为流增加分片器，流已有正在运行的分片器或推流端已断开时不再增加
+func (server *Server) Publish(stream *rtmp.Stream) {
+	defer server.mutex.Unlock()
+	server.mutex.Lock()
+
+	if seg, ok := server.active[stream.Name]; ok && seg.running() {
+		return
+	}
+	seg := newSegmenter(server, stream.Name)
+	if !stream.AddOutput(seg.queue) {
+		return
+	}
+	server.active[stream.Name] = seg
+	server.wg.Add(1)
+
+	log.Println(c.Front("HLS %s started", c.G, stream.Name))
+	go seg.run()
+}
+
+// Shutdown 等待所有分片器写出最后的分片，推流端需先断开
+func (server *Server) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		server.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return errors.WithStack(ctx.Err())
+	}
+}
+
+// nextSequence 分配下一个分片序列号
+func (server *Server) nextSequence(name string) uint64 {
+	defer server.mutex.Unlock()
+	server.mutex.Lock()
+
+	sequence := server.sequences[name]
+	server.sequences[name] = sequence + 1
+	return sequence
+}
+
+// putPlaylist 写出播放列表并唤醒阻塞的请求，流已被新的推流接管时不再写出
+func (server *Server) putPlaylist(seg *Segmenter, playlist []byte, sequence uint64, parts int, ended bool, bandwidth int) error {
+	defer server.mutex.Unlock()
+	server.mutex.Lock()
+
+	if root := seg.root(); server.active[root.Name] != root {
+		return nil
+	}
+	if err := server.Storage.Put(seg.Name+"/"+PlaylistName, playlist); err != nil {
+		return errors.WithStack(err)
+	}
+	state, ok := server.states[seg.Name]
+	if !ok {
+		state = &playlistState{updated: make(chan struct{})}
+		server.states[seg.Name] = state
+	}
+	state.bandwidth = bandwidth
+	state.update(sequence, parts, ended)
+	return nil
+}
+
+// finished 分片器结束，按配置延迟删除分片，子分片器随所属的分片器删除
+func (server *Server) finished(seg *Segmenter) {
+	if seg.parent != nil {
+		return
+	}
+	delay := server.Config.Cleanup.Duration()
+	if delay > 0 {
+		time.AfterFunc(delay, func() {
+			server.cleanup(seg)
+		})
+	}
+	server.wg.Done()
+}
+
+// cleanup 删除已结束的分片器生成的分片，流没有重新推流时同时删除播放列表
+func (server *Server) cleanup(seg *Segmenter) {
+	defer server.mutex.Unlock()
+	server.mutex.Lock()
+
+	if server.active[seg.Name] == seg {
+		delete(server.active, seg.Name)
+		for _, s := range append([]*Segmenter{seg}, seg.renditions...) {
+			delete(server.states, s.Name)
+			server.Storage.Delete(s.Name + "/" + PlaylistName)
+		}
+	}
+	seg.cleanup()
+}
+
+// targetDuration 目标分片时长
+func (server *Server) targetDuration() time.Duration {
+	return server.Config.TargetDuration.Duration()
+}
+
+// partDuration 部分分片目标时长，为0时不使用低延迟模式
+func (server *Server) partDuration() time.Duration {
+	if server.Config.Format != FormatFMP4 {
+		return 0
+	}
+	return server.Config.PartDuration.Duration()
+}
+
+// Handler HTTP处理函数，开启跨域时增加跨域响应头
+func (server *Server) Handler() http.Handler {
+	var handler http.Handler = server
+	if server.Config.CORS.Enable {
+		handler = s.CORS(server.Config.CORS.AllowOrigins, handler)
+	}
+	return handler
+}
+
+// ServeHTTP 提供播放列表与分片
+func (server *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	appName, streamName, rendition, file, ok := server.parsePath(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	cfg := server.RTMP.Config
+	app, ok := cfg.Application(appName)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	query := r.URL.Query()
+	if !app.AllowPlay() || !server.RTMP.Authorize(app, false, query) {
+		http.Error(w, "play denied", http.StatusForbidden)
+		return
+	}
+
+	name := appName + "/" + streamName
+	if rendition != "" {
+		name += "/" + rendition
+	}
+	if file == MasterPlaylistName {
+		server.serveMaster(w, r, name)
+		return
+	}
+	if file == PlaylistName && server.partDuration() > 0 {
+		msn, part, blocking, err := parseBlockingQuery(query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if blocking {
+			switch server.waitPlaylist(r.Context(), name, msn, part) {
+			case waitTooFar:
+				http.Error(w, "_HLS_msn too far ahead", http.StatusBadRequest)
+				return
+			case waitTimeout:
+				http.Error(w, "playlist update timeout", http.StatusServiceUnavailable)
+				return
+			}
+		}
+	}
+
+	data, err := server.Storage.Get(name + "/" + file)
+	if err == ErrNotFound && server.partDuration() > 0 && server.waitPart(r.Context(), name, file) {
+		data, err = server.Storage.Get(name + "/" + file)
+	}
+	if err == ErrNotFound {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		log.Println(c.Front("HLS %s: %v", c.R, r.URL.Path, err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	if file == PlaylistName {
+		writePlaylist(w, r, data)
+		return
+	}
+	header := w.Header()
+	header.Set("Content-Type", contentTypes[path.Ext(file)])
+	header.Set("Cache-Control", "max-age=3600")
+	header.Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodGet {
+		w.Write(data)
+	}
+}
+
+// serveMaster 生成列出所有轨道的主播放列表，流没有推流时返回404
+func (server *Server) serveMaster(w http.ResponseWriter, r *http.Request, name string) {
+	var list masterPlaylist
+	server.mutex.Lock()
+	seg, ok := server.active[name]
+	state, started := server.states[name]
+	if ok && started {
+		list.bandwidth = state.bandwidth
+		for _, rendition := range seg.renditions {
+			// 只列出已生成播放列表的替代轨道
+			if state, ok := server.states[rendition.Name]; ok {
+				list.renditions = append(list.renditions, masterRendition{rendition.rendition, rendition.noVideo, state.bandwidth})
+			}
+		}
+	}
+	server.mutex.Unlock()
+	if !ok || !started {
+		http.NotFound(w, r)
+		return
+	}
+	writePlaylist(w, r, list.Bytes())
+}
+
+// writePlaylist 写出播放列表，请求带有鉴权密钥时为其中的地址增加密钥
+func writePlaylist(w http.ResponseWriter, r *http.Request, data []byte) {
+	if key := r.URL.Query().Get("key"); key != "" {
+		data = appendQuery(data, url.Values{"key": []string{key}}.Encode())
+	}
+	header := w.Header()
+	header.Set("Content-Type", "application/vnd.apple.mpegurl")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodGet {
+		w.Write(data)
+	}
+}
+
+// parsePath 从请求路径中解析应用、流名称、替代轨道与文件名，不是替代轨道时rendition为空
+func (server *Server) parsePath(urlPath string) (appName, streamName, rendition, file string, ok bool) {
+	prefix := server.Config.Prefix + "/"
+	if !strings.HasPrefix(urlPath, prefix) {
+		return "", "", "", "", false
+	}
+	parts := strings.Split(strings.TrimPrefix(urlPath, prefix), "/")
+	if len(parts) == 4 && renditionPattern.MatchString(parts[2]) {
+		rendition = parts[2]
+		parts = append(parts[:2], parts[3])
+	} else if len(parts) == 3 && parts[2] == MasterPlaylistName {
+		return parts[0], parts[1], "", parts[2], parts[0] != "" && parts[1] != ""
+	}
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || !filePattern.MatchString(parts[2]) {
+		return "", "", "", "", false
+	}
+	return parts[0], parts[1], rendition, parts[2], true
+}
+
+// appendQuery 为播放列表中的分片地址增加参数，用于传递鉴权密钥
+func appendQuery(playlist []byte, query string) []byte {
+	buf := new(bytes.Buffer)
+	scanner := bufio.NewScanner(bytes.NewReader(playlist))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			// 标签中的地址，如初始化分片、部分分片与预加载提示
+			line = uriPattern.ReplaceAllStringFunc(line, func(uri string) string {
+				return strings.TrimSuffix(uri, "\"") + "?" + query + "\""
+			})
+			buf.WriteString(line)
+		} else {
+			buf.WriteString(line)
+			if line != "" {
+				buf.WriteString("?")
+				buf.WriteString(query)
+			}
+		}
+		buf.WriteString("\n")
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,75 @@
+package hls
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"../config"
+	"../rtmp"
+	s "../server"
+)
+
+// TestPublish 测试只为有推流端的流增加分片器，同一条流不会同时运行两个分片器
+func TestPublish(t *testing.T) {
+	cfg := config.Default()
+	rtmpServer := rtmp.NewServer(cfg, nil)
+	server := NewServer(&rtmpServer, NewMemoryStorage())
+	name := "live/a"
+
+	var tests = []struct {
+		in       string // input: publish 调用推流开始的处理，join 推流端加入，leave 推流端断开
+		expected string // expected: none 没有分片器，new 新的分片器，same 原有的分片器
+	}{
+		{"publish", "none"},
+		{"join", "none"},
+		{"publish", "new"},
+		{"publish", "same"},
+		{"leave", "same"},
+		{"join", "same"},
+		{"publish", "new"},
+		{"leave", "same"},
+	}
+
+	var publisher *rtmp.Connect
+	var last *Segmenter
+	for _, test := range tests {
+		switch test.in {
+		case "publish":
+			server.Publish(rtmpServer.GetStream(name))
+		case "join":
+			local, remote := net.Pipe()
+			defer remote.Close()
+			publisher = rtmp.NewConnect(s.NewConnect(local, 4096), &rtmpServer)
+			rtmpServer.GetStream(name).AddPublisher(publisher)
+		case "leave":
+			rtmpServer.GetStream(name).DelConnect(publisher)
+		}
+
+		server.mutex.Lock()
+		seg := server.active[name]
+		server.mutex.Unlock()
+		actual := "same"
+		if seg == nil {
+			actual = "none"
+		} else if seg != last {
+			actual = "new"
+		}
+		last = seg
+		if actual != test.expected {
+			t.Errorf("[×] in: %s out: %s expected: %s\n", test.in, actual, test.expected)
+		} else {
+			t.Logf("[√] in: %s out: %s expected: %s\n", test.in, actual, test.expected)
+		}
+	}
+
+	// 推流端全部断开后所有分片器结束
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		t.Errorf("[×] in: shutdown out: %v expected: nil\n", err)
+	} else {
+		t.Logf("[√] in: shutdown out: %v expected: nil\n", err)
+	}
+}
@@ -0,0 +1,102 @@
+package hls
+
+import (
+	"bytes"
+
+	"../flv"
+	"../mpegts"
+	"github.com/pkg/errors"
+)
+
+// 分片封装格式
+const (
+	FormatTS   = "ts"
+	FormatFMP4 = "fmp4"
+)
+
+// packager 分片封装，由分片器决定切分位置，时间戳单位均为毫秒
+type packager interface {
+	// supports 是否可以封装该视频编码
+	supports(video *videoConfig) bool
+	// begin 开始新的分片，video与aac为当前的编码参数，可能为nil
+	begin(video *videoConfig, aac *aacConfig) error
+	// writeVideo 写入视频帧，H.264/H.265为长度前缀的NALU
+	writeVideo(timestamp uint32, cts int32, data []byte, keyFrame bool) error
+	// writeAudio 写入AAC原始帧
+	writeAudio(timestamp uint32, data []byte) error
+	// flush 取出上次取出后封装的数据，end为下一帧的时间戳
+	flush(end uint32) ([]byte, error)
+	// initSegment 初始化分片，不需要时返回nil
+	initSegment() []byte
+	// extension 分片文件扩展名
+	extension() string
+}
+
+// newPackager 按封装格式新建分片封装
+func newPackager(format string) packager {
+	if format == FormatFMP4 {
+		return &fmp4Packager{}
+	}
+	return &tsPackager{}
+}
+
+// tsPackager MPEG-TS分片封装
+type tsPackager struct {
+	video   *videoConfig
+	aac     *aacConfig
+	muxer   *mpegts.Muxer
+	buffer  bytes.Buffer
+	scratch []byte
+}
+
+// supports MPEG-TS只封装可以转换为Annex B的H.264与H.265
+func (p *tsPackager) supports(video *videoConfig) bool {
+	return video.annexB()
+}
+
+// begin 开始新的分片，写出PAT与PMT
+func (p *tsPackager) begin(video *videoConfig, aac *aacConfig) error {
+	p.video, p.aac = video, aac
+	p.buffer.Reset()
+	p.muxer = mpegts.NewMuxer(&p.buffer, video != nil, aac != nil)
+	if video != nil && video.codec == flv.FourCCHEVC {
+		p.muxer.VideoType = mpegts.StreamTypeHEVC
+	}
+	return errors.WithStack(p.muxer.WriteTables())
+}
+
+// writeVideo 转换为Annex B后写入
+func (p *tsPackager) writeVideo(timestamp uint32, cts int32, data []byte, keyFrame bool) error {
+	var err error
+	p.scratch, err = p.video.appendAnnexB(p.scratch[:0], data, keyFrame)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	dts := uint64(timestamp) * 90
+	pts := uint64(int64(dts) + int64(cts)*90)
+	return errors.WithStack(p.muxer.WriteVideo(pts, dts, p.scratch, keyFrame))
+}
+
+// writeAudio 增加ADTS头后写入
+func (p *tsPackager) writeAudio(timestamp uint32, data []byte) error {
+	p.scratch = p.aac.AppendADTS(p.scratch[:0], data)
+	return errors.WithStack(p.muxer.WriteAudio(uint64(timestamp)*90, p.scratch))
+}
+
+// flush 取出已封装的数据
+func (p *tsPackager) flush(end uint32) ([]byte, error) {
+	data := make([]byte, p.buffer.Len())
+	copy(data, p.buffer.Bytes())
+	p.buffer.Reset()
+	return data, nil
+}
+
+// initSegment MPEG-TS没有初始化分片
+func (p *tsPackager) initSegment() []byte {
+	return nil
+}
+
+// extension 分片文件扩展名
+func (p *tsPackager) extension() string {
+	return ".ts"
+}
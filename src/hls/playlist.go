@@ -0,0 +1,162 @@
+package hls
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"time"
+)
+
+// segment 一个已生成的分片
+type segment struct {
+	sequence uint64        // 媒体序列号
+	duration time.Duration // 分片时长
+	name     string        // 分片文件名
+	parts    []part        // 低延迟模式下的部分分片
+}
+
+// part 低延迟模式下的部分分片
+type part struct {
+	duration    time.Duration
+	name        string
+	independent bool // 是否可以独立解码，即以关键帧开始
+}
+
+// playlist 播放列表
+type playlist struct {
+	segments   []segment     // 窗口内已完成的分片
+	parts      []part        // 正在生成的分片中已完成的部分分片
+	ended      bool          // 推流是否已结束
+	target     time.Duration // 目标分片时长，分片超出时使用最长的分片时长
+	initName   string        // 初始化分片文件名，为空时不使用初始化分片
+	partTarget time.Duration // 部分分片目标时长，为0时不使用低延迟模式
+	hint       string        // 下一个部分分片的文件名
+}
+
+// partSegments 播放列表中列出部分分片的已完成分片数
+const partSegments = 2
+
+// Bytes 生成播放列表
+func (list *playlist) Bytes() []byte {
+	target := int(math.Ceil(list.target.Seconds()))
+	if target < 1 {
+		target = 1
+	}
+	for _, seg := range list.segments {
+		if d := int(math.Ceil(seg.duration.Seconds())); d > target {
+			target = d
+		}
+	}
+	var sequence uint64
+	if len(list.segments) > 0 {
+		sequence = list.segments[0].sequence
+	}
+
+	version := 3
+	if list.partTarget > 0 {
+		version = 9
+	} else if list.initName != "" {
+		version = 7
+	}
+
+	buf := new(bytes.Buffer)
+	buf.WriteString("#EXTM3U\n")
+	fmt.Fprintf(buf, "#EXT-X-VERSION:%d\n", version)
+	fmt.Fprintf(buf, "#EXT-X-TARGETDURATION:%d\n", target)
+	if list.partTarget > 0 {
+		fmt.Fprintf(buf, "#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=%.3f\n", 3*list.partTarget.Seconds())
+		fmt.Fprintf(buf, "#EXT-X-PART-INF:PART-TARGET=%.3f\n", list.partTarget.Seconds())
+	}
+	fmt.Fprintf(buf, "#EXT-X-MEDIA-SEQUENCE:%d\n", sequence)
+	if list.initName != "" {
+		fmt.Fprintf(buf, "#EXT-X-MAP:URI=\"%s\"\n", list.initName)
+	}
+	for i, seg := range list.segments {
+		if list.partTarget > 0 && i >= len(list.segments)-partSegments {
+			writeParts(buf, seg.parts)
+		}
+		fmt.Fprintf(buf, "#EXTINF:%.3f,\n", seg.duration.Seconds())
+		buf.WriteString(seg.name)
+		buf.WriteString("\n")
+	}
+	if list.ended {
+		buf.WriteString("#EXT-X-ENDLIST\n")
+	} else if list.partTarget > 0 {
+		writeParts(buf, list.parts)
+		if list.hint != "" {
+			fmt.Fprintf(buf, "#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"%s\"\n", list.hint)
+		}
+	}
+	return buf.Bytes()
+}
+
+// writeParts 写出部分分片
+func writeParts(buf *bytes.Buffer, parts []part) {
+	for _, p := range parts {
+		fmt.Fprintf(buf, "#EXT-X-PART:DURATION=%.3f,URI=\"%s\"", p.duration.Seconds(), p.name)
+		if p.independent {
+			buf.WriteString(",INDEPENDENT=YES")
+		}
+		buf.WriteString("\n")
+	}
+}
+
+// defaultBandwidth 还没有完成的分片时主播放列表中的码率
+const defaultBandwidth = 1000000
+
+// masterRendition 主播放列表中的替代轨道
+type masterRendition struct {
+	name      string // 替代轨道名称，播放列表位于该目录下
+	audio     bool   // 是否为音频轨道
+	bandwidth int    // 分片的最高码率
+}
+
+// masterPlaylist 列出轨道0与替代轨道的主播放列表
+type masterPlaylist struct {
+	bandwidth  int // 轨道0的分片的最高码率
+	renditions []masterRendition
+}
+
+// Bytes 生成主播放列表，音频轨道作为同一组的替代音频，视频轨道作为不同的码流
+func (list *masterPlaylist) Bytes() []byte {
+	var audios, videos []masterRendition
+	audioBandwidth := 0
+	for _, r := range list.renditions {
+		if r.audio {
+			audios = append(audios, r)
+			if r.bandwidth > audioBandwidth {
+				audioBandwidth = r.bandwidth
+			}
+		} else {
+			videos = append(videos, r)
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	buf.WriteString("#EXTM3U\n")
+	buf.WriteString("#EXT-X-VERSION:3\n")
+	if len(audios) > 0 {
+		// 轨道0的音频封装在码流中，没有单独的播放列表
+		buf.WriteString("#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"audio\",NAME=\"audio0\",DEFAULT=YES,AUTOSELECT=YES\n")
+		for _, r := range audios {
+			fmt.Fprintf(buf, "#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"audio\",NAME=\"%s\",AUTOSELECT=YES,URI=\"%s/%s\"\n", r.name, r.name, PlaylistName)
+		}
+	}
+	writeStreamInf := func(bandwidth int, uri string) {
+		if bandwidth <= 0 {
+			bandwidth = defaultBandwidth
+		}
+		fmt.Fprintf(buf, "#EXT-X-STREAM-INF:BANDWIDTH=%d", bandwidth+audioBandwidth)
+		if len(audios) > 0 {
+			buf.WriteString(",AUDIO=\"audio\"")
+		}
+		buf.WriteString("\n")
+		buf.WriteString(uri)
+		buf.WriteString("\n")
+	}
+	writeStreamInf(list.bandwidth, PlaylistName)
+	for _, r := range videos {
+		writeStreamInf(r.bandwidth, r.name+"/"+PlaylistName)
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,96 @@
+package hls
+
+import (
+	"testing"
+	"time"
+)
+
+// TestPlaylist 测试播放列表生成
+func TestPlaylist(t *testing.T) {
+	var tests = []struct {
+		in       playlist // input
+		expected string   // expected result
+	}{
+		{
+			playlist{target: 2 * time.Second},
+			"#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:2\n#EXT-X-MEDIA-SEQUENCE:0\n",
+		},
+		{
+			playlist{
+				segments: []segment{{3, 2000 * time.Millisecond, "3.ts", nil}, {4, 2040 * time.Millisecond, "4.ts", nil}},
+				target:   2 * time.Second,
+			},
+			"#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:3\n#EXT-X-MEDIA-SEQUENCE:3\n" +
+				"#EXTINF:2.000,\n3.ts\n#EXTINF:2.040,\n4.ts\n",
+		},
+		{
+			playlist{
+				segments: []segment{{0, 1500 * time.Millisecond, "0.ts", nil}},
+				ended:    true,
+				target:   time.Second,
+			},
+			"#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:2\n#EXT-X-MEDIA-SEQUENCE:0\n" +
+				"#EXTINF:1.500,\n0.ts\n#EXT-X-ENDLIST\n",
+		},
+		{
+			playlist{
+				segments: []segment{
+					{7, time.Second, "7.m4s", []part{{500 * time.Millisecond, "7.0.m4s", true}, {500 * time.Millisecond, "7.1.m4s", false}}},
+				},
+				parts:      []part{{500 * time.Millisecond, "8.0.m4s", true}},
+				target:     time.Second,
+				initName:   "init-7.mp4",
+				partTarget: 500 * time.Millisecond,
+				hint:       "8.1.m4s",
+			},
+			"#EXTM3U\n#EXT-X-VERSION:9\n#EXT-X-TARGETDURATION:1\n" +
+				"#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=1.500\n#EXT-X-PART-INF:PART-TARGET=0.500\n" +
+				"#EXT-X-MEDIA-SEQUENCE:7\n#EXT-X-MAP:URI=\"init-7.mp4\"\n" +
+				"#EXT-X-PART:DURATION=0.500,URI=\"7.0.m4s\",INDEPENDENT=YES\n#EXT-X-PART:DURATION=0.500,URI=\"7.1.m4s\"\n" +
+				"#EXTINF:1.000,\n7.m4s\n" +
+				"#EXT-X-PART:DURATION=0.500,URI=\"8.0.m4s\",INDEPENDENT=YES\n#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"8.1.m4s\"\n",
+		},
+	}
+
+	for _, test := range tests {
+		actual := string(test.in.Bytes())
+		if actual != test.expected {
+			t.Errorf("[×] in: %v out: %q expected: %q\n", test.in, actual, test.expected)
+		} else {
+			t.Logf("[√] in: %v out: %q expected: %q\n", test.in, actual, test.expected)
+		}
+	}
+}
+
+// TestMasterPlaylist 测试多轨道主播放列表生成
+func TestMasterPlaylist(t *testing.T) {
+	var tests = []struct {
+		in       masterPlaylist // input
+		expected string         // expected result
+	}{
+		{
+			masterPlaylist{bandwidth: 2000000},
+			"#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-STREAM-INF:BANDWIDTH=2000000\nindex.m3u8\n",
+		},
+		{
+			masterPlaylist{
+				bandwidth:  2000000,
+				renditions: []masterRendition{{"audio1", true, 128000}, {"video2", false, 0}},
+			},
+			"#EXTM3U\n#EXT-X-VERSION:3\n" +
+				"#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"audio\",NAME=\"audio0\",DEFAULT=YES,AUTOSELECT=YES\n" +
+				"#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"audio\",NAME=\"audio1\",AUTOSELECT=YES,URI=\"audio1/index.m3u8\"\n" +
+				"#EXT-X-STREAM-INF:BANDWIDTH=2128000,AUDIO=\"audio\"\nindex.m3u8\n" +
+				"#EXT-X-STREAM-INF:BANDWIDTH=1128000,AUDIO=\"audio\"\nvideo2/index.m3u8\n",
+		},
+	}
+
+	for _, test := range tests {
+		actual := string(test.in.Bytes())
+		if actual != test.expected {
+			t.Errorf("[×] in: %v out: %q expected: %q\n", test.in, actual, test.expected)
+		} else {
+			t.Logf("[√] in: %v out: %q expected: %q\n", test.in, actual, test.expected)
+		}
+	}
+}
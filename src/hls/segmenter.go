@@ -0,0 +1,531 @@
+package hls
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"time"
+
+	"../flv"
+	c "../lib/colorful"
+	"../rtmp"
+	"../rtmp/amf"
+	"github.com/pkg/errors"
+)
+
+/*
+
+HLS 分片器
+
+作为封装输出订阅推流，将FLV负载中的H.264/H.265/AAC封装为MPEG-TS，或将H.264/H.265/AV1/VP9/AAC封装为fMP4，在达到目标时长后的第一个关键帧处切分
+低延迟模式下分片再按部分分片时长切分为部分分片，分片由其部分分片拼接而成
+分片器封装轨道0，多轨道的流中其他轨道由子分片器封装为替代轨道，如 应用/流名称/audio1/index.m3u8
+	音频轨道的子分片器只封装该音频轨道，视频轨道的子分片器封装该视频轨道与轨道0的音频
+
+*/
+
+// audioOnlyFrames 没有元数据时，收到多少个音频帧仍没有视频序列头则视为纯音频流
+const audioOnlyFrames = 50
+
+// Segmenter 一条流的HLS分片器
+type Segmenter struct {
+	Name   string // 应用/流名称，子分片器为 应用/流名称/替代轨道名称
+	server *Server
+	queue  *rtmp.Queue
+
+	parent     *Segmenter   // 子分片器所属的分片器
+	rendition  string       // 子分片器的替代轨道名称，如 audio1 video1
+	videoTrack uint8        // 封装的视频轨道
+	audioTrack uint8        // 封装的音频轨道
+	noVideo    bool         // 只封装音频轨道
+	renditions []*Segmenter // 子分片器，加入时需持有server.mutex
+	bandwidth  int          // 分片的最高码率，单位为bit/s
+
+	video        *videoConfig
+	aac          *aacConfig
+	metadata     bool // 是否收到元数据
+	metaHasVideo bool // 元数据中是否有视频
+	unsupported  bool // 视频编码不能封装为当前格式，按纯音频流处理
+	audioFrames  int  // 没有视频序列头时收到的音频帧数
+
+	packager      packager
+	init          []byte   // 当前初始化分片
+	initName      string   // 当前初始化分片的文件名
+	inits         []string // 生成过的初始化分片
+	started       bool     // 是否已开始第一个分片
+	sequence      uint64   // 当前分片的序列号
+	segmentVideo  bool     // 当前分片是否包含视频
+	segmentAudio  bool     // 当前分片是否包含音频
+	segmentStart  uint32   // 当前分片开始的时间戳
+	lastTimestamp uint32   // 最后一帧的时间戳
+	frameDelta    uint32   // 最近两帧的时间戳间隔，用于估计最后一帧的时长
+	segments      []segment
+
+	partStart       uint32   // 当前部分分片开始的时间戳
+	partTimestamp   uint32   // 决定部分分片切分的轨道上一帧的时间戳
+	partIndependent bool     // 当前部分分片是否以关键帧开始
+	parts           []part   // 当前分片已完成的部分分片
+	partData        [][]byte // 当前分片已完成的部分分片数据
+}
+
+// newSegmenter 新建分片器
+func newSegmenter(server *Server, name string) *Segmenter {
+	return &Segmenter{
+		Name:   name,
+		server: server,
+		queue:  rtmp.NewQueue(server.Config.Queue),
+
+		packager: newPackager(server.Config.Format),
+	}
+}
+
+// run 处理音视频帧直到推流结束
+func (seg *Segmenter) run() {
+	for {
+		select {
+		case frame := <-seg.queue.Frames:
+			seg.handle(frame)
+		case <-seg.queue.Done():
+			seg.drain()
+			seg.finish()
+			return
+		}
+	}
+}
+
+// running 分片器是否仍在接收音视频帧，推流结束后正在写出最后的分片时返回false
+func (seg *Segmenter) running() bool {
+	select {
+	case <-seg.queue.Done():
+		return false
+	default:
+		return true
+	}
+}
+
+// drain 处理推流结束前已加入队列的音视频帧
+func (seg *Segmenter) drain() {
+	for {
+		select {
+		case frame := <-seg.queue.Frames:
+			seg.handle(frame)
+		default:
+			return
+		}
+	}
+}
+
+// handle 处理一个音视频帧
+func (seg *Segmenter) handle(frame *rtmp.Frame) {
+	msg := &frame.Message
+	if seg.parent == nil {
+		seg.dispatch(frame)
+	}
+	if !seg.selected(msg) {
+		return
+	}
+
+	var err error
+	switch msg.Type {
+	case rtmp.RTMPTypeAMFData:
+		seg.handleMetadata(msg.Data)
+	case rtmp.RTMPTypeVideoData:
+		err = seg.handleVideo(msg)
+	case rtmp.RTMPTypeAudioData:
+		err = seg.handleAudio(msg)
+	}
+	if err != nil {
+		log.Println(c.Front("HLS %s: %v", c.R, seg.Name, err))
+	}
+}
+
+// selected 消息是否属于封装的轨道
+func (seg *Segmenter) selected(msg *rtmp.Message) bool {
+	switch msg.Type {
+	case rtmp.RTMPTypeVideoData:
+		return !seg.noVideo && msg.TrackID == seg.videoTrack
+	case rtmp.RTMPTypeAudioData:
+		return msg.TrackID == seg.audioTrack
+	}
+	return true
+}
+
+// dispatch 将帧交给子分片器，收到轨道0之外的新轨道时为其增加子分片器
+func (seg *Segmenter) dispatch(frame *rtmp.Frame) {
+	msg := &frame.Message
+	if (msg.Type == rtmp.RTMPTypeVideoData || msg.Type == rtmp.RTMPTypeAudioData) && msg.TrackID != 0 {
+		found := false
+		for _, r := range seg.renditions {
+			if r.selected(msg) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			seg.addRendition(msg.Type, msg.TrackID)
+		}
+	}
+	for _, r := range seg.renditions {
+		r.handle(frame)
+	}
+}
+
+// addRendition 为轨道增加子分片器
+func (seg *Segmenter) addRendition(msgType uint32, trackID uint8) {
+	r := &Segmenter{
+		server:   seg.server,
+		parent:   seg,
+		packager: newPackager(seg.server.Config.Format),
+	}
+	if msgType == rtmp.RTMPTypeVideoData {
+		// 轨道0的音频序列头可能已经收到
+		r.rendition = fmt.Sprintf("video%d", trackID)
+		r.videoTrack = trackID
+		r.aac = seg.aac
+		r.metadata, r.metaHasVideo = seg.metadata, seg.metaHasVideo
+	} else {
+		r.rendition = fmt.Sprintf("audio%d", trackID)
+		r.audioTrack = trackID
+		r.noVideo = true
+	}
+	r.Name = seg.Name + "/" + r.rendition
+
+	seg.server.mutex.Lock()
+	seg.renditions = append(seg.renditions, r)
+	seg.server.mutex.Unlock()
+	log.Println(c.Front("HLS %s started", c.G, r.Name))
+}
+
+// root 分片器自身或子分片器所属的分片器
+func (seg *Segmenter) root() *Segmenter {
+	if seg.parent != nil {
+		return seg.parent
+	}
+	return seg
+}
+
+// handleMetadata 从元数据中判断是否有视频
+func (seg *Segmenter) handleMetadata(data []byte) {
+	array, err := amf.ByteToAMFArray(data)
+	if err != nil || len(array) < 2 {
+		return
+	}
+	if name, _ := array[0].Value().(string); name != "onMetaData" {
+		return
+	}
+	if metadata, ok := array[1].Value().(map[string]interface{}); ok {
+		_, seg.metaHasVideo = metadata["videocodecid"]
+		seg.metadata = true
+	}
+}
+
+// handleVideo 处理视频帧，封装格式不支持的编码忽略
+func (seg *Segmenter) handleVideo(msg *rtmp.Message) error {
+	tag, err := flv.ParseVideoTag(msg.Data)
+	if err != nil || tag.FourCC == "" {
+		return nil
+	}
+	if tag.SequenceHeader() {
+		video, err := parseVideoConfig(tag.FourCC, tag.Body)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if !seg.packager.supports(video) {
+			if seg.video == nil && !seg.unsupported {
+				log.Println(c.Front("HLS %s: video codec %s not supported by %s", c.Y, seg.Name, tag.FourCC, seg.server.Config.Format))
+			}
+			seg.unsupported = true
+			return nil
+		}
+		seg.video = video
+		return nil
+	}
+	if !tag.Frame() || seg.video == nil || tag.FourCC != seg.video.codec {
+		return nil
+	}
+
+	keyFrame := tag.KeyFrame()
+	if keyFrame && (!seg.started || seg.elapsed(msg.Timestamp) >= seg.server.targetDuration()) {
+		if err := seg.cut(msg.Timestamp); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	if !seg.started || !seg.segmentVideo {
+		return nil
+	}
+	if err := seg.checkPart(msg.Timestamp, keyFrame); err != nil {
+		return errors.WithStack(err)
+	}
+
+	seg.setTimestamp(msg.Timestamp)
+	return errors.WithStack(seg.packager.writeVideo(msg.Timestamp, tag.CompositionTime, tag.Body, keyFrame))
+}
+
+// handleAudio 处理AAC音频帧
+func (seg *Segmenter) handleAudio(msg *rtmp.Message) error {
+	data := msg.Data
+	if len(data) < 2 || data[0]>>4 != 10 {
+		// 仅支持AAC
+		return nil
+	}
+	if data[1] == 0 {
+		aac, err := parseAACConfig(data[2:])
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		seg.aac = aac
+		return nil
+	}
+	if seg.aac == nil {
+		return nil
+	}
+	if seg.video == nil {
+		seg.audioFrames++
+	}
+
+	if seg.audioOnly() && (!seg.started || seg.elapsed(msg.Timestamp) >= seg.server.targetDuration()) {
+		if err := seg.cut(msg.Timestamp); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	if !seg.started || !seg.segmentAudio {
+		return nil
+	}
+	if !seg.segmentVideo {
+		if err := seg.checkPart(msg.Timestamp, true); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	seg.setTimestamp(msg.Timestamp)
+	return errors.WithStack(seg.packager.writeAudio(msg.Timestamp, data[2:]))
+}
+
+// audioOnly 是否为纯音频流，纯音频流在音频帧处切分
+func (seg *Segmenter) audioOnly() bool {
+	if seg.video != nil {
+		return false
+	}
+	if seg.unsupported || seg.noVideo {
+		return true
+	}
+	if seg.metadata {
+		return !seg.metaHasVideo
+	}
+	return seg.audioFrames > audioOnlyFrames
+}
+
+// setTimestamp 记录最后一帧的时间戳
+func (seg *Segmenter) setTimestamp(timestamp uint32) {
+	if timestamp > seg.lastTimestamp {
+		seg.frameDelta = timestamp - seg.lastTimestamp
+	}
+	seg.lastTimestamp = timestamp
+}
+
+// elapsed 当前分片已有的时长
+func (seg *Segmenter) elapsed(timestamp uint32) time.Duration {
+	if timestamp < seg.segmentStart {
+		return 0
+	}
+	return time.Duration(timestamp-seg.segmentStart) * time.Millisecond
+}
+
+// cut 结束当前分片并从timestamp开始新的分片
+func (seg *Segmenter) cut(timestamp uint32) error {
+	if seg.started {
+		if err := seg.finishSegment(timestamp); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	seg.started = true
+	seg.sequence = seg.server.nextSequence(seg.Name)
+	seg.segmentVideo = seg.video != nil
+	seg.segmentAudio = seg.aac != nil
+	if err := seg.packager.begin(seg.video, seg.aac); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := seg.putInit(); err != nil {
+		return errors.WithStack(err)
+	}
+
+	seg.segmentStart = timestamp
+	seg.lastTimestamp = timestamp
+	seg.partStart = timestamp
+	seg.partTimestamp = timestamp
+	seg.partIndependent = true
+	return errors.WithStack(seg.writePlaylist(false))
+}
+
+// putInit 初始化分片变化时保存，文件名中带有首个分片的序列号，避免播放端使用缓存的旧分片
+func (seg *Segmenter) putInit() error {
+	init := seg.packager.initSegment()
+	if init == nil || bytes.Equal(init, seg.init) {
+		return nil
+	}
+	name := fmt.Sprintf("init-%d.mp4", seg.sequence)
+	if err := seg.server.Storage.Put(seg.Name+"/"+name, init); err != nil {
+		return errors.WithStack(err)
+	}
+	seg.init = init
+	seg.initName = name
+	seg.inits = append(seg.inits, name)
+	return nil
+}
+
+// checkPart 低延迟模式下，加入时间戳为timestamp的帧会超出部分分片目标时长时先结束当前部分分片
+func (seg *Segmenter) checkPart(timestamp uint32, keyFrame bool) error {
+	target := seg.server.partDuration()
+	if target <= 0 {
+		return nil
+	}
+	delta := uint32(0)
+	if timestamp > seg.partTimestamp {
+		delta = timestamp - seg.partTimestamp
+	}
+	seg.partTimestamp = timestamp
+	if timestamp <= seg.partStart || time.Duration(timestamp-seg.partStart+delta)*time.Millisecond <= target {
+		return nil
+	}
+
+	if err := seg.finishPart(timestamp); err != nil {
+		return errors.WithStack(err)
+	}
+	seg.partIndependent = keyFrame
+	return errors.WithStack(seg.writePlaylist(false))
+}
+
+// finishPart 保存当前部分分片，end为部分分片结束的时间戳
+func (seg *Segmenter) finishPart(end uint32) error {
+	data, err := seg.packager.flush(end)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if len(data) == 0 {
+		return nil
+	}
+
+	p := part{
+		name:        fmt.Sprintf("%d.%d%s", seg.sequence, len(seg.parts), seg.packager.extension()),
+		independent: seg.partIndependent,
+	}
+	if end > seg.partStart {
+		p.duration = time.Duration(end-seg.partStart) * time.Millisecond
+	}
+	if err := seg.server.Storage.Put(seg.Name+"/"+p.name, data); err != nil {
+		return errors.WithStack(err)
+	}
+	seg.parts = append(seg.parts, p)
+	seg.partData = append(seg.partData, data)
+	seg.partStart = end
+	return nil
+}
+
+// finishSegment 保存当前分片，end为分片结束的时间戳
+func (seg *Segmenter) finishSegment(end uint32) error {
+	var data []byte
+	if seg.server.partDuration() > 0 {
+		if err := seg.finishPart(end); err != nil {
+			return errors.WithStack(err)
+		}
+		for _, p := range seg.partData {
+			data = append(data, p...)
+		}
+	} else {
+		var err error
+		data, err = seg.packager.flush(end)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	s := segment{
+		sequence: seg.sequence,
+		duration: seg.elapsed(end),
+		name:     fmt.Sprintf("%d%s", seg.sequence, seg.packager.extension()),
+		parts:    seg.parts,
+	}
+	seg.parts, seg.partData = nil, nil
+	if err := seg.server.Storage.Put(seg.Name+"/"+s.name, data); err != nil {
+		return errors.WithStack(err)
+	}
+	seg.segments = append(seg.segments, s)
+	if seconds := s.duration.Seconds(); seconds > 0 {
+		if bandwidth := int(float64(len(data)*8) / seconds); bandwidth > seg.bandwidth {
+			seg.bandwidth = bandwidth
+		}
+	}
+
+	// 删除超出保留数量的分片
+	keep := seg.server.Config.Window + seg.server.Config.Retention
+	for len(seg.segments) > keep {
+		seg.deleteSegment(seg.segments[0])
+		seg.segments = seg.segments[1:]
+	}
+	return nil
+}
+
+// deleteSegment 删除分片及其部分分片
+func (seg *Segmenter) deleteSegment(s segment) {
+	seg.server.Storage.Delete(seg.Name + "/" + s.name)
+	for _, p := range s.parts {
+		seg.server.Storage.Delete(seg.Name + "/" + p.name)
+	}
+}
+
+// writePlaylist 写出播放列表，只包含最近的分片
+func (seg *Segmenter) writePlaylist(ended bool) error {
+	segments := seg.segments
+	if window := seg.server.Config.Window; len(segments) > window {
+		segments = segments[len(segments)-window:]
+	}
+	list := &playlist{
+		segments:   segments,
+		ended:      ended,
+		target:     seg.server.targetDuration(),
+		initName:   seg.initName,
+		partTarget: seg.server.partDuration(),
+	}
+	if !ended && list.partTarget > 0 {
+		list.parts = seg.parts
+		list.hint = fmt.Sprintf("%d.%d%s", seg.sequence, len(seg.parts), seg.packager.extension())
+	}
+	return errors.WithStack(seg.server.putPlaylist(seg, list.Bytes(), seg.sequence, len(seg.parts), ended, seg.bandwidth))
+}
+
+// finish 推流结束，保存最后一个分片并在播放列表中标记结束
+func (seg *Segmenter) finish() {
+	defer seg.server.finished(seg)
+
+	for _, r := range seg.renditions {
+		r.finish()
+	}
+	if !seg.started {
+		return
+	}
+	// 最后一帧的时长按前两帧的间隔估计
+	err := seg.finishSegment(seg.lastTimestamp + seg.frameDelta)
+	if err == nil {
+		err = seg.writePlaylist(true)
+	}
+	if err != nil {
+		log.Println(c.Front("HLS %s: %v", c.R, seg.Name, err))
+	}
+	log.Println(c.Front("HLS %s ended", c.G, seg.Name))
+}
+
+// cleanup 删除分片器与子分片器生成的分片
+func (seg *Segmenter) cleanup() {
+	for _, r := range seg.renditions {
+		r.cleanup()
+	}
+	for _, s := range seg.segments {
+		seg.deleteSegment(s)
+	}
+	seg.segments = nil
+	for _, name := range seg.inits {
+		seg.server.Storage.Delete(seg.Name + "/" + name)
+	}
+	seg.inits = nil
+}
@@ -0,0 +1,147 @@
+package hls
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+/*
+
+HLS 播放列表与分片的存储
+
+同一条流的HLS与DASH文件保存在同一目录下，文件名互不相同
+
+*/
+
+// ErrNotFound 文件不存在
+var ErrNotFound = errors.New("hls: not found")
+
+// Storage 播放列表与分片的存储，name为 应用/流名称/文件名
+type Storage interface {
+	Put(name string, data []byte) error
+	Get(name string) ([]byte, error)
+	Delete(name string) error
+}
+
+// NewStorage 按配置新建存储，kind为 memory 或 disk
+func NewStorage(kind string, dir string) (Storage, error) {
+	if kind == "disk" {
+		disk, err := NewDiskStorage(dir)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return disk, nil
+	}
+	return NewMemoryStorage(), nil
+}
+
+// MemoryStorage 内存存储
+type MemoryStorage struct {
+	mutex sync.RWMutex
+	files map[string][]byte
+}
+
+// NewMemoryStorage 新建内存存储
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{files: make(map[string][]byte)}
+}
+
+// Put 保存文件，data在保存后不能再修改
+func (storage *MemoryStorage) Put(name string, data []byte) error {
+	defer storage.mutex.Unlock()
+	storage.mutex.Lock()
+
+	storage.files[name] = data
+	return nil
+}
+
+// Get 读取文件
+func (storage *MemoryStorage) Get(name string) ([]byte, error) {
+	defer storage.mutex.RUnlock()
+	storage.mutex.RLock()
+
+	data, ok := storage.files[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return data, nil
+}
+
+// Delete 删除文件
+func (storage *MemoryStorage) Delete(name string) error {
+	defer storage.mutex.Unlock()
+	storage.mutex.Lock()
+
+	delete(storage.files, name)
+	return nil
+}
+
+// DiskStorage 磁盘存储，文件先写入临时文件再重命名，读取时不会读到写了一半的文件
+type DiskStorage struct {
+	Dir string
+}
+
+// NewDiskStorage 新建磁盘存储，目录不存在时自动创建
+func NewDiskStorage(dir string) (*DiskStorage, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &DiskStorage{Dir: dir}, nil
+}
+
+// Put 保存文件
+func (storage *DiskStorage) Put(name string, data []byte) error {
+	path, err := storage.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errors.WithStack(err)
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(os.Rename(tmp, path))
+}
+
+// Get 读取文件
+func (storage *DiskStorage) Get(name string) ([]byte, error) {
+	path, err := storage.path(name)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return data, errors.WithStack(err)
+}
+
+// Delete 删除文件
+func (storage *DiskStorage) Delete(name string) error {
+	path, err := storage.path(name)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return errors.WithStack(err)
+}
+
+// path 文件在磁盘上的路径，名称清理后不在存储目录之内时返回错误
+func (storage *DiskStorage) path(name string) (string, error) {
+	path := filepath.Join(storage.Dir, filepath.FromSlash(name))
+	rel, err := filepath.Rel(storage.Dir, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) || strings.ContainsRune(name, '\\') {
+		return "", errors.Errorf("hls: invalid file name %q", name)
+	}
+	return path, nil
+}
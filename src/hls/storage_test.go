@@ -0,0 +1,40 @@
+package hls
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestDiskStoragePath 测试磁盘存储拒绝存储目录之外的文件名
+func TestDiskStoragePath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	storage := &DiskStorage{Dir: dir}
+
+	var tests = []struct {
+		in       string // input
+		expected string // expected path, empty for error
+	}{
+		{"live/test/index.m3u8", filepath.Join(dir, "live", "test", "index.m3u8")},
+		{"live/test/../other/0.ts", filepath.Join(dir, "live", "other", "0.ts")},
+		{"../x/index.m3u8", ""},
+		{"live/../../x/index.m3u8", ""},
+		{"live/..", ""},
+		{"..", ""},
+		{"live\\..\\..\\x", ""},
+	}
+
+	for _, test := range tests {
+		actual, err := storage.path(test.in)
+		if (err != nil) != (test.expected == "") || actual != test.expected {
+			t.Errorf("[×] in: %q out: %q %v expected: %q\n", test.in, actual, err, test.expected)
+		} else {
+			t.Logf("[√] in: %q out: %q %v expected: %q\n", test.in, actual, err, test.expected)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package httpflv
+
+import (
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+
+	"../flv"
+	c "../lib/colorful"
+	"../rtmp"
+	s "../server"
+	"../websocket"
+	"github.com/pkg/errors"
+)
+
+/*
+
+HTTP-FLV 拉流输出
+
+拉流端作为订阅者加入RTMP流，路径为 前缀/应用/流名称.flv，如 GET /live/app/stream.flv
+多轨道的流可以通过参数选择轨道，如 GET /live/app/stream.flv?audio_track=1
+开启WebSocket时同一路径接受升级请求，如 ws://host/live/app/stream.flv
+
+*/
+
+// Handler HTTP-FLV拉流服务
+type Handler struct {
+	Server *rtmp.Server
+}
+
+// NewHandler 按配置新建HTTP-FLV拉流服务，开启跨域时增加跨域响应头
+func NewHandler(server *rtmp.Server) http.Handler {
+	cfg := server.Config.Outputs.HTTPFLV
+	var handler http.Handler = &Handler{Server: server}
+	if cfg.CORS.Enable {
+		handler = s.CORS(cfg.CORS.AllowOrigins, handler)
+	}
+	return handler
+}
+
+// ServeHTTP 处理拉流请求
+func (handler *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	appName, streamName, ok := handler.parsePath(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	server := handler.Server
+	cfg := server.Config
+	app, ok := cfg.Application(appName)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if websocket.IsUpgrade(r) && !handler.allowWebSocket(r) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+	if !app.AllowPlay() || !server.Authorize(app, false, r.URL.Query()) {
+		log.Println(c.Front("HTTP-FLV play(%s/%s) denied", c.R, appName, streamName))
+		http.Error(w, "play denied", http.StatusForbidden)
+		return
+	}
+	if server.Closing() {
+		http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
+	// 流不存在且不会回源时不新建流
+	stream, ok := server.PlayStream(app, appName, streamName)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	sub := newSubscriber(cfg.Outputs.HTTPFLV.Queue)
+	sub.gate.SelectTracks(r.URL.Query())
+	if !stream.AddReceiver(sub, app.ViewerLimit(cfg.Limits)) {
+		if stream.Removed() {
+			http.NotFound(w, r)
+			return
+		}
+		log.Println(c.Front("HTTP-FLV play(%s/%s) rejected: too many viewers", c.R, appName, streamName))
+		server.Stats.AddRejection(rtmp.RejectMaxViewers)
+		http.Error(w, "too many viewers", http.StatusServiceUnavailable)
+		return
+	}
+	defer stream.DelReceiver(sub)
+	defer sub.CloseServer()
+
+	log.Println(c.Front("HTTP-FLV play(%s) from %s", c.G, stream.Name, r.RemoteAddr))
+	if r.Method != http.MethodHead {
+		server.NotifyPlay(stream)
+	}
+	serve := sub.serve
+	if cfg.Outputs.HTTPFLV.WebSocket && websocket.IsUpgrade(r) {
+		serve = sub.serveWebSocket
+	}
+	if err := serve(w, r, stream); err != nil {
+		log.Println(c.Front("HTTP-FLV play(%s) end: %v", c.Y, stream.Name, err))
+	}
+}
+
+// allowWebSocket 是否允许该来源的WebSocket升级，浏览器不对WebSocket做跨域检查，按跨域设置校验来源
+func (handler *Handler) allowWebSocket(r *http.Request) bool {
+	cfg := handler.Server.Config.Outputs.HTTPFLV
+	var allowOrigins []string
+	if cfg.CORS.Enable {
+		allowOrigins = cfg.CORS.AllowOrigins
+	}
+	return s.OriginAllowed(allowOrigins, r)
+}
+
+// parsePath 从请求路径中解析应用与流名称
+func (handler *Handler) parsePath(path string) (string, string, bool) {
+	prefix := handler.Server.Config.Outputs.HTTPFLV.Prefix + "/"
+	if !strings.HasPrefix(path, prefix) || !strings.HasSuffix(path, ".flv") {
+		return "", "", false
+	}
+	path = strings.TrimSuffix(strings.TrimPrefix(path, prefix), ".flv")
+	idx := strings.IndexByte(path, '/')
+	if idx <= 0 || idx == len(path)-1 || strings.IndexByte(path[idx+1:], '/') >= 0 {
+		return "", "", false
+	}
+	return path[:idx], path[idx+1:], true
+}
+
+// subscriber HTTP-FLV拉流端
+type subscriber struct {
+	*rtmp.Queue
+	gate rtmp.Gate // 起播、丢帧控制与轨道选择
+}
+
+// newSubscriber 新建拉流端，queueSize为待发送音视频帧队列长度
+func newSubscriber(queueSize int) *subscriber {
+	return &subscriber{Queue: rtmp.NewQueue(queueSize)}
+}
+
+// serve 写出FLV文件头后持续写出流内的音视频帧
+func (sub *subscriber) serve(w http.ResponseWriter, r *http.Request, stream *rtmp.Stream) error {
+	header := w.Header()
+	header.Set("Content-Type", "video/x-flv")
+	header.Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return nil
+	}
+
+	flusher, _ := w.(http.Flusher)
+	flush := func() {
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	return sub.pump(flv.NewWriter(w), stream, flush, r.Context().Done())
+}
+
+// serveWebSocket 升级为WebSocket连接后写出FLV文件头与音视频帧，每个二进制帧为一个FLV tag
+func (sub *subscriber) serveWebSocket(w http.ResponseWriter, r *http.Request, stream *rtmp.Stream) error {
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer conn.Close()
+
+	// 客户端不发送数据，持续读取以回复ping并在对端关闭时结束
+	closed := make(chan struct{})
+	go func() {
+		io.Copy(ioutil.Discard, conn)
+		close(closed)
+	}()
+	return sub.pump(flv.NewTagWriter(conn), stream, func() {}, closed)
+}
+
+// pump 写出FLV文件头后持续写出流内的音视频帧，队列暂时为空时调用flush，done关闭或流结束时返回
+func (sub *subscriber) pump(writer *flv.Writer, stream *rtmp.Stream, flush func(), done <-chan struct{}) error {
+	if err := writer.WriteHeader(true, true); err != nil {
+		return errors.WithStack(err)
+	}
+	flush()
+
+	for {
+		select {
+		case frame := <-sub.Frames:
+			if err := sub.writeFrame(writer, stream, frame); err != nil {
+				return errors.WithStack(err)
+			}
+			if len(sub.Frames) == 0 {
+				flush()
+			}
+		case <-sub.Done():
+			return nil
+		case <-done:
+			return nil
+		}
+	}
+}
+
+// writeFrame 写出一个音视频帧，起播时先写出元数据与序列头
+func (sub *subscriber) writeFrame(writer *flv.Writer, stream *rtmp.Stream, frame *rtmp.Frame) error {
+	headers, ok := sub.gate.Pass(stream, &frame.Message, false)
+	if !ok {
+		return nil
+	}
+	for _, header := range headers {
+		tag, ok := header.FLVTag()
+		if !ok {
+			continue
+		}
+		tag.Timestamp = 0
+		if err := writer.Write(&tag); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	tag, ok := frame.FLVTag()
+	if !ok {
+		return nil
+	}
+	tag.Timestamp = sub.gate.Timestamp(&frame.Message)
+	return errors.WithStack(writer.Write(&tag))
+}
@@ -0,0 +1,107 @@
+package httpflv
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"../config"
+	"../flv"
+	"../rtmp"
+	s "../server"
+	"../websocket"
+)
+
+// readFrame 读入服务端发送的一个不带掩码的WebSocket帧，返回操作码与负载
+func readFrame(reader *bufio.Reader) (byte, []byte, error) {
+	var header [10]byte
+	if _, err := io.ReadFull(reader, header[:2]); err != nil {
+		return 0, nil, err
+	}
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		if _, err := io.ReadFull(reader, header[2:4]); err != nil {
+			return 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(header[2:4]))
+	case 127:
+		if _, err := io.ReadFull(reader, header[2:10]); err != nil {
+			return 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(header[2:10])
+	}
+	payload := make([]byte, length)
+	_, err := io.ReadFull(reader, payload)
+	return header[0] & 0x0f, payload, err
+}
+
+// TestWebSocketFrames 测试WebSocket拉流时第一个二进制帧为FLV文件头，之后每个二进制帧为一个完整的FLV tag
+func TestWebSocketFrames(t *testing.T) {
+	cfg := config.Default()
+	cfg.Outputs.HTTPFLV.WebSocket = true
+	rtmpServer := rtmp.NewServer(cfg, nil)
+	stream := rtmpServer.GetStream("live/a")
+	local, remote := net.Pipe()
+	defer remote.Close()
+	stream.AddPublisher(rtmp.NewConnect(s.NewConnect(local, 4096), &rtmpServer))
+
+	httpServer := httptest.NewServer(NewHandler(&rtmpServer))
+	defer httpServer.Close()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(httpServer.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte("GET /live/live/a.flv HTTP/1.1\r\nHost: test\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n"))
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil || resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("[×] in: upgrade out: %v %v expected: %d\n", resp, err, http.StatusSwitchingProtocols)
+	}
+
+	// 拉流端加入后推流端发送序列头、关键帧与非关键帧，数据超过一个chunk
+	frames := []rtmp.Message{
+		{Type: rtmp.RTMPTypeVideoData, Data: []byte{0x17, 0x00, 0, 0, 0, 1, 0x64, 0, 0x1f, 0xff}},
+		{Type: rtmp.RTMPTypeVideoData, Timestamp: 40, Data: append([]byte{0x17, 0x01, 0, 0, 0}, make([]byte, 300)...)},
+		{Type: rtmp.RTMPTypeVideoData, Timestamp: 80, Data: append([]byte{0x27, 0x01, 0, 0, 0}, make([]byte, 70000)...)},
+	}
+	for _, msg := range frames {
+		msg.Length = uint32(len(msg.Data))
+		stream.Broadcase(msg)
+	}
+
+	var tests = []struct {
+		size     int   // expected: 负载长度
+		tagType  uint8 // expected: FLV tag类型，文件头为0
+		dataSize int   // expected: tag数据长度
+	}{
+		{len(flv.Header(true, true)), 0, 0},
+		{flv.TagHeaderSize + len(frames[0].Data) + 4, flv.TagTypeVideo, len(frames[0].Data)},
+		{flv.TagHeaderSize + len(frames[1].Data) + 4, flv.TagTypeVideo, len(frames[1].Data)},
+		{flv.TagHeaderSize + len(frames[2].Data) + 4, flv.TagTypeVideo, len(frames[2].Data)},
+	}
+
+	for _, test := range tests {
+		opcode, payload, err := readFrame(reader)
+		ok := err == nil && opcode == websocket.OpBinary && len(payload) == test.size
+		if ok && test.tagType != 0 {
+			dataSize := int(payload[1])<<16 | int(payload[2])<<8 | int(payload[3])
+			tagSize := int(binary.BigEndian.Uint32(payload[len(payload)-4:]))
+			ok = payload[0] == test.tagType && dataSize == test.dataSize && tagSize == flv.TagHeaderSize+dataSize
+		}
+		if !ok {
+			t.Errorf("[×] in: frame out: %d %d bytes %v expected: %d bytes type %d\n", opcode, len(payload), err, test.size, test.tagType)
+		} else {
+			t.Logf("[√] in: frame out: %d bytes expected: %d bytes type %d\n", len(payload), test.size, test.tagType)
+		}
+	}
+}
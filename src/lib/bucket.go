@@ -0,0 +1,102 @@
+package lib
+
+import (
+	"sync"
+	"time"
+)
+
+// TokenBucket 令牌桶，按固定速率产生令牌，用于限制数据速率
+type TokenBucket struct {
+	mutex  sync.Mutex
+	rate   float64 // 每秒产生的令牌数，为0时不限制
+	burst  float64 // 令牌桶容量
+	tokens float64 // 当前令牌数，透支时为负数
+	last   time.Time
+}
+
+// NewTokenBucket 新建令牌桶，初始时令牌桶是满的
+func NewTokenBucket(rate int, burst int) *TokenBucket {
+	bucket := &TokenBucket{}
+	bucket.SetRate(rate, burst)
+	return bucket
+}
+
+// SetRate 修改速率与容量，rate为0时不限制
+func (bucket *TokenBucket) SetRate(rate int, burst int) {
+	defer bucket.mutex.Unlock()
+	bucket.mutex.Lock()
+
+	now := time.Now()
+	if bucket.last.IsZero() || bucket.rate <= 0 {
+		bucket.tokens = float64(burst)
+	} else {
+		bucket.refill(now)
+	}
+	bucket.last = now
+	bucket.rate = float64(rate)
+	bucket.burst = float64(burst)
+	if bucket.tokens > bucket.burst {
+		bucket.tokens = bucket.burst
+	}
+}
+
+// Rate 每秒产生的令牌数，为0时不限制
+func (bucket *TokenBucket) Rate() int {
+	defer bucket.mutex.Unlock()
+	bucket.mutex.Lock()
+
+	return int(bucket.rate)
+}
+
+// Reserve 取出n个令牌，令牌不足时透支，返回令牌补足前需要等待的时间
+func (bucket *TokenBucket) Reserve(n int) time.Duration {
+	defer bucket.mutex.Unlock()
+	bucket.mutex.Lock()
+
+	return bucket.reserve(n, time.Now())
+}
+
+// Allow 令牌足够时取出n个令牌并返回true，不足时不取出
+func (bucket *TokenBucket) Allow(n int) bool {
+	defer bucket.mutex.Unlock()
+	bucket.mutex.Lock()
+
+	return bucket.allow(n, time.Now())
+}
+
+// reserve Reserve的实现，now为当前时间
+func (bucket *TokenBucket) reserve(n int, now time.Time) time.Duration {
+	if bucket.rate <= 0 {
+		return 0
+	}
+	bucket.refill(now)
+	bucket.tokens -= float64(n)
+	if bucket.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-bucket.tokens / bucket.rate * float64(time.Second))
+}
+
+// allow Allow的实现，now为当前时间
+func (bucket *TokenBucket) allow(n int, now time.Time) bool {
+	if bucket.rate <= 0 {
+		return true
+	}
+	bucket.refill(now)
+	if bucket.tokens < float64(n) {
+		return false
+	}
+	bucket.tokens -= float64(n)
+	return true
+}
+
+// refill 按经过的时间补充令牌
+func (bucket *TokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(bucket.last); elapsed > 0 {
+		bucket.tokens += elapsed.Seconds() * bucket.rate
+		if bucket.tokens > bucket.burst {
+			bucket.tokens = bucket.burst
+		}
+	}
+	bucket.last = now
+}
@@ -0,0 +1,60 @@
+package lib
+
+import (
+	"testing"
+	"time"
+)
+
+// TestTokenBucket 测试令牌桶
+func TestTokenBucket(t *testing.T) {
+	bucket := NewTokenBucket(1000, 500)
+	start := bucket.last
+
+	var tests = []struct {
+		offset   time.Duration // 距创建时的时间
+		reserve  int           // 取出的令牌数
+		expected time.Duration // 需要等待的时间
+	}{
+		{0, 500, 0},
+		{0, 100, 100 * time.Millisecond},
+		{100 * time.Millisecond, 100, 100 * time.Millisecond},
+		{200 * time.Millisecond, 1000, time.Second},
+		{10 * time.Second, 600, 100 * time.Millisecond},
+	}
+
+	for _, test := range tests {
+		actual := bucket.reserve(test.reserve, start.Add(test.offset))
+		if actual != test.expected {
+			t.Errorf("[×] in: %v out: %v expected: %v\n", test, actual, test.expected)
+		} else {
+			t.Logf("[√] in: %v out: %v expected: %v\n", test, actual, test.expected)
+		}
+	}
+}
+
+// TestTokenBucketAllow 测试令牌不足时不取出令牌
+func TestTokenBucketAllow(t *testing.T) {
+	bucket := NewTokenBucket(1000, 500)
+	start := bucket.last
+
+	var tests = []struct {
+		offset   time.Duration // 距创建时的时间
+		allow    int           // 取出的令牌数
+		expected bool
+	}{
+		{0, 400, true},
+		{0, 200, false},
+		{0, 100, true},
+		{200 * time.Millisecond, 200, true},
+		{200 * time.Millisecond, 1, false},
+	}
+
+	for _, test := range tests {
+		actual := bucket.allow(test.allow, start.Add(test.offset))
+		if actual != test.expected {
+			t.Errorf("[×] in: %v out: %v expected: %v\n", test, actual, test.expected)
+		} else {
+			t.Logf("[√] in: %v out: %v expected: %v\n", test, actual, test.expected)
+		}
+	}
+}
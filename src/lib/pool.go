@@ -0,0 +1,43 @@
+package lib
+
+import "sync"
+
+// 字节池按2的幂分级，最小 1<<minBufferShift，最大 1<<maxBufferShift
+const (
+	minBufferShift = 7
+	maxBufferShift = 24
+)
+
+var bufferPools [maxBufferShift - minBufferShift + 1]sync.Pool
+
+// bufferClass 返回能容纳size字节的最小分级，超出最大分级时返回-1
+func bufferClass(size int) int {
+	for class := 0; class <= maxBufferShift-minBufferShift; class++ {
+		if size <= 1<<uint(class+minBufferShift) {
+			return class
+		}
+	}
+	return -1
+}
+
+// GetBuffer 从字节池中获取一个长度为size的字节切片，内容未清零
+func GetBuffer(size int) []byte {
+	class := bufferClass(size)
+	if class < 0 {
+		return make([]byte, size)
+	}
+	if buf, ok := bufferPools[class].Get().(*[]byte); ok {
+		return (*buf)[:size]
+	}
+	return make([]byte, size, 1<<uint(class+minBufferShift))
+}
+
+// PutBuffer 将GetBuffer获取的字节切片归还字节池，调用后不能再使用该切片
+func PutBuffer(buf []byte) {
+	class := bufferClass(cap(buf))
+	if class < 0 || cap(buf) != 1<<uint(class+minBufferShift) {
+		return
+	}
+	buf = buf[:0]
+	bufferPools[class].Put(&buf)
+}
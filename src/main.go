@@ -1,28 +1,243 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"database/sql"
+	"flag"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 
 	"./rtmp"
 
 	"./config"
+	"./dash"
+	"./hls"
+	"./httpflv"
+	"./record"
+	"./relay"
 	"./server"
 )
 
+// listener 监听端口上的服务
+type listener interface {
+	Listen(ctx context.Context) error
+	Shutdown(ctx context.Context) error
+}
+
 func main() {
-	db, err := sql.Open("mysql", config.GetDatabaseData())
-	if err != nil {
-		log.Println(err)
+	configPath := flag.String("config", "", "配置文件路径，为空时使用默认配置")
+	flag.Parse()
+
+	cfg := config.Default()
+	if *configPath != "" {
+		var err error
+		cfg, err = config.Load(*configPath)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	var db *sql.DB
+	if cfg.Registry.Backend == "mysql" {
+		var err error
+		db, err = sql.Open("mysql", cfg.Registry.DSN)
+		if err != nil {
+			log.Println(err)
+		}
 	}
-	rtmpServer := rtmp.NewServer(db)
+	rtmpServer := rtmp.NewServer(cfg, db)
 
-	s := server.Server{
-		Protocol: "tcp",
-		Address:  "127.0.0.1",
-		Port:     19356,
-		Handle:   rtmp.HandleConnection,
-		Args:     &rtmpServer,
+	mux := http.NewServeMux()
+	if cfg.Outputs.HTTPFLV.Enable {
+		mux.Handle(cfg.Outputs.HTTPFLV.Prefix+"/", httpflv.NewHandler(&rtmpServer))
+	}
+	var hlsServer *hls.Server
+	var dashServer *dash.Server
+	if cfg.Outputs.HLS.Enable || cfg.Outputs.DASH.Enable {
+		// HLS与DASH共用分片存储
+		storage, err := hls.NewStorage(cfg.Outputs.HLS.Storage, cfg.Outputs.HLS.Path)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if cfg.Outputs.HLS.Enable {
+			hlsServer = hls.NewServer(&rtmpServer, storage)
+			rtmpServer.OnPublish(hlsServer.Publish)
+			mux.Handle(cfg.Outputs.HLS.Prefix+"/", hlsServer.Handler())
+		}
+		if cfg.Outputs.DASH.Enable {
+			dashServer = dash.NewServer(&rtmpServer, storage)
+			rtmpServer.OnPublish(dashServer.Publish)
+			mux.Handle(cfg.Outputs.DASH.Prefix+"/", dashServer.Handler())
+		}
+	}
+	var recordServer *record.Server
+	if cfg.Outputs.Record.Enable {
+		recordServer = record.NewServer(&rtmpServer)
+		rtmpServer.OnPublish(recordServer.Publish)
+		if cfg.HasService("http") {
+			mux.Handle(cfg.Outputs.Record.Prefix+"/", recordServer)
+		}
+	}
+
+	var pushServer *relay.PushServer
+	if cfg.Outputs.Push.Enable {
+		pushServer = relay.NewPushServer(&rtmpServer)
+		rtmpServer.OnPublish(pushServer.Publish)
+		if cfg.HasService("http") {
+			mux.Handle(cfg.Outputs.Push.Prefix+"/", pushServer)
+		}
+	}
+
+	limiter := server.NewLimiter(cfg.Limits.MaxConnections, cfg.Limits.MaxConnectionsPerIP)
+	var rtmptServer *server.RTMPTServer
+	if cfg.RTMPT.Enable {
+		rtmptServer = &server.RTMPTServer{
+			Handle:      rtmp.HandleConnection,
+			Args:        &rtmpServer,
+			IdleTimeout: cfg.RTMPT.IdleTimeout.Duration(),
+			MaxBuffer:   cfg.RTMPT.MaxBuffer,
+			Limiter:     limiter,
+			OnReject:    rtmpServer.Stats.AddRejection,
+		}
+		for _, prefix := range config.RTMPTPaths {
+			mux.Handle(prefix+"/", rtmptServer)
+		}
+	}
+
+	var wsServer *server.WebSocketServer
+	if cfg.WebSocket.Enable {
+		wsServer = &server.WebSocketServer{
+			Handle: rtmp.HandleConnection,
+			Args:   &rtmpServer,
+		}
+		mux.Handle(cfg.WebSocket.Path, wsServer)
+	}
+
+	if cfg.Stats.Enable {
+		mux.Handle(cfg.Stats.Prefix, rtmpServer.StatsHandler())
+	}
+
+	var pullServer *relay.PullServer
+	if cfg.Pull.Enable {
+		pullServer = relay.NewPullServer(&rtmpServer)
+		rtmpServer.OnPlay(pullServer.Play)
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+	if interval := cfg.Stats.Interval.Duration(); interval > 0 {
+		go rtmpServer.LogStats(ctx, interval)
+	}
+	wg := sync.WaitGroup{}
+	servers := make([]listener, 0, len(cfg.Listeners))
+	for _, l := range cfg.Listeners {
+		tlsConfig, err := loadTLS(ctx, l.TLS)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		var s listener
+		if l.ServiceName() == "http" {
+			s = &server.HTTPServer{
+				Address:  l.Address,
+				Port:     l.Port,
+				Handler:  mux,
+				TLS:      tlsConfig,
+				Limiter:  limiter,
+				OnReject: rtmpServer.Stats.AddRejection,
+			}
+		} else {
+			s = &server.Server{
+				Protocol: l.Protocol,
+				Address:  l.Address,
+				Port:     l.Port,
+				Handle:   rtmp.HandleConnection,
+				Args:     &rtmpServer,
+				TLS:      tlsConfig,
+				Limiter:  limiter,
+				OnReject: rtmpServer.Stats.AddRejection,
+			}
+		}
+		servers = append(servers, s)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.Listen(ctx); err != nil {
+				stop()
+			}
+		}()
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	select {
+	case sig := <-signals:
+		log.Printf("Receive signal %v, shutting down.\n", sig)
+	case <-ctx.Done():
+	}
+	signal.Stop(signals)
+	stop()
+
+	// 通知所有连接后等待排空，超时后强制断开剩余的推流端与拉流端，为0时一直等待
+	drainCtx := context.Background()
+	if drain := cfg.Timeouts.Drain.Duration(); drain > 0 {
+		var cancelDrain context.CancelFunc
+		drainCtx, cancelDrain = context.WithTimeout(drainCtx, drain)
+		defer cancelDrain()
+	}
+	rtmpServer.Shutdown(drainCtx)
+	// 推流端断开后写出最后的分片与结束标记，使用单独的等待时间
+	finishCtx := context.Background()
+	if finish := cfg.Timeouts.Finish.Duration(); finish > 0 {
+		var cancelFinish context.CancelFunc
+		finishCtx, cancelFinish = context.WithTimeout(finishCtx, finish)
+		defer cancelFinish()
+	}
+	if hlsServer != nil {
+		hlsServer.Shutdown(finishCtx)
+	}
+	if dashServer != nil {
+		dashServer.Shutdown(finishCtx)
+	}
+	if recordServer != nil {
+		recordServer.Shutdown(finishCtx)
+	}
+	if pushServer != nil {
+		pushServer.Shutdown(finishCtx)
+	}
+	if pullServer != nil {
+		pullServer.Shutdown(finishCtx)
+	}
+	if rtmptServer != nil {
+		rtmptServer.Shutdown(drainCtx)
+	}
+	if wsServer != nil {
+		wsServer.Shutdown(drainCtx)
+	}
+	for _, s := range servers {
+		s.Shutdown(drainCtx)
+	}
+	wg.Wait()
+}
+
+// loadTLS 加载监听端口的证书，设置了重新加载间隔时在后台检查证书文件变化，t为空时返回nil
+func loadTLS(ctx context.Context, t *config.TLS) (*tls.Config, error) {
+	if t == nil {
+		return nil, nil
+	}
+	files := make([]server.CertFile, 0, len(t.Certificates))
+	for _, cert := range t.Certificates {
+		files = append(files, server.CertFile{Cert: cert.Cert, Key: cert.Key})
+	}
+	certs, err := server.NewCertificates(files)
+	if err != nil {
+		return nil, err
+	}
+	if interval := t.Reload.Duration(); interval > 0 {
+		go certs.Watch(ctx, interval)
 	}
-	s.Listen()
+	return certs.Config(), nil
 }
@@ -0,0 +1,30 @@
+package mpegts
+
+// crcTable CRC-32/MPEG-2 查找表
+var crcTable = makeCRCTable()
+
+// makeCRCTable 生成CRC-32/MPEG-2查找表，多项式 0x04C11DB7，不反转
+func makeCRCTable() [256]uint32 {
+	var table [256]uint32
+	for i := range table {
+		crc := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04c11db7
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}
+
+// CRC32 计算PSI表使用的CRC-32/MPEG-2校验值
+func CRC32(data []byte) uint32 {
+	crc := uint32(0xffffffff)
+	for _, b := range data {
+		crc = crc<<8 ^ crcTable[byte(crc>>24)^b]
+	}
+	return crc
+}
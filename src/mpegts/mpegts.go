@@ -0,0 +1,265 @@
+package mpegts
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+/*
+
+MPEG-TS 封装，用于HLS分片
+
+每个分片以PAT、PMT开头，视频为H.264或H.265(Annex B)，音频为AAC(ADTS)
+
+*/
+
+// PacketSize TS包长度
+const PacketSize = 188
+
+// 各个流的PID
+const (
+	PIDPAT   = uint16(0x0000)
+	PIDPMT   = uint16(0x1000)
+	PIDVideo = uint16(0x0100)
+	PIDAudio = uint16(0x0101)
+)
+
+// PMT中的流类型
+const (
+	StreamTypeH264 = uint8(0x1b)
+	StreamTypeHEVC = uint8(0x24)
+	StreamTypeAAC  = uint8(0x0f)
+)
+
+// PES流id
+const (
+	streamIDVideo = uint8(0xe0)
+	streamIDAudio = uint8(0xc0)
+)
+
+// Muxer MPEG-TS封装，时间戳单位为90kHz
+type Muxer struct {
+	VideoType  uint8 // PMT中视频的流类型，默认为H.264
+	w          io.Writer
+	hasVideo   bool
+	hasAudio   bool
+	continuity map[uint16]uint8 // 各PID的连续计数器
+	packet     [PacketSize]byte
+	pes        []byte
+}
+
+// NewMuxer 新建MPEG-TS封装
+func NewMuxer(w io.Writer, hasVideo bool, hasAudio bool) *Muxer {
+	return &Muxer{
+		VideoType:  StreamTypeH264,
+		w:          w,
+		hasVideo:   hasVideo,
+		hasAudio:   hasAudio,
+		continuity: make(map[uint16]uint8),
+	}
+}
+
+// WriteTables 写出PAT与PMT
+func (muxer *Muxer) WriteTables() error {
+	if err := muxer.writeSection(PIDPAT, muxer.pat()); err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(muxer.writeSection(PIDPMT, muxer.pmt()))
+}
+
+// WriteVideo 写出一个视频访问单元，data为Annex B格式，关键帧时携带随机访问标志与PCR
+func (muxer *Muxer) WriteVideo(pts uint64, dts uint64, data []byte, keyFrame bool) error {
+	muxer.pes = appendPESHeader(muxer.pes[:0], streamIDVideo, pts, dts, 0)
+	muxer.pes = append(muxer.pes, data...)
+	return errors.WithStack(muxer.writePES(PIDVideo, muxer.pes, dts, keyFrame))
+}
+
+// WriteAudio 写出AAC音频帧，data为ADTS格式
+func (muxer *Muxer) WriteAudio(pts uint64, data []byte) error {
+	muxer.pes = appendPESHeader(muxer.pes[:0], streamIDAudio, pts, pts, len(data))
+	muxer.pes = append(muxer.pes, data...)
+	return errors.WithStack(muxer.writePES(PIDAudio, muxer.pes, pts, !muxer.hasVideo))
+}
+
+// pcrPID 携带PCR的PID，有视频时为视频PID
+func (muxer *Muxer) pcrPID() uint16 {
+	if muxer.hasVideo {
+		return PIDVideo
+	}
+	return PIDAudio
+}
+
+// pat 生成PAT，只有一个节目
+func (muxer *Muxer) pat() []byte {
+	section := []byte{
+		0x00,       // table_id
+		0xb0, 0x00, // section_syntax_indicator, section_length
+		0x00, 0x01, // transport_stream_id
+		0xc1,       // version_number, current_next_indicator
+		0x00, 0x00, // section_number, last_section_number
+		0x00, 0x01, // program_number
+		0xe0 | byte(PIDPMT>>8), byte(PIDPMT & 0xff),
+	}
+	return finishSection(section)
+}
+
+// pmt 生成PMT
+func (muxer *Muxer) pmt() []byte {
+	pcrPID := muxer.pcrPID()
+	section := []byte{
+		0x02,       // table_id
+		0xb0, 0x00, // section_syntax_indicator, section_length
+		0x00, 0x01, // program_number
+		0xc1,       // version_number, current_next_indicator
+		0x00, 0x00, // section_number, last_section_number
+		0xe0 | byte(pcrPID>>8), byte(pcrPID & 0xff),
+		0xf0, 0x00, // program_info_length
+	}
+	if muxer.hasVideo {
+		section = append(section, muxer.VideoType, 0xe0|byte(PIDVideo>>8), byte(PIDVideo&0xff), 0xf0, 0x00)
+	}
+	if muxer.hasAudio {
+		section = append(section, StreamTypeAAC, 0xe0|byte(PIDAudio>>8), byte(PIDAudio&0xff), 0xf0, 0x00)
+	}
+	return finishSection(section)
+}
+
+// finishSection 填写section_length并追加CRC
+func finishSection(section []byte) []byte {
+	length := len(section) - 3 + 4
+	section[1] = section[1]&0xf0 | byte(length>>8)&0x0f
+	section[2] = byte(length)
+	var crc [4]byte
+	binary.BigEndian.PutUint32(crc[:], CRC32(section))
+	return append(section, crc[:]...)
+}
+
+// writeSection 写出一个只占一个TS包的PSI表
+func (muxer *Muxer) writeSection(pid uint16, section []byte) error {
+	packet := muxer.packet[:]
+	muxer.writePacketHeader(packet, pid, true, false)
+	packet[4] = 0x00 // pointer_field
+	n := copy(packet[5:], section)
+	for i := 5 + n; i < PacketSize; i++ {
+		packet[i] = 0xff
+	}
+	_, err := muxer.w.Write(packet)
+	return errors.WithStack(err)
+}
+
+// writePacketHeader 写出TS包头并递增连续计数器，adaptation表示是否带有adaptation field
+func (muxer *Muxer) writePacketHeader(packet []byte, pid uint16, start bool, adaptation bool) {
+	packet[0] = 0x47
+	packet[1] = byte(pid>>8) & 0x1f
+	if start {
+		packet[1] |= 0x40
+	}
+	packet[2] = byte(pid)
+	counter := muxer.continuity[pid]
+	muxer.continuity[pid] = (counter + 1) & 0x0f
+	packet[3] = 0x10 | counter
+	if adaptation {
+		packet[3] |= 0x20
+	}
+}
+
+// writePES 将PES包切分为TS包写出，首个包可携带PCR与随机访问标志，最后一个包用adaptation field填充
+func (muxer *Muxer) writePES(pid uint16, pes []byte, dts uint64, randomAccess bool) error {
+	start := true
+	for len(pes) > 0 {
+		packet := muxer.packet[:]
+
+		// adaptation field
+		var adaptation []byte
+		if start && (randomAccess || pid == muxer.pcrPID()) {
+			flags := byte(0)
+			if randomAccess {
+				flags |= 0x40
+			}
+			if pid == muxer.pcrPID() {
+				flags |= 0x10
+			}
+			adaptation = append(muxer.packet[4:4], 0, flags)
+			if flags&0x10 != 0 {
+				adaptation = appendPCR(adaptation, dts)
+			}
+		}
+
+		space := PacketSize - 4 - len(adaptation)
+		if len(pes) < space {
+			// 填充不足一个包的数据
+			stuffing := space - len(pes)
+			if len(adaptation) == 0 {
+				if stuffing == 1 {
+					adaptation = append(muxer.packet[4:4], 0)
+				} else {
+					adaptation = append(muxer.packet[4:4], 0, 0)
+				}
+				stuffing -= len(adaptation)
+			}
+			for i := 0; i < stuffing; i++ {
+				adaptation = append(adaptation, 0xff)
+			}
+		}
+		if len(adaptation) > 0 {
+			adaptation[0] = byte(len(adaptation) - 1)
+		}
+
+		muxer.writePacketHeader(packet, pid, start, len(adaptation) > 0)
+		n := copy(packet[4+len(adaptation):], pes)
+		if _, err := muxer.w.Write(packet); err != nil {
+			return errors.WithStack(err)
+		}
+		pes = pes[n:]
+		start = false
+	}
+	return nil
+}
+
+// appendPESHeader 追加PES包头，pts与dts相同时只写pts，dataSize为0时不限制包长度
+func appendPESHeader(buf []byte, streamID uint8, pts uint64, dts uint64, dataSize int) []byte {
+	headerSize := 5
+	flags := byte(0x80)
+	if pts != dts {
+		headerSize = 10
+		flags = 0xc0
+	}
+	packetSize := 0
+	if dataSize > 0 {
+		packetSize = 3 + headerSize + dataSize
+		if packetSize > 0xffff {
+			packetSize = 0
+		}
+	}
+	buf = append(buf, 0x00, 0x00, 0x01, streamID, byte(packetSize>>8), byte(packetSize), 0x80, flags, byte(headerSize))
+	if pts != dts {
+		buf = appendTimestamp(buf, 0x30, pts)
+		return appendTimestamp(buf, 0x10, dts)
+	}
+	return appendTimestamp(buf, 0x20, pts)
+}
+
+// appendTimestamp 追加33位的PTS或DTS
+func appendTimestamp(buf []byte, prefix byte, ts uint64) []byte {
+	return append(buf,
+		prefix|byte(ts>>29)&0x0e|0x01,
+		byte(ts>>22),
+		byte(ts>>14)|0x01,
+		byte(ts>>7),
+		byte(ts<<1)|0x01,
+	)
+}
+
+// appendPCR 追加PCR，扩展部分为0
+func appendPCR(buf []byte, pcr uint64) []byte {
+	return append(buf,
+		byte(pcr>>25),
+		byte(pcr>>17),
+		byte(pcr>>9),
+		byte(pcr>>1),
+		byte(pcr<<7)|0x7e,
+		0x00,
+	)
+}
@@ -0,0 +1,58 @@
+package mpegts
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestCRC32 测试CRC-32/MPEG-2校验值
+func TestCRC32(t *testing.T) {
+	var tests = []struct {
+		in       []byte // input
+		expected uint32 // expected result
+	}{
+		{[]byte("123456789"), 0x0376e6e7},
+		{[]byte{}, 0xffffffff},
+		{[]byte{0x00, 0xb0, 0x0d, 0x00, 0x01, 0xc1, 0x00, 0x00, 0x00, 0x01, 0xf0, 0x00}, 0x2ab104b2},
+	}
+
+	for _, test := range tests {
+		actual := CRC32(test.in)
+		if actual != test.expected {
+			t.Errorf("[×] in: %v out: %08x expected: %08x\n", test.in, actual, test.expected)
+		} else {
+			t.Logf("[√] in: %v out: %08x expected: %08x\n", test.in, actual, test.expected)
+		}
+	}
+}
+
+// TestMuxer 测试TS包的切分与填充
+func TestMuxer(t *testing.T) {
+	var tests = []struct {
+		size     int // PES数据长度
+		expected int // 期望的TS包数
+	}{
+		{1, 1},
+		{100, 1},
+		{170, 2},
+		{1000, 6},
+	}
+
+	for _, test := range tests {
+		buf := new(bytes.Buffer)
+		muxer := NewMuxer(buf, true, true)
+		muxer.WriteTables()
+		muxer.WriteVideo(90000, 90000, make([]byte, test.size), true)
+		data := buf.Bytes()
+
+		ok := len(data)%PacketSize == 0 && len(data)/PacketSize-2 == test.expected
+		for i := 0; ok && i < len(data); i += PacketSize {
+			ok = data[i] == 0x47
+		}
+		if !ok {
+			t.Errorf("[×] in: %v out: %d bytes expected: %d packets\n", test.size, len(data), test.expected)
+		} else {
+			t.Logf("[√] in: %v out: %d bytes expected: %d packets\n", test.size, len(data), test.expected)
+		}
+	}
+}
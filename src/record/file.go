@@ -0,0 +1,232 @@
+package record
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"../flv"
+	"../rtmp/amf"
+	"github.com/pkg/errors"
+)
+
+/*
+
+FLV 录制文件
+
+文件以onMetaData开始，其中duration与filesize固定放在最前面，完成文件时在原位置改写为实际值
+
+*/
+
+// mediaFile 一个正在写出的录制文件
+type mediaFile interface {
+	writeTag(tagType uint8, timestamp uint32, data []byte) error // 写出FLV负载，timestamp为流内时间戳
+	timestamp(timestamp uint32) uint32                           // 流内时间戳转换为文件内时间戳
+	duration() time.Duration                                     // 已写出的时长
+	size() int64                                                 // 已写出的字节数
+	path() string
+	close() error // 完成文件
+}
+
+// timeLayout 文件名模板中 {time} 的格式
+const timeLayout = "20060102-150405"
+
+// durationOffset onMetaData数据中duration数值的位置，即 "onMetaData" 字符串与ECMA数组头之后
+const durationOffset = 13 + 5 + 10 + 1
+
+// filesizeOffset onMetaData数据中filesize数值的位置
+const filesizeOffset = durationOffset + 8 + 10 + 1
+
+// clock 文件内时间戳，从文件第一帧开始
+type clock struct {
+	base uint32 // 文件第一帧的流内时间戳
+	last uint32 // 已写出的最大文件内时间戳
+}
+
+// timestamp 流内时间戳转换为文件内时间戳
+func (clock *clock) timestamp(timestamp uint32) uint32 {
+	if timestamp < clock.base {
+		return 0
+	}
+	return timestamp - clock.base
+}
+
+// advance 转换为文件内时间戳并记录已写出的时长
+func (clock *clock) advance(timestamp uint32) uint32 {
+	ts := clock.timestamp(timestamp)
+	if ts > clock.last {
+		clock.last = ts
+	}
+	return ts
+}
+
+// duration 已写出的时长
+func (clock *clock) duration() time.Duration {
+	return time.Duration(clock.last) * time.Millisecond
+}
+
+// createUnique 新建文件，文件已存在时在文件名后增加序号，返回实际的文件名
+func createUnique(path string) (*os.File, string, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, "", errors.WithStack(err)
+	}
+	ext := filepath.Ext(path)
+	name := path
+	for i := 1; ; i++ {
+		f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			return f, name, nil
+		}
+		if !os.IsExist(err) {
+			return nil, "", errors.WithStack(err)
+		}
+		name = fmt.Sprintf("%s-%d%s", strings.TrimSuffix(path, ext), i, ext)
+	}
+}
+
+// flvFile 一个正在写出的FLV文件
+type flvFile struct {
+	clock
+	name    string
+	f       *os.File
+	buf     *bufio.Writer
+	writer  *flv.Writer
+	written int64
+}
+
+// createFLVFile 新建FLV文件并写出文件头与元数据
+func createFLVFile(path string, hasAudio bool, hasVideo bool, metadata map[string]interface{}, base uint32) (*flvFile, error) {
+	f, name, err := createUnique(path)
+	if err != nil {
+		return nil, err
+	}
+
+	file := &flvFile{clock: clock{base: base}, name: name, f: f}
+	file.buf = bufio.NewWriter(f)
+	file.writer = flv.NewWriter(file)
+	if err := file.writer.WriteHeader(hasAudio, hasVideo); err != nil {
+		f.Close()
+		return nil, errors.WithStack(err)
+	}
+	if err := file.writer.WriteTag(flv.TagTypeScript, 0, metadataData(metadata)); err != nil {
+		f.Close()
+		return nil, errors.WithStack(err)
+	}
+	return file, nil
+}
+
+// Write 写入缓冲并统计文件大小
+func (file *flvFile) Write(data []byte) (int, error) {
+	n, err := file.buf.Write(data)
+	file.written += int64(n)
+	return n, err
+}
+
+// writeTag 写出一个标签
+func (file *flvFile) writeTag(tagType uint8, timestamp uint32, data []byte) error {
+	return errors.WithStack(file.writer.WriteTag(tagType, file.advance(timestamp), data))
+}
+
+// size 已写出的字节数
+func (file *flvFile) size() int64 {
+	return file.written
+}
+
+// path 文件路径
+func (file *flvFile) path() string {
+	return file.name
+}
+
+// close 写出缓冲，改写元数据中的时长与文件大小后关闭文件
+func (file *flvFile) close() error {
+	err := file.finalize()
+	if closeErr := file.f.Close(); err == nil {
+		err = closeErr
+	}
+	return errors.WithStack(err)
+}
+
+// finalize 写出缓冲并改写元数据
+func (file *flvFile) finalize() error {
+	if err := file.buf.Flush(); err != nil {
+		return err
+	}
+	dataStart := int64(flv.HeaderSize + flv.TagHeaderSize)
+	if _, err := file.f.WriteAt(amfNumber(file.duration().Seconds()), dataStart+durationOffset); err != nil {
+		return err
+	}
+	_, err := file.f.WriteAt(amfNumber(float64(file.written)), dataStart+filesizeOffset)
+	return err
+}
+
+// amfNumber AMF0数值的8字节数据，不包括类型
+func amfNumber(value float64) []byte {
+	return amf.NewNumber(value).Bytes()[1:]
+}
+
+// metadataData 生成文件开头的onMetaData，duration与filesize在最前面，其余为推流端元数据中的数值、字符串与布尔值
+func metadataData(metadata map[string]interface{}) []byte {
+	keys := make([]string, 0, len(metadata))
+	for key, value := range metadata {
+		if key == "duration" || key == "filesize" {
+			continue
+		}
+		switch value.(type) {
+		case float64, string, bool:
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	data := amf.NewString("onMetaData").Bytes()
+	count := len(keys) + 2
+	data = append(data, byte(amf.AMFTypeECMAArray), byte(count>>24), byte(count>>16), byte(count>>8), byte(count))
+	data = appendProperty(data, "duration", amf.NewNumber(0))
+	data = appendProperty(data, "filesize", amf.NewNumber(0))
+	for _, key := range keys {
+		var value amf.AMF
+		switch v := metadata[key].(type) {
+		case float64:
+			value = amf.NewNumber(v)
+		case string:
+			value = amf.NewString(v)
+		case bool:
+			value = amf.NewBoolean(v)
+		}
+		data = appendProperty(data, key, value)
+	}
+	return append(data, 0, 0, byte(amf.AMFTypeObjectEnd))
+}
+
+// appendProperty 追加ECMA数组中的一个属性
+func appendProperty(data []byte, key string, value amf.AMF) []byte {
+	data = append(data, byte(len(key)>>8), byte(len(key)))
+	data = append(data, key...)
+	return append(data, value.Bytes()...)
+}
+
+// expandFilename 展开文件名模板
+func expandFilename(template string, app string, stream string, start time.Time, index int) string {
+	replacer := strings.NewReplacer(
+		"{app}", sanitize(app),
+		"{stream}", sanitize(stream),
+		"{time}", start.Format(timeLayout),
+		"{unix}", strconv.FormatInt(start.Unix(), 10),
+		"{index}", strconv.Itoa(index),
+	)
+	return replacer.Replace(template)
+}
+
+// sanitize 替换名称中的路径分隔符，避免写出到录制目录之外
+func sanitize(name string) string {
+	name = strings.NewReplacer("/", "_", "\\", "_", "\x00", "_").Replace(name)
+	if name == "" || name == "." || name == ".." {
+		return "_"
+	}
+	return name
+}
@@ -0,0 +1,76 @@
+package record
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"../flv"
+	"../lib"
+)
+
+// TestExpandFilename 测试文件名模板
+func TestExpandFilename(t *testing.T) {
+	start := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	var tests = []struct {
+		template string // input
+		app      string // input
+		stream   string // input
+		expected string // expected result
+	}{
+		{"{app}/{stream}/{time}.flv", "live", "test", "live/test/20200102-030405.flv"},
+		{"{app}-{stream}-{unix}-{index}.flv", "live", "test", "live-test-1577934245-2.flv"},
+		{"{app}/{stream}.flv", "live", "../../etc", "live/.._.._etc.flv"},
+		{"{app}/{stream}.flv", "live", "..", "live/_.flv"},
+	}
+
+	for _, test := range tests {
+		actual := expandFilename(test.template, test.app, test.stream, start, 2)
+		if actual != test.expected {
+			t.Errorf("[×] in: %q %q %q out: %q expected: %q\n", test.template, test.app, test.stream, actual, test.expected)
+		} else {
+			t.Logf("[√] in: %q %q %q out: %q expected: %q\n", test.template, test.app, test.stream, actual, test.expected)
+		}
+	}
+}
+
+// TestFile 测试完成文件时改写元数据中的时长与文件大小
+func TestFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "record")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "live", "test.flv")
+	metadata := map[string]interface{}{"width": 1280.0, "duration": 5.0, "encoder": "obs", "stereo": true, "nested": map[string]interface{}{}}
+	for i := 0; i < 2; i++ {
+		file, err := createFLVFile(path, true, true, metadata, 1000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.writeTag(flv.TagTypeVideo, 1000, []byte{0x17, 1, 0, 0, 0})
+		file.writeTag(flv.TagTypeVideo, 3500, []byte{0x27, 1, 0, 0, 0})
+		if err := file.close(); err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := ioutil.ReadFile(file.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dataStart := flv.HeaderSize + flv.TagHeaderSize
+		duration := lib.ByteToFloat64(data[dataStart+durationOffset : dataStart+durationOffset+8])
+		filesize := lib.ByteToFloat64(data[dataStart+filesizeOffset : dataStart+filesizeOffset+8])
+		if duration != 2.5 || filesize != float64(len(data)) || int64(len(data)) != file.written {
+			t.Errorf("[×] %s duration: %v filesize: %v size: %d\n", file.name, duration, filesize, len(data))
+		} else {
+			t.Logf("[√] %s duration: %v filesize: %v size: %d\n", file.name, duration, filesize, len(data))
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "live", "test-1.flv")); err != nil {
+		t.Errorf("[×] existing file overwritten: %v\n", err)
+	}
+}
@@ -0,0 +1,310 @@
+package record
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+
+	"../flv"
+	"../fmp4"
+	"github.com/pkg/errors"
+)
+
+/*
+
+MP4 录制文件
+
+将FLV负载中的H.264/H.265/AV1/VP9/AAC转换为MP4样本，视频的显示时间偏移取自FLV的CompositionTime
+视频兼容传统与 Enhanced RTMP 标签头
+非分片模式下媒体数据先写入 文件名.part，结束时生成moov，按 ftyp+moov+mdat 的顺序写出最终文件，便于边下载边播放
+分片模式下每个关键帧开始一个moof+mdat分段并立即写出，异常退出时已写出的分段仍可播放
+
+*/
+
+// errConfigChanged 序列头变化，MP4文件的样本描述不能修改，需要切换到新文件
+var errConfigChanged = errors.New("codec config changed")
+
+// mdatHeaderSize mdat头部长度，使用64位长度
+const mdatHeaderSize = 16
+
+// codecAAC FLV中AAC的SoundFormat
+const codecAAC = 10
+
+// mp4File 一个正在写出的MP4文件
+type mp4File struct {
+	clock
+	name    string // 最终的文件路径
+	f       *os.File
+	buf     *bufio.Writer
+	written int64
+
+	videoHeader []byte
+	audioHeader []byte
+	tracks      []*fmp4.Track
+	video       int // 视频轨道的序号，没有视频时为-1
+	audio       int // 音频轨道的序号，没有音频时为-1
+
+	// 非分片模式
+	movie     *fmp4.Movie
+	tmp       string // 录制中的媒体数据文件
+	mdatStart int64
+
+	// 分片模式
+	fragmented    bool
+	buffers       []*fmp4.SampleBuffer
+	sequence      uint32
+	fragmentStart uint64 // 纯音频流当前分段开始的解码时间
+}
+
+// createMP4File 由音视频序列头新建MP4文件，不支持的编码不写入
+func createMP4File(path string, videoHeader []byte, audioHeader []byte, base uint32, fragmented bool) (*mp4File, error) {
+	file := &mp4File{
+		clock:       clock{base: base},
+		videoHeader: videoHeader,
+		audioHeader: audioHeader,
+		video:       -1,
+		audio:       -1,
+		fragmented:  fragmented,
+	}
+	if err := file.createTracks(); err != nil {
+		return nil, err
+	}
+
+	f, name, err := createUnique(path)
+	if err != nil {
+		return nil, err
+	}
+	file.name = name
+	if fragmented {
+		file.f = f
+		for _, track := range file.tracks {
+			file.buffers = append(file.buffers, fmp4.NewSampleBuffer(track))
+		}
+	} else {
+		// 最终文件先保留文件名，完成时再写入
+		f.Close()
+		file.tmp = name + ".part"
+		if file.f, err = os.Create(file.tmp); err != nil {
+			os.Remove(name)
+			return nil, errors.WithStack(err)
+		}
+		file.movie = fmp4.NewMovie(file.tracks)
+	}
+	file.buf = bufio.NewWriter(file.f)
+
+	if fragmented {
+		err = file.write(fmp4.InitSegment(file.tracks))
+	} else {
+		err = file.write(fmp4.FileType())
+		file.mdatStart = file.written
+		// mdat长度在完成时回填
+		header := []byte{0, 0, 0, 1, 'm', 'd', 'a', 't', 0, 0, 0, 0, 0, 0, 0, 0}
+		if err == nil {
+			err = file.write(header)
+		}
+	}
+	if err != nil {
+		file.f.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// createTracks 由序列头新建轨道
+func (file *mp4File) createTracks() error {
+	id := uint32(1)
+	if header := file.videoHeader; header != nil {
+		tag, err := flv.ParseVideoTag(header)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		track, err := fmp4.NewCodecTrack(id, tag.FourCC, tag.Body)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		file.video = len(file.tracks)
+		file.tracks = append(file.tracks, track)
+		id++
+	}
+	if header := file.audioHeader; len(header) > 2 && header[0]>>4 == codecAAC {
+		track, err := fmp4.NewAudioTrack(id, header[2:])
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		file.audio = len(file.tracks)
+		file.tracks = append(file.tracks, track)
+	}
+	if len(file.tracks) == 0 {
+		return errors.New("no track supported by mp4")
+	}
+	return nil
+}
+
+// write 写入缓冲并统计文件大小
+func (file *mp4File) write(data []byte) error {
+	n, err := file.buf.Write(data)
+	file.written += int64(n)
+	return errors.WithStack(err)
+}
+
+// writeTag 将FLV负载转换为样本写出，元数据与不支持的编码忽略
+func (file *mp4File) writeTag(tagType uint8, timestamp uint32, data []byte) error {
+	switch tagType {
+	case flv.TagTypeVideo:
+		if file.video < 0 {
+			return nil
+		}
+		tag, err := flv.ParseVideoTag(data)
+		if err != nil {
+			return nil
+		}
+		if tag.SequenceHeader() {
+			if !bytes.Equal(data, file.videoHeader) {
+				return errConfigChanged
+			}
+			return nil
+		}
+		if !tag.Frame() {
+			return nil
+		}
+		ts := file.advance(timestamp)
+		return file.addSample(file.video, uint64(ts)*90, fmp4.Sample{
+			CompositionOffset: tag.CompositionTime * 90,
+			KeyFrame:          tag.KeyFrame(),
+			Data:              tag.Body,
+		})
+	case flv.TagTypeAudio:
+		if len(data) < 2 || data[0]>>4 != codecAAC {
+			return nil
+		}
+		if data[1] == 0 {
+			if !bytes.Equal(data, file.audioHeader) {
+				return errConfigChanged
+			}
+			return nil
+		}
+		if file.audio < 0 {
+			return nil
+		}
+		rate := uint64(file.tracks[file.audio].Timescale)
+		ts := file.advance(timestamp)
+		return file.addSample(file.audio, uint64(ts)*rate/1000, fmp4.Sample{
+			Duration: aacFrameSamples,
+			KeyFrame: true,
+			Data:     data[2:],
+		})
+	}
+	return nil
+}
+
+// aacFrameSamples 每个AAC帧的采样数
+const aacFrameSamples = 1024
+
+// addSample 写出一个样本，分片模式下在关键帧处先写出之前的分段
+func (file *mp4File) addSample(index int, decodeTime uint64, sample fmp4.Sample) error {
+	if !file.fragmented {
+		file.movie.AddSample(index, decodeTime, uint64(file.written), sample)
+		return file.write(sample.Data)
+	}
+
+	var cut bool
+	if index == file.video {
+		cut = sample.KeyFrame
+	} else if file.video < 0 {
+		// 纯音频流每秒一个分段
+		cut = decodeTime-file.fragmentStart >= uint64(file.tracks[index].Timescale)
+	}
+	if cut {
+		if err := file.flushFragment(decodeTime); err != nil {
+			return err
+		}
+		file.fragmentStart = decodeTime
+	}
+	file.buffers[index].Add(decodeTime, sample)
+	return nil
+}
+
+// flushFragment 写出缓存的样本，next为下一个视频帧的解码时间，为0时沿用上一帧的时长
+func (file *mp4File) flushFragment(next uint64) error {
+	var fragments []fmp4.Fragment
+	for i, buffer := range file.buffers {
+		if buffer.Len() == 0 {
+			continue
+		}
+		if i == file.video {
+			fragments = append(fragments, buffer.Take(next))
+		} else {
+			fragments = append(fragments, buffer.Take(0))
+		}
+	}
+	if len(fragments) == 0 {
+		return nil
+	}
+	file.sequence++
+	if err := file.write(fmp4.AppendFragment(nil, file.sequence, fragments)); err != nil {
+		return err
+	}
+	// 每个分段都写入磁盘，异常退出时不会丢失
+	return errors.WithStack(file.buf.Flush())
+}
+
+// size 已写出的字节数
+func (file *mp4File) size() int64 {
+	return file.written
+}
+
+// path 文件路径
+func (file *mp4File) path() string {
+	return file.name
+}
+
+// close 完成文件
+func (file *mp4File) close() error {
+	if file.fragmented {
+		err := file.flushFragment(0)
+		if closeErr := file.f.Close(); err == nil {
+			err = closeErr
+		}
+		return errors.WithStack(err)
+	}
+
+	err := file.finalize()
+	file.f.Close()
+	if err != nil {
+		return err
+	}
+	return errors.WithStack(os.Remove(file.tmp))
+}
+
+// finalize 回填mdat长度，按 ftyp+moov+mdat 的顺序写出最终文件
+func (file *mp4File) finalize() error {
+	if err := file.buf.Flush(); err != nil {
+		return errors.WithStack(err)
+	}
+	var size [8]byte
+	binary.BigEndian.PutUint64(size[:], uint64(file.written-file.mdatStart))
+	if _, err := file.f.WriteAt(size[:], file.mdatStart+8); err != nil {
+		return errors.WithStack(err)
+	}
+
+	out, err := os.OpenFile(file.name, os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer out.Close()
+	w := bufio.NewWriter(out)
+	w.Write(fmp4.FileType())
+	w.Write(file.movie.FastStart())
+	if _, err := file.f.Seek(file.mdatStart, io.SeekStart); err != nil {
+		return errors.WithStack(err)
+	}
+	if _, err := io.Copy(w, file.f); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := w.Flush(); err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(out.Close())
+}
@@ -0,0 +1,241 @@
+package record
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+
+	"../config"
+	c "../lib/colorful"
+	"../rtmp"
+	"github.com/pkg/errors"
+)
+
+/*
+
+直播录制
+
+推流开始时按应用的录制规则决定是否录制，manual模式下通过HTTP接口开始与停止录制
+	GET  前缀/应用/流名称        查询录制状态
+	POST 前缀/应用/流名称/start  开始录制
+	POST 前缀/应用/流名称/stop   停止录制
+接口使用推流密钥鉴权
+
+*/
+
+// Server 录制服务
+type Server struct {
+	RTMP   *rtmp.Server
+	Config config.RecordOutput
+
+	mutex  sync.Mutex
+	active map[string]*Recorder // 正在录制的流
+	wg     sync.WaitGroup
+}
+
+// status 录制状态接口的响应
+type status struct {
+	Name      string `json:"name"`
+	Recording bool   `json:"recording"`
+	File      string `json:"file,omitempty"` // 正在写出的文件
+}
+
+// NewServer 新建录制服务
+func NewServer(server *rtmp.Server) *Server {
+	return &Server{
+		RTMP:   server,
+		Config: server.Config.Outputs.Record,
+		active: make(map[string]*Recorder),
+	}
+}
+
+// Publish 推流开始时调用，按录制规则开始录制
+func (server *Server) Publish(stream *rtmp.Stream) {
+	appName, streamName := splitName(stream.Name)
+	rule, ok := server.rule(appName)
+	if !ok || !matchRule(rule, streamName) {
+		return
+	}
+	server.start(stream)
+}
+
+// Shutdown 等待所有录制器完成文件，推流端需先断开
+func (server *Server) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		server.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return errors.WithStack(ctx.Err())
+	}
+}
+
+// rule 应用的录制规则
+func (server *Server) rule(appName string) (config.RecordRule, bool) {
+	app, ok := server.RTMP.Config.Application(appName)
+	if !ok {
+		return config.RecordRule{}, false
+	}
+	return app.RecordRule(server.Config.Rule), true
+}
+
+// matchRule 推流开始时是否自动录制
+func matchRule(rule config.RecordRule, streamName string) bool {
+	switch rule.Mode {
+	case "all":
+		return true
+	case "match":
+		for _, pattern := range rule.Streams {
+			if ok, _ := path.Match(pattern, streamName); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// splitName 拆分 应用/流名称
+func splitName(name string) (string, string) {
+	idx := strings.IndexByte(name, '/')
+	if idx < 0 {
+		return name, ""
+	}
+	return name[:idx], name[idx+1:]
+}
+
+// start 开始录制，流已在录制或没有推流端时返回false
+func (server *Server) start(stream *rtmp.Stream) bool {
+	defer server.mutex.Unlock()
+	server.mutex.Lock()
+
+	if _, ok := server.active[stream.Name]; ok {
+		return false
+	}
+	appName, _ := splitName(stream.Name)
+	rec := newRecorder(server, appName, stream)
+	if !stream.AddOutput(rec.queue) {
+		return false
+	}
+	server.active[stream.Name] = rec
+	server.wg.Add(1)
+
+	log.Println(c.Front("Record %s started", c.G, stream.Name))
+	go rec.run()
+	return true
+}
+
+// stop 停止录制，录制器写出已加入队列的音视频帧后完成文件
+func (server *Server) stop(stream *rtmp.Stream) bool {
+	server.mutex.Lock()
+	rec, ok := server.active[stream.Name]
+	delete(server.active, stream.Name)
+	server.mutex.Unlock()
+
+	if !ok {
+		return false
+	}
+	stream.DelOutput(rec.queue)
+	rec.queue.CloseServer()
+	return true
+}
+
+// finished 录制器结束
+func (server *Server) finished(rec *Recorder) {
+	server.mutex.Lock()
+	if server.active[rec.Name] == rec {
+		delete(server.active, rec.Name)
+	}
+	server.mutex.Unlock()
+
+	log.Println(c.Front("Record %s ended", c.G, rec.Name))
+	server.wg.Done()
+}
+
+// status 流的录制状态
+func (server *Server) status(name string) status {
+	defer server.mutex.Unlock()
+	server.mutex.Lock()
+
+	st := status{Name: name}
+	if rec, ok := server.active[name]; ok {
+		st.Recording = true
+		st.File = rec.Path()
+	}
+	return st
+}
+
+// ServeHTTP 查询录制状态，手动开始与停止录制
+func (server *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	appName, streamName, action, ok := server.parsePath(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if (action == "" && r.Method != http.MethodGet) || (action != "" && r.Method != http.MethodPost) {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	app, ok := server.RTMP.Config.Application(appName)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if !server.RTMP.Authorize(app, true, r.URL.Query()) {
+		http.Error(w, "record denied", http.StatusForbidden)
+		return
+	}
+	name := appName + "/" + streamName
+	if action != "" && app.RecordRule(server.Config.Rule).Mode != "manual" {
+		http.Error(w, "recording of this application is not manual", http.StatusForbidden)
+		return
+	}
+
+	switch action {
+	case "start":
+		stream, ok := server.RTMP.FindStream(name)
+		if !ok || !stream.HasPublisher() {
+			http.Error(w, "stream is not publishing", http.StatusConflict)
+			return
+		}
+		if server.start(stream) {
+			log.Println(c.Front("Record %s started manually by %s", c.G, name, r.RemoteAddr))
+		}
+	case "stop":
+		if stream, ok := server.RTMP.FindStream(name); ok && server.stop(stream) {
+			log.Println(c.Front("Record %s stopped manually by %s", c.G, name, r.RemoteAddr))
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	json.NewEncoder(w).Encode(server.status(name))
+}
+
+// parsePath 从请求路径中解析应用、流名称与操作
+func (server *Server) parsePath(urlPath string) (string, string, string, bool) {
+	prefix := server.Config.Prefix + "/"
+	if !strings.HasPrefix(urlPath, prefix) {
+		return "", "", "", false
+	}
+	parts := strings.Split(strings.TrimPrefix(urlPath, prefix), "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return "", "", "", false
+	}
+	if len(parts) == 2 {
+		return parts[0], parts[1], "", true
+	}
+	if parts[2] != "start" && parts[2] != "stop" {
+		return "", "", "", false
+	}
+	return parts[0], parts[1], parts[2], true
+}
@@ -0,0 +1,281 @@
+package record
+
+import (
+	"log"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"../flv"
+	c "../lib/colorful"
+	"../rtmp"
+	"../rtmp/amf"
+)
+
+/*
+
+录制器
+
+作为封装输出订阅推流，从第一个关键帧开始写出，纯音频流从第一个音频帧开始
+每个文件都以元数据与音视频序列头开始，时间戳从0开始，超过时长或大小上限后在下一个关键帧处切换到新文件
+
+*/
+
+// extensions 各录制格式的文件扩展名
+var extensions = map[string]string{
+	"flv":  ".flv",
+	"mp4":  ".mp4",
+	"fmp4": ".mp4",
+}
+
+// audioOnlyFrames 没有元数据时，收到多少个音频帧仍没有视频序列头则视为纯音频流
+const audioOnlyFrames = 50
+
+// Recorder 一条流的录制器
+type Recorder struct {
+	Name   string // 应用/流名称
+	App    string
+	Stream string
+	server *Server
+	queue  *rtmp.Queue
+
+	metadata     map[string]interface{} // 推流端元数据
+	metaHasVideo bool                   // 元数据中是否有视频
+	videoHeader  []byte                 // 视频序列头
+	videoInfo    []byte                 // Enhanced RTMP 的视频元数据
+	audioHeader  []byte                 // 音频序列头
+	audioFrames  int                    // 没有视频序列头时收到的音频帧数
+
+	file  mediaFile // 正在写出的文件，开始录制前与写出出错后为空
+	index int       // 本次录制的文件序号
+
+	mutex sync.Mutex
+	path  string // 正在写出的文件路径，供状态接口读取
+}
+
+// newRecorder 新建录制器，中途开始录制时从流的缓存中取得元数据与序列头
+func newRecorder(server *Server, app string, stream *rtmp.Stream) *Recorder {
+	rec := &Recorder{
+		Name:   stream.Name,
+		App:    app,
+		Stream: stream.Name[len(app)+1:],
+		server: server,
+		queue:  rtmp.NewQueue(server.Config.Queue),
+	}
+	// 写出跟不上推流时丢帧会造成录制文件缺失画面，逐帧记录
+	rec.queue.OnDrop = func(frame *rtmp.Frame) {
+		log.Println(c.Front("Record %s dropped frame type %d at %dms", c.Y, rec.Name, frame.Type, frame.Timestamp))
+		server.RTMP.Stats.AddWarning("record frame dropped")
+	}
+	for _, header := range stream.GetHeaders() {
+		frame := rtmp.NewFrame(header)
+		rec.cache(frame)
+	}
+	return rec
+}
+
+// run 写出音视频帧直到推流结束或停止录制
+func (rec *Recorder) run() {
+	for {
+		select {
+		case frame := <-rec.queue.Frames:
+			rec.handle(frame)
+		case <-rec.queue.Done():
+			rec.drain()
+			rec.finish()
+			return
+		}
+	}
+}
+
+// drain 写出推流结束前已加入队列的音视频帧
+func (rec *Recorder) drain() {
+	for {
+		select {
+		case frame := <-rec.queue.Frames:
+			rec.handle(frame)
+		default:
+			return
+		}
+	}
+}
+
+// cache 缓存元数据与序列头，返回是否为元数据或序列头
+func (rec *Recorder) cache(frame *rtmp.Frame) bool {
+	data := frame.Data
+	switch {
+	case frame.Type == rtmp.RTMPTypeAMFData:
+		array, err := amf.ByteToAMFArray(data)
+		if err != nil || len(array) < 2 {
+			return true
+		}
+		if name, _ := array[0].Value().(string); name != "onMetaData" {
+			return true
+		}
+		if metadata, ok := array[1].Value().(map[string]interface{}); ok {
+			_, rec.metaHasVideo = metadata["videocodecid"]
+			rec.metadata = metadata
+		}
+		return true
+	case frame.SequenceHeader() && frame.Type == rtmp.RTMPTypeVideoData:
+		rec.videoHeader = append([]byte{}, data...)
+		return true
+	case frame.SequenceHeader():
+		rec.audioHeader = append([]byte{}, data...)
+		return true
+	case frame.VideoMetadata():
+		rec.videoInfo = append([]byte{}, data...)
+		return true
+	}
+	return false
+}
+
+// audioOnly 是否为纯音频流
+func (rec *Recorder) audioOnly() bool {
+	if rec.videoHeader != nil {
+		return false
+	}
+	if rec.metadata != nil {
+		return !rec.metaHasVideo
+	}
+	return rec.audioFrames >= audioOnlyFrames
+}
+
+// handle 写出一个音视频帧，在可以切分的帧处开始或切换文件
+func (rec *Recorder) handle(frame *rtmp.Frame) {
+	if frame.TrackID != 0 {
+		// 多轨道的流只录制轨道0
+		return
+	}
+	if rec.cache(frame) {
+		if rec.file != nil {
+			rec.write(frame)
+		}
+		return
+	}
+
+	var boundary bool
+	switch frame.Type {
+	case rtmp.RTMPTypeVideoData:
+		boundary = frame.KeyFrame()
+	case rtmp.RTMPTypeAudioData:
+		if rec.videoHeader == nil {
+			rec.audioFrames++
+		}
+		boundary = rec.audioOnly()
+	default:
+		return
+	}
+
+	if rec.file == nil {
+		if !boundary {
+			return
+		}
+		rec.open(frame.Timestamp)
+	} else if boundary && rec.full(frame.Timestamp) {
+		rec.close()
+		rec.index++
+		rec.open(frame.Timestamp)
+	}
+	if rec.file != nil {
+		rec.write(frame)
+	}
+}
+
+// full 当前文件到timestamp处是否已达到时长或大小上限
+func (rec *Recorder) full(timestamp uint32) bool {
+	cfg := rec.server.Config
+	duration := time.Duration(rec.file.timestamp(timestamp)) * time.Millisecond
+	if max := cfg.MaxDuration.Duration(); max > 0 && duration >= max {
+		return true
+	}
+	return cfg.MaxSize > 0 && rec.file.size() >= cfg.MaxSize
+}
+
+// open 新建文件并写出序列头，timestamp为文件第一帧的流内时间戳
+func (rec *Recorder) open(timestamp uint32) {
+	cfg := rec.server.Config
+	name := expandFilename(cfg.Filename, rec.App, rec.Stream, time.Now(), rec.index)
+	path := filepath.Join(cfg.Path, filepath.FromSlash(name))
+	if filepath.Ext(path) == "" {
+		path += extensions[cfg.Format]
+	}
+
+	var file mediaFile
+	var err error
+	if cfg.Format == "flv" {
+		file, err = createFLVFile(path, rec.audioHeader != nil || rec.audioOnly(), rec.videoHeader != nil, rec.metadata, timestamp)
+	} else {
+		file, err = createMP4File(path, rec.videoHeader, rec.audioHeader, timestamp, cfg.Format == "fmp4")
+	}
+	if err != nil {
+		log.Println(c.Front("Record %s: %v", c.R, rec.Name, err))
+		return
+	}
+	for _, header := range []struct {
+		tagType uint8
+		data    []byte
+	}{{flv.TagTypeVideo, rec.videoHeader}, {flv.TagTypeVideo, rec.videoInfo}, {flv.TagTypeAudio, rec.audioHeader}} {
+		if header.data == nil {
+			continue
+		}
+		if err := file.writeTag(header.tagType, timestamp, header.data); err != nil {
+			log.Println(c.Front("Record %s: %v", c.R, rec.Name, err))
+			file.close()
+			return
+		}
+	}
+
+	rec.file = file
+	rec.setPath(file.path())
+	log.Println(c.Front("Record %s -> %s", c.G, rec.Name, file.path()))
+}
+
+// write 写出一个标签，出错或序列头变化时关闭文件，在下一个可以切分的帧处重新开始
+func (rec *Recorder) write(frame *rtmp.Frame) {
+	if err := rec.file.writeTag(uint8(frame.Type), frame.Timestamp, frame.Data); err != nil {
+		if err == errConfigChanged {
+			log.Println(c.Front("Record %s: codec config changed, starting a new file", c.Y, rec.Name))
+		} else {
+			log.Println(c.Front("Record %s: %v", c.R, rec.Name, err))
+		}
+		rec.close()
+		rec.index++
+	}
+}
+
+// close 完成当前文件
+func (rec *Recorder) close() {
+	file := rec.file
+	rec.file = nil
+	rec.setPath("")
+	if err := file.close(); err != nil {
+		log.Println(c.Front("Record %s: %v", c.R, rec.Name, err))
+		return
+	}
+	log.Println(c.Front("Record %s saved %s (%v, %d bytes)", c.G, rec.Name, file.path(), file.duration(), file.size()))
+}
+
+// finish 推流结束或停止录制，完成最后的文件
+func (rec *Recorder) finish() {
+	if rec.file != nil {
+		rec.close()
+	}
+	rec.server.finished(rec)
+}
+
+// setPath 记录正在写出的文件路径
+func (rec *Recorder) setPath(path string) {
+	defer rec.mutex.Unlock()
+	rec.mutex.Lock()
+
+	rec.path = path
+}
+
+// Path 正在写出的文件路径，没有正在写出的文件时为空
+func (rec *Recorder) Path() string {
+	defer rec.mutex.Unlock()
+	rec.mutex.Lock()
+
+	return rec.path
+}
@@ -0,0 +1,194 @@
+package relay
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"../config"
+	c "../lib/colorful"
+	"../rtmp"
+	"github.com/pkg/errors"
+)
+
+/*
+
+回源
+
+拉流端请求没有推流端的流时，按应用的源站地址建立出站RTMP连接拉流，连接作为该流的推流端分发给本地所有拉流端
+每条流只有一个回源连接，最后一个拉流端离开后保持一段时间再断开，源站断开时本地流随之结束
+
+*/
+
+// PullCheckInterval 回源期间检查拉流端数量的间隔
+const PullCheckInterval = 500 * time.Millisecond
+
+// PullServer 回源服务
+type PullServer struct {
+	RTMP   *rtmp.Server
+	Config config.Pull
+
+	mutex   sync.Mutex
+	pullers map[string]*Puller // 正在回源的流
+	wg      sync.WaitGroup
+}
+
+// NewPullServer 新建回源服务
+func NewPullServer(server *rtmp.Server) *PullServer {
+	return &PullServer{
+		RTMP:    server,
+		Config:  server.Config.Pull,
+		pullers: make(map[string]*Puller),
+	}
+}
+
+// Play 拉流端加入没有推流端的流时调用，应用配置了源站且流尚未回源时开始回源
+func (server *PullServer) Play(stream *rtmp.Stream) {
+	appName, streamName := splitName(stream.Name)
+	app, ok := server.RTMP.Config.Application(appName)
+	if !ok || !app.PullStream(streamName) {
+		return
+	}
+
+	defer server.mutex.Unlock()
+	server.mutex.Lock()
+
+	if puller, ok := server.pullers[stream.Name]; ok && puller.stream == stream {
+		return
+	}
+	origin := expandURL(app.Pull.URL, appName, streamName)
+	puller := &Puller{
+		Name:   stream.Name,
+		Origin: redactURL(origin),
+		url:    origin,
+		server: server,
+		stream: stream,
+	}
+	server.pullers[stream.Name] = puller
+	server.wg.Add(1)
+
+	log.Println(c.Front("Pull %s <- %s started", c.G, puller.Name, puller.Origin))
+	go puller.run()
+}
+
+// Shutdown 等待所有回源连接断开，服务关闭时回源连接不再等待拉流端离开
+func (server *PullServer) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		server.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return errors.WithStack(ctx.Err())
+	}
+}
+
+// release 流已没有拉流端、服务正在关闭或force时结束回源，返回是否已结束
+// 在锁内检查并移除，保证之后加入的拉流端会重新开始回源
+func (server *PullServer) release(puller *Puller, force bool) bool {
+	defer server.mutex.Unlock()
+	server.mutex.Lock()
+
+	if !force && !server.RTMP.Closing() && puller.stream.CountReceivers() > 0 {
+		return false
+	}
+	if server.pullers[puller.Name] == puller {
+		// 流被移除后重新建立时，同名的回源已被新的回源替换
+		delete(server.pullers, puller.Name)
+	}
+	return true
+}
+
+// Puller 一条流的回源
+type Puller struct {
+	Name   string // 应用/流名称
+	Origin string // 去掉流名称的源站地址
+	url    string
+	server *PullServer
+	stream *rtmp.Stream
+}
+
+// run 回源直到没有拉流端，失败后仍有拉流端等待时重试
+func (puller *Puller) run() {
+	defer puller.server.wg.Done()
+
+	for {
+		err := puller.session()
+		if err == nil {
+			break
+		}
+		log.Println(c.Front("Pull %s <- %s: %v", c.Y, puller.Name, puller.Origin, errors.Cause(err)))
+		if puller.released() || !puller.sleep(puller.server.Config.Retry.Duration()) {
+			break
+		}
+	}
+	log.Println(c.Front("Pull %s <- %s ended", c.G, puller.Name, puller.Origin))
+}
+
+// released 回源连接断开后检查是否结束回源，流已有其他推流端时不再回源
+func (puller *Puller) released() bool {
+	return puller.server.release(puller, puller.stream.HasPublisher())
+}
+
+// sleep 等待重试，期间拉流端全部离开时返回false
+func (puller *Puller) sleep(delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	ticker := time.NewTicker(PullCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-timer.C:
+			return true
+		case <-ticker.C:
+			if puller.released() {
+				return false
+			}
+		}
+	}
+}
+
+// session 一次回源连接，拉流端离开超过保持时间后返回nil
+func (puller *Puller) session() error {
+	cfg := puller.server.Config
+	client, err := dial(puller.server.RTMP, puller.url, cfg.Timeout.Duration(), clientTLS(cfg.Insecure))
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	if err := client.Play(puller.stream); err != nil {
+		return err
+	}
+	log.Println(c.Front("Pull %s <- %s playing", c.G, puller.Name, puller.Origin))
+
+	ticker := time.NewTicker(PullCheckInterval)
+	defer ticker.Stop()
+	var idle time.Time // 最后一个拉流端离开的时间
+	for {
+		select {
+		case <-client.Done():
+			// 源站断开时本地流随之结束
+			if err := client.Err(); err != nil {
+				return err
+			}
+			return errors.New("connection closed")
+		case now := <-ticker.C:
+			closing := puller.server.RTMP.Closing()
+			if !closing && puller.stream.CountReceivers() > 0 {
+				idle = time.Time{}
+				continue
+			}
+			if idle.IsZero() {
+				idle = now
+			}
+			if (closing || now.Sub(idle) >= cfg.Linger.Duration()) && puller.server.release(puller, false) {
+				return nil
+			}
+		}
+	}
+}
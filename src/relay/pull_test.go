@@ -0,0 +1,152 @@
+package relay
+
+import (
+	"context"
+	"crypto/tls"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"../config"
+	"../rtmp"
+	"github.com/pkg/errors"
+)
+
+// newTestPullServer 新建回源服务，出站连接全部失败并计数
+func newTestPullServer(source *config.PullSource) (*PullServer, *int32, func()) {
+	cfg := config.Default()
+	cfg.Pull.Retry = "20ms"
+	cfg.Applications = []config.Application{{Name: "live", Pull: source}}
+	server := rtmp.NewServer(cfg, nil)
+
+	dials := new(int32)
+	original := dial
+	dial = func(server *rtmp.Server, rawURL string, timeout time.Duration, tlsConfig *tls.Config) (*rtmp.Client, error) {
+		atomic.AddInt32(dials, 1)
+		return nil, errors.New("connection refused")
+	}
+	return NewPullServer(&server), dials, func() {
+		dial = original
+	}
+}
+
+// waitFor 等待条件成立，超时返回false
+func waitFor(cond func() bool) bool {
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return true
+}
+
+// shutdown 等待所有回源结束
+func shutdown(server *PullServer) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return server.Shutdown(ctx)
+}
+
+// TestPullPlay 测试只为配置了源站且匹配的流回源，没有拉流端后结束回源
+func TestPullPlay(t *testing.T) {
+	var tests = []struct {
+		source   *config.PullSource // input
+		in       string             // input
+		expected bool               // expected: 是否回源
+	}{
+		{nil, "live/a", false},
+		{&config.PullSource{URL: "rtmp://origin/{app}/{stream}"}, "live/a", true},
+		{&config.PullSource{URL: "rtmp://origin/{app}/{stream}"}, "tv/a", false},
+		{&config.PullSource{URL: "rtmp://origin/{app}/{stream}", Streams: []string{"cam*"}}, "live/cam1", true},
+		{&config.PullSource{URL: "rtmp://origin/{app}/{stream}", Streams: []string{"cam*"}}, "live/other", false},
+	}
+
+	for _, test := range tests {
+		server, dials, restore := newTestPullServer(test.source)
+		stream := server.RTMP.GetStream(test.in)
+		queue := rtmp.NewQueue(1)
+		stream.AddReceiver(queue, 0)
+		server.Play(stream)
+
+		server.mutex.Lock()
+		_, actual := server.pullers[test.in]
+		server.mutex.Unlock()
+		if actual {
+			actual = waitFor(func() bool {
+				return atomic.LoadInt32(dials) > 0
+			})
+		}
+		stream.DelReceiver(queue)
+		err := shutdown(server)
+		if actual != test.expected || err != nil || len(server.pullers) != 0 {
+			t.Errorf("[×] in: %s out: %v %v %d expected: %v\n", test.in, actual, err, len(server.pullers), test.expected)
+		} else {
+			t.Logf("[√] in: %s out: %v expected: %v\n", test.in, actual, test.expected)
+		}
+		restore()
+	}
+}
+
+// TestPullRelease 测试每条流只有一个回源，流被移除后重新建立时替换旧的回源，旧的回源结束时不影响新的回源
+func TestPullRelease(t *testing.T) {
+	server, _, restore := newTestPullServer(&config.PullSource{URL: "rtmp://origin/{app}/{stream}"})
+	defer restore()
+
+	streams := []*rtmp.Stream{server.RTMP.GetStream("live/a"), rtmp.NewStream("live/a")}
+	queues := []*rtmp.Queue{rtmp.NewQueue(1), rtmp.NewQueue(1)}
+	var tests = []struct {
+		in       string // input: play 拉流端加入并开始回源，replay 再次请求回源，leave 拉流端离开
+		index    int    // input: 操作的流
+		expected int    // expected: 正在回源的流对应的序号，-1表示没有回源
+	}{
+		{"play", 0, 0},
+		{"replay", 0, 0},
+		{"play", 1, 1},
+		{"leave", 0, 1},
+		{"leave", 1, -1},
+	}
+
+	for _, test := range tests {
+		stream := streams[test.index]
+		switch test.in {
+		case "play":
+			stream.AddReceiver(queues[test.index], 0)
+			server.Play(stream)
+		case "replay":
+			server.Play(stream)
+		case "leave":
+			stream.DelReceiver(queues[test.index])
+		}
+
+		current := func() int {
+			defer server.mutex.Unlock()
+			server.mutex.Lock()
+
+			puller, ok := server.pullers["live/a"]
+			for i := 0; ok && i < len(streams); i++ {
+				if puller.stream == streams[i] {
+					return i
+				}
+			}
+			return -1
+		}
+		var actual int
+		ok := waitFor(func() bool {
+			actual = current()
+			return actual == test.expected
+		})
+		// 旧的回源结束时不能移除新的回源
+		time.Sleep(50 * time.Millisecond)
+		if actual = current(); !ok || actual != test.expected {
+			t.Errorf("[×] in: %s %d out: %d expected: %d\n", test.in, test.index, actual, test.expected)
+		} else {
+			t.Logf("[√] in: %s %d out: %d expected: %d\n", test.in, test.index, actual, test.expected)
+		}
+	}
+
+	if err := shutdown(server); err != nil {
+		t.Errorf("[×] in: shutdown out: %v expected: nil\n", err)
+	}
+}
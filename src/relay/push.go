@@ -0,0 +1,203 @@
+package relay
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+
+	"../config"
+	c "../lib/colorful"
+	"../rtmp"
+	"github.com/pkg/errors"
+)
+
+/*
+
+转推
+
+推流开始时按应用的转推目标为每个目标建立出站RTMP连接，转发元数据、序列头与音视频帧
+连接断开后按退避时间重连，推流结束时断开
+	GET 前缀/应用/流名称  查询转推状态，使用推流密钥鉴权
+
+*/
+
+// PushServer 转推服务
+type PushServer struct {
+	RTMP   *rtmp.Server
+	Config config.PushOutput
+
+	mutex   sync.Mutex
+	pushers map[string][]*Pusher // 正在转推的流
+	wg      sync.WaitGroup
+}
+
+// NewPushServer 新建转推服务
+func NewPushServer(server *rtmp.Server) *PushServer {
+	return &PushServer{
+		RTMP:    server,
+		Config:  server.Config.Outputs.Push,
+		pushers: make(map[string][]*Pusher),
+	}
+}
+
+// Publish 推流开始时调用，为每个匹配的转推目标开始转推
+func (server *PushServer) Publish(stream *rtmp.Stream) {
+	appName, streamName := splitName(stream.Name)
+	app, ok := server.RTMP.Config.Application(appName)
+	if !ok {
+		return
+	}
+	for _, target := range app.Push {
+		if !matchStreams(target.Streams, streamName) {
+			continue
+		}
+		server.start(stream, expandURL(target.URL, appName, streamName))
+	}
+}
+
+// Shutdown 等待所有转推连接断开，推流端需先断开
+func (server *PushServer) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		server.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return errors.WithStack(ctx.Err())
+	}
+}
+
+// start 开始转推到一个目标
+func (server *PushServer) start(stream *rtmp.Stream, target string) {
+	defer server.mutex.Unlock()
+	server.mutex.Lock()
+
+	pusher := newPusher(server, stream, target)
+	if !stream.AddOutput(pusher.queue) {
+		return
+	}
+	server.pushers[stream.Name] = append(server.pushers[stream.Name], pusher)
+	server.wg.Add(1)
+
+	log.Println(c.Front("Push %s -> %s started", c.G, stream.Name, pusher.Target))
+	go pusher.run()
+}
+
+// finished 转推结束
+func (server *PushServer) finished(pusher *Pusher) {
+	server.mutex.Lock()
+	pushers := server.pushers[pusher.Name]
+	for i, p := range pushers {
+		if p == pusher {
+			pushers = append(pushers[:i], pushers[i+1:]...)
+			break
+		}
+	}
+	if len(pushers) == 0 {
+		delete(server.pushers, pusher.Name)
+	} else {
+		server.pushers[pusher.Name] = pushers
+	}
+	server.mutex.Unlock()
+
+	log.Println(c.Front("Push %s -> %s ended", c.G, pusher.Name, pusher.Target))
+	server.wg.Done()
+}
+
+// status 流的所有转推状态
+func (server *PushServer) status(name string) []PushStatus {
+	defer server.mutex.Unlock()
+	server.mutex.Lock()
+
+	list := make([]PushStatus, 0, len(server.pushers[name]))
+	for _, pusher := range server.pushers[name] {
+		list = append(list, pusher.Status())
+	}
+	return list
+}
+
+// ServeHTTP 查询流的转推状态
+func (server *PushServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, server.Config.Prefix+"/"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		http.NotFound(w, r)
+		return
+	}
+	app, ok := server.RTMP.Config.Application(parts[0])
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if !server.RTMP.Authorize(app, true, r.URL.Query()) {
+		http.Error(w, "push status denied", http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	json.NewEncoder(w).Encode(server.status(parts[0] + "/" + parts[1]))
+}
+
+// splitName 拆分 应用/流名称
+func splitName(name string) (string, string) {
+	idx := strings.IndexByte(name, '/')
+	if idx < 0 {
+		return name, ""
+	}
+	return name[:idx], name[idx+1:]
+}
+
+// matchStreams 流名称是否匹配，patterns为空时匹配所有流
+func matchStreams(patterns []string, streamName string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, streamName); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// expandURL 展开目标地址模板
+func expandURL(template string, app string, stream string) string {
+	return strings.NewReplacer(
+		"{app}", url.PathEscape(app),
+		"{stream}", url.PathEscape(stream),
+	).Replace(template)
+}
+
+// redactURL 去掉目标地址中的流名称与参数，流名称通常是平台的推流密钥
+func redactURL(rawURL string) string {
+	endpoint, err := rtmp.ParseURL(rawURL)
+	if err != nil {
+		return "invalid"
+	}
+	return endpoint.Scheme + "://" + endpoint.Address + "/" + endpoint.App + "/***"
+}
+
+// dial 建立出站RTMP连接，测试时可替换
+var dial = rtmp.Dial
+
+// clientTLS 出站rtmps连接的TLS配置，insecure时不校验对端证书
+func clientTLS(insecure bool) *tls.Config {
+	if !insecure {
+		return nil
+	}
+	return &tls.Config{InsecureSkipVerify: true}
+}
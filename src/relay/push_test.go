@@ -0,0 +1,153 @@
+package relay
+
+import (
+	"crypto/tls"
+	"testing"
+	"time"
+
+	"../config"
+	"../rtmp"
+	"github.com/pkg/errors"
+)
+
+// fakeDial 替换出站连接，每次连接都失败并记录连接时间，连接count次后调用onLimit
+func fakeDial(count int, onLimit func()) (chan time.Time, func()) {
+	calls := make(chan time.Time, count+1)
+	original := dial
+	dial = func(server *rtmp.Server, rawURL string, timeout time.Duration, tlsConfig *tls.Config) (*rtmp.Client, error) {
+		calls <- time.Now()
+		if len(calls) == count {
+			onLimit()
+		}
+		return nil, errors.New("connection refused")
+	}
+	return calls, func() {
+		dial = original
+	}
+}
+
+// TestPusherBackoff 测试转推连接失败后的重连等待时间加倍，且不超过上限
+func TestPusherBackoff(t *testing.T) {
+	var tests = []struct {
+		min      config.Duration // input
+		max      config.Duration // input
+		expected []time.Duration // expected: 每次重连前的等待时间
+	}{
+		{"20ms", "80ms", []time.Duration{20 * time.Millisecond, 40 * time.Millisecond, 80 * time.Millisecond, 80 * time.Millisecond}},
+		{"30ms", "30ms", []time.Duration{30 * time.Millisecond, 30 * time.Millisecond}},
+	}
+
+	for _, test := range tests {
+		cfg := config.Default()
+		cfg.Outputs.Push.RetryMin = test.min
+		cfg.Outputs.Push.RetryMax = test.max
+		server := rtmp.NewServer(cfg, nil)
+		push := NewPushServer(&server)
+		pusher := newPusher(push, server.GetStream("live/a"), "rtmp://127.0.0.1/live/key")
+
+		calls, restore := fakeDial(len(test.expected)+1, pusher.queue.NotifyUnpublish)
+		push.wg.Add(1)
+		done := make(chan struct{})
+		go func() {
+			pusher.run()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("[×] in: %s %s out: still running expected: stopped\n", test.min, test.max)
+		}
+		restore()
+		close(calls)
+
+		actual := make([]time.Duration, 0, len(test.expected))
+		last := <-calls
+		for call := range calls {
+			actual = append(actual, call.Sub(last))
+			last = call
+		}
+		ok := len(actual) == len(test.expected)
+		for i := 0; ok && i < len(actual); i++ {
+			// 定时器只保证不早于设定的时间
+			ok = actual[i] >= test.expected[i] && actual[i] < test.expected[i]+100*time.Millisecond
+		}
+		status := pusher.Status()
+		if !ok || status.Reconnects != len(test.expected)+1 || status.Error != "connection refused" {
+			t.Errorf("[×] in: %s %s out: %v %+v expected: %v\n", test.min, test.max, actual, status, test.expected)
+		} else {
+			t.Logf("[√] in: %s %s out: %v expected: %v\n", test.min, test.max, actual, test.expected)
+		}
+	}
+}
+
+// TestMatchStreams 测试转推目标的流名称匹配
+func TestMatchStreams(t *testing.T) {
+	var tests = []struct {
+		patterns []string // input
+		in       string   // input
+		expected bool     // expected result
+	}{
+		{nil, "test", true},
+		{[]string{"test"}, "test", true},
+		{[]string{"test"}, "test2", false},
+		{[]string{"cam*"}, "cam01", true},
+		{[]string{"cam?"}, "cam01", false},
+		{[]string{"a", "b[0-9]"}, "b7", true},
+		{[]string{"[invalid"}, "test", false},
+	}
+
+	for _, test := range tests {
+		actual := matchStreams(test.patterns, test.in)
+		if actual != test.expected {
+			t.Errorf("[×] in: %v %s out: %v expected: %v\n", test.patterns, test.in, actual, test.expected)
+		} else {
+			t.Logf("[√] in: %v %s out: %v expected: %v\n", test.patterns, test.in, actual, test.expected)
+		}
+	}
+}
+
+// TestExpandURL 测试目标地址模板的展开
+func TestExpandURL(t *testing.T) {
+	var tests = []struct {
+		in       string // input
+		app      string // input
+		stream   string // input
+		expected string // expected result
+	}{
+		{"rtmp://cdn/{app}/{stream}", "live", "test", "rtmp://cdn/live/test"},
+		{"rtmp://cdn/push/key", "live", "test", "rtmp://cdn/push/key"},
+		{"rtmp://cdn/{app}/{stream}_{stream}", "live", "a", "rtmp://cdn/live/a_a"},
+		{"rtmp://cdn/{app}/{stream}", "live", "a b?c", "rtmp://cdn/live/a%20b%3Fc"},
+	}
+
+	for _, test := range tests {
+		actual := expandURL(test.in, test.app, test.stream)
+		if actual != test.expected {
+			t.Errorf("[×] in: %s %s %s out: %s expected: %s\n", test.in, test.app, test.stream, actual, test.expected)
+		} else {
+			t.Logf("[√] in: %s %s %s out: %s expected: %s\n", test.in, test.app, test.stream, actual, test.expected)
+		}
+	}
+}
+
+// TestRedactURL 测试去掉目标地址中的推流密钥
+func TestRedactURL(t *testing.T) {
+	var tests = []struct {
+		in       string // input
+		expected string // expected result
+	}{
+		{"rtmp://cdn.example.com/live/secret", "rtmp://cdn.example.com:1935/live/***"},
+		{"rtmp://cdn.example.com:1936/live/secret?token=x", "rtmp://cdn.example.com:1936/live/***"},
+		{"rtmps://cdn.example.com/app/secret", "rtmps://cdn.example.com:443/app/***"},
+		{"http://cdn.example.com/live/secret", "invalid"},
+	}
+
+	for _, test := range tests {
+		actual := redactURL(test.in)
+		if actual != test.expected {
+			t.Errorf("[×] in: %s out: %s expected: %s\n", test.in, actual, test.expected)
+		} else {
+			t.Logf("[√] in: %s out: %s expected: %s\n", test.in, actual, test.expected)
+		}
+	}
+}
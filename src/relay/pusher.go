@@ -0,0 +1,207 @@
+package relay
+
+import (
+	"bytes"
+	"log"
+	"sync"
+	"time"
+
+	c "../lib/colorful"
+	"../rtmp"
+	"../rtmp/amf"
+	"github.com/pkg/errors"
+)
+
+// 转推连接的状态
+const (
+	StateConnecting = "connecting" // 正在建立连接
+	StatePublishing = "publishing" // 正在转推
+	StateRetrying   = "retrying"   // 连接失败或断开，等待重连
+)
+
+// PushStatus 转推状态
+type PushStatus struct {
+	Stream     string    `json:"stream"`
+	Target     string    `json:"target"` // 去掉推流密钥的目标地址
+	State      string    `json:"state"`
+	Since      time.Time `json:"since"`           // 进入当前状态的时间
+	Reconnects int       `json:"reconnects"`      // 重连次数
+	Bytes      int64     `json:"bytes"`           // 已转发的音视频数据字节数
+	Error      string    `json:"error,omitempty"` // 最近一次失败的原因
+}
+
+// Pusher 一条流到一个目标的转推
+type Pusher struct {
+	Name   string // 应用/流名称
+	Target string // 去掉推流密钥的目标地址
+	url    string
+	server *PushServer
+	stream *rtmp.Stream
+	queue  *rtmp.Queue
+
+	mutex  sync.Mutex
+	status PushStatus
+}
+
+// newPusher 新建转推
+func newPusher(server *PushServer, stream *rtmp.Stream, target string) *Pusher {
+	pusher := &Pusher{
+		Name:   stream.Name,
+		Target: redactURL(target),
+		url:    target,
+		server: server,
+		stream: stream,
+		queue:  rtmp.NewQueue(server.Config.Queue),
+	}
+	pusher.status = PushStatus{Stream: pusher.Name, Target: pusher.Target, State: StateConnecting, Since: time.Now()}
+	return pusher
+}
+
+// Status 转推状态
+func (pusher *Pusher) Status() PushStatus {
+	defer pusher.mutex.Unlock()
+	pusher.mutex.Lock()
+
+	return pusher.status
+}
+
+// setState 更新转推状态，err不为空时记录失败原因
+func (pusher *Pusher) setState(state string, err error) {
+	defer pusher.mutex.Unlock()
+	pusher.mutex.Lock()
+
+	pusher.status.State = state
+	pusher.status.Since = time.Now()
+	if state == StateRetrying {
+		pusher.status.Reconnects++
+	}
+	if err != nil {
+		pusher.status.Error = errors.Cause(err).Error()
+	}
+}
+
+// addBytes 统计已转发的字节数
+func (pusher *Pusher) addBytes(n int) {
+	defer pusher.mutex.Unlock()
+	pusher.mutex.Lock()
+
+	pusher.status.Bytes += int64(n)
+}
+
+// run 建立连接并转发，断开后按退避时间重连，直到推流结束
+func (pusher *Pusher) run() {
+	defer pusher.server.finished(pusher)
+
+	cfg := pusher.server.Config
+	retry := cfg.RetryMin.Duration()
+	for {
+		pusher.setState(StateConnecting, nil)
+		start := time.Now()
+		err := pusher.session()
+		if err == nil {
+			return
+		}
+		if time.Since(start) >= cfg.RetryMax.Duration() {
+			// 连接保持了足够长的时间，重新从最短的等待时间开始
+			retry = cfg.RetryMin.Duration()
+		}
+
+		log.Println(c.Front("Push %s -> %s: %v, retry in %v", c.Y, pusher.Name, pusher.Target, errors.Cause(err), retry))
+		pusher.setState(StateRetrying, err)
+		if !pusher.sleep(retry) {
+			return
+		}
+		retry *= 2
+		if max := cfg.RetryMax.Duration(); retry > max {
+			retry = max
+		}
+	}
+}
+
+// sleep 等待重连，期间丢弃音视频帧，推流结束时返回false
+func (pusher *Pusher) sleep(delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	for {
+		select {
+		case <-pusher.queue.Frames:
+		case <-pusher.queue.Done():
+			return false
+		case <-timer.C:
+			return true
+		}
+	}
+}
+
+// session 一次连接的转推过程，推流结束时返回nil
+func (pusher *Pusher) session() error {
+	cfg := pusher.server.Config
+	client, err := dial(pusher.server.RTMP, pusher.url, cfg.Timeout.Duration(), clientTLS(cfg.Insecure))
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	if err := client.Publish(); err != nil {
+		return err
+	}
+	pusher.setState(StatePublishing, nil)
+	log.Println(c.Front("Push %s -> %s publishing", c.G, pusher.Name, pusher.Target))
+
+	var gate rtmp.Gate
+	for {
+		select {
+		case frame := <-pusher.queue.Frames:
+			if err := pusher.forward(client, &gate, frame); err != nil {
+				return err
+			}
+		case <-pusher.queue.Done():
+			// 转发推流结束前已加入队列的音视频帧
+			for {
+				select {
+				case frame := <-pusher.queue.Frames:
+					if err := pusher.forward(client, &gate, frame); err != nil {
+						return err
+					}
+				default:
+					return nil
+				}
+			}
+		case <-client.Done():
+			if err := client.Err(); err != nil {
+				return err
+			}
+			return errors.New("connection closed")
+		}
+	}
+}
+
+// forward 转发一个音视频帧，从关键帧开始，起播前先发送元数据与序列头，时间戳从起播的关键帧开始计算
+func (pusher *Pusher) forward(client *rtmp.Client, gate *rtmp.Gate, frame *rtmp.Frame) error {
+	headers, ok := gate.Pass(pusher.stream, &frame.Message, false)
+	if !ok {
+		return nil
+	}
+	for _, header := range headers {
+		header.Timestamp = 0
+		if err := pusher.write(client, header); err != nil {
+			return err
+		}
+	}
+	msg := frame.Message
+	msg.Timestamp = gate.Timestamp(&frame.Message)
+	return pusher.write(client, msg)
+}
+
+// onMetaData 元数据消息的开头
+var onMetaData = amf.NewString("onMetaData").Bytes()
+
+// write 发送一条消息，元数据需要通过 @setDataFrame 设置
+func (pusher *Pusher) write(client *rtmp.Client, msg rtmp.Message) error {
+	if msg.Type == rtmp.RTMPTypeAMFData && bytes.HasPrefix(msg.Data, onMetaData) {
+		msg.Data = append(amf.NewString("@setDataFrame").Bytes(), msg.Data...)
+		msg.Length = uint32(len(msg.Data))
+	} else if msg.Type != rtmp.RTMPTypeAMFData {
+		pusher.addBytes(len(msg.Data))
+	}
+	return errors.WithStack(client.WriteMessage(msg))
+}
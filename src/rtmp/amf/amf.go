@@ -6,13 +6,14 @@ import (
 
 // AMF 类型常量
 const (
-	AMFTypeNumber    = uint32(0x00)
-	AMFTypeBoolean   = uint32(0x01)
-	AMFTypeString    = uint32(0x02)
-	AMFTypeObject    = uint32(0x03)
-	AMFTypeNone      = uint32(0x05)
-	AMFTypeECMAArray = uint32(0x08)
-	AMFTypeObjectEnd = uint32(0x09)
+	AMFTypeNumber      = uint32(0x00)
+	AMFTypeBoolean     = uint32(0x01)
+	AMFTypeString      = uint32(0x02)
+	AMFTypeObject      = uint32(0x03)
+	AMFTypeNone        = uint32(0x05)
+	AMFTypeECMAArray   = uint32(0x08)
+	AMFTypeObjectEnd   = uint32(0x09)
+	AMFTypeStrictArray = uint32(0x0a)
 )
 
 // Base 基类
@@ -52,6 +53,8 @@ func NewAMF(data []byte) (AMF, error) {
 		value = NewECMAArrayDefault()
 	case AMFTypeObjectEnd:
 		value = NewObjectEndDefault()
+	case AMFTypeStrictArray:
+		value = NewStrictArrayDefault()
 	case AMFTypeNone:
 		value = NewNone()
 	default:
@@ -102,6 +105,14 @@ func MakeAMF(data interface{}) (AMF, error) {
 		amf, err = NewObject(data.(map[string]interface{}))
 	case string:
 		amf = NewString(data.(string))
+	case []interface{}:
+		amf, err = NewStrictArray(data.([]interface{}))
+	case []string:
+		values := make([]interface{}, len(data.([]string)))
+		for i, value := range data.([]string) {
+			values[i] = value
+		}
+		amf, err = NewStrictArray(values)
 	default:
 		amf = NewNone()
 	}
@@ -37,6 +37,8 @@ func NewECMAArray(m map[string]interface{}) (AMF, error) {
 		case map[string]interface{}:
 			valueECMAArray := value.(map[string]interface{})
 			amfValue, err = NewECMAArray(valueECMAArray)
+		default:
+			amfValue, err = MakeAMF(value)
 		}
 		if err != nil {
 			return amf, errors.WithStack(err)
@@ -78,6 +80,9 @@ func (arr *ECMAArray) Read(data []byte) error {
 func (arr *ECMAArray) Value() interface{} {
 	m := make(map[string]interface{})
 	for _, item := range arr.value {
+		if item.value.Type() == AMFTypeObjectEnd {
+			continue
+		}
 		m[item.key] = item.value.Value()
 	}
 	return m
@@ -61,6 +61,9 @@ func (obj *Object) Read(data []byte) error {
 func (obj *Object) Value() interface{} {
 	m := make(map[string]interface{})
 	for _, item := range obj.value {
+		if item.value.Type() == AMFTypeObjectEnd {
+			continue
+		}
 		m[item.key] = item.value.Value()
 	}
 	return m
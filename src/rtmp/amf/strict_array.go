@@ -0,0 +1,89 @@
+package amf
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"../../lib"
+	"github.com/pkg/errors"
+)
+
+// StrictArray StrictArray类型，如connect命令中的fourCcList
+type StrictArray struct {
+	Base
+	value  []AMF
+	length uint32
+}
+
+// NewStrictArrayDefault 实例化一个StrictArray类型
+func NewStrictArrayDefault() AMF {
+	return &StrictArray{Base{AMFTypeStrictArray}, []AMF{}, 0}
+}
+
+// NewStrictArray 实例化一个StrictArray类型
+func NewStrictArray(values []interface{}) (AMF, error) {
+	arr := &StrictArray{Base{AMFTypeStrictArray}, []AMF{}, 4}
+	for _, value := range values {
+		amfValue, err := MakeAMF(value)
+		if err != nil {
+			return arr, errors.WithStack(err)
+		}
+		arr.length += 1 + amfValue.Length()
+		arr.value = append(arr.value, amfValue)
+	}
+	return arr, nil
+}
+
+// Read 从字节流读入AMF StrictArray
+func (arr *StrictArray) Read(data []byte) error {
+	if len(data) < 4 {
+		return errors.New("AMF strict array too short")
+	}
+	count := lib.ToUint32(data[0:4])
+	arr.length = 4
+	for i := uint32(0); i < count; i++ {
+		if arr.length >= uint32(len(data)) {
+			return errors.New("AMF strict array too short")
+		}
+		value, err := NewAMF(data[arr.length:])
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		arr.length += 1 + value.Length()
+		arr.value = append(arr.value, value)
+	}
+	return nil
+}
+
+// Value 返回对应的StrictArray数据
+func (arr *StrictArray) Value() interface{} {
+	values := make([]interface{}, len(arr.value))
+	for i, item := range arr.value {
+		values[i] = item.Value()
+	}
+	return values
+}
+
+// Length 返回该数据相对于字节流的长度
+func (arr *StrictArray) Length() uint32 {
+	return arr.length
+}
+
+// Type 返回该数据的Type
+func (arr *StrictArray) Type() uint32 {
+	return arr.Base.DataType
+}
+
+// Bytes 输出该数据的字节流
+func (arr *StrictArray) Bytes() []byte {
+	buf := new(bytes.Buffer)
+	bytes := make([]byte, 4)
+
+	buf.WriteByte(byte(AMFTypeStrictArray)) // 类型
+	binary.BigEndian.PutUint32(bytes, uint32(len(arr.value)))
+	buf.Write(bytes) // 个数
+	for _, value := range arr.value {
+		buf.Write(value.Bytes())
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,96 @@
+package rtmp
+
+import (
+	"log"
+	"sync"
+
+	"../codec"
+	"../flv"
+	c "../lib/colorful"
+)
+
+/*
+
+音频码流统计
+
+AAC 与 Opus 从序列头中解析实际的采样率与声道，MP3 与其他传统编码从每个音频帧中解析
+AAC 标签头中的采样率与声道是固定值，编码器配置错误时只能从 AudioSpecificConfig 中发现，只统计轨道0
+
+*/
+
+// lowSampleRate 低于该采样率时告警，通常是编码器配置错误
+const lowSampleRate = 16000
+
+// AudioStats 流的音频统计
+type AudioStats struct {
+	codec.AudioInfo
+	Frames        uint64 // 音频帧数
+	FormatChanges uint64 // 推流期间采样率、声道等变化的次数
+}
+
+// audioAnalyzer 统计一条流轨道0的音频码流
+type audioAnalyzer struct {
+	mutex  *sync.Mutex
+	stats  AudioStats
+	parsed bool // 是否已取得音频信息
+}
+
+// newAudioAnalyzer 新建音频码流统计
+func newAudioAnalyzer() *audioAnalyzer {
+	return &audioAnalyzer{mutex: &sync.Mutex{}}
+}
+
+// Stats 返回音频统计，还没有取得音频信息时ok为false
+func (analyzer *audioAnalyzer) Stats() (stats AudioStats, ok bool) {
+	defer analyzer.mutex.Unlock()
+	analyzer.mutex.Lock()
+
+	return analyzer.stats, analyzer.parsed
+}
+
+// reset 推流结束后清除统计
+func (analyzer *audioAnalyzer) reset() {
+	defer analyzer.mutex.Unlock()
+	analyzer.mutex.Lock()
+
+	analyzer.stats = AudioStats{}
+	analyzer.parsed = false
+}
+
+// analyze 统计一条音频消息，name为流名称，用于日志
+func (analyzer *audioAnalyzer) analyze(name string, msg *Message) {
+	if msg.Type != RTMPTypeAudioData || msg.TrackID != 0 {
+		return
+	}
+	tag, err := flv.ParseAudioTag(msg.Data)
+	if err != nil {
+		return
+	}
+	info, err := codec.ParseAudioInfo(&tag)
+
+	defer analyzer.mutex.Unlock()
+	analyzer.mutex.Lock()
+
+	if tag.Frame() {
+		analyzer.stats.Frames++
+	}
+	if err != nil {
+		if tag.SequenceHeader() {
+			log.Println(c.Front("Stream %s audio config: %v", c.Y, name, err))
+		}
+		return
+	}
+	if info == nil || analyzer.parsed && *info == analyzer.stats.AudioInfo {
+		return
+	}
+
+	if analyzer.parsed {
+		analyzer.stats.FormatChanges++
+	}
+	analyzer.stats.AudioInfo = *info
+	analyzer.parsed = true
+	log.Println(c.Front("Stream %s audio %s", c.G, name, info))
+	if info.SampleRate > 0 && info.SampleRate < lowSampleRate {
+		log.Println(c.Front("Stream %s audio sample rate is only %dHz, check the encoder settings", c.Y, name, info.SampleRate))
+	}
+}
@@ -0,0 +1,73 @@
+package rtmp
+
+import (
+	"crypto/subtle"
+	"net/url"
+	"strings"
+
+	"../config"
+)
+
+/*
+
+推流、拉流鉴权
+
+*/
+
+// parseStreamName 拆分流名称与参数，如 stream?key=xxx
+func parseStreamName(name string) (string, url.Values) {
+	idx := strings.IndexByte(name, '?')
+	if idx < 0 {
+		return name, url.Values{}
+	}
+	query, err := url.ParseQuery(name[idx+1:])
+	if err != nil {
+		return name[:idx], url.Values{}
+	}
+	return name[:idx], query
+}
+
+// ValidStreamName 应用/流名称是否可以用作输出文件的路径，拒绝空名称、绝对路径、反斜杠与 . .. 路径段
+func ValidStreamName(name string) bool {
+	if name == "" || strings.ContainsAny(name, "\\\x00") {
+		return false
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == "" || part == "." || part == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// authorize 校验连接推流或拉流的密钥
+func (conn *Connect) authorize(publish bool, query url.Values) bool {
+	return conn.WithinServer.Authorize(conn.App, publish, query)
+}
+
+// Authorize 校验推流或拉流的密钥，应用鉴权优先于全局鉴权，未开启鉴权时直接通过
+func (server *Server) Authorize(app *config.Application, publish bool, query url.Values) bool {
+	auth := server.Config.Auth
+	if app != nil && app.Auth != nil {
+		auth = *app.Auth
+	}
+	if !auth.Enable {
+		return true
+	}
+
+	keys := auth.PlayKeys
+	if publish {
+		keys = auth.PublishKeys
+	}
+	if len(keys) == 0 {
+		return true
+	}
+
+	key := []byte(query.Get("key"))
+	for _, k := range keys {
+		if subtle.ConstantTimeCompare([]byte(k), key) == 1 {
+			return true
+		}
+	}
+	return false
+}
@@ -1,7 +1,6 @@
 package rtmp
 
 import (
-	"bytes"
 	"encoding/binary"
 
 	"../lib"
@@ -15,7 +14,13 @@ type Chunk struct {
 	Data    []byte
 }
 
-// NewChunk 读入一个新的chunk
+// 头部中的消息长度未经验证，缓冲区按实际到达的数据逐步增长，并限制同时接收中的Message数量
+const (
+	maxPartialMessages   = 64
+	initialMessageBuffer = 64 * 1024
+)
+
+// NewChunk 读入一个新的chunk，数据直接读入所属Message的缓冲区
 func NewChunk(conn *Connect) (Chunk, error) {
 	chk := Chunk{}
 
@@ -24,85 +29,129 @@ func NewChunk(conn *Connect) (Chunk, error) {
 	if err != nil {
 		return chk, errors.WithStack(err)
 	}
-	err = chk.Message.Read(chk.Basic.Format, chk.Basic.ChunkStreamID, conn)
+	csid := chk.Basic.ChunkStreamID
+	msg, ok := conn.RecvMessageMap[csid]
+	err = chk.Message.Read(chk.Basic.Format, csid, !ok, conn)
 	if err != nil {
 		return chk, errors.WithStack(err)
 	}
 
-	// 判断要读入的长度
-	msid := chk.Message.MessageStreamID
-	mslen := chk.Message.MessageLength
-	msg, ok := conn.RecvMessageMap[msid]
-	var readLength uint32
-	if ok {
-		readLength = lib.Min(msg.Length-msg.ReadLength, conn.RecvChunkSize)
-	} else {
-		readLength = lib.Min(mslen, conn.RecvChunkSize)
+	if !ok {
+		if len(conn.RecvMessageMap) >= maxPartialMessages {
+			return chk, errors.New("RTMP too many partial messages")
+		}
+		// 新的Message，按头部中的长度分配初始缓冲区
+		msg = &Message{
+			Length:        chk.Message.MessageLength,
+			Type:          chk.Message.MessageType,
+			ChunkStreamID: csid,
+			StreamID:      chk.Message.MessageStreamID,
+			Timestamp:     chk.Message.Timestamp,
+			Data:          newMessageBuffer(chk.Message.MessageType, chk.Message.MessageLength),
+		}
+		conn.RecvMessageMap[csid] = msg
 	}
 
 	// 读入数据
-	chk.Data, err = conn.Read(readLength)
+	readLength := lib.Min(msg.Length-msg.ReadLength, conn.RecvChunkSize)
+	end := msg.ReadLength + readLength
+	msg.grow(end)
+	chk.Data = msg.Data[msg.ReadLength:end]
+	err = conn.ReadFull(chk.Data)
 	if err != nil {
 		return chk, errors.WithStack(err)
 	}
+	msg.ReadLength += readLength
+
+	// 保存头部用于后续chunk的头部压缩
+	conn.LastRecvChunk[csid] = Chunk{Basic: chk.Basic, Message: chk.Message}
 
 	// log.Printf(c.Front("Chunk Received %+v", c.S, chk))
 	return chk, nil
 }
 
+// newMessageBuffer 分配Message的初始缓冲区，音视频数据会被拉流端引用，不使用字节池
+func newMessageBuffer(msgType uint32, length uint32) []byte {
+	size := lib.Min(length, initialMessageBuffer)
+	if msgType == RTMPTypeAudioData || msgType == RTMPTypeVideoData || msgType == RTMPTypeAMFData {
+		return make([]byte, 0, size)
+	}
+	return lib.GetBuffer(int(size))[:0]
+}
+
+// grow 将Message缓冲区扩展到end字节，容量不足时按倍数增长且不超过消息长度
+func (msg *Message) grow(end uint32) {
+	if uint32(cap(msg.Data)) < end {
+		data := make([]byte, end, lib.Min(msg.Length, end*2))
+		copy(data, msg.Data)
+		lib.PutBuffer(msg.Data)
+		msg.Data = data
+	}
+	msg.Data = msg.Data[:end]
+}
+
 // Bytes 输出chunk的数据
 func (chk *Chunk) Bytes() ([]byte, error) {
-	buf := new(bytes.Buffer)
-	numByte := make([]byte, 8)
+	if chk.Basic.Format > 3 {
+		return make([]byte, 0), errors.WithStack(errors.New("RTMP chunk basic header format type error"))
+	}
+	buf := make([]byte, 0, 18+len(chk.Data))
+	buf = appendBasicHeader(buf, chk.Basic.Format, chk.Basic.ChunkStreamID)
+	buf = appendMessageHeader(buf, chk.Basic.Format, &chk.Message)
+	return append(buf, chk.Data...), nil
+}
 
-	switch chk.Basic.Format {
-	case 0:
-		// 写出BasicHeader
-		binary.BigEndian.PutUint16(numByte, uint16(0x3f&chk.Basic.ChunkStreamID))
-		buf.Write(numByte[1:2])
-		// 写出时间戳
-		binary.BigEndian.PutUint32(numByte, uint32(chk.Message.Timestamp))
-		buf.Write(numByte[1:4])
+// appendBasicHeader 写出Basic Header
+func appendBasicHeader(buf []byte, format uint32, csid uint32) []byte {
+	switch {
+	case csid >= 64+256:
+		id := csid - 64
+		return append(buf, byte(format<<6|1), byte(id), byte(id>>8))
+	case csid >= 64:
+		return append(buf, byte(format<<6), byte(csid-64))
+	default:
+		return append(buf, byte(format<<6|csid))
+	}
+}
+
+// appendMessageHeader 按格式写出Message Header，格式0使用绝对时间戳，格式1、2使用时间戳增量，
+// 格式3仅在使用扩展时间戳时重复写出扩展时间戳
+func appendMessageHeader(buf []byte, format uint32, header *MessageHeader) []byte {
+	if format == 3 {
+		if timestamp := header.Timestamp; timestamp >= 0xffffff {
+			buf = append(buf, byte(timestamp>>24), byte(timestamp>>16), byte(timestamp>>8), byte(timestamp))
+		}
+		return buf
+	}
+
+	timestamp := header.Timestamp
+	if format != 0 {
+		timestamp = header.TimestampDelta
+	}
+	extended := timestamp >= 0xffffff
+	field := timestamp
+	if extended {
+		field = 0xffffff
+	}
+
+	// 写出时间戳
+	buf = append(buf, byte(field>>16), byte(field>>8), byte(field))
+	if format <= 1 {
 		// 写出长度
-		binary.BigEndian.PutUint32(numByte, uint32(chk.Message.MessageLength))
-		buf.Write(numByte[1:4])
+		buf = append(buf, byte(header.MessageLength>>16), byte(header.MessageLength>>8), byte(header.MessageLength))
 		// 写出类型
-		binary.BigEndian.PutUint16(numByte, uint16(chk.Message.MessageType))
-		buf.Write(numByte[1:2])
+		buf = append(buf, byte(header.MessageType))
+	}
+	if format == 0 {
 		// 写出流id
-		binary.LittleEndian.PutUint32(numByte, uint32(chk.Message.MessageStreamID))
-		buf.Write(numByte[0:4])
-	case 1:
-		// 写出BasicHeader
-		binary.BigEndian.PutUint16(numByte, uint16(0x40|(0x3f&chk.Basic.ChunkStreamID)))
-		buf.Write(numByte[1:2])
-		// 写出时间戳
-		binary.BigEndian.PutUint32(numByte, uint32(chk.Message.Timestamp))
-		buf.Write(numByte[1:4])
-		// 写出长度
-		binary.BigEndian.PutUint32(numByte, uint32(chk.Message.MessageLength))
-		buf.Write(numByte[1:4])
-		// 写出类型
-		binary.BigEndian.PutUint16(numByte, uint16(chk.Message.MessageType))
-		buf.Write(numByte[1:2])
-	case 2:
-		// 写出BasicHeader
-		binary.BigEndian.PutUint16(numByte, uint16(0x80|(0x3f&chk.Basic.ChunkStreamID)))
-		buf.Write(numByte[1:2])
-		// 写出时间戳
-		binary.BigEndian.PutUint32(numByte, uint32(chk.Message.Timestamp))
-		buf.Write(numByte[1:4])
-		// 写出长度
-		binary.BigEndian.PutUint32(numByte, uint32(chk.Message.MessageLength))
-		buf.Write(numByte[1:4])
-	case 3:
-		// 写出BasicHeader
-		binary.BigEndian.PutUint16(numByte, uint16(0xC0|(0x3f&chk.Basic.ChunkStreamID)))
-		buf.Write(numByte[1:2])
-	default:
-		return make([]byte, 0), errors.WithStack(errors.New("RTMP chunk basic header format type error"))
+		var msid [4]byte
+		binary.LittleEndian.PutUint32(msid[:], header.MessageStreamID)
+		buf = append(buf, msid[:]...)
 	}
-	return lib.ByteArrayConcat(buf.Bytes(), chk.Data), nil
+	if extended {
+		buf = append(buf, byte(timestamp>>24), byte(timestamp>>16), byte(timestamp>>8), byte(timestamp))
+	}
+	return buf
 }
 
 /*
@@ -119,7 +168,8 @@ type BasicHeader struct {
 
 // Read 读取Basic Header
 func (basic *BasicHeader) Read(conn *Connect) error {
-	data, err := conn.Read(1)
+	data := conn.header[:1]
+	err := conn.ReadFull(data)
 	if err != nil {
 		return errors.WithStack(err)
 	}
@@ -128,14 +178,16 @@ func (basic *BasicHeader) Read(conn *Connect) error {
 	basic.Format = uint32((int(data[0]) & 0xc0) >> 6)
 	if csid == 0 {
 		// 格式1
-		data, err = conn.Read(1)
+		data = conn.header[:1]
+		err = conn.ReadFull(data)
 		if err != nil {
 			return errors.WithStack(err)
 		}
 		basic.ChunkStreamID = uint32(int(data[0]) + 64)
 	} else if csid == 1 {
 		// 格式2
-		data, err = conn.Read(2)
+		data = conn.header[:2]
+		err = conn.ReadFull(data)
 		if err != nil {
 			return errors.WithStack(err)
 		}
@@ -155,68 +207,77 @@ MessageHeader
 
 // MessageHeader ...
 type MessageHeader struct {
-	Timestamp       uint32
+	Timestamp       uint32 // 绝对时间戳
+	TimestampDelta  uint32 // 时间戳增量，格式3开始新消息时使用
 	MessageLength   uint32
 	MessageType     uint32
 	MessageStreamID uint32
+	Extended        bool // 是否使用扩展时间戳
 }
 
-// Read 读取Message Header
-func (basic *MessageHeader) Read(format uint32, csid uint32, conn *Connect) error {
-	data := make([]byte, 16)
-	var err error
-
-	lastReceChunk, ok := conn.LastRecvChunk[csid]
-	if !ok {
-		lastReceChunk = Chunk{}
-	}
+// Read 读取Message Header，newMessage表示该chunk是否为一条新消息的开始
+func (basic *MessageHeader) Read(format uint32, csid uint32, newMessage bool, conn *Connect) error {
+	last := conn.LastRecvChunk[csid].Message
 
+	var timestamp uint32
 	switch format {
 	case 0:
-		data, err = conn.Read(11)
-		if err != nil {
+		data := conn.header[:11]
+		if err := conn.ReadFull(data); err != nil {
 			return errors.WithStack(err)
 		}
-		basic.Timestamp = lib.ToUint32(data[0:3])
+		timestamp = lib.ToUint32(data[0:3])
 		basic.MessageLength = lib.ToUint32(data[3:6])
 		basic.MessageType = lib.ToUint32(data[6:7])
-		basic.MessageStreamID = lib.ToUint32(data[7:])
-		if basic.Timestamp == 0xffffff {
-			data, err = conn.Read(4)
-			if err != nil {
-				return errors.WithStack(err)
-			}
-			basic.Timestamp = lib.ToUint32(data[0:4])
-		}
+		basic.MessageStreamID = binary.LittleEndian.Uint32(data[7:11])
 	case 1:
-		data, err = conn.Read(7)
-		if err != nil {
+		data := conn.header[:7]
+		if err := conn.ReadFull(data); err != nil {
 			return errors.WithStack(err)
 		}
-		basic.Timestamp = lib.ToUint32(data[0:3]) + lastReceChunk.Message.Timestamp
+		timestamp = lib.ToUint32(data[0:3])
 		basic.MessageLength = lib.ToUint32(data[3:6])
 		basic.MessageType = lib.ToUint32(data[6:7])
-		basic.MessageStreamID = lastReceChunk.Message.MessageStreamID
+		basic.MessageStreamID = last.MessageStreamID
 	case 2:
-		data, err = conn.Read(3)
-		if err != nil {
+		data := conn.header[:3]
+		if err := conn.ReadFull(data); err != nil {
 			return errors.WithStack(err)
 		}
-		basic.Timestamp = lib.ToUint32(data[0:3]) + lastReceChunk.Message.Timestamp
-		basic.MessageLength = lastReceChunk.Message.MessageLength
-		basic.MessageType = lastReceChunk.Message.MessageType
-		basic.MessageStreamID = lastReceChunk.Message.MessageStreamID
+		timestamp = lib.ToUint32(data[0:3])
+		basic.MessageLength = last.MessageLength
+		basic.MessageType = last.MessageType
+		basic.MessageStreamID = last.MessageStreamID
 	case 3:
-		data, err = conn.Read(0)
-		if err != nil {
-			return errors.WithStack(err)
+		*basic = last
+		if basic.Extended {
+			// 扩展时间戳在格式3中重复出现
+			if err := conn.ReadFull(conn.header[:4]); err != nil {
+				return errors.WithStack(err)
+			}
 		}
-		basic.Timestamp = lastReceChunk.Message.Timestamp
-		basic.MessageLength = lastReceChunk.Message.MessageLength
-		basic.MessageType = lastReceChunk.Message.MessageType
-		basic.MessageStreamID = lastReceChunk.Message.MessageStreamID
+		if newMessage {
+			basic.Timestamp = last.Timestamp + last.TimestampDelta
+		}
+		return nil
 	default:
 		return errors.WithStack(errors.New("RTMP format error"))
 	}
+
+	basic.Extended = timestamp == 0xffffff
+	if basic.Extended {
+		data := conn.header[:4]
+		if err := conn.ReadFull(data); err != nil {
+			return errors.WithStack(err)
+		}
+		timestamp = lib.ToUint32(data[0:4])
+	}
+	if format == 0 {
+		basic.Timestamp = timestamp
+		basic.TimestampDelta = 0
+	} else {
+		basic.Timestamp = last.Timestamp + timestamp
+		basic.TimestampDelta = timestamp
+	}
 	return nil
 }
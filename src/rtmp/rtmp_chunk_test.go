@@ -0,0 +1,252 @@
+package rtmp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"../config"
+	s "../server"
+)
+
+// newTestConnect 新建通过内存管道读写的连接，返回连接与管道的对端
+func newTestConnect() (*Connect, net.Conn) {
+	local, remote := net.Pipe()
+	server := NewServer(config.Default(), nil)
+	return NewConnect(s.NewConnect(local, 4096), &server), remote
+}
+
+// testChunk 测试用的chunk，按格式写出头部
+type testChunk struct {
+	format uint32
+	csid   uint32
+	header MessageHeader
+	data   []byte
+}
+
+// bytes 写出chunk
+func (chk testChunk) bytes() []byte {
+	buf := appendBasicHeader(nil, chk.format, chk.csid)
+	buf = appendMessageHeader(buf, chk.format, &chk.header)
+	return append(buf, chk.data...)
+}
+
+// readMessages 从对端写入数据，读出count条消息
+func readMessages(conn *Connect, remote net.Conn, data []byte, count int) ([]Message, error) {
+	go func() {
+		remote.Write(data)
+	}()
+	messages := make([]Message, 0, count)
+	for len(messages) < count {
+		msg, err := NewMessage(conn)
+		if err != nil {
+			return messages, err
+		}
+		messages = append(messages, msg)
+	}
+	return messages, nil
+}
+
+// sameMessage 比较消息头部与数据
+func sameMessage(a Message, b Message) bool {
+	return a.Timestamp == b.Timestamp && a.Type == b.Type && a.StreamID == b.StreamID &&
+		a.ChunkStreamID == b.ChunkStreamID && a.Length == b.Length && bytes.Equal(a.Data, b.Data)
+}
+
+// payload 生成测试数据
+func payload(size int, seed byte) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = seed + byte(i)
+	}
+	return data
+}
+
+// TestReadChunks 测试按chunk读入消息，包括交错的chunk stream、格式1-3的头部压缩与扩展时间戳
+func TestReadChunks(t *testing.T) {
+	a := payload(300, 1)
+	b := payload(200, 7)
+	c := payload(40, 9)
+	var tests = []struct {
+		name     string
+		in       []testChunk // input
+		expected []Message   // expected result
+	}{
+		{
+			"fmt3 continuation",
+			[]testChunk{
+				{0, 4, MessageHeader{Timestamp: 1000, MessageLength: 300, MessageType: RTMPTypeVideoData, MessageStreamID: 1}, a[:128]},
+				{3, 4, MessageHeader{}, a[128:256]},
+				{3, 4, MessageHeader{}, a[256:]},
+			},
+			[]Message{{Timestamp: 1000, Type: RTMPTypeVideoData, Length: 300, StreamID: 1, ChunkStreamID: 4, Data: a}},
+		},
+		{
+			"interleaved csids",
+			[]testChunk{
+				{0, 4, MessageHeader{Timestamp: 40, MessageLength: 300, MessageType: RTMPTypeVideoData, MessageStreamID: 1}, a[:128]},
+				{0, 6, MessageHeader{Timestamp: 20, MessageLength: 200, MessageType: RTMPTypeAudioData, MessageStreamID: 1}, b[:128]},
+				{3, 4, MessageHeader{}, a[128:256]},
+				{3, 6, MessageHeader{}, b[128:]},
+				{3, 4, MessageHeader{}, a[256:]},
+			},
+			[]Message{
+				{Timestamp: 20, Type: RTMPTypeAudioData, Length: 200, StreamID: 1, ChunkStreamID: 6, Data: b},
+				{Timestamp: 40, Type: RTMPTypeVideoData, Length: 300, StreamID: 1, ChunkStreamID: 4, Data: a},
+			},
+		},
+		{
+			"header compression",
+			[]testChunk{
+				{0, 6, MessageHeader{Timestamp: 100, MessageLength: 40, MessageType: RTMPTypeAudioData, MessageStreamID: 1}, c},
+				{1, 6, MessageHeader{TimestampDelta: 23, MessageLength: 40, MessageType: RTMPTypeAudioData}, c},
+				{2, 6, MessageHeader{TimestampDelta: 21}, c},
+				{3, 6, MessageHeader{}, c},
+			},
+			[]Message{
+				{Timestamp: 100, Type: RTMPTypeAudioData, Length: 40, StreamID: 1, ChunkStreamID: 6, Data: c},
+				{Timestamp: 123, Type: RTMPTypeAudioData, Length: 40, StreamID: 1, ChunkStreamID: 6, Data: c},
+				{Timestamp: 144, Type: RTMPTypeAudioData, Length: 40, StreamID: 1, ChunkStreamID: 6, Data: c},
+				{Timestamp: 165, Type: RTMPTypeAudioData, Length: 40, StreamID: 1, ChunkStreamID: 6, Data: c},
+			},
+		},
+		{
+			"extended timestamp",
+			[]testChunk{
+				{0, 4, MessageHeader{Timestamp: 0x1000000, MessageLength: 300, MessageType: RTMPTypeVideoData, MessageStreamID: 1}, a[:128]},
+				{3, 4, MessageHeader{Timestamp: 0x1000000}, a[128:256]},
+				{3, 4, MessageHeader{Timestamp: 0x1000000}, a[256:]},
+			},
+			[]Message{{Timestamp: 0x1000000, Type: RTMPTypeVideoData, Length: 300, StreamID: 1, ChunkStreamID: 4, Data: a}},
+		},
+		{
+			"three byte basic header",
+			[]testChunk{
+				{0, 400, MessageHeader{Timestamp: 5, MessageLength: 40, MessageType: RTMPTypeAMFData, MessageStreamID: 1}, c},
+			},
+			[]Message{{Timestamp: 5, Type: RTMPTypeAMFData, Length: 40, StreamID: 1, ChunkStreamID: 400, Data: c}},
+		},
+	}
+
+	for _, test := range tests {
+		conn, remote := newTestConnect()
+		data := make([]byte, 0)
+		for _, chk := range test.in {
+			data = append(data, chk.bytes()...)
+		}
+		actual, err := readMessages(conn, remote, data, len(test.expected))
+		ok := err == nil
+		for i := 0; ok && i < len(actual); i++ {
+			ok = sameMessage(actual[i], test.expected[i])
+		}
+		if !ok {
+			t.Errorf("[×] in: %s out: %+v %v expected: %d messages\n", test.name, actual, err, len(test.expected))
+		} else {
+			t.Logf("[√] in: %s out: %d messages expected: %d messages\n", test.name, len(actual), len(test.expected))
+		}
+		remote.Close()
+	}
+}
+
+// TestChunkRoundTrip 测试写出的chunk可以按原样读入
+func TestChunkRoundTrip(t *testing.T) {
+	var tests = []struct {
+		chunkSize uint32  // input
+		in        Message // input
+	}{
+		{128, Message{Timestamp: 0, Type: RTMPTypeAMF0Command, StreamID: 0, ChunkStreamID: 3, Data: payload(90, 3)}},
+		{128, Message{Timestamp: 40, Type: RTMPTypeVideoData, StreamID: 1, ChunkStreamID: 60, Data: payload(1000, 5)}},
+		{4096, Message{Timestamp: 33, Type: RTMPTypeAudioData, StreamID: 1, ChunkStreamID: 61, Data: payload(4096, 8)}},
+		{100, Message{Timestamp: 0xffffff, Type: RTMPTypeVideoData, StreamID: 1, ChunkStreamID: 60, Data: payload(350, 2)}},
+		{60000, Message{Timestamp: 0x12345678, Type: RTMPTypeVideoData, StreamID: 1, ChunkStreamID: 320, Data: payload(200000, 4)}},
+	}
+
+	for _, test := range tests {
+		conn, remote := newTestConnect()
+		conn.SendChunkSize = test.chunkSize
+		conn.RecvChunkSize = test.chunkSize
+		test.in.Length = uint32(len(test.in.Data))
+		data := make([]byte, 0)
+		for _, chk := range test.in.ToChunks(conn) {
+			b, _ := chk.Bytes()
+			data = append(data, b...)
+		}
+		actual, err := readMessages(conn, remote, data, 1)
+		if err != nil || !sameMessage(actual[0], test.in) {
+			t.Errorf("[×] in: chunk size %d ts %d length %d out: %v expected: same message\n", test.chunkSize, test.in.Timestamp, test.in.Length, err)
+		} else {
+			t.Logf("[√] in: chunk size %d ts %d length %d out: same message expected: same message\n", test.chunkSize, test.in.Timestamp, test.in.Length)
+		}
+		remote.Close()
+	}
+}
+
+// TestPartialMessages 测试消息缓冲区按到达的数据增长，并限制同时接收中的消息数量
+func TestPartialMessages(t *testing.T) {
+	var tests = []struct {
+		in       int  // input: 只发送了首个chunk的消息数量
+		expected bool // expected: 是否读入成功
+	}{
+		{1, true},
+		{maxPartialMessages, true},
+		{maxPartialMessages + 1, false},
+	}
+
+	for _, test := range tests {
+		conn, remote := newTestConnect()
+		data := make([]byte, 0)
+		for i := 0; i < test.in; i++ {
+			// 头部声明16MB的消息，只发送一个chunk
+			chk := testChunk{0, uint32(10 + i), MessageHeader{MessageLength: 0xffffff, MessageType: RTMPTypeVideoData, MessageStreamID: 1}, payload(128, 0)}
+			data = append(data, chk.bytes()...)
+		}
+		go func() {
+			remote.Write(data)
+		}()
+		var err error
+		allocated := 0
+		for i := 0; i < test.in && err == nil; i++ {
+			_, err = NewChunk(conn)
+		}
+		for _, msg := range conn.RecvMessageMap {
+			allocated += cap(msg.Data)
+		}
+		actual := err == nil
+		if actual != test.expected || allocated > len(conn.RecvMessageMap)*initialMessageBuffer {
+			t.Errorf("[×] in: %d out: %v %d bytes expected: %v\n", test.in, err, allocated, test.expected)
+		} else {
+			t.Logf("[√] in: %d out: %v %d bytes expected: %v\n", test.in, err, allocated, test.expected)
+		}
+		remote.Close()
+	}
+}
+
+// TestSetChunkSize 测试设置分块大小的校验
+func TestSetChunkSize(t *testing.T) {
+	var tests = []struct {
+		in       uint32 // input
+		expected bool   // expected result
+	}{
+		{128, true},
+		{4096, true},
+		{0x7fffffff, true},
+		{0, false},
+		{0x80000000, false},
+		{0xffffffff, false},
+	}
+
+	for _, test := range tests {
+		conn, remote := newTestConnect()
+		msg := Message{Type: RTMPTypeSetChunkSize, Data: make([]byte, 4)}
+		binary.BigEndian.PutUint32(msg.Data, test.in)
+		err := msg.solveSetChunkSize(conn)
+		actual := err == nil && conn.RecvChunkSize == test.in
+		if actual != test.expected {
+			t.Errorf("[×] in: %#x out: %v expected: %v\n", test.in, actual, test.expected)
+		} else {
+			t.Logf("[√] in: %#x out: %v expected: %v\n", test.in, actual, test.expected)
+		}
+		remote.Close()
+	}
+}
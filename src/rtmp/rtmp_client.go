@@ -0,0 +1,492 @@
+package rtmp
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"../flv"
+	c "../lib/colorful"
+	s "../server"
+	"./amf"
+	"github.com/pkg/errors"
+)
+
+/*
+
+出站 RTMP 连接，用于转推与回源
+
+连接建立后由读线程处理协议控制消息，命令的响应与状态消息交给等待中的调用者
+回源时连接作为本地流的推流端，读线程将收到的音视频数据在流内广播
+	rtmp://host[:port]/app/stream?query  默认端口为1935，stream可包含 / 与参数
+	rtmps://host[:port]/app/stream?query 使用TLS，默认端口为443
+
+*/
+
+// DefaultPort RTMP默认端口
+const DefaultPort = "1935"
+
+// DefaultTLSPort RTMPS默认端口
+const DefaultTLSPort = "443"
+
+// 出站连接使用的chunk stream id
+const (
+	clientCommandChunkID = 3
+	clientAudioChunkID   = 4
+	clientVideoChunkID   = 6
+	clientStreamChunkID  = 8
+)
+
+// UserControlMessagePingRequest 对端的ping请求
+const UserControlMessagePingRequest = uint32(6)
+
+// UserControlMessagePingResponse 对ping请求的响应
+const UserControlMessagePingResponse = uint32(7)
+
+// Client 出站RTMP连接
+type Client struct {
+	URL      string // 目标地址
+	Address  string // host:port
+	App      string
+	Stream   string // 流名称，包括参数
+	TCURL    string // connect命令中的tcUrl
+	conn     *Connect
+	timeout  time.Duration
+	streamID uint32
+
+	transactionID float64
+	responses     chan AMFCommand // 命令的响应与状态消息
+	done          chan struct{}   // 读线程结束时关闭
+	err           error           // 读线程结束的原因
+
+	mutex   sync.Mutex
+	pulling bool // 是否已作为本地流的推流端
+}
+
+// Endpoint 出站连接的目标
+type Endpoint struct {
+	Scheme  string // rtmp 或 rtmps
+	Host    string // 主机名，用于TLS校验证书
+	Address string // host:port
+	App     string
+	Stream  string // 流名称，包括参数
+}
+
+// ParseURL 解析RTMP地址
+func ParseURL(rawURL string) (Endpoint, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return Endpoint{}, errors.WithStack(err)
+	}
+	port := DefaultPort
+	switch u.Scheme {
+	case "rtmp":
+	case "rtmps":
+		port = DefaultTLSPort
+	default:
+		return Endpoint{}, errors.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return Endpoint{}, errors.New("host is required")
+	}
+	address := u.Host
+	if u.Port() == "" {
+		address = net.JoinHostPort(u.Hostname(), port)
+	}
+	parts := strings.SplitN(strings.TrimPrefix(u.Path, "/"), "/", 2)
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return Endpoint{}, errors.New("path must be /app/stream")
+	}
+	stream := parts[1]
+	if u.RawQuery != "" {
+		stream += "?" + u.RawQuery
+	}
+	return Endpoint{Scheme: u.Scheme, Host: u.Hostname(), Address: address, App: parts[0], Stream: stream}, nil
+}
+
+// Dial 建立出站连接，完成握手与connect命令，timeout为连接与每个命令的超时
+// rtmps地址使用tlsConfig建立TLS连接，tlsConfig为空时使用默认配置
+func Dial(server *Server, rawURL string, timeout time.Duration, tlsConfig *tls.Config) (*Client, error) {
+	endpoint, err := ParseURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{Timeout: timeout}
+	var nc net.Conn
+	if endpoint.Scheme == "rtmps" {
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{}
+		}
+		if tlsConfig.ServerName == "" {
+			tlsConfig = tlsConfig.Clone()
+			tlsConfig.ServerName = endpoint.Host
+		}
+		nc, err = tls.DialWithDialer(dialer, "tcp", endpoint.Address, tlsConfig)
+	} else {
+		nc, err = dialer.Dial("tcp", endpoint.Address)
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	client := &Client{
+		URL:       rawURL,
+		Address:   endpoint.Address,
+		App:       endpoint.App,
+		Stream:    endpoint.Stream,
+		TCURL:     fmt.Sprintf("%s://%s/%s", endpoint.Scheme, endpoint.Address, endpoint.App),
+		conn:      NewConnect(s.NewConnect(nc, s.ReadBufferSize), server),
+		timeout:   timeout,
+		responses: make(chan AMFCommand, 16),
+		done:      make(chan struct{}),
+	}
+	conn := client.conn
+	conn.FullName = endpoint.App + "/" + endpoint.Stream
+
+	nc.SetDeadline(time.Now().Add(timeout))
+	if err := ClientHandshake(conn); err != nil {
+		nc.Close()
+		return nil, err
+	}
+	nc.SetDeadline(time.Time{})
+	conn.phase = phaseCommand
+	conn.Writer.Start()
+	go client.loop()
+
+	if err := client.connect(); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+// connect 发送设置分块大小与connect命令
+func (client *Client) connect() error {
+	cfg := client.conn.WithinServer.Config
+	if err := client.conn.SendSetChunkSize(cfg.RTMP.ChunkSize); err != nil {
+		return errors.WithStack(err)
+	}
+	tx := client.call("connect", map[string]interface{}{
+		"app":      client.App,
+		"type":     "nonprivate",
+		"flashVer": "FMLE/3.0 (compatible; Donview)",
+		"tcUrl":    client.TCURL,
+		// 声明支持 Enhanced RTMP，回源时对端才会发送HEVC/AV1/VP9
+		"fourCcList": flv.VideoFourCCs,
+	})
+	_, err := client.waitResult(tx)
+	return err
+}
+
+// Publish 创建流并开始推流
+func (client *Client) Publish() error {
+	client.call("releaseStream", nil, client.Stream)
+	client.call("FCPublish", nil, client.Stream)
+	if err := client.createStream(); err != nil {
+		return err
+	}
+	client.send(client.streamID, "publish", 0, nil, client.Stream, "live")
+	if err := client.waitStatus("NetStream.Publish.Start"); err != nil {
+		return err
+	}
+	client.conn.phase = phasePublish
+	return nil
+}
+
+// Play 创建流并从对端拉流，开始播放后作为推流端加入本地流stream，连接断开时本地流随之结束
+func (client *Client) Play(stream *Stream) error {
+	if err := client.createStream(); err != nil {
+		return err
+	}
+	client.send(client.streamID, "play", 0, nil, client.Stream)
+	if err := client.waitStatus("NetStream.Play.Start"); err != nil {
+		return err
+	}
+	if stream.HasPublisher() {
+		return errors.Errorf("stream %s is already published", stream.Name)
+	}
+
+	server := client.conn.WithinServer
+	appName := strings.SplitN(stream.Name, "/", 2)[0]
+	app, _ := server.Config.Application(appName)
+
+	defer client.mutex.Unlock()
+	client.mutex.Lock()
+
+	conn := client.conn
+	conn.App = app
+	conn.AppName = appName
+	conn.StreamName = strings.TrimPrefix(stream.Name, appName+"/")
+	conn.FullName = stream.Name
+	if err := server.AddPublisher(stream, conn); err != nil {
+		if err == errTooManyPublishers {
+			server.Stats.AddRejection(RejectMaxPublishers)
+		}
+		return errors.Wrapf(err, "pull %s", stream.Name)
+	}
+	conn.WithinStream = stream
+	conn.phase = phasePublish
+	client.pulling = true
+	// 读线程可能阻塞在没有超时的读取上，设置后立即生效
+	conn.Conn.SetReadDeadline(client.readDeadline(true))
+	return nil
+}
+
+// isPulling 是否已作为本地流的推流端
+func (client *Client) isPulling() bool {
+	defer client.mutex.Unlock()
+	client.mutex.Lock()
+
+	return client.pulling
+}
+
+// createStream 创建消息流
+func (client *Client) createStream() error {
+	result, err := client.waitResult(client.call("createStream", nil))
+	if err != nil {
+		return err
+	}
+	streamID, ok := result.OptionalUserArguments.(float64)
+	if !ok {
+		return errors.New("RTMP createStream 格式错误")
+	}
+	client.streamID = uint32(streamID)
+	return nil
+}
+
+// WriteMessage 在推流的消息流上发送音视频或数据消息，发送队列满时阻塞
+func (client *Client) WriteMessage(msg Message) error {
+	msg.StreamID = client.streamID
+	msg.ChunkStreamID = clientAudioChunkID
+	if msg.Type == RTMPTypeVideoData {
+		msg.ChunkStreamID = clientVideoChunkID
+	}
+	return client.conn.WriteMessage(msg)
+}
+
+// Done 连接断开时关闭的channel
+func (client *Client) Done() <-chan struct{} {
+	return client.done
+}
+
+// Err 连接断开的原因
+func (client *Client) Err() error {
+	select {
+	case <-client.done:
+		return client.err
+	default:
+		return nil
+	}
+}
+
+// Close 删除流并关闭连接，已排队的消息会在关闭前写出
+func (client *Client) Close() {
+	if client.streamID != 0 {
+		if client.conn.phase == phasePublish && !client.isPulling() {
+			client.call("FCUnpublish", nil, client.Stream)
+		}
+		client.call("deleteStream", nil, float64(client.streamID))
+	}
+	client.conn.CloseServer()
+	client.conn.Writer.Stop()
+	client.conn.Conn.Close()
+	<-client.done
+}
+
+// call 在消息流0上发送命令，返回事务id
+func (client *Client) call(name string, values ...interface{}) float64 {
+	client.transactionID++
+	client.send(0, append([]interface{}{name, client.transactionID}, values...)...)
+	return client.transactionID
+}
+
+// send 发送由AMF0值组成的命令
+func (client *Client) send(streamID uint32, values ...interface{}) error {
+	var data []byte
+	for _, value := range values {
+		amfValue, err := amf.MakeAMF(value)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		data = append(data, amfValue.Bytes()...)
+	}
+	csid := uint32(clientCommandChunkID)
+	if streamID != 0 {
+		csid = clientStreamChunkID
+	}
+	return client.conn.WriteMessage(Message{
+		Type:          RTMPTypeAMF0Command,
+		Length:        uint32(len(data)),
+		ReadLength:    uint32(len(data)),
+		StreamID:      streamID,
+		ChunkStreamID: csid,
+		Data:          data,
+	})
+}
+
+// wait 等待满足条件的响应
+func (client *Client) wait(match func(cmd AMFCommand) bool) (AMFCommand, error) {
+	timer := time.NewTimer(client.timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case cmd := <-client.responses:
+			if match(cmd) {
+				return cmd, nil
+			}
+		case <-client.done:
+			return AMFCommand{}, errors.Wrap(client.err, "RTMP connection closed")
+		case <-timer.C:
+			return AMFCommand{}, errors.New("RTMP command timeout")
+		}
+	}
+}
+
+// waitResult 等待事务的 _result，收到 _error 时返回错误
+func (client *Client) waitResult(tx float64) (AMFCommand, error) {
+	cmd, err := client.wait(func(cmd AMFCommand) bool {
+		return (cmd.CommandName == "_result" || cmd.CommandName == "_error") && cmd.TransactionID == tx
+	})
+	if err != nil {
+		return cmd, err
+	}
+	if cmd.CommandName == "_error" {
+		return cmd, statusError(cmd)
+	}
+	return cmd, nil
+}
+
+// waitStatus 等待状态码为code的onStatus，收到error级别的状态时返回错误
+func (client *Client) waitStatus(code string) error {
+	cmd, err := client.wait(func(cmd AMFCommand) bool {
+		if cmd.CommandName != "onStatus" {
+			return false
+		}
+		info, _ := cmd.OptionalUserArguments.(map[string]interface{})
+		return info["code"] == code || info["level"] == "error"
+	})
+	if err != nil {
+		return err
+	}
+	if info, _ := cmd.OptionalUserArguments.(map[string]interface{}); info["level"] == "error" {
+		return statusError(cmd)
+	}
+	return nil
+}
+
+// statusError 由错误响应生成错误信息
+func statusError(cmd AMFCommand) error {
+	info, _ := cmd.OptionalUserArguments.(map[string]interface{})
+	return errors.Errorf("%s %v: %v", cmd.CommandName, info["code"], info["description"])
+}
+
+// loop 读线程，处理协议控制消息并转交命令的响应，回源时广播音视频数据
+func (client *Client) loop() {
+	defer close(client.done)
+
+	conn := client.conn
+	for {
+		conn.Conn.SetReadDeadline(client.readDeadline(client.isPulling()))
+		msg, err := NewMessage(conn)
+		if err != nil {
+			client.stop(err)
+			return
+		}
+		if msg.Type == RTMPTypeAudioData || msg.Type == RTMPTypeVideoData || msg.Type == RTMPTypeAMFData {
+			if client.isPulling() {
+				// 音视频数据会被广播给拉流端，不能回收
+				msg.Solve(conn)
+				continue
+			}
+		}
+		err = client.handle(msg)
+		msg.Release()
+		if err != nil {
+			client.stop(err)
+			return
+		}
+	}
+}
+
+// readDeadline 读线程的读超时时刻，回源时与推流端一样按媒体超时计算，其余情况对端可能长时间不发送数据，不设超时
+func (client *Client) readDeadline(pulling bool) time.Time {
+	if !pulling {
+		return time.Time{}
+	}
+	timeout := client.conn.WithinServer.Config.Timeouts.Media.Duration()
+	if timeout <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(timeout)
+}
+
+// stop 读线程结束，关闭连接，回源时本地流随之结束，读超时与推流端一样计入驱逐
+func (client *Client) stop(err error) {
+	conn := client.conn
+	client.err = err
+	pulling := client.isPulling()
+	if pulling {
+		conn.checkTimeout(err)
+	}
+	conn.CloseServer()
+	if pulling {
+		conn.WithinStream.DelConnect(conn)
+	}
+}
+
+// handle 处理收到的消息，对端发送无效的协议控制消息时返回错误
+func (client *Client) handle(msg Message) error {
+	conn := client.conn
+	switch msg.Type {
+	case RTMPTypeSetChunkSize:
+		return msg.solveSetChunkSize(conn)
+	case RTMPTypeWindowAcknowledgementSize:
+		if len(msg.Data) >= 4 {
+			conn.RecvWindowAcknowledgementSize = binary.BigEndian.Uint32(msg.Data)
+		}
+	case RTMPTypeUserControlMessage:
+		if len(msg.Data) >= 6 && uint32(binary.BigEndian.Uint16(msg.Data)) == UserControlMessagePingRequest {
+			conn.SendUserControlMessage(UserControlMessagePingResponse, binary.BigEndian.Uint32(msg.Data[2:]))
+		}
+	case RTMPTypeAMF0Command, RTMPTypeAMF3Command:
+		data := msg.Data
+		if msg.Type == RTMPTypeAMF3Command && len(data) > 0 {
+			data = data[1:]
+		}
+		array, err := amf.ByteToAMFArray(data)
+		if err != nil || len(array) < 2 {
+			return nil
+		}
+		cmd := AMFCommand{}
+		cmd.CommandName, _ = array[0].Value().(string)
+		cmd.TransactionID, _ = array[1].Value().(float64)
+		if len(array) > 2 {
+			cmd.CommandObject = array[2].Value()
+		}
+		if len(array) > 3 {
+			cmd.OptionalUserArguments = array[3].Value()
+		}
+		if cmd.CommandName == "close" {
+			log.Println(c.Front("RTMP %s closed by peer", c.Y, client.Address))
+			conn.CloseServer()
+			return nil
+		}
+		select {
+		case client.responses <- cmd:
+		default:
+			// 没有等待中的调用者，丢弃最早的响应
+			select {
+			case <-client.responses:
+			default:
+			}
+			client.responses <- cmd
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package rtmp
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"../config"
+)
+
+// newTestClient 新建通过内存管道读写的出站连接并启动读线程，返回连接与管道的对端
+func newTestClient() (*Client, net.Conn) {
+	conn, remote := newTestConnect()
+	client := &Client{
+		Address:   "test",
+		conn:      conn,
+		timeout:   time.Second,
+		responses: make(chan AMFCommand, 16),
+		done:      make(chan struct{}),
+	}
+	go client.loop()
+	return client, remote
+}
+
+// TestClientSetChunkSize 测试出站连接校验对端设置的chunk大小，无效时关闭连接
+func TestClientSetChunkSize(t *testing.T) {
+	var tests = []struct {
+		in       []byte // input: 设置分块大小消息的数据
+		closed   bool   // expected: 连接是否关闭
+		expected uint32 // expected: 连接的接收chunk大小
+	}{
+		{[]byte{0, 0, 0x10, 0}, false, 4096},
+		{[]byte{0, 0}, true, 128},
+		{[]byte{0, 0, 0, 0}, true, 128},
+		{[]byte{0x80, 0, 0x10, 0}, true, 128},
+	}
+
+	for _, test := range tests {
+		client, remote := newTestClient()
+		chk := testChunk{0, 2, MessageHeader{MessageLength: uint32(len(test.in)), MessageType: RTMPTypeSetChunkSize}, test.in}
+		remote.SetWriteDeadline(time.Now().Add(time.Second))
+		remote.Write(chk.bytes())
+
+		closed := false
+		select {
+		case <-client.Done():
+			closed = client.Err() != nil
+		case <-time.After(100 * time.Millisecond):
+		}
+		remote.Close()
+		<-client.Done()
+		actual := client.conn.RecvChunkSize
+		if closed != test.closed || actual != test.expected {
+			t.Errorf("[×] in: %v out: %v %d expected: %v %d\n", test.in, closed, actual, test.closed, test.expected)
+		} else {
+			t.Logf("[√] in: %v out: %v %d expected: %v %d\n", test.in, closed, actual, test.closed, test.expected)
+		}
+	}
+}
+
+// TestClientPullTimeout 测试回源连接在媒体超时内没有收到消息时被驱逐，本地流随之结束
+func TestClientPullTimeout(t *testing.T) {
+	var tests = []struct {
+		media   config.Duration // input: 媒体超时
+		closed  bool            // expected: 连接是否关闭
+		evicted uint64          // expected: 媒体超时驱逐次数
+	}{
+		{"50ms", true, 1},
+		{"", false, 0},
+	}
+
+	for _, test := range tests {
+		client, remote := newTestClient()
+		conn := client.conn
+		server := conn.WithinServer
+		server.Config.Timeouts.Media = test.media
+		stream := server.GetStream("live/a")
+
+		// 与Play开始播放后相同，作为推流端加入本地流
+		client.mutex.Lock()
+		conn.App, _ = server.Config.Application("live")
+		conn.AppName = "live"
+		server.AddPublisher(stream, conn)
+		conn.WithinStream = stream
+		conn.phase = phasePublish
+		client.pulling = true
+		conn.Conn.SetReadDeadline(client.readDeadline(true))
+		client.mutex.Unlock()
+
+		closed := false
+		select {
+		case <-client.Done():
+			closed = true
+		case <-time.After(300 * time.Millisecond):
+		}
+		evictions := server.Stats.Snapshot()["evictions"].(map[string]uint64)
+		published := stream.HasPublisher()
+		remote.Close()
+		<-client.Done()
+		if closed != test.closed || published == test.closed || evictions["media idle timeout"] != test.evicted {
+			t.Errorf("[×] in: %q out: %v %v %v expected: %v %d\n", test.media, closed, published, evictions, test.closed, test.evicted)
+		} else {
+			t.Logf("[√] in: %q out: %v %v %v expected: %v %d\n", test.media, closed, published, evictions, test.closed, test.evicted)
+		}
+	}
+}
@@ -2,8 +2,14 @@ package rtmp
 
 import (
 	"encoding/binary"
+	"io"
 	"log"
+	"net"
+	"sync/atomic"
+	"time"
 
+	"../config"
+	"../lib"
 	c "../lib/colorful"
 	s "../server"
 	"github.com/pkg/errors"
@@ -15,12 +21,31 @@ import (
 
 */
 
+// 连接阶段
+const (
+	phaseHandshake = iota // 握手
+	phaseCommand          // 握手完成，尚未推流或拉流
+	phasePublish          // 推流
+	phasePlay             // 拉流
+)
+
+// IngestBurst 推流码率限制允许的突发数据，单位为秒
+const IngestBurst = 2
+
+// IngestWarnInterval 推流码率超过上限时两次告警的最小间隔
+const IngestWarnInterval = 10 * time.Second
+
+// CloseGrace 关闭连接时等待写出线程发送剩余数据的时间，超时后强制关闭套接字
+const CloseGrace = 2 * time.Second
+
 // Connect 连接对象
 type Connect struct {
-	WithinServer *Server    // 所在的RTMP服务
-	WithinStream *Stream    // 所在的流信息
-	Conn         *s.Connect // 服务连接
-	Close        bool       // 是否需要关闭
+	WithinServer *Server             // 所在的RTMP服务
+	WithinStream *Stream             // 所在的流信息
+	App          *config.Application // 所在的应用配置
+	Conn         *s.Connect          // 服务连接
+	closed       int32               // 是否需要关闭
+	phase        int                 // 连接所处阶段，决定读超时
 
 	RecvChunkSize                 uint32 // 对方的最大Chunk长度
 	SendChunkSize                 uint32 // 本地的最大Chunk长度
@@ -31,26 +56,31 @@ type Connect struct {
 	SendBandwidth                 uint32 // 带宽大小
 	BufferSize                    uint32 // 缓冲区大小
 
-	TotalReceive uint32 // 已接受的总数
-	Seq          uint32 // 窗口计数
+	TotalReceive uint32   // 已接收的总字节数
+	Seq          uint32   // 上次确认后接收的字节数
+	header       [16]byte // 读入chunk头部的缓冲区
 
 	LastRecvChunk  map[uint32]Chunk
 	LastSendChunk  map[uint32]Chunk
 	RecvMessageMap map[uint32]*Message // 未完全接收的Message列表
 
-	isBegin   bool   // Tag是否已发送
-	beginTime uint32 // 开始关键帧时间戳
 	TotalTime uint32 // 视频流时间戳总时间
 
+	ingest         *lib.TokenBucket // 推流码率限制，为空时不限制
+	ingestAction   string           // 推流超过码率上限时的处理
+	ingestWarnTime time.Time        // 上次码率告警的时间
+
 	FullName   string
 	AppName    string
 	StreamName string
 
-	VideoChunkID uint32
-	AudioChunkID uint32
-	StreamID     uint32
+	VideoChunkID    uint32
+	AudioChunkID    uint32
+	StreamID        uint32
+	PublishStreamID uint32 // 推流端publish命令所在的消息流id
 
-	Thread AVThread
+	Writer *Writer    // 写出线程
+	vod    *vodPlayer // 点播发送线程，仅点播拉流端使用
 
 	Test bool
 }
@@ -72,9 +102,7 @@ func NewConnect(conn *s.Connect, server *Server) *Connect {
 	connect.AudioChunkID = 61
 	connect.StreamID = 67
 
-	connect.isBegin = false
-	connect.beginTime = 0
-	connect.Thread = NewAVThread(&connect)
+	connect.Writer = NewWriter(&connect, server.Config.Cache.ReceiverQueue)
 
 	connect.Test = false
 
@@ -84,13 +112,24 @@ func NewConnect(conn *s.Connect, server *Server) *Connect {
 // Server RTMP连接服务
 func (conn *Connect) Server() error {
 	// 握手
+	conn.phase = phaseHandshake
+	if timeout := conn.WithinServer.Config.Timeouts.Handshake.Duration(); timeout > 0 {
+		conn.Conn.SetDeadline(time.Now().Add(timeout))
+	}
 	err := Handshake(conn)
 	if err != nil {
+		conn.checkTimeout(err)
 		return errors.WithStack(err)
 	}
+	conn.Conn.SetDeadline(time.Time{})
 	log.Println(c.Front("Handshake ok.", c.G))
 
+	// 握手完成后所有数据都通过写出线程发送
+	conn.phase = phaseCommand
+	conn.Writer.Start()
+
 	if err := conn.loop(); err != nil {
+		conn.checkTimeout(err)
 		return errors.WithStack(err)
 	}
 	return nil
@@ -99,16 +138,29 @@ func (conn *Connect) Server() error {
 // loop 循环过程
 func (conn *Connect) loop() error {
 	for {
+		if timeout := conn.readTimeout(); timeout > 0 {
+			conn.Conn.SetReadDeadline(time.Now().Add(timeout))
+		} else {
+			conn.Conn.SetReadDeadline(time.Time{})
+		}
 		msg, err := NewMessage(conn)
 		if err != nil {
+			if conn.Closed() {
+				// 服务端主动关闭时中断了读取
+				break
+			}
 			return errors.WithStack(err)
 		}
 
 		if err := msg.Solve(conn); err != nil {
 			return errors.WithStack(err)
 		}
+		if msg.Type != RTMPTypeAudioData && msg.Type != RTMPTypeVideoData && msg.Type != RTMPTypeAMFData {
+			// 音视频数据会被广播给拉流端，其余消息处理后即可回收
+			msg.Release()
+		}
 
-		if conn.Close {
+		if conn.Closed() {
 			// 连接结束
 			break
 		}
@@ -116,86 +168,166 @@ func (conn *Connect) loop() error {
 	return nil
 }
 
-// CloseServer 关闭连接
-func (conn *Connect) CloseServer() {
-	conn.Close = true
+// readTimeout 当前阶段的读超时
+func (conn *Connect) readTimeout() time.Duration {
+	timeouts := conn.WithinServer.Config.Timeouts
+	switch conn.phase {
+	case phaseCommand:
+		return timeouts.Command.Duration()
+	case phasePublish:
+		return timeouts.Media.Duration()
+	default:
+		return timeouts.Idle.Duration()
+	}
 }
 
-// BeforeClose 关闭连接前的处理函数，与CloseServer不同，这里包括报错关闭的情况
-func (conn *Connect) BeforeClose() {
-	if conn.WithinStream != nil {
-		conn.WithinStream.DelConnect(conn)
+// checkTimeout 读超时时按所处阶段驱逐连接，主动关闭的连接不计入
+func (conn *Connect) checkTimeout(err error) {
+	if conn.Closed() || !isTimeout(err) {
+		return
 	}
-	conn.Thread.Stop()
+	reasons := map[int]string{
+		phaseHandshake: "handshake timeout",
+		phaseCommand:   "command timeout",
+		phasePublish:   "media idle timeout",
+		phasePlay:      "play idle timeout",
+	}
+	conn.Evict(reasons[conn.phase])
 }
 
-// Read 读入指定长度的数据
-func (conn *Connect) Read(len uint32) ([]byte, error) {
-	var readLength uint32
-	data := make([]byte, len)
+// Evict 驱逐连接，记录原因并关闭连接
+func (conn *Connect) Evict(reason string) {
+	log.Println(c.Front("Evict %v (%s): %s", c.Y, conn.Conn.RemoteAddr(), conn.FullName, reason))
+	conn.WithinServer.Stats.AddEviction(reason)
+	conn.CloseServer()
+}
 
-	for readLength < len {
-		buff := make([]byte, len-readLength)
-		l, err := conn.Conn.Read(buff)
-		if err != nil {
-			// log.Println(err)
-			return data, err
-			// continue
+// applyPeerBandwidth 按对端的Set Peer Bandwidth消息调整发送速率限制
+func (conn *Connect) applyPeerBandwidth(size uint32, limitType uint32) {
+	switch limitType {
+	case BandwidthLimitHard:
+	case BandwidthLimitSoft:
+		if conn.RecvBandwidth != 0 && conn.RecvBandwidth < size {
+			size = conn.RecvBandwidth
 		}
-		if conn.Close {
-			return data, errors.New("Close Server")
+	case BandwidthLimitDynamic:
+		if conn.RecvBandwidth == 0 || conn.RecvBandwidthType != BandwidthLimitHard {
+			return
 		}
-		data = append(data[:readLength], buff[:l]...)
-		readLength += uint32(l)
+		limitType = BandwidthLimitHard
+	default:
+		return
 	}
+	conn.RecvBandwidth = size
+	conn.RecvBandwidthType = limitType
 
-	return data, nil
+	if conn.WithinServer.Config.RTMP.ShapeOutput {
+		conn.Writer.SetRate(size)
+	}
 }
 
-// Write 写出数据
-func (conn *Connect) Write(b []byte) (int, error) {
-	return conn.Conn.Write(b)
+// checkIngest 检查推流码率，超过应用的码率上限时按配置告警或断开连接，返回是否继续处理该消息
+func (conn *Connect) checkIngest(size int) bool {
+	if conn.ingest == nil || conn.ingest.Allow(size) {
+		return true
+	}
+	if conn.ingestAction == "disconnect" {
+		conn.Evict("ingest bitrate exceeded")
+		return false
+	}
+	if now := time.Now(); now.Sub(conn.ingestWarnTime) >= IngestWarnInterval {
+		conn.ingestWarnTime = now
+		log.Println(c.Front("Publisher %s exceeds max bitrate", c.Y, conn.FullName))
+		conn.WithinServer.Stats.AddWarning("ingest bitrate exceeded")
+	}
+	return true
 }
 
-// WriteChunk 写出Chunk
-func (conn *Connect) WriteChunk(chk Chunk) (int, error) {
-	conn.LastSendChunk[chk.Basic.ChunkStreamID] = chk
-	b, err := chk.Bytes()
-	if err != nil {
-		return 0, errors.WithStack(err)
+// SendFrame 将流内广播的音视频帧加入发送队列
+func (conn *Connect) SendFrame(frame *Frame) error {
+	return conn.Writer.SendFrame(frame)
+}
+
+// NotifyUnpublish 通知拉流端流即将结束
+func (conn *Connect) NotifyUnpublish() {
+	conn.SendStatus(conn.StreamID, "status", "NetStream.Play.UnpublishNotify", conn.FullName+" is now unpublished.")
+}
+
+// Closed 连接是否已被关闭
+func (conn *Connect) Closed() bool {
+	return atomic.LoadInt32(&conn.closed) != 0
+}
+
+// CloseServer 关闭连接，可在其他协程中调用
+// 立即中断阻塞的读取，写出线程发送完已排队的控制消息后关闭套接字，超过CloseGrace后强制关闭
+func (conn *Connect) CloseServer() {
+	if !atomic.CompareAndSwapInt32(&conn.closed, 0, 1) {
+		return
 	}
-	// log.Println(c.Front("%v Write chunk %v", c.Y, conn, b))
-	return conn.Conn.Write(b)
+	conn.Conn.SetReadDeadline(time.Now())
+	time.AfterFunc(CloseGrace, func() {
+		conn.Conn.Close()
+	})
 }
 
-// WriteChunks 写出Chunks
-func (conn *Connect) WriteChunks(chunks []Chunk) error {
-	for _, chk := range chunks {
-		_, err := conn.WriteChunk(chk)
-		if err != nil {
-			return errors.WithStack(err)
-		}
+// BeforeClose 关闭连接前的处理函数，与CloseServer不同，这里包括报错关闭的情况
+func (conn *Connect) BeforeClose() {
+	if conn.WithinStream != nil {
+		conn.WithinStream.DelConnect(conn)
+	}
+	if conn.vod != nil {
+		conn.vod.Stop()
+	}
+	conn.Writer.Stop()
+}
+
+// isTimeout 是否为网络超时错误
+func isTimeout(err error) bool {
+	netErr, ok := errors.Cause(err).(net.Error)
+	return ok && netErr.Timeout()
+}
+
+// Read 读入指定长度的数据
+func (conn *Connect) Read(len uint32) ([]byte, error) {
+	data := make([]byte, len)
+	err := conn.ReadFull(data)
+	return data, err
+}
+
+// ReadFull 读满整个缓冲区
+func (conn *Connect) ReadFull(buf []byte) error {
+	n, err := io.ReadFull(conn.Conn, buf)
+	conn.TotalReceive += uint32(n)
+	conn.Seq += uint32(n)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if conn.Closed() {
+		return errors.New("Close Server")
 	}
 	return nil
 }
 
-// WriteMessage 写出Message
+// Write 直接写出数据，仅用于写出线程启动前的握手阶段
+func (conn *Connect) Write(b []byte) (int, error) {
+	return conn.Conn.Write(b)
+}
+
+// WriteMessage 将Message加入写出线程的发送队列
 func (conn *Connect) WriteMessage(msg Message) error {
-	chunks := msg.ToChunks(conn)
-	err := conn.WriteChunks(chunks)
+	err := conn.Writer.SendMessage(msg)
 	if err != nil {
 		return errors.WithStack(err)
 	}
-	// log.Println(c.Front("%v %d", c.Y, msg.Type, msg.Length))
-	return err
+	return nil
 }
 
 // SendACK 发送一个窗口确认消息
 func (conn *Connect) SendACK() error {
 	data := make([]byte, 4)
 	binary.BigEndian.PutUint32(data, uint32(conn.TotalReceive))
-	msg, err := MakeMessage(RTMPTypeACK, data, 0, 0, 0)
-	if err != nil {
+	msg, err := MakeMessage(RTMPTypeACK, data, 0, 2, 0)
+	if err == nil {
 		err = conn.WriteMessage(msg)
 	}
 	if err != nil {
@@ -226,18 +358,40 @@ func (conn *Connect) SendResponse(amfCommand AMFCommand, streamID uint32, chunkS
 	return err
 }
 
+// SendStatus 发送onStatus状态消息
+func (conn *Connect) SendStatus(streamID uint32, level string, code string, description string) error {
+	return conn.SendResponse(AMFCommand{
+		"onStatus",
+		0,
+		nil,
+		map[string]interface{}{
+			"level":       level,
+			"code":        code,
+			"description": description,
+		},
+	}, streamID, 3)
+}
+
 // SendStreamIsRecord 发送流记录命令
 func (conn *Connect) SendStreamIsRecord(streamID uint32) error {
-	_, err := conn.Write([]byte{0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x06, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0x04, 0x00, 0x00, 0x00, byte(streamID)})
-	if err != nil {
-		return errors.WithStack(err)
-	}
-	return nil
+	return errors.WithStack(conn.SendUserControlMessage(UserControlMessageStreamIsRecorded, streamID))
 }
 
 // SendStreamBegin 发送流开始命令
 func (conn *Connect) SendStreamBegin(streamID uint32) error {
-	_, err := conn.Write([]byte{0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x06, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, byte(streamID)})
+	return errors.WithStack(conn.SendUserControlMessage(UserControlMessageStreamBegin, streamID))
+}
+
+// SendUserControlMessage 发送用户控制消息
+func (conn *Connect) SendUserControlMessage(event uint32, value uint32) error {
+	data := make([]byte, 6)
+	binary.BigEndian.PutUint16(data[0:2], uint16(event))
+	binary.BigEndian.PutUint32(data[2:6], value)
+
+	msg, err := MakeMessage(RTMPTypeUserControlMessage, data, 0, 2, 0)
+	if err == nil {
+		err = conn.WriteMessage(msg)
+	}
 	if err != nil {
 		return errors.WithStack(err)
 	}
@@ -265,15 +419,15 @@ func (conn *Connect) SendWinACKSize(size uint32) error {
 	return nil
 }
 
-// SendSetPeerBandwidth 发送窗口大小命令
-func (conn *Connect) SendSetPeerBandwidth(size uint32) error {
+// SendSetPeerBandwidth 发送对端带宽命令
+func (conn *Connect) SendSetPeerBandwidth(size uint32, limitType uint32) error {
 	conn.SendBandwidth = size
 	sizeBytes := make([]byte, 4)
 	binary.BigEndian.PutUint32(sizeBytes, conn.SendBandwidth)
 
 	msg, err := MakeMessage(
 		RTMPTypeSetPeerBandwidth,
-		append(sizeBytes, 0x02),
+		append(sizeBytes, byte(limitType)),
 		0,
 		2,
 		0,
@@ -286,11 +440,10 @@ func (conn *Connect) SendSetPeerBandwidth(size uint32) error {
 	return nil
 }
 
-// SendSetChunkSize 设置分块大小
+// SendSetChunkSize 设置分块大小，写出线程写出该消息后生效
 func (conn *Connect) SendSetChunkSize(size uint32) error {
-	conn.SendChunkSize = size
 	sizeBytes := make([]byte, 4)
-	binary.BigEndian.PutUint32(sizeBytes, conn.SendChunkSize)
+	binary.BigEndian.PutUint32(sizeBytes, size)
 
 	msg, err := MakeMessage(
 		RTMPTypeSetChunkSize,
@@ -306,53 +459,3 @@ func (conn *Connect) SendSetChunkSize(size uint32) error {
 
 	return nil
 }
-
-// SendAVMessage 发送音视频消息
-func (conn *Connect) SendAVMessage(msg Message) error {
-
-	if !conn.isBegin {
-		// 尚未发送关键帧
-
-		frameType := uint32(msg.Data[0]) >> 4
-		if msg.Type == RTMPTypeAudioData || (frameType != 1 && frameType != 4) {
-			// 等待有视频帧发送过后再发送音频帧
-			// 非关键帧也应该忽略
-			log.Println("F")
-			return nil
-		}
-		tag, ok := conn.WithinStream.GetTag()
-
-		if !ok {
-			// 推流端还未发送关键帧
-			return nil
-		}
-		tag.Timestamp = 0
-		tag.ChunkStreamID = conn.VideoChunkID
-		conn.isBegin = true
-		conn.beginTime = msg.Timestamp
-
-		err := conn.WriteMessage(tag)
-
-		if err != nil {
-			return errors.WithStack(err)
-		}
-	}
-
-	var csid uint32
-	if msg.Type == RTMPTypeVideoData {
-		csid = conn.VideoChunkID
-	} else {
-		csid = conn.AudioChunkID
-	}
-	frame := msg.Copy()
-	frame.ChunkStreamID = csid
-
-	frame.Timestamp -= conn.beginTime
-
-	err := conn.WriteMessage(frame)
-	if err != nil {
-		return errors.WithStack(err)
-	}
-
-	return nil
-}
@@ -0,0 +1,95 @@
+package rtmp
+
+import (
+	"testing"
+	"time"
+
+	"../config"
+	s "../server"
+)
+
+// TestReadTimeout 测试按连接所处阶段选择读超时
+func TestReadTimeout(t *testing.T) {
+	var tests = []struct {
+		in       int           // input
+		expected time.Duration // expected result
+	}{
+		{phaseCommand, 30 * time.Second},
+		{phasePublish, 5 * time.Second},
+		{phasePlay, time.Minute},
+	}
+
+	conn, remote := newTestConnect()
+	defer remote.Close()
+	conn.WithinServer.Config.Timeouts.Media = "5s"
+	conn.WithinServer.Config.Timeouts.Idle = "1m"
+	for _, test := range tests {
+		conn.phase = test.in
+		actual := conn.readTimeout()
+		if actual != test.expected {
+			t.Errorf("[×] in: %d out: %v expected: %v\n", test.in, actual, test.expected)
+		} else {
+			t.Logf("[√] in: %d out: %v expected: %v\n", test.in, actual, test.expected)
+		}
+	}
+}
+
+// TestEvict 测试连接在各阶段超时后被驱逐并计入统计
+func TestEvict(t *testing.T) {
+	var tests = []struct {
+		in       string // input
+		expected string // expected eviction reason
+	}{
+		{"handshake", "handshake timeout"},
+		{"command", "command timeout"},
+		{"write", "write timeout"},
+	}
+
+	for _, test := range tests {
+		conn, remote := newTestConnect()
+		timeouts := &conn.WithinServer.Config.Timeouts
+		timeouts.Handshake = "50ms"
+		timeouts.Command = "50ms"
+		timeouts.Write = "50ms"
+
+		done := make(chan error, 1)
+		switch test.in {
+		case "handshake":
+			// 对端不发送握手数据
+			go func() {
+				done <- conn.Server()
+			}()
+		case "command":
+			// 对端完成握手后不发送命令
+			go func() {
+				done <- conn.Server()
+			}()
+			server := NewServer(config.Default(), nil)
+			client := NewConnect(s.NewConnect(remote, 4096), &server)
+			if err := ClientHandshake(client); err != nil {
+				t.Errorf("[×] in: %s out: %v expected: handshake ok\n", test.in, err)
+			}
+		case "write":
+			// 对端不读取数据
+			conn.Writer.Start()
+			conn.Writer.SendMessage(Message{Type: RTMPTypeAMF0Command, ChunkStreamID: 3, Length: 4, Data: make([]byte, 4)})
+			go func() {
+				<-conn.Writer.done
+				done <- nil
+			}()
+		}
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Errorf("[×] in: %s out: no eviction expected: %s\n", test.in, test.expected)
+		}
+		evictions := conn.WithinServer.Stats.Snapshot()["evictions"].(map[string]uint64)
+		if !conn.Closed() || evictions[test.expected] != 1 {
+			t.Errorf("[×] in: %s out: %v %v expected: %s\n", test.in, conn.Closed(), evictions, test.expected)
+		} else {
+			t.Logf("[√] in: %s out: %v %v expected: %s\n", test.in, conn.Closed(), evictions, test.expected)
+		}
+		remote.Close()
+	}
+}
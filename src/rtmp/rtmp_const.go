@@ -9,6 +9,7 @@ const (
 	RTMPTypeSetPeerBandwidth          = uint32(0x06)
 	RTMPTypeAudioData                 = uint32(0x08)
 	RTMPTypeVideoData                 = uint32(0x09)
+	RTMPTypeAMF3Data                  = uint32(0x0F)
 	RTMPTypeAMF3Command               = uint32(0x11)
 	RTMPTypeAMFData                   = uint32(0x12)
 	RTMPTypeAMF0Command               = uint32(0x14)
@@ -31,5 +32,15 @@ const (
 
 // 用户控制信息 常量字段
 const (
-	UserControlMessageSetBufferLength = uint32(3)
+	UserControlMessageStreamBegin      = uint32(0)
+	UserControlMessageStreamEOF        = uint32(1)
+	UserControlMessageSetBufferLength  = uint32(3)
+	UserControlMessageStreamIsRecorded = uint32(4)
+)
+
+// 对端带宽限制类型
+const (
+	BandwidthLimitHard    = uint32(0) // 按给定带宽限制
+	BandwidthLimitSoft    = uint32(1) // 取给定带宽与当前限制中较小的一个
+	BandwidthLimitDynamic = uint32(2) // 当前限制为hard时视为hard，否则忽略
 )
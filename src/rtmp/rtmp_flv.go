@@ -0,0 +1,46 @@
+package rtmp
+
+import (
+	"../flv"
+)
+
+/*
+
+RTMP消息与FLV标签的转换
+
+音频、视频与AMF0数据消息的类型与FLV标签类型一致，消息数据即为标签数据
+AMF3数据消息以一个格式字节开始，之后为AMF0编码的数据，转换为标签时去除
+
+*/
+
+// MessageFromTag 由FLV标签构造音视频或数据消息，数据引用tag.Data
+func MessageFromTag(tag *flv.Tag, streamID uint32, chunkStreamID uint32) Message {
+	length := uint32(len(tag.Data))
+	return Message{
+		Timestamp:     tag.Timestamp,
+		Type:          uint32(tag.Type),
+		Length:        length,
+		ReadLength:    length,
+		StreamID:      streamID,
+		ChunkStreamID: chunkStreamID,
+		Data:          tag.Data,
+	}
+}
+
+// FLVTag 将音视频或数据消息转换为FLV标签，数据引用msg.Data，其他消息返回false
+func (msg *Message) FLVTag() (flv.Tag, bool) {
+	tag := flv.Tag{Timestamp: msg.Timestamp, Data: msg.Data}
+	switch msg.Type {
+	case RTMPTypeAudioData, RTMPTypeVideoData, RTMPTypeAMFData:
+		tag.Type = uint8(msg.Type)
+	case RTMPTypeAMF3Data:
+		if len(msg.Data) < 1 {
+			return tag, false
+		}
+		tag.Type = flv.TagTypeScript
+		tag.Data = msg.Data[1:]
+	default:
+		return tag, false
+	}
+	return tag, true
+}
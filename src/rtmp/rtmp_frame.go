@@ -0,0 +1,81 @@
+package rtmp
+
+import (
+	"sync"
+)
+
+/*
+
+在流内广播的音视频帧
+
+同一条流的所有拉流端共享一份分块编码结果，每个拉流端只需生成首个chunk的消息头
+
+*/
+
+// frameKey 编码缓存的键，对应拉流端的分块大小与chunk stream id
+type frameKey struct {
+	chunkSize uint32
+	csid      uint32
+}
+
+// Frame 音视频帧，广播后只读
+type Frame struct {
+	Message
+	mutex   sync.Mutex
+	encoded map[frameKey][]byte // 分块编码缓存
+}
+
+// NewFrame 由消息构造一个音视频帧
+func NewFrame(msg Message) *Frame {
+	return &Frame{
+		Message: msg,
+		encoded: make(map[frameKey][]byte, 1),
+	}
+}
+
+// KeyFrame 是否为视频关键帧
+func (frame *Frame) KeyFrame() bool {
+	return isKeyFrame(&frame.Message)
+}
+
+// SequenceHeader 是否为音视频序列头
+func (frame *Frame) SequenceHeader() bool {
+	return isSequenceHeader(&frame.Message)
+}
+
+// VideoMetadata 是否为 Enhanced RTMP 的视频元数据
+func (frame *Frame) VideoMetadata() bool {
+	return isVideoMetadata(&frame.Message)
+}
+
+// Encoded 返回按chunkSize与csid分块后的数据，不包含首个chunk的头部，首次调用时生成并缓存
+func (frame *Frame) Encoded(chunkSize uint32, csid uint32) []byte {
+	defer frame.mutex.Unlock()
+	frame.mutex.Lock()
+
+	key := frameKey{chunkSize, csid}
+	if data, ok := frame.encoded[key]; ok {
+		return data
+	}
+
+	var header [3]byte
+	basic := appendBasicHeader(header[:0], 3, csid)
+	chunks := 0
+	if frame.Length > 0 {
+		chunks = int((frame.Length - 1) / chunkSize)
+	}
+
+	data := make([]byte, 0, int(frame.Length)+chunks*len(basic))
+	for i := uint32(0); i < frame.Length; i += chunkSize {
+		if i > 0 {
+			data = append(data, basic...)
+		}
+		end := i + chunkSize
+		if end > frame.Length {
+			end = frame.Length
+		}
+		data = append(data, frame.Data[i:end]...)
+	}
+	frame.encoded[key] = data
+	return data
+}
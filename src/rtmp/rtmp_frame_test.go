@@ -0,0 +1,48 @@
+package rtmp
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestFrameEncoded 测试共享的分块编码加上首个chunk的头部与逐个chunk写出的结果一致
+func TestFrameEncoded(t *testing.T) {
+	var tests = []struct {
+		chunkSize uint32 // input
+		csid      uint32 // input
+		length    int    // input
+	}{
+		{128, 6, 100},
+		{128, 6, 128},
+		{128, 6, 129},
+		{128, 7, 1000},
+		{4096, 6, 10000},
+		{128, 64, 300},
+		{128, 320, 300},
+	}
+
+	for _, test := range tests {
+		conn, remote := newTestConnect()
+		conn.SendChunkSize = test.chunkSize
+		msg := Message{Timestamp: 40, Type: RTMPTypeVideoData, Length: uint32(test.length), StreamID: 1, ChunkStreamID: test.csid, Data: payload(test.length, 1)}
+
+		expected := make([]byte, 0)
+		for _, chk := range msg.ToChunks(conn) {
+			b, _ := chk.Bytes()
+			expected = append(expected, b...)
+		}
+
+		frame := NewFrame(msg)
+		actual := appendBasicHeader(nil, 0, test.csid)
+		actual = appendMessageHeader(actual, 0, &MessageHeader{Timestamp: msg.Timestamp, MessageLength: msg.Length, MessageType: msg.Type, MessageStreamID: msg.StreamID})
+		actual = append(actual, frame.Encoded(test.chunkSize, test.csid)...)
+		// 相同的分块参数使用缓存
+		frame.Encoded(test.chunkSize, test.csid)
+		if !bytes.Equal(actual, expected) || len(frame.encoded) != 1 {
+			t.Errorf("[×] in: chunk size %d csid %d length %d out: %d bytes expected: %d bytes\n", test.chunkSize, test.csid, test.length, len(actual), len(expected))
+		} else {
+			t.Logf("[√] in: chunk size %d csid %d length %d out: %d bytes expected: %d bytes\n", test.chunkSize, test.csid, test.length, len(actual), len(expected))
+		}
+		remote.Close()
+	}
+}
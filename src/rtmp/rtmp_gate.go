@@ -0,0 +1,104 @@
+package rtmp
+
+import (
+	"net/url"
+
+	"../flv"
+)
+
+/*
+
+拉流端的起播与丢帧控制，RTMP与HTTP-FLV等输出共用
+
+拉流端从关键帧开始接收，起播前先发送流的元数据与序列头，时间戳从起播的关键帧开始计算
+多轨道的流只发送选择的视频与音频轨道，默认为轨道0
+
+*/
+
+// Gate 拉流端的起播与丢帧控制
+type Gate struct {
+	isBegin   bool   // 是否已起播
+	skipping  bool   // 是否正在丢弃音视频帧直到下一个关键帧
+	beginTime uint32 // 起播关键帧的时间戳
+
+	VideoTrack uint8 // 选择的视频轨道
+	AudioTrack uint8 // 选择的音频轨道
+}
+
+// SelectTracks 按播放参数选择视频与音频轨道
+func (gate *Gate) SelectTracks(query url.Values) {
+	gate.VideoTrack = ParseTrackParam(query, VideoTrackParam)
+	gate.AudioTrack = ParseTrackParam(query, AudioTrackParam)
+}
+
+// Pass 判断帧是否需要发送，congested表示发送速率跟不上推流，此时丢弃至下一个关键帧
+// 起播时返回需要在该帧之前发送的元数据与序列头
+func (gate *Gate) Pass(stream *Stream, msg *Message, congested bool) ([]Message, bool) {
+	if (msg.Type == RTMPTypeVideoData && msg.TrackID != gate.VideoTrack) || (msg.Type == RTMPTypeAudioData && msg.TrackID != gate.AudioTrack) {
+		return nil, false
+	}
+	if !gate.isBegin {
+		// 元数据与序列头在起播时从流的缓存中发送，音频帧与非关键帧应该忽略
+		if !isKeyFrame(msg) {
+			return nil, false
+		}
+		gate.isBegin = true
+		gate.beginTime = msg.Timestamp
+		return stream.GetTrackHeaders(gate.VideoTrack, gate.AudioTrack), true
+	}
+
+	if msg.Type == RTMPTypeAMFData || isSequenceHeader(msg) || isVideoMetadata(msg) {
+		return nil, true
+	}
+	if gate.skipping {
+		if !isKeyFrame(msg) {
+			return nil, false
+		}
+		gate.skipping = false
+		return nil, true
+	}
+	if congested {
+		gate.skipping = true
+		return nil, false
+	}
+	return nil, true
+}
+
+// Timestamp 帧相对起播关键帧的时间戳
+func (gate *Gate) Timestamp(msg *Message) uint32 {
+	if msg.Timestamp < gate.beginTime {
+		return 0
+	}
+	return msg.Timestamp - gate.beginTime
+}
+
+// isKeyFrame 是否为视频关键帧，不包括序列头
+func isKeyFrame(msg *Message) bool {
+	if msg.Type != RTMPTypeVideoData {
+		return false
+	}
+	tag, err := flv.ParseVideoTag(msg.Data)
+	return err == nil && tag.KeyFrame()
+}
+
+// isSequenceHeader 是否为AVC/HEVC/AV1/VP9视频序列头或AAC/Opus等音频序列头
+func isSequenceHeader(msg *Message) bool {
+	switch msg.Type {
+	case RTMPTypeVideoData:
+		tag, err := flv.ParseVideoTag(msg.Data)
+		return err == nil && tag.SequenceHeader()
+	case RTMPTypeAudioData:
+		tag, err := flv.ParseAudioTag(msg.Data)
+		return err == nil && tag.SequenceHeader()
+	}
+	return false
+}
+
+// isVideoMetadata 是否为 Enhanced RTMP 的视频元数据，如HDR的colorInfo
+func isVideoMetadata(msg *Message) bool {
+	if msg.Type != RTMPTypeVideoData {
+		return false
+	}
+	tag, err := flv.ParseVideoTag(msg.Data)
+	return err == nil && tag.Metadata()
+}
@@ -156,3 +156,31 @@ func HMACSha256(key []byte, data []byte) []byte {
 	h.Write(data)
 	return h.Sum(nil)
 }
+
+// ClientHandshake 出站连接的简单握手
+func ClientHandshake(conn *Connect) error {
+	// C0 C1
+	C1 := make([]byte, 1536)
+	for i := 8; i < 1536; i++ {
+		C1[i] = byte(rand.Intn(256))
+	}
+	if _, err := conn.Write(append([]byte{3}, C1...)); err != nil {
+		return errors.WithStack(err)
+	}
+
+	// S0 S1 S2
+	S0S1, err := conn.Read(1 + 1536)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if S0S1[0] != 3 {
+		return errors.Errorf("RTMP handshake error: unsupported version %d", S0S1[0])
+	}
+	if _, err := conn.Read(1536); err != nil {
+		return errors.WithStack(err)
+	}
+
+	// C2
+	_, err = conn.Write(S0S1[1:])
+	return errors.WithStack(err)
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 
+	"../flv"
 	"../lib"
 	c "../lib/colorful"
 	"./amf"
@@ -19,56 +20,38 @@ type Message struct {
 	ReadLength    uint32
 	StreamID      uint32
 	ChunkStreamID uint32
+	TrackID       uint8 // Enhanced RTMP 多轨道拆分后的轨道ID
 	Data          []byte
 }
 
 // NewMessage 读入一条message
 func NewMessage(conn *Connect) (Message, error) {
-	chunk, err := NewChunk(conn)
-	if err != nil {
-		return Message{}, errors.WithStack(err)
-	}
-	conn.Seq++
-	if conn.Seq >= conn.RecvWindowAcknowledgementSize {
-		conn.TotalReceive += conn.Seq
-		conn.Seq = 0
-		conn.SendACK()
-	}
-
-	msid := chunk.Message.MessageStreamID
-
-	msg, ok := conn.RecvMessageMap[msid]
-	if ok {
-		// Map中存在,更新该msg
-		msg.Data = lib.ByteArrayConcat(msg.Data, chunk.Data)
-		msg.ReadLength += uint32(len(chunk.Data))
-		if msg.ReadLength >= msg.Length {
-			// 读取完毕,从Map删除
-			delete(conn.RecvMessageMap, msid)
+	for {
+		chunk, err := NewChunk(conn)
+		if err != nil {
+			return Message{}, errors.WithStack(err)
 		}
-	} else {
-		// Map中不存在，生成一个新的Message
-		msg = &Message{
-			Length:        chunk.Message.MessageLength,
-			ReadLength:    uint32(len(chunk.Data)),
-			Data:          chunk.Data,
-			Type:          chunk.Message.MessageType,
-			ChunkStreamID: chunk.Basic.ChunkStreamID,
-			StreamID:      chunk.Message.MessageStreamID,
-			Timestamp:     chunk.Message.Timestamp,
+		if window := conn.RecvWindowAcknowledgementSize; window > 0 && conn.Seq >= window {
+			conn.Seq = 0
+			if err := conn.SendACK(); err != nil {
+				return Message{}, errors.WithStack(err)
+			}
 		}
 
-		if msg.ReadLength < msg.Length {
-			// 未读取完毕,插入到Map中
-			conn.RecvMessageMap[msid] = msg
+		csid := chunk.Basic.ChunkStreamID
+		msg := conn.RecvMessageMap[csid]
+		if msg.ReadLength >= msg.Length {
+			// 读取完毕,从Map删除
+			delete(conn.RecvMessageMap, csid)
+			return *msg, nil
 		}
 	}
-	conn.LastRecvChunk[chunk.Basic.ChunkStreamID] = chunk
+}
 
-	if msg.ReadLength >= msg.Length {
-		return *msg, nil
-	}
-	return NewMessage(conn)
+// Release 将消息数据归还字节池，仅用于数据不再被引用的消息
+func (msg *Message) Release() {
+	lib.PutBuffer(msg.Data)
+	msg.Data = nil
 }
 
 /*
@@ -126,6 +109,7 @@ func (msg *Message) ToChunks(conn *Connect) []Chunk {
 		} else {
 			chk.Basic.Format = 3
 			chk.Basic.ChunkStreamID = msg.ChunkStreamID
+			chk.Message.Timestamp = msg.Timestamp
 		}
 		chk.Data = msg.Data[i:lib.Min(i+conn.SendChunkSize, msg.Length)]
 		chunkList = append(chunkList, chk)
@@ -143,6 +127,7 @@ func (msg *Message) Copy() Message {
 		Length:     msg.Length,
 		ReadLength: msg.ReadLength,
 		StreamID:   msg.StreamID,
+		TrackID:    msg.TrackID,
 		Data:       msg.Data,
 	}
 }
@@ -221,7 +206,13 @@ func (msg *Message) Solve(conn *Connect) error {
 
 // solveSetChunkSize 处理 设置分块大小
 func (msg *Message) solveSetChunkSize(conn *Connect) error {
-	size := lib.ToUint32(msg.Data)
+	if len(msg.Data) < 4 {
+		return errors.New("RTMP set chunk size message too short")
+	}
+	size := lib.ToUint32(msg.Data[0:4])
+	if size == 0 || size&0x80000000 != 0 {
+		return errors.Errorf("RTMP invalid chunk size %d", size)
+	}
 	conn.RecvChunkSize = size
 	log.Println(c.Front("Set Recive Chunk Size %d", c.G, size))
 	return nil
@@ -229,6 +220,9 @@ func (msg *Message) solveSetChunkSize(conn *Connect) error {
 
 // solveUserControlMessage 处理 用户控制消息
 func (msg *Message) solveUserControlMessage(conn *Connect) error {
+	if len(msg.Data) < 10 {
+		return nil
+	}
 	size := lib.ToUint32(msg.Data[0:2])
 	v2 := lib.ToUint32(msg.Data[2:10])
 	log.Println(c.Front("Set Recive Window Acknowledge Size %d", c.G, size, v2))
@@ -251,22 +245,26 @@ func (msg *Message) solveWindowsAcknowledgementSize(conn *Connect) error {
 
 // solveSetPeerBandwidth 处理 设置带宽
 func (msg *Message) solveSetPeerBandwidth(conn *Connect) error {
+	if len(msg.Data) < 5 {
+		return nil
+	}
 	size := lib.ToUint32(msg.Data[0:4])
 	bandwidthType := lib.ToUint32(msg.Data[4:5])
-	conn.RecvBandwidth = size
-	conn.RecvBandwidthType = bandwidthType
 	log.Println(c.Front("Set Bandwidth %d %d", c.G, size, bandwidthType))
+	conn.applyPeerBandwidth(size, bandwidthType)
 	return nil
 }
 
 // solveAudioData 处理 音频数据
 func (msg *Message) solveAudioData(conn *Connect) error {
+	if conn.phase != phasePublish || !conn.checkIngest(len(msg.Data)) {
+		return nil
+	}
 	message, err := MakeMessage(RTMPTypeAudioData, msg.Data, conn.StreamID, msg.ChunkStreamID, msg.Timestamp)
 	if err != nil {
 		return errors.WithStack(err)
 	}
-	conn.TotalTime += message.Timestamp
-	message.Timestamp = conn.TotalTime
+	conn.TotalTime = message.Timestamp
 	conn.WithinStream.Broadcase(message)
 	// log.Println(c.Front("Audio Data", c.G))
 	return nil
@@ -274,12 +272,14 @@ func (msg *Message) solveAudioData(conn *Connect) error {
 
 // solveVideoData 处理 视频数据
 func (msg *Message) solveVideoData(conn *Connect) error {
+	if conn.phase != phasePublish || !conn.checkIngest(len(msg.Data)) {
+		return nil
+	}
 	message, err := MakeMessage(RTMPTypeVideoData, msg.Data, conn.StreamID, msg.ChunkStreamID, msg.Timestamp)
 	if err != nil {
 		return errors.WithStack(err)
 	}
-	conn.TotalTime += message.Timestamp
-	message.Timestamp = conn.TotalTime
+	conn.TotalTime = message.Timestamp
 	conn.WithinStream.Broadcase(message)
 	// log.Println(c.Front("Video Data", c.G))
 	return nil
@@ -291,9 +291,26 @@ func (msg *Message) solveAMFData(conn *Connect) error {
 	if err != nil {
 		return errors.WithStack(err)
 	}
-	AMFArray2 := make([]interface{}, 0)
-	for _, item := range AMFArray {
-		AMFArray2 = append(AMFArray2, item.Value())
+	if conn.phase != phasePublish || len(AMFArray) == 0 {
+		return nil
+	}
+
+	// 推流端通过 @setDataFrame 设置元数据，转发给拉流端时去掉该字段
+	data := msg.Data
+	name, _ := AMFArray[0].Value().(string)
+	if name == "@setDataFrame" && len(AMFArray) > 1 {
+		data = data[AMFArray[0].Length()+1:]
+		name, _ = AMFArray[1].Value().(string)
+	}
+
+	message, err := MakeMessage(RTMPTypeAMFData, data, conn.StreamID, msg.ChunkStreamID, msg.Timestamp)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if name == "onMetaData" {
+		conn.WithinStream.SetMetadata(message)
+	} else {
+		conn.WithinStream.Broadcase(message)
 	}
 	return nil
 }
@@ -346,6 +363,10 @@ func (msg *Message) solveAMFCommand(conn *Connect, AMFType uint32) error {
 		msg.solveFCSubscribe(conn, &amfCommand)
 	case "deleteStream":
 		msg.solveDeleteStream(conn, &amfCommand)
+	case "seek":
+		msg.solveSeek(conn, &amfCommand)
+	case "pause":
+		msg.solvePause(conn, &amfCommand)
 	default:
 		log.Println(c.Front("Unknown AMf command name %s", c.R, amfCommand.CommandName))
 		return nil
@@ -374,11 +395,22 @@ func (msg *Message) solveConnect(conn *Connect, amfCommand *AMFCommand) error {
 
 	log.Println(c.Front("connect %v", c.G, amfCommand))
 
-	err := conn.SendWinACKSize(524288)
+	cfg := conn.WithinServer.Config
+	app, ok := cfg.Application(conn.AppName)
+	if !ok {
+		log.Println(c.Front("Unknown application %s", c.R, conn.AppName))
+		return msg.rejectConnect(conn, amfCommand, "Unknown application "+conn.AppName)
+	}
+	if conn.WithinServer.Closing() {
+		return msg.rejectConnect(conn, amfCommand, "Server is shutting down")
+	}
+	conn.App = app
+
+	err := conn.SendWinACKSize(cfg.RTMP.WindowAckSize)
 	if err != nil {
 		return errors.WithStack(err)
 	}
-	err = conn.SendSetPeerBandwidth(524288)
+	err = conn.SendSetPeerBandwidth(cfg.RTMP.PeerBandwidth, cfg.RTMP.PeerBandwidthType)
 	if err != nil {
 		return errors.WithStack(err)
 	}
@@ -402,6 +434,8 @@ func (msg *Message) solveConnect(conn *Connect, amfCommand *AMFCommand) error {
 			"capabilities": 31,
 			"Author":       "Donview",
 			"fmsVer":       "Donview/1.0",
+			// Enhanced RTMP 支持的视频编码
+			"fourCcList": flv.VideoFourCCs,
 		},
 		map[string]interface{}{
 			"level":          "status",
@@ -415,6 +449,25 @@ func (msg *Message) solveConnect(conn *Connect, amfCommand *AMFCommand) error {
 	return nil
 }
 
+// rejectConnect 拒绝 connect命令并关闭连接
+func (msg *Message) rejectConnect(conn *Connect, amfCommand *AMFCommand, description string) error {
+	err := conn.SendResponse(AMFCommand{
+		"_error",
+		amfCommand.TransactionID,
+		nil,
+		map[string]interface{}{
+			"level":       "error",
+			"code":        "NetConnection.Connect.Rejected",
+			"description": description,
+		},
+	}, 0, msg.ChunkStreamID)
+	conn.CloseServer()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
 // solveReleaseStream 处理 releaseStream命令
 func (msg *Message) solveReleaseStream(conn *Connect, amfCommand *AMFCommand) error {
 	streamName, ok := amfCommand.OptionalUserArguments.(string)
@@ -424,9 +477,17 @@ func (msg *Message) solveReleaseStream(conn *Connect, amfCommand *AMFCommand) er
 
 	log.Println(c.Front("releaseStram(%s) %v", c.G, streamName, amfCommand))
 
+	// 与推流相同的鉴权，避免未授权的连接断开正在推流的推流端
+	streamName, query := parseStreamName(streamName)
+	if conn.App == nil || !conn.App.AllowPublish() || !conn.authorize(true, query) {
+		log.Println(c.Front("releaseStream(%s) denied", c.R, streamName))
+		return nil
+	}
 	fullName := fmt.Sprintf("%s/%s", conn.AppName, streamName)
 
-	conn.WithinServer.GetStream(fullName).CloseAll()
+	if stream, ok := conn.WithinServer.FindStream(fullName); ok {
+		stream.CloseAll()
+	}
 
 	return nil
 }
@@ -440,13 +501,60 @@ func (msg *Message) solveFCPublish(conn *Connect, amfCommand *AMFCommand) error
 
 	log.Println(c.Front("FCPublish(%s) %v", c.G, streamName, amfCommand))
 
-	conn.StreamName = streamName
-	conn.FullName = fmt.Sprintf("%s/%s", conn.AppName, streamName)
+	conn.startPublish(streamName, msg.StreamID)
+	return nil
+}
 
-	conn.WithinStream = conn.WithinServer.GetStream(conn.FullName)
-	conn.WithinStream.AddPublisher(conn)
+// startPublish 开始推流，校验推流权限并加入对应的流，已在推流时直接返回
+func (conn *Connect) startPublish(streamName string, streamID uint32) bool {
+	if conn.WithinStream != nil {
+		return true
+	}
 
-	return nil
+	streamName, query := parseStreamName(streamName)
+	fullName := fmt.Sprintf("%s/%s", conn.AppName, streamName)
+	if !ValidStreamName(fullName) {
+		// 流名称会用作HLS分片与录制文件的路径
+		log.Println(c.Front("publish(%q) denied: invalid stream name", c.R, fullName))
+		conn.SendStatus(streamID, "error", "NetStream.Publish.BadName", "Invalid stream name")
+		conn.CloseServer()
+		return false
+	}
+	if conn.App == nil || !conn.App.AllowPublish() || !conn.authorize(true, query) {
+		log.Println(c.Front("publish(%s) denied", c.R, streamName))
+		conn.SendStatus(streamID, "error", "NetStream.Publish.Denied", "Publish denied")
+		conn.CloseServer()
+		return false
+	}
+
+	stream := conn.WithinServer.GetStream(fullName)
+	err := conn.WithinServer.AddPublisher(stream, conn)
+	if err == errStreamRemoved {
+		// 流在加入推流端前因空闲被移除，重新获取
+		stream = conn.WithinServer.GetStream(fullName)
+		err = conn.WithinServer.AddPublisher(stream, conn)
+	}
+	if err != nil {
+		log.Println(c.Front("publish(%s) rejected: %v", c.R, streamName, err))
+		if err == errTooManyPublishers {
+			conn.WithinServer.Stats.AddRejection(RejectMaxPublishers)
+		}
+		conn.SendStatus(streamID, "error", "NetStream.Publish.Denied", "Publish rejected: "+err.Error())
+		conn.CloseServer()
+		return false
+	}
+
+	conn.StreamName = streamName
+	conn.FullName = fullName
+	conn.phase = phasePublish
+	conn.WithinStream = stream
+
+	if bitrate, action := conn.App.BitrateLimit(conn.WithinServer.Config.Limits); bitrate > 0 {
+		rate := bitrate * 1000 / 8
+		conn.ingest = lib.NewTokenBucket(rate, rate*IngestBurst)
+		conn.ingestAction = action
+	}
+	return true
 }
 
 // solveCreateStream 处理 createStream命令
@@ -475,7 +583,16 @@ func (msg *Message) solveCreateStream(conn *Connect, amfCommand *AMFCommand) err
 
 // solvePublish 处理 publish命令
 func (msg *Message) solvePublish(conn *Connect, amfCommand *AMFCommand) error {
-	log.Println(c.Front("createStream() %v", c.G, amfCommand))
+	log.Println(c.Front("publish() %v", c.G, amfCommand))
+
+	streamName, ok := amfCommand.OptionalUserArguments.(string)
+	if !ok {
+		return errors.WithStack(errors.Errorf("RTMP publish 格式错误"))
+	}
+	if !conn.startPublish(streamName, msg.StreamID) {
+		return nil
+	}
+	conn.PublishStreamID = msg.StreamID
 
 	err := conn.SendResponse(AMFCommand{
 		"onStatus",
@@ -507,7 +624,7 @@ func (msg *Message) solveFCUnpublish(conn *Connect, amfCommand *AMFCommand) erro
 	return nil
 }
 
-// solveGetStreamLength 处理 getStreamLength命令
+// solveGetStreamLength 处理 getStreamLength命令，返回点播文件的时长，直播流为0
 func (msg *Message) solveGetStreamLength(conn *Connect, amfCommand *AMFCommand) error {
 
 	streamName, ok := amfCommand.OptionalUserArguments.(string)
@@ -517,9 +634,36 @@ func (msg *Message) solveGetStreamLength(conn *Connect, amfCommand *AMFCommand)
 
 	log.Println(c.Front("getStreamLength(%s) %v", c.G, streamName, amfCommand))
 
+	var duration float64
+	streamName, _ = parseStreamName(streamName)
+	if conn.App != nil && conn.App.VOD != "" {
+		if file, err := openVODFile(vodPath(conn.App.VOD, streamName)); err == nil {
+			duration = float64(file.duration) / 1000
+			file.close()
+		}
+	}
+
+	err := conn.SendResponse(AMFCommand{
+		"_result",
+		amfCommand.TransactionID,
+		nil,
+		duration,
+	}, msg.StreamID, msg.ChunkStreamID)
+	if err != nil {
+		return errors.WithStack(err)
+	}
 	return nil
 }
 
+// commandArgument 命令中的第index个值，从命令名称开始计数，不存在时返回nil
+func (msg *Message) commandArgument(index int) interface{} {
+	array, err := amf.ByteToAMFArray(msg.Data)
+	if err != nil || index >= len(array) {
+		return nil
+	}
+	return array[index].Value()
+}
+
 // solvePlay 处理 play命令
 func (msg *Message) solvePlay(conn *Connect, amfCommand *AMFCommand) error {
 
@@ -530,10 +674,39 @@ func (msg *Message) solvePlay(conn *Connect, amfCommand *AMFCommand) error {
 
 	log.Println(c.Front("play(%s) %v", c.G, streamName, amfCommand))
 
+	cfg := conn.WithinServer.Config
+	streamName, query := parseStreamName(streamName)
+	if !cfg.Outputs.RTMP.Enable || conn.App == nil || !conn.App.AllowPlay() || !conn.authorize(false, query) {
+		log.Println(c.Front("play(%s) denied", c.R, streamName))
+		err := conn.SendStatus(conn.StreamID, "error", "NetStream.Play.Failed", "Play denied")
+		conn.CloseServer()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		return nil
+	}
+
 	conn.StreamName = streamName
 	conn.FullName = fmt.Sprintf("%s/%s", conn.AppName, streamName)
 
-	err := conn.SendSetChunkSize(512)
+	if conn.App.VOD != "" {
+		return msg.playVOD(conn)
+	}
+
+	// 流不存在且不会回源时不新建流
+	stream, ok := conn.WithinServer.PlayStream(conn.App, conn.AppName, streamName)
+	if !ok {
+		return msg.playNotFound(conn, streamName)
+	}
+	// 为回源新建的流没有加入拉流端时随之移除
+	defer stream.release()
+	// 先检查拉流端数量，避免在发送Play.Start后才拒绝
+	limit := conn.App.ViewerLimit(cfg.Limits)
+	if limit > 0 && stream.CountReceivers() >= limit {
+		return msg.rejectPlay(conn, streamName)
+	}
+
+	err := conn.SendSetChunkSize(cfg.RTMP.ChunkSize)
 	if err != nil {
 		return errors.WithStack(err)
 	}
@@ -577,11 +750,90 @@ func (msg *Message) solvePlay(conn *Connect, amfCommand *AMFCommand) error {
 		return errors.WithStack(err)
 	}
 
-	stream := conn.WithinServer.GetStream(conn.FullName)
+	conn.Writer.SelectTracks(query)
+	if !stream.AddReceiver(conn, limit) {
+		if stream.Removed() {
+			// 检查后推流端已离开，流被移除
+			return msg.playNotFound(conn, streamName)
+		}
+		// 检查后有其他拉流端加入
+		return msg.rejectPlay(conn, streamName)
+	}
 	conn.WithinStream = stream
+	conn.phase = phasePlay
+	conn.WithinServer.NotifyPlay(stream)
 
-	stream.AddReceiver(conn)
+	return nil
+}
+
+// playVOD 点播应用的 play命令，start与duration单位为秒，start小于0时从头播放，duration小于0时播放到文件结束
+func (msg *Message) playVOD(conn *Connect) error {
+	if conn.vod != nil {
+		return nil
+	}
+	file, err := openVODFile(vodPath(conn.App.VOD, conn.StreamName))
+	if err != nil {
+		log.Println(c.Front("play(%s) not found: %v", c.R, conn.StreamName, err))
+		return errors.WithStack(conn.SendStatus(conn.StreamID, "error", "NetStream.Play.StreamNotFound", "Stream not found"))
+	}
 
+	var start, duration uint32
+	if value, ok := msg.commandArgument(4).(float64); ok && value > 0 {
+		start = uint32(value * 1000)
+	}
+	if value, ok := msg.commandArgument(5).(float64); ok && value > 0 {
+		duration = uint32(value * 1000)
+	}
+	reset := true
+	if value, ok := msg.commandArgument(6).(bool); ok {
+		reset = value
+	}
+
+	cfg := conn.WithinServer.Config
+	err = conn.SendSetChunkSize(cfg.RTMP.ChunkSize)
+	if err == nil {
+		err = conn.SendStreamIsRecord(conn.StreamID)
+	}
+	if err == nil {
+		err = conn.SendStreamBegin(conn.StreamID)
+	}
+	if err == nil && reset {
+		err = conn.SendStatus(conn.StreamID, "status", "NetStream.Play.Reset", "Playing and resetting "+conn.FullName)
+	}
+	if err == nil {
+		err = conn.SendStatus(conn.StreamID, "status", "NetStream.Play.Start", "Started playing "+conn.FullName)
+	}
+	if err != nil {
+		file.close()
+		return errors.WithStack(err)
+	}
+
+	log.Println(c.Front("VOD %s from %dms", c.G, file.name, start))
+	conn.phase = phasePlay
+	conn.vod = newVODPlayer(conn, file, start, duration)
+	return nil
+}
+
+// rejectPlay 拉流端达到上限时拒绝 play命令并关闭连接
+func (msg *Message) rejectPlay(conn *Connect, streamName string) error {
+	log.Println(c.Front("play(%s) rejected: too many viewers", c.R, streamName))
+	conn.WithinServer.Stats.AddRejection(RejectMaxViewers)
+	err := conn.SendStatus(conn.StreamID, "error", "NetStream.Play.Failed", "Too many viewers")
+	conn.CloseServer()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// playNotFound 流不存在时拒绝拉流
+func (msg *Message) playNotFound(conn *Connect, streamName string) error {
+	log.Println(c.Front("play(%s) not found", c.R, streamName))
+	err := conn.SendStatus(conn.StreamID, "error", "NetStream.Play.StreamNotFound", "Stream not found")
+	conn.CloseServer()
+	if err != nil {
+		return errors.WithStack(err)
+	}
 	return nil
 }
 
@@ -598,6 +850,43 @@ func (msg *Message) solveFCSubscribe(conn *Connect, amfCommand *AMFCommand) erro
 	return nil
 }
 
+// solveSeek 处理 seek命令，仅点播有效，位置单位为毫秒
+func (msg *Message) solveSeek(conn *Connect, amfCommand *AMFCommand) error {
+	log.Println(c.Front("seek() %v", c.G, amfCommand))
+
+	position, ok := amfCommand.OptionalUserArguments.(float64)
+	if !ok {
+		return errors.WithStack(errors.Errorf("RTMP seek 格式错误"))
+	}
+	if conn.vod == nil {
+		return errors.WithStack(conn.SendStatus(conn.StreamID, "error", "NetStream.Seek.Failed", "Seek is not supported by live streams"))
+	}
+	if position < 0 {
+		position = 0
+	}
+	conn.vod.Seek(uint32(position))
+	return nil
+}
+
+// solvePause 处理 pause命令，仅点播有效，继续播放的位置单位为毫秒
+func (msg *Message) solvePause(conn *Connect, amfCommand *AMFCommand) error {
+	log.Println(c.Front("pause() %v", c.G, amfCommand))
+
+	pause, ok := amfCommand.OptionalUserArguments.(bool)
+	if !ok {
+		return errors.WithStack(errors.Errorf("RTMP pause 格式错误"))
+	}
+	if conn.vod == nil {
+		return errors.WithStack(conn.SendStatus(conn.StreamID, "error", "NetStream.Pause.Failed", "Pause is not supported by live streams"))
+	}
+	position, _ := msg.commandArgument(4).(float64)
+	if position < 0 {
+		position = 0
+	}
+	conn.vod.Pause(pause, uint32(position))
+	return nil
+}
+
 // solveDeleteStream 处理 deleteStream命令
 func (msg *Message) solveDeleteStream(conn *Connect, amfCommand *AMFCommand) error {
 	log.Println(c.Front("deleteStream() %v", c.G, amfCommand))
@@ -0,0 +1,77 @@
+package rtmp
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+/*
+
+不阻塞推流端的订阅者发送队列，用于HTTP-FLV、HLS等输出
+
+*/
+
+// Queue 音视频帧发送队列，队列满时丢弃音视频帧直到下一个关键帧
+type Queue struct {
+	Frames   chan *Frame
+	done     chan struct{}
+	once     sync.Once
+	skipping bool // 是否正在丢弃音视频帧，仅在广播时访问
+
+	OnDrop func(frame *Frame) // 丢弃音视频帧时在广播的协程中调用，需在加入流之前设置
+}
+
+// NewQueue 新建发送队列，size为队列长度
+func NewQueue(size int) *Queue {
+	return &Queue{
+		Frames: make(chan *Frame, size),
+		done:   make(chan struct{}),
+	}
+}
+
+// SendFrame 将音视频帧加入发送队列，队列满时丢弃而不阻塞推流端
+func (queue *Queue) SendFrame(frame *Frame) error {
+	select {
+	case <-queue.done:
+		return errors.New("Queue closed")
+	default:
+	}
+
+	if queue.skipping && !frame.KeyFrame() {
+		queue.drop(frame)
+		return nil
+	}
+	select {
+	case queue.Frames <- frame:
+		queue.skipping = false
+	default:
+		queue.skipping = true
+		queue.drop(frame)
+	}
+	return nil
+}
+
+// drop 记录丢弃的音视频帧
+func (queue *Queue) drop(frame *Frame) {
+	if queue.OnDrop != nil {
+		queue.OnDrop(frame)
+	}
+}
+
+// NotifyUnpublish 流即将结束，关闭队列
+func (queue *Queue) NotifyUnpublish() {
+	queue.CloseServer()
+}
+
+// CloseServer 关闭队列，已加入队列的音视频帧仍可读出
+func (queue *Queue) CloseServer() {
+	queue.once.Do(func() {
+		close(queue.done)
+	})
+}
+
+// Done 队列关闭时关闭的channel
+func (queue *Queue) Done() <-chan struct{} {
+	return queue.done
+}
@@ -1,32 +1,45 @@
 package rtmp
 
 import (
+	"context"
 	"database/sql"
 	"log"
+	"strings"
 	"sync"
+	"time"
 
+	"../config"
 	c "../lib/colorful"
+	"github.com/pkg/errors"
 	// sql
 	_ "github.com/go-sql-driver/mysql"
 )
 
 // Server RTMP服务
 type Server struct {
+	Config    *config.Config
+	Stats     *Stats
 	db        *sql.DB
 	streamMap map[string]*Stream
 	mutex     *sync.Mutex
+	closing   bool // 是否正在关闭服务
+
+	publishHooks []func(stream *Stream) // 推流开始时调用
+	playHooks    []func(stream *Stream) // 拉流端加入没有推流端的流时调用
 }
 
-// NewServer 新建一个服务
-func NewServer(db *sql.DB) Server {
+// NewServer 新建一个服务，db为空时不登记推流信息
+func NewServer(cfg *config.Config, db *sql.DB) Server {
 	return Server{
+		Config:    cfg,
+		Stats:     NewStats(),
 		db:        db,
 		streamMap: map[string]*Stream{},
 		mutex:     &sync.Mutex{},
 	}
 }
 
-// GetStream 有一条新的流被建立，获取对应的流信息
+// GetStream 获取流，流不存在时新建，仅用于推流与回源，拉流端使用PlayStream
 func (server *Server) GetStream(streamName string) *Stream {
 	defer server.mutex.Unlock()
 	server.mutex.Lock()
@@ -34,21 +47,162 @@ func (server *Server) GetStream(streamName string) *Stream {
 	stream, ok := server.streamMap[streamName]
 	if !ok {
 		stream = NewStream(streamName)
-		 server.streamMap[streamName]= stream
+		stream.server = server
+		server.streamMap[streamName] = stream
 	}
 
 	return stream
 }
 
-// ExecSQL 执行SQL语句
-func (server *Server) ExecSQL(sqlStr string, args ...interface{}) {
+// FindStream 获取已存在的流，不新建流
+func (server *Server) FindStream(streamName string) (*Stream, bool) {
+	defer server.mutex.Unlock()
+	server.mutex.Lock()
+
+	stream, ok := server.streamMap[streamName]
+	return stream, ok
+}
+
+// PlayStream 获取拉流端要加入的流，流不存在且不会回源时返回false，避免为任意请求的名称新建流
+func (server *Server) PlayStream(app *config.Application, appName string, streamName string) (*Stream, bool) {
+	fullName := appName + "/" + streamName
+	if server.Config.Pull.Enable && app.PullStream(streamName) && ValidStreamName(fullName) {
+		return server.GetStream(fullName), true
+	}
+	return server.FindStream(fullName)
+}
+
+// removeStream 流空闲时从流列表中移除
+func (server *Server) removeStream(stream *Stream) {
+	defer server.mutex.Unlock()
+	server.mutex.Lock()
+
+	if server.streamMap[stream.Name] == stream && stream.remove() {
+		delete(server.streamMap, stream.Name)
+	}
+}
+
+// OnPublish 注册推流开始时调用的函数，用于为流增加HLS等封装输出，需在服务启动前调用
+func (server *Server) OnPublish(hook func(stream *Stream)) {
+	server.publishHooks = append(server.publishHooks, hook)
+}
+
+// OnPlay 注册拉流端加入没有推流端的流时调用的函数，用于从源站回源，需在服务启动前调用
+func (server *Server) OnPlay(hook func(stream *Stream)) {
+	server.playHooks = append(server.playHooks, hook)
+}
+
+// NotifyPlay 拉流端加入流后调用，流没有推流端时调用注册的函数
+func (server *Server) NotifyPlay(stream *Stream) {
+	if stream.HasPublisher() {
+		return
+	}
+	for _, hook := range server.playHooks {
+		hook(stream)
+	}
+}
+
+// 推流端加入流失败的原因
+var (
+	errTooManyPublishers = errors.New("too many publishers")
+	errStreamRemoved     = errors.New("stream removed")
+)
+
+// AddPublisher 在应用内推流端未达到上限时将连接作为推流端加入流，流在加入前因空闲被移除时返回errStreamRemoved
+// 加锁顺序为server.mutex、stream.mutex，检查数量与加入流在同一次加锁中完成，登记推流信息与推流回调不持有server.mutex
+func (server *Server) AddPublisher(stream *Stream, conn *Connect) error {
+	server.mutex.Lock()
+	limit := conn.App.PublisherLimit(server.Config.Limits)
+	full := limit > 0 && server.countPublishers(conn.AppName) >= limit
+	added := !full && stream.AddPublisher(conn)
+	server.mutex.Unlock()
+	if full {
+		// 为推流新建的流没有加入推流端时随之移除
+		stream.release()
+		return errTooManyPublishers
+	}
+	if !added {
+		return errStreamRemoved
+	}
+
+	server.ExecSQL("REPLACE INTO connect(`url`,`datetime`) VALUES(?,NOW())", stream.Name)
+	for _, hook := range server.publishHooks {
+		hook(stream)
+	}
+	return nil
+}
+
+// countPublishers 统计应用内正在推流的流数量
+func (server *Server) countPublishers(appName string) int {
+	prefix := appName + "/"
+	count := 0
+	for name, stream := range server.streamMap {
+		if strings.HasPrefix(name, prefix) && stream.HasPublisher() {
+			count++
+		}
+	}
+	return count
+}
+
+// Closing 服务是否正在关闭，关闭过程中拒绝新的连接
+func (server *Server) Closing() bool {
 	defer server.mutex.Unlock()
 	server.mutex.Lock()
 
+	return server.closing
+}
+
+// Shutdown 关闭服务，通知所有推流端和拉流端流即将结束，并等待连接断开直到ctx结束
+// ctx结束时强制断开剩余的连接，使HLS、录制等输出可以随推流端结束
+func (server *Server) Shutdown(ctx context.Context) error {
+	server.mutex.Lock()
+	server.closing = true
+	streams := make([]*Stream, 0, len(server.streamMap))
+	for _, stream := range server.streamMap {
+		streams = append(streams, stream)
+	}
+	server.mutex.Unlock()
+
+	for _, stream := range streams {
+		stream.NotifyUnpublish()
+	}
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		active := 0
+		for _, stream := range streams {
+			if stream.Active() {
+				active++
+			}
+		}
+		if active == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			log.Println(c.Front("Shutdown with %d active streams, closing", c.Y, active))
+			for _, stream := range streams {
+				stream.CloseAll()
+			}
+			return errors.WithStack(ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// ExecSQL 执行SQL语句，sql.DB可以并发使用，调用时不能持有server.mutex或stream.mutex
+func (server *Server) ExecSQL(sqlStr string, args ...interface{}) {
+	if server.db == nil {
+		return
+	}
+
 	stmt, er := server.db.Prepare(sqlStr)
 	if er != nil {
 		log.Println(c.Front("%s", c.R, er))
+		return
 	}
+	defer stmt.Close()
 	_, er = stmt.Exec(args...)
 	if er != nil {
 		log.Println(c.Front("%s", c.R, er))
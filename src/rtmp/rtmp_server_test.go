@@ -0,0 +1,160 @@
+package rtmp
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"../config"
+	"github.com/pkg/errors"
+)
+
+// TestAddPublisher 测试应用内推流端数量的限制，超出限制时为推流新建的流随之移除
+func TestAddPublisher(t *testing.T) {
+	var tests = []struct {
+		limit    int      // input: 每个应用的推流端数
+		in       []string // input: +name 推流，-name 推流端断开
+		expected string   // expected: 每次推流的结果，o成功，x超出限制
+		streams  int      // expected: 最终保留的流数量
+	}{
+		{0, []string{"+live/a", "+live/b", "+live/c"}, "ooo", 3},
+		{1, []string{"+live/a", "+live/b"}, "ox", 1},
+		{1, []string{"+live/a", "+tv/a", "+live/b"}, "oox", 2},
+		{1, []string{"+live/a", "-live/a", "+live/b"}, "oo", 1},
+		{2, []string{"+live/a", "+live/b", "+live/c", "-live/b", "+live/c"}, "ooxo", 2},
+	}
+
+	for _, test := range tests {
+		cfg := config.Default()
+		cfg.Limits.MaxPublishers = test.limit
+		server := NewServer(cfg, nil)
+		publishers := make(map[string]*Connect)
+		actual := ""
+		for _, op := range test.in {
+			name := op[1:]
+			if op[0] == '-' {
+				server.GetStream(name).DelConnect(publishers[name])
+				continue
+			}
+			conn, remote := newTestConnect()
+			defer remote.Close()
+			conn.WithinServer = &server
+			conn.App = &config.Application{}
+			conn.AppName = strings.Split(name, "/")[0]
+			if err := server.AddPublisher(server.GetStream(name), conn); err != nil {
+				actual += "x"
+			} else {
+				publishers[name] = conn
+				actual += "o"
+			}
+		}
+		streams := len(server.streamStats())
+		if actual != test.expected || streams != test.streams {
+			t.Errorf("[×] in: %d %v out: %s %d expected: %s %d\n", test.limit, test.in, actual, streams, test.expected, test.streams)
+		} else {
+			t.Logf("[√] in: %d %v out: %s %d expected: %s %d\n", test.limit, test.in, actual, streams, test.expected, test.streams)
+		}
+	}
+}
+
+// TestShutdown 测试关闭服务时等待推流端断开，期间拒绝新的连接，ctx结束时强制断开剩余的推流端
+func TestShutdown(t *testing.T) {
+	var tests = []struct {
+		in       string        // input: leave 排空期间推流端断开，stay 推流端一直不断开
+		timeout  time.Duration // input: 等待排空的时间
+		expected error         // expected error
+	}{
+		{"leave", 5 * time.Second, nil},
+		{"stay", 100 * time.Millisecond, context.DeadlineExceeded},
+	}
+
+	for _, test := range tests {
+		publisher, remote := newTestConnect()
+		defer remote.Close()
+		server := publisher.WithinServer
+		stream := server.GetStream("live/a")
+		stream.AddPublisher(publisher)
+
+		ctx, cancel := context.WithTimeout(context.Background(), test.timeout)
+		defer cancel()
+		result := make(chan error, 1)
+		go func() {
+			result <- server.Shutdown(ctx)
+		}()
+
+		// 排空期间新的连接在connect时被拒绝
+		for deadline := time.Now().Add(time.Second); !server.Closing() && time.Now().Before(deadline); {
+			time.Sleep(5 * time.Millisecond)
+		}
+		conn, other := newTestConnect()
+		defer other.Close()
+		conn.WithinServer = server
+		msg := Message{}
+		msg.solveConnect(conn, &AMFCommand{CommandName: "connect", TransactionID: 1, CommandObject: map[string]interface{}{"app": "live"}})
+		if !conn.Closed() {
+			t.Errorf("[×] in: %s connect out: accepted expected: rejected\n", test.in)
+		}
+
+		if test.in == "leave" {
+			stream.DelConnect(publisher)
+		}
+		var err error
+		select {
+		case err = <-result:
+		case <-time.After(5 * time.Second):
+			err = errors.New("blocked")
+		}
+		if errors.Cause(err) != test.expected || !publisher.Closed() {
+			t.Errorf("[×] in: %s out: %v %v expected: %v true\n", test.in, err, publisher.Closed(), test.expected)
+		} else {
+			t.Logf("[√] in: %s out: %v %v expected: %v true\n", test.in, err, publisher.Closed(), test.expected)
+		}
+	}
+}
+
+// TestAddPublisherConcurrent 测试并发推流时应用内推流端数量不超出限制
+func TestAddPublisherConcurrent(t *testing.T) {
+	var tests = []struct {
+		limit    int // input: 每个应用的推流端数
+		in       int // input: 同时推流的不同流数量
+		expected int // expected: 推流成功的数量
+	}{
+		{1, 64, 1},
+		{3, 64, 3},
+		{0, 64, 64},
+	}
+
+	for _, test := range tests {
+		cfg := config.Default()
+		cfg.Limits.MaxPublishers = test.limit
+		server := NewServer(cfg, nil)
+		start := make(chan struct{})
+		results := make(chan error, test.in)
+		for i := 0; i < test.in; i++ {
+			conn, remote := newTestConnect()
+			defer remote.Close()
+			conn.WithinServer = &server
+			conn.App = &config.Application{}
+			conn.AppName = "live"
+			name := fmt.Sprintf("live/%d", i)
+			go func() {
+				<-start
+				results <- server.AddPublisher(server.GetStream(name), conn)
+			}()
+		}
+		close(start)
+		actual := 0
+		for i := 0; i < test.in; i++ {
+			if <-results == nil {
+				actual++
+			}
+		}
+		if actual != test.expected {
+			t.Errorf("[×] in: %d %d out: %d expected: %d\n", test.limit, test.in, actual, test.expected)
+		} else {
+			t.Logf("[√] in: %d %d out: %d expected: %d\n", test.limit, test.in, actual, test.expected)
+		}
+	}
+}
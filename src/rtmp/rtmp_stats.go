@@ -0,0 +1,173 @@
+package rtmp
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	c "../lib/colorful"
+)
+
+/*
+
+服务统计
+
+通过http监听端口的统计接口或周期性的日志输出，如 GET /stats
+
+*/
+
+// 拒绝推流或拉流的原因
+const (
+	RejectMaxPublishers = "max publishers"
+	RejectMaxViewers    = "max viewers"
+)
+
+// Stats 服务统计计数
+type Stats struct {
+	mutex      sync.Mutex
+	evictions  map[string]uint64 // 按原因统计的连接驱逐次数
+	rejections map[string]uint64 // 按原因统计的连接拒绝次数
+	warnings   map[string]uint64 // 按原因统计的告警次数
+}
+
+// NewStats 新建统计计数
+func NewStats() *Stats {
+	return &Stats{
+		evictions:  make(map[string]uint64),
+		rejections: make(map[string]uint64),
+		warnings:   make(map[string]uint64),
+	}
+}
+
+// AddEviction 记录一次连接驱逐
+func (stats *Stats) AddEviction(reason string) {
+	defer stats.mutex.Unlock()
+	stats.mutex.Lock()
+
+	stats.evictions[reason]++
+}
+
+// AddRejection 记录一次因超出限制而拒绝的连接
+func (stats *Stats) AddRejection(reason string) {
+	defer stats.mutex.Unlock()
+	stats.mutex.Lock()
+
+	stats.rejections[reason]++
+}
+
+// AddWarning 记录一次告警
+func (stats *Stats) AddWarning(reason string) {
+	defer stats.mutex.Unlock()
+	stats.mutex.Lock()
+
+	stats.warnings[reason]++
+}
+
+// Snapshot 返回当前统计数据的副本
+func (stats *Stats) Snapshot() map[string]interface{} {
+	defer stats.mutex.Unlock()
+	stats.mutex.Lock()
+
+	return map[string]interface{}{
+		"evictions":  copyCounter(stats.evictions),
+		"rejections": copyCounter(stats.rejections),
+		"warnings":   copyCounter(stats.warnings),
+	}
+}
+
+// copyCounter 复制计数表
+func copyCounter(counter map[string]uint64) map[string]uint64 {
+	result := make(map[string]uint64, len(counter))
+	for key, value := range counter {
+		result[key] = value
+	}
+	return result
+}
+
+// StreamStats 单条流的统计
+type StreamStats struct {
+	Name       string `json:"name"`
+	Publishing bool   `json:"publishing"` // 是否有推流端
+	Viewers    int    `json:"viewers"`    // 拉流端数量
+
+	Video *VideoStats `json:"video,omitempty"` // 还没有收到可以解析的视频序列头时为空
+	Audio *AudioStats `json:"audio,omitempty"` // 还没有收到可以解析的音频序列头或音频帧时为空
+}
+
+// StatsSnapshot 返回服务统计计数与每条流的统计
+func (server *Server) StatsSnapshot() map[string]interface{} {
+	snapshot := server.Stats.Snapshot()
+	snapshot["streams"] = server.streamStats()
+	return snapshot
+}
+
+// streamStats 按名称排序的每条流的统计
+func (server *Server) streamStats() []StreamStats {
+	server.mutex.Lock()
+	streams := make([]*Stream, 0, len(server.streamMap))
+	for _, stream := range server.streamMap {
+		streams = append(streams, stream)
+	}
+	server.mutex.Unlock()
+
+	result := make([]StreamStats, 0, len(streams))
+	for _, stream := range streams {
+		result = append(result, stream.Stats())
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// Stats 当前流的统计
+func (stream *Stream) Stats() StreamStats {
+	stats := StreamStats{
+		Name:       stream.Name,
+		Publishing: stream.HasPublisher(),
+		Viewers:    stream.CountReceivers(),
+	}
+	if video, ok := stream.VideoStats(); ok {
+		stats.Video = &video
+	}
+	if audio, ok := stream.AudioStats(); ok {
+		stats.Audio = &audio
+	}
+	return stats
+}
+
+// StatsHandler 统计接口，以JSON格式返回服务统计
+func (server *Server) StatsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-cache")
+		json.NewEncoder(w).Encode(server.StatsSnapshot())
+	})
+}
+
+// LogStats 每隔interval在日志中输出一次服务统计，直到ctx结束
+func (server *Server) LogStats(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		data, err := json.Marshal(server.StatsSnapshot())
+		if err != nil {
+			continue
+		}
+		log.Println(c.Front("Stats %s", c.G, string(data)))
+	}
+}
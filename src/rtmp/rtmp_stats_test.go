@@ -0,0 +1,55 @@
+package rtmp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"../config"
+)
+
+// TestStatsHandler 测试统计接口的输出
+func TestStatsHandler(t *testing.T) {
+	server := NewServer(config.Default(), nil)
+	server.GetStream("live/b")
+	server.GetStream("live/a")
+	server.Stats.AddEviction("command timeout")
+	server.Stats.AddEviction("command timeout")
+	server.Stats.AddRejection(RejectMaxViewers)
+
+	var tests = []struct {
+		in       string // input
+		expected int    // expected status
+	}{
+		{http.MethodGet, http.StatusOK},
+		{http.MethodPost, http.StatusMethodNotAllowed},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		server.StatsHandler().ServeHTTP(w, httptest.NewRequest(test.in, "/stats", nil))
+		if w.Code != test.expected {
+			t.Errorf("[×] in: %s out: %d expected: %d\n", test.in, w.Code, test.expected)
+			continue
+		}
+		if w.Code != http.StatusOK {
+			t.Logf("[√] in: %s out: %d expected: %d\n", test.in, w.Code, test.expected)
+			continue
+		}
+
+		var actual struct {
+			Evictions  map[string]uint64 `json:"evictions"`
+			Rejections map[string]uint64 `json:"rejections"`
+			Streams    []StreamStats     `json:"streams"`
+		}
+		err := json.Unmarshal(w.Body.Bytes(), &actual)
+		if err != nil || actual.Evictions["command timeout"] != 2 || actual.Rejections[RejectMaxViewers] != 1 ||
+			len(actual.Streams) != 2 || actual.Streams[0].Name != "live/a" || actual.Streams[1].Name != "live/b" ||
+			actual.Streams[0].Publishing || actual.Streams[0].Video != nil {
+			t.Errorf("[×] in: %s out: %s %v expected: %d\n", test.in, w.Body.String(), err, test.expected)
+		} else {
+			t.Logf("[√] in: %s out: %s expected: %d\n", test.in, w.Body.String(), test.expected)
+		}
+	}
+}
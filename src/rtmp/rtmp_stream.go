@@ -13,36 +13,147 @@ import (
 
 */
 
+// Subscriber 流的订阅者，接收流内广播的音视频帧，如RTMP拉流端与HTTP-FLV拉流端
+type Subscriber interface {
+	SendFrame(frame *Frame) error // 将音视频帧加入发送队列
+	NotifyUnpublish()             // 通知流即将结束
+	CloseServer()                 // 断开订阅者
+}
+
 // Stream RTMP流，有一个输入流id，多个输出流id
 type Stream struct {
-	Name      string      // 流名称
-	Publisher *Connect    // 输入流
-	Receivers []*Connect  // 输出流
-	mutex     *sync.Mutex //锁
-	Tag       *Message    // 视频Tag
+	Name      string       // 流名称
+	Publisher *Connect     // 输入流
+	Receivers []Subscriber // 输出流
+	outputs   []Subscriber // 推流期间的封装输出，如HLS，不计入拉流端
+	mutex     *sync.Mutex  //锁
+	server    *Server      // 所属服务，流空闲时从服务中移除
+	removed   bool         // 是否已从服务中移除，移除后不能再加入推流端或拉流端
+
+	metadata    *Message              // 元数据
+	tracks      []Track               // 推流端发送过的音视频轨道
+	headers     map[trackKey]*Message // 各轨道的序列头
+	videoInfos  map[uint8]*Message    // 各视频轨道 Enhanced RTMP 的视频元数据
+	headerMutex *sync.RWMutex
+	video       *videoAnalyzer // 轨道0的视频码流统计
+	audio       *audioAnalyzer // 轨道0的音频码流统计
 }
 
 // NewStream 新建一个流
 func NewStream(fullName string) *Stream {
 	stream := Stream{
-		Name:      fullName,
-		Publisher: nil,
-		Receivers: make([]*Connect, 0),
-		mutex:     &sync.Mutex{},
-		Tag:       nil,
+		Name:        fullName,
+		Publisher:   nil,
+		Receivers:   make([]Subscriber, 0),
+		mutex:       &sync.Mutex{},
+		headers:     make(map[trackKey]*Message),
+		videoInfos:  make(map[uint8]*Message),
+		headerMutex: &sync.RWMutex{},
+		video:       newVideoAnalyzer(),
+		audio:       newAudioAnalyzer(),
 	}
 	return &stream
 }
 
-// GetTag 获取音视频标签
-func (stream *Stream) GetTag() (Message, bool) {
-	// defer stream.mutex.Unlock()
-	// stream.mutex.Lock()
+// GetHeaders 获取拉流端起播前需要发送的元数据与轨道0的音视频序列头
+func (stream *Stream) GetHeaders() []Message {
+	return stream.GetTrackHeaders(0, 0)
+}
+
+// GetTrackHeaders 获取拉流端起播前需要发送的元数据与所选轨道的音视频序列头
+func (stream *Stream) GetTrackHeaders(video uint8, audio uint8) []Message {
+	// 拉流端的写出线程调用，使用单独的读写锁，避免与持有stream.mutex广播的推流端相互等待
+	defer stream.headerMutex.RUnlock()
+	stream.headerMutex.RLock()
+
+	headers := make([]Message, 0, 4)
+	for _, header := range []*Message{
+		stream.metadata,
+		stream.headers[trackKey{RTMPTypeVideoData, video}],
+		stream.videoInfos[video],
+		stream.headers[trackKey{RTMPTypeAudioData, audio}],
+	} {
+		if header != nil {
+			headers = append(headers, header.Copy())
+		}
+	}
+	return headers
+}
+
+// Tracks 推流端发送过的音视频轨道
+func (stream *Stream) Tracks() []Track {
+	defer stream.headerMutex.RUnlock()
+	stream.headerMutex.RLock()
+
+	return append([]Track(nil), stream.tracks...)
+}
+
+// updateTracks 记录消息所属的轨道，并缓存序列头与视频元数据
+func (stream *Stream) updateTracks(msg *Message) {
+	key := trackKey{msg.Type, msg.TrackID}
+	header := isSequenceHeader(msg)
+	info := !header && isVideoMetadata(msg)
+
+	known := false
+	stream.headerMutex.RLock()
+	for _, track := range stream.tracks {
+		if track.Type == key.Type && track.ID == key.ID {
+			known = true
+			break
+		}
+	}
+	stream.headerMutex.RUnlock()
+	if known && !header && !info {
+		return
+	}
+
+	codec, ok := trackCodec(msg)
+	if !ok {
+		return
+	}
+	defer stream.headerMutex.Unlock()
+	stream.headerMutex.Lock()
 
-	if stream.Tag == nil {
-		return Message{}, false
+	if header {
+		// 序列头缓存后供之后加入的拉流端起播使用
+		copied := msg.Copy()
+		stream.headers[key] = &copied
+	} else if info {
+		copied := msg.Copy()
+		stream.videoInfos[key.ID] = &copied
+	}
+	for idx, track := range stream.tracks {
+		if track.Type == key.Type && track.ID == key.ID {
+			// 序列头可能切换编码
+			if header {
+				stream.tracks[idx].Codec = codec
+			}
+			return
+		}
+	}
+	stream.tracks = append(stream.tracks, Track{key.Type, key.ID, codec})
+	if key.ID != 0 {
+		log.Println(c.Front("Stream %s track %d(%s) added", c.G, stream.Name, key.ID, codec))
 	}
-	return stream.Tag.Copy(), true
+}
+
+// VideoStats 从视频码流中统计的视频信息，还没有收到可以解析的H.264/H.265序列头时ok为false
+func (stream *Stream) VideoStats() (VideoStats, bool) {
+	return stream.video.Stats()
+}
+
+// AudioStats 从音频码流中统计的音频信息，还没有收到可以解析的序列头或音频帧时ok为false
+func (stream *Stream) AudioStats() (AudioStats, bool) {
+	return stream.audio.Stats()
+}
+
+// SetMetadata 更新流的元数据并广播
+func (stream *Stream) SetMetadata(data Message) {
+	stream.headerMutex.Lock()
+	stream.metadata = &data
+	stream.headerMutex.Unlock()
+
+	stream.Broadcase(data)
 }
 
 // Broadcase 在当前流内广播对应数据
@@ -50,67 +161,152 @@ func (stream *Stream) Broadcase(data Message) {
 	defer stream.mutex.Unlock()
 	stream.mutex.Lock()
 
-	data = data.Copy()
+	// 多轨道消息拆分为每个轨道一个帧
+	for _, msg := range splitTracks(data.Copy()) {
+		if msg.Type == RTMPTypeVideoData || msg.Type == RTMPTypeAudioData {
+			stream.updateTracks(&msg)
+			stream.video.analyze(stream.Name, &msg)
+			stream.audio.analyze(stream.Name, &msg)
+		}
 
-	if stream.Tag == nil && data.Type == RTMPTypeVideoData {
-		tag := data.Copy()
-		stream.Tag = &tag
-	} else {
-		// 当有新的音视频消息时，应该先将其存入StreamData
-		// stream.StreamData = append(stream.StreamData, data)
+		// 所有拉流端共享同一个帧及其编码缓存
+		frame := NewFrame(msg)
+		for _, sub := range stream.Receivers {
+			sub.SendFrame(frame)
+		}
+		for _, sub := range stream.outputs {
+			sub.SendFrame(frame)
+		}
+	}
+}
 
-		for _, conn := range stream.Receivers {
-			// log.Println("chan", len(conn.Thread.MessageChannel))
-			conn.Thread.MessageChannel <- data
+// AddPublisher 在当前流中增加一个推流端，流已从服务中移除时返回false
+func (stream *Stream) AddPublisher(conn *Connect) bool {
+	defer stream.mutex.Unlock()
+	stream.mutex.Lock()
+
+	if stream.removed {
+		return false
+	}
+	stream.Publisher = conn
+	return true
+}
+
+// AddOutput 在当前流中增加一个封装输出，推流端断开时随之关闭，流没有推流端时返回false
+func (stream *Stream) AddOutput(sub Subscriber) bool {
+	defer stream.mutex.Unlock()
+	stream.mutex.Lock()
+
+	if stream.Publisher == nil {
+		return false
+	}
+	stream.outputs = append(stream.outputs, sub)
+	return true
+}
+
+// DelOutput 在当前流中删除一个封装输出
+func (stream *Stream) DelOutput(sub Subscriber) {
+	stream.mutex.Lock()
+	for idx, output := range stream.outputs {
+		if output == sub {
+			stream.outputs = append(stream.outputs[:idx], stream.outputs[idx+1:]...)
+			break
 		}
 	}
+	stream.mutex.Unlock()
 
+	stream.release()
 }
 
-// AddPublisher 在当前流中增加一个推流端
-func (stream *Stream) AddPublisher(conn *Connect) {
+// HasPublisher 当前流是否有推流端
+func (stream *Stream) HasPublisher() bool {
 	defer stream.mutex.Unlock()
 	stream.mutex.Lock()
 
-	stream.Publisher = conn
-	conn.WithinServer.ExecSQL("REPLACE INTO connect(`url`,`datetime`) VALUES(?,NOW())", conn.FullName)
+	return stream.Publisher != nil
 }
 
-// AddReceiver 在当前流中增加一个拉流端
-func (stream *Stream) AddReceiver(conn *Connect) {
+// CountReceivers 当前流的拉流端数量
+func (stream *Stream) CountReceivers() int {
 	defer stream.mutex.Unlock()
 	stream.mutex.Lock()
 
-	log.Println(c.Front("AddReceiver %s", c.G, stream.Name))
-	conn.Thread.Start()
+	return len(stream.Receivers)
+}
 
-	stream.Receivers = append(stream.Receivers, conn)
+// AddReceiver 在当前流中增加一个拉流端，limit大于0且拉流端已达到上限或流已从服务中移除时返回false
+func (stream *Stream) AddReceiver(sub Subscriber, limit int) bool {
+	defer stream.mutex.Unlock()
+	stream.mutex.Lock()
 
-	// for _, msg := range stream.StreamData {
-	// 	// conn.Chan <- msg
-	// 	conn.SendAVMessage(msg)
-	// }
+	if stream.removed || (limit > 0 && len(stream.Receivers) >= limit) {
+		return false
+	}
+	log.Println(c.Front("AddReceiver %s", c.G, stream.Name))
 
+	stream.Receivers = append(stream.Receivers, sub)
+	return true
 }
 
 // DelConnect 在当前流删除连接，自动判断属于推流端还是拉流端
 func (stream *Stream) DelConnect(conn *Connect) {
-	defer stream.mutex.Unlock()
 	stream.mutex.Lock()
-
-	if conn == stream.Publisher {
-		conn.WithinServer.ExecSQL("DELETE from connect WHERE `url`=?", conn.FullName)
+	isPublisher := conn == stream.Publisher
+	if isPublisher {
 		stream.closeAll()
 	} else {
 		stream.delReceiver(conn)
 	}
+	stream.mutex.Unlock()
+
+	if isPublisher {
+		// 在锁外删除推流登记，避免数据库访问阻塞流内的广播
+		conn.WithinServer.ExecSQL("DELETE from connect WHERE `url`=?", stream.Name)
+	}
+	stream.release()
+}
+
+// DelReceiver 在当前流中删除一个拉流端
+func (stream *Stream) DelReceiver(sub Subscriber) {
+	stream.mutex.Lock()
+	stream.delReceiver(sub)
+	stream.mutex.Unlock()
+
+	stream.release()
+}
+
+// Removed 流是否已从服务中移除
+func (stream *Stream) Removed() bool {
+	defer stream.mutex.Unlock()
+	stream.mutex.Lock()
+
+	return stream.removed
+}
+
+// release 流没有推流端、拉流端与封装输出时从服务中移除，调用时不能持有stream.mutex
+func (stream *Stream) release() {
+	if stream.server != nil {
+		stream.server.removeStream(stream)
+	}
+}
+
+// remove 流空闲时标记为已移除，返回是否已移除，调用时需持有server.mutex
+func (stream *Stream) remove() bool {
+	defer stream.mutex.Unlock()
+	stream.mutex.Lock()
+
+	if stream.Publisher != nil || len(stream.Receivers) > 0 || len(stream.outputs) > 0 {
+		return false
+	}
+	stream.removed = true
+	return true
 }
 
 // delReceiver 在当前流中删除一个拉流端
-func (stream *Stream) delReceiver(conn *Connect) {
+func (stream *Stream) delReceiver(sub Subscriber) {
 	index := -1
-	for idx, _conn := range stream.Receivers {
-		if conn == _conn {
+	for idx, _sub := range stream.Receivers {
+		if sub == _sub {
 			index = idx
 			break
 		}
@@ -120,6 +316,32 @@ func (stream *Stream) delReceiver(conn *Connect) {
 	}
 }
 
+// Active 该流是否仍有推流端或拉流端
+func (stream *Stream) Active() bool {
+	defer stream.mutex.Unlock()
+	stream.mutex.Lock()
+
+	return stream.Publisher != nil || len(stream.Receivers) > 0
+}
+
+// NotifyUnpublish 通知推流端与拉流端该流即将结束，用于关闭服务前的排空
+// 发送可能阻塞，在锁外进行，避免推流端与拉流端的加入和断开等待
+func (stream *Stream) NotifyUnpublish() {
+	stream.mutex.Lock()
+	publisher := stream.Publisher
+	subs := make([]Subscriber, 0, len(stream.Receivers)+len(stream.outputs))
+	subs = append(subs, stream.Receivers...)
+	subs = append(subs, stream.outputs...)
+	stream.mutex.Unlock()
+
+	if publisher != nil {
+		publisher.SendStatus(publisher.PublishStreamID, "status", "NetStream.Unpublish.Success", stream.Name+" is now unpublished.")
+	}
+	for _, sub := range subs {
+		sub.NotifyUnpublish()
+	}
+}
+
 // CloseAll 断开该流的所有连接
 func (stream *Stream) CloseAll() {
 	defer stream.mutex.Unlock()
@@ -128,14 +350,27 @@ func (stream *Stream) CloseAll() {
 	stream.closeAll()
 }
 
-// closeAll 断开该流的所有连接
+// closeAll 断开该流的所有连接，并清除上一次推流的元数据与序列头
 func (stream *Stream) closeAll() {
 	if stream.Publisher != nil {
 		stream.Publisher.CloseServer()
 	}
-	for _, conn := range stream.Receivers {
-		conn.CloseServer()
+	for _, sub := range stream.Receivers {
+		sub.CloseServer()
+	}
+	for _, sub := range stream.outputs {
+		sub.CloseServer()
 	}
 	stream.Publisher = nil
 	stream.Receivers = stream.Receivers[0:0]
+	stream.outputs = nil
+
+	stream.headerMutex.Lock()
+	stream.metadata = nil
+	stream.tracks = nil
+	stream.headers = make(map[trackKey]*Message)
+	stream.videoInfos = make(map[uint8]*Message)
+	stream.headerMutex.Unlock()
+	stream.video.reset()
+	stream.audio.reset()
 }
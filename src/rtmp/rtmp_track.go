@@ -0,0 +1,111 @@
+package rtmp
+
+import (
+	"log"
+	"net/url"
+	"strconv"
+
+	"../flv"
+	c "../lib/colorful"
+)
+
+/*
+
+Enhanced RTMP 多轨道
+
+推流端可以在一条消息中发送多个音频或视频轨道，如不同语言的解说音轨
+广播前将多轨道消息拆分为每个轨道一条消息，轨道ID记录在 Message.TrackID 中
+	avc1 与 mp4a 轨道转换为传统标签头，其他编码使用 Enhanced RTMP 单轨道标签头
+	轨道0与不使用多轨道的推流一致，不支持多轨道的输出只处理轨道0
+拉流端通过播放参数 video_track 与 audio_track 选择轨道，如 stream?audio_track=1
+
+*/
+
+// 选择轨道的播放参数
+const (
+	VideoTrackParam = "video_track"
+	AudioTrackParam = "audio_track"
+)
+
+// Track 流内的音视频轨道
+type Track struct {
+	Type  uint32 // RTMPTypeVideoData 或 RTMPTypeAudioData
+	ID    uint8  // 轨道ID，不使用多轨道时为0
+	Codec string // 编码的 FourCC，不支持的传统编码为空
+}
+
+// trackKey 轨道在流内的唯一标识
+type trackKey struct {
+	Type uint32
+	ID   uint8
+}
+
+// ParseTrackParam 读入播放参数中的轨道ID，不存在或格式错误时为0
+func ParseTrackParam(query url.Values, key string) uint8 {
+	id, err := strconv.ParseUint(query.Get(key), 10, 8)
+	if err != nil {
+		return 0
+	}
+	return uint8(id)
+}
+
+// splitTracks 将多轨道音视频消息拆分为每个轨道一条消息，其他消息原样返回
+func splitTracks(msg Message) []Message {
+	var data [][]byte
+	var ids []uint8
+	var err error
+	switch {
+	case msg.Type == RTMPTypeVideoData && flv.IsMultitrackVideo(msg.Data):
+		var tags []flv.VideoTag
+		tags, err = flv.ParseVideoTracks(msg.Data)
+		for _, tag := range tags {
+			if tag.FourCC == flv.FourCCAVC {
+				tag.Enhanced = false
+				tag.CodecID = flv.CodecIDAVC
+			}
+			data = append(data, flv.AppendVideoTag(nil, &tag))
+			ids = append(ids, tag.TrackID)
+		}
+	case msg.Type == RTMPTypeAudioData && flv.IsMultitrackAudio(msg.Data):
+		var tags []flv.AudioTag
+		tags, err = flv.ParseAudioTracks(msg.Data)
+		for _, tag := range tags {
+			if tag.FourCC == flv.FourCCAAC {
+				// 传统标签头的AAC固定为44kHz 16位 立体声
+				tag.Enhanced = false
+				tag.SoundFormat, tag.SoundRate, tag.SoundSize, tag.SoundType = flv.SoundFormatAAC, 3, 1, 1
+			}
+			data = append(data, flv.AppendAudioTag(nil, &tag))
+			ids = append(ids, tag.TrackID)
+		}
+	default:
+		return []Message{msg}
+	}
+	if err != nil {
+		log.Println(c.Front("multitrack message dropped: %v", c.Y, err))
+		return nil
+	}
+
+	msgs := make([]Message, len(data))
+	for i := range data {
+		msgs[i] = msg.Copy()
+		msgs[i].Length = uint32(len(data[i]))
+		msgs[i].ReadLength = msgs[i].Length
+		msgs[i].TrackID = ids[i]
+		msgs[i].Data = data[i]
+	}
+	return msgs
+}
+
+// trackCodec 音视频消息所属轨道的编码，ok为false时不是可识别的音视频消息
+func trackCodec(msg *Message) (codec string, ok bool) {
+	switch msg.Type {
+	case RTMPTypeVideoData:
+		tag, err := flv.ParseVideoTag(msg.Data)
+		return tag.FourCC, err == nil
+	case RTMPTypeAudioData:
+		tag, err := flv.ParseAudioTag(msg.Data)
+		return tag.FourCC, err == nil
+	}
+	return "", false
+}
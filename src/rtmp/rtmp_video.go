@@ -0,0 +1,199 @@
+package rtmp
+
+import (
+	"log"
+	"sync"
+
+	"../codec"
+	"../flv"
+	c "../lib/colorful"
+)
+
+/*
+
+视频码流统计
+
+从序列头的解码配置记录中解析实际的分辨率、profile、level、色度格式与帧率，不依赖推流端元数据
+遍历每个视频帧的NALU，统计IDR、SEI与码流内参数集的变化，只统计轨道0的H.264/H.265
+
+*/
+
+// maxKnownParameterSets 记录的不同参数集数量上限，超过后重新记录
+const maxKnownParameterSets = 32
+
+// VideoStats 流的视频统计
+type VideoStats struct {
+	codec.VideoInfo
+	Frames              uint64  // 视频帧数
+	IDRFrames           uint64  // 包含IDR的帧数
+	SEIFrames           uint64  // 包含SEI的帧数
+	ParameterSetChanges uint64  // 序列头或码流内的参数集变化次数
+	ObservedFrameRate   float64 // 按时间戳统计的帧率
+}
+
+// videoAnalyzer 统计一条流轨道0的视频码流
+type videoAnalyzer struct {
+	mutex      *sync.Mutex
+	stats      VideoStats
+	parsed     bool            // 是否已从序列头取得视频信息
+	lengthSize int             // NALU长度字段的字节数，不是H.264/H.265时为0
+	params     map[string]bool // 已出现的参数集
+	first      uint32          // 第一个视频帧的时间戳
+	last       uint32          // 最后一个视频帧的时间戳
+}
+
+// newVideoAnalyzer 新建视频码流统计
+func newVideoAnalyzer() *videoAnalyzer {
+	return &videoAnalyzer{
+		mutex:  &sync.Mutex{},
+		params: make(map[string]bool),
+	}
+}
+
+// Stats 返回视频统计，还没有收到可以解析的序列头时ok为false
+func (analyzer *videoAnalyzer) Stats() (stats VideoStats, ok bool) {
+	defer analyzer.mutex.Unlock()
+	analyzer.mutex.Lock()
+
+	return analyzer.stats, analyzer.parsed
+}
+
+// reset 推流结束后清除统计
+func (analyzer *videoAnalyzer) reset() {
+	defer analyzer.mutex.Unlock()
+	analyzer.mutex.Lock()
+
+	analyzer.stats = VideoStats{}
+	analyzer.parsed = false
+	analyzer.lengthSize = 0
+	analyzer.params = make(map[string]bool)
+}
+
+// analyze 统计一条视频消息，name为流名称，用于日志
+func (analyzer *videoAnalyzer) analyze(name string, msg *Message) {
+	if msg.Type != RTMPTypeVideoData || msg.TrackID != 0 {
+		return
+	}
+	tag, err := flv.ParseVideoTag(msg.Data)
+	if err != nil {
+		return
+	}
+
+	defer analyzer.mutex.Unlock()
+	analyzer.mutex.Lock()
+
+	switch {
+	case tag.PacketType == flv.PacketTypeSequenceStart:
+		analyzer.sequenceStart(name, tag.FourCC, tag.Body)
+	case tag.Frame():
+		if analyzer.stats.Frames == 0 {
+			analyzer.first = msg.Timestamp
+		}
+		analyzer.last = msg.Timestamp
+		analyzer.stats.Frames++
+		if duration := analyzer.last - analyzer.first; duration > 0 && analyzer.stats.Frames > 1 {
+			analyzer.stats.ObservedFrameRate = float64(analyzer.stats.Frames-1) * 1000 / float64(duration)
+		}
+		if analyzer.lengthSize > 0 && tag.FourCC == analyzer.stats.Codec {
+			analyzer.frame(name, tag.Body)
+		}
+	}
+}
+
+// sequenceStart 由序列头中的解码配置记录更新视频信息
+func (analyzer *videoAnalyzer) sequenceStart(name string, fourCC string, record []byte) {
+	var lengthSize int
+	var params [][]byte
+	switch fourCC {
+	case flv.FourCCAVC:
+		config, err := codec.ParseAVCConfig(record)
+		if err != nil {
+			log.Println(c.Front("Stream %s video config: %v", c.Y, name, err))
+			return
+		}
+		lengthSize, params = config.LengthSize, config.ParameterSets()
+	case flv.FourCCHEVC:
+		config, err := codec.ParseHEVCConfig(record)
+		if err != nil {
+			log.Println(c.Front("Stream %s video config: %v", c.Y, name, err))
+			return
+		}
+		lengthSize, params = config.LengthSize, config.ParameterSets()
+	default:
+		// AV1/VP9 只记录编码
+		analyzer.stats.VideoInfo = codec.VideoInfo{Codec: fourCC}
+		analyzer.lengthSize = 0
+		return
+	}
+
+	info, err := codec.ParseVideoInfo(fourCC, record)
+	if err != nil {
+		log.Println(c.Front("Stream %s video config: %v", c.Y, name, err))
+		return
+	}
+	if analyzer.parsed && analyzer.changed(params) {
+		analyzer.stats.ParameterSetChanges++
+	}
+	analyzer.params = make(map[string]bool)
+	for _, param := range params {
+		analyzer.params[string(param)] = true
+	}
+	analyzer.lengthSize = lengthSize
+	if !analyzer.parsed || analyzer.stats.VideoInfo != *info {
+		log.Println(c.Front("Stream %s video %s", c.G, name, info))
+	}
+	analyzer.stats.VideoInfo = *info
+	analyzer.parsed = true
+}
+
+// changed 参数集中是否有之前没有出现过的参数集
+func (analyzer *videoAnalyzer) changed(params [][]byte) bool {
+	for _, param := range params {
+		if !analyzer.params[string(param)] {
+			return true
+		}
+	}
+	return false
+}
+
+// frame 遍历视频帧中的NALU，码流内出现新的SPS时更新分辨率
+func (analyzer *videoAnalyzer) frame(name string, data []byte) {
+	info, err := codec.ParseFrame(analyzer.stats.Codec, data, analyzer.lengthSize)
+	if err != nil {
+		return
+	}
+	if info.IDR {
+		analyzer.stats.IDRFrames++
+	}
+	if info.SEI {
+		analyzer.stats.SEIFrames++
+	}
+	if !analyzer.changed(info.ParameterSets) {
+		return
+	}
+
+	analyzer.stats.ParameterSetChanges++
+	if len(analyzer.params)+len(info.ParameterSets) > maxKnownParameterSets {
+		analyzer.params = make(map[string]bool)
+	}
+	for _, param := range info.ParameterSets {
+		analyzer.params[string(param)] = true
+		hevc := analyzer.stats.Codec == flv.FourCCHEVC
+		var sps *codec.SPS
+		switch {
+		case hevc && codec.HEVCNALType(param) == codec.HEVCNALSPS:
+			sps, err = codec.ParseHEVCSPS(param)
+		case !hevc && codec.AVCNALType(param) == codec.AVCNALSPS:
+			sps, err = codec.ParseAVCSPS(param)
+		default:
+			continue
+		}
+		if err != nil || *sps == analyzer.stats.SPS {
+			continue
+		}
+		if sps.Width != analyzer.stats.Width || sps.Height != analyzer.stats.Height {
+			log.Println(c.Front("Stream %s video resolution changed %dx%d -> %dx%d", c.Y, name, analyzer.stats.Width, analyzer.stats.Height, sps.Width, sps.Height))
+		}
+		analyzer.stats.SPS = *sps
+	}
+}
@@ -0,0 +1,449 @@
+package rtmp
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"../flv"
+	c "../lib/colorful"
+	"./amf"
+	"github.com/pkg/errors"
+)
+
+/*
+
+点播
+
+点播应用的拉流端从应用目录中的FLV文件播放，流名称为相对目录的文件路径，可省略 .flv 扩展名
+打开文件时扫描所有标签，建立关键帧索引并取得元数据与序列头，开始位置与跳转通过索引找到之前最近的关键帧
+按文件时间戳以实际速度发送，开始播放或跳转后先发送VODPreload时长的数据填充拉流端缓冲区
+
+*/
+
+// VODPreload 开始播放或跳转后不等待直接发送的时长
+const VODPreload = time.Second
+
+// vodIndexInterval 纯音频文件的索引间隔，单位为毫秒
+const vodIndexInterval = 1000
+
+// vodIndex 关键帧索引
+type vodIndex struct {
+	timestamp uint32
+	offset    int64 // 标签在文件中的位置
+}
+
+// vodFile 点播文件
+type vodFile struct {
+	name     string
+	f        *os.File
+	size     int64      // 文件大小
+	headers  []flv.Tag  // 第一个音视频帧之前的元数据与序列头
+	index    []vodIndex // 按时间戳排序
+	duration uint32     // 最后一个标签的时间戳，单位为毫秒
+}
+
+// vodPath 流名称对应的文件路径，不允许访问应用目录之外的文件
+func vodPath(app string, name string) string {
+	name = path.Clean("/" + name)
+	if path.Ext(name) == "" {
+		name += ".flv"
+	}
+	return filepath.Join(app, filepath.FromSlash(name))
+}
+
+// openVODFile 打开点播文件并建立索引
+func openVODFile(name string) (*vodFile, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	file := &vodFile{name: name, f: f}
+	if err := file.scan(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// scan 扫描所有标签，只读入元数据、序列头与视频帧的第一个字节
+func (file *vodFile) scan() error {
+	info, err := file.f.Stat()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	file.size = info.Size()
+
+	reader := flv.NewReader(file.f)
+	if _, _, err := reader.ReadHeader(); err != nil {
+		return err
+	}
+	offset, err := file.f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	var hasVideo, started bool
+	var audioIndex []vodIndex
+	for {
+		tagType, timestamp, dataSize, err := reader.ReadTagHeader()
+		if err != nil {
+			// 文件结束，异常中断的录制文件最后一个标签可能不完整
+			break
+		}
+		next := offset + flv.TagHeaderSize + int64(dataSize) + 4
+		if next > file.size {
+			break
+		}
+
+		var data []byte
+		if tagType == flv.TagTypeScript || !started {
+			data = make([]byte, dataSize)
+		} else if tagType == flv.TagTypeVideo && dataSize > 0 {
+			data = make([]byte, 1)
+		}
+		if _, err := io.ReadFull(file.f, data); err != nil {
+			return errors.WithStack(err)
+		}
+		msg := Message{Type: uint32(tagType), Data: data}
+
+		switch {
+		case tagType == flv.TagTypeScript:
+			if !started {
+				file.headers = append(file.headers, flv.Tag{Type: tagType, Data: data})
+			}
+		case tagType != flv.TagTypeVideo && tagType != flv.TagTypeAudio:
+		case !started && (isSequenceHeader(&msg) || isVideoMetadata(&msg)):
+			file.headers = append(file.headers, flv.Tag{Type: tagType, Data: data})
+		case tagType == flv.TagTypeVideo:
+			started = true
+			hasVideo = true
+			if len(data) > 0 {
+				if frameType := flv.VideoFrameType(data[0]); frameType == flv.FrameTypeKey || frameType == flv.FrameTypeGenerated {
+					file.index = append(file.index, vodIndex{timestamp, offset})
+				}
+			}
+		default:
+			started = true
+			if n := len(audioIndex); n == 0 || timestamp >= audioIndex[n-1].timestamp+vodIndexInterval {
+				audioIndex = append(audioIndex, vodIndex{timestamp, offset})
+			}
+		}
+		if timestamp > file.duration {
+			file.duration = timestamp
+		}
+
+		if _, err := file.f.Seek(next, io.SeekStart); err != nil {
+			return errors.WithStack(err)
+		}
+		offset = next
+	}
+
+	if !hasVideo {
+		file.index = audioIndex
+	}
+	if len(file.index) == 0 {
+		return errors.Errorf("%s has no media", file.name)
+	}
+	return nil
+}
+
+// seek 时间戳之前最近的索引
+func (file *vodFile) seek(timestamp uint32) vodIndex {
+	i := sort.Search(len(file.index), func(i int) bool {
+		return file.index[i].timestamp > timestamp
+	})
+	if i > 0 {
+		i--
+	}
+	return file.index[i]
+}
+
+// close 关闭文件
+func (file *vodFile) close() {
+	file.f.Close()
+}
+
+// vodCommand 拉流端的跳转与暂停命令
+type vodCommand struct {
+	pause    bool   // 暂停，否则从position处继续播放
+	seek     bool   // 是否为跳转
+	position uint32 // 跳转或继续播放的位置，单位为毫秒
+}
+
+// vodPlayer 一个点播拉流端的发送线程
+type vodPlayer struct {
+	conn     *Connect
+	file     *vodFile
+	end      uint32 // 播放结束的时间戳，为0时播放到文件结束
+	commands chan vodCommand
+	stop     chan struct{}
+	once     sync.Once
+	done     chan struct{}
+
+	reader *flv.Reader
+	buffer *bufio.Reader
+	sent   int64 // 已发送的字节数
+}
+
+// newVODPlayer 新建点播发送线程，start与duration单位为毫秒，duration为0时播放到文件结束
+func newVODPlayer(conn *Connect, file *vodFile, start uint32, duration uint32) *vodPlayer {
+	player := &vodPlayer{
+		conn:     conn,
+		file:     file,
+		commands: make(chan vodCommand, 4),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+		buffer:   bufio.NewReaderSize(file.f, 64*1024),
+	}
+	if duration > 0 {
+		player.end = start + duration
+	}
+	player.reader = flv.NewReader(player.buffer)
+	go player.run(start)
+	return player
+}
+
+// Seek 跳转到position，单位为毫秒
+func (player *vodPlayer) Seek(position uint32) {
+	player.command(vodCommand{seek: true, position: position})
+}
+
+// Pause 暂停或从position处继续播放
+func (player *vodPlayer) Pause(pause bool, position uint32) {
+	player.command(vodCommand{pause: pause, position: position})
+}
+
+// command 将命令交给发送线程
+func (player *vodPlayer) command(cmd vodCommand) {
+	select {
+	case player.commands <- cmd:
+	case <-player.done:
+	}
+}
+
+// Stop 结束发送线程并关闭文件
+func (player *vodPlayer) Stop() {
+	player.once.Do(func() {
+		close(player.stop)
+	})
+	<-player.done
+}
+
+// run 发送循环
+func (player *vodPlayer) run(start uint32) {
+	defer close(player.done)
+	defer player.file.close()
+
+	err := player.play(start)
+	if err != nil && !player.stopped() {
+		log.Println(c.Front("VOD %s: %v", c.R, player.conn.FullName, err))
+		player.conn.CloseServer()
+	}
+}
+
+// stopped 发送线程是否已被结束
+func (player *vodPlayer) stopped() bool {
+	select {
+	case <-player.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// play 从start开始按实际速度发送，处理跳转与暂停，文件结束后等待跳转
+func (player *vodPlayer) play(start uint32) error {
+	base, err := player.seek(start)
+	if err != nil {
+		return err
+	}
+	begin := time.Now()
+	complete := false
+
+	for {
+		if complete {
+			cmd, err := player.wait()
+			if err != nil {
+				return err
+			}
+			if base, err = player.apply(cmd); err != nil {
+				return err
+			}
+			begin = time.Now()
+			complete = false
+			continue
+		}
+
+		tag, err := player.reader.Next()
+		if err != nil && err != io.EOF && errors.Cause(err) != io.ErrUnexpectedEOF {
+			return errors.WithStack(err)
+		}
+		if err != nil || (player.end > 0 && tag.Timestamp >= player.end) {
+			complete = true
+			if err := player.complete(); err != nil {
+				return err
+			}
+			continue
+		}
+		if tag.Type != flv.TagTypeVideo && tag.Type != flv.TagTypeAudio && tag.Type != flv.TagTypeScript {
+			continue
+		}
+
+		// 等待到发送时间，期间处理拉流端的命令
+		if tag.Timestamp > base {
+			delay := time.Duration(tag.Timestamp-base)*time.Millisecond - VODPreload - time.Since(begin)
+			cmd, ok, err := player.sleep(delay)
+			if err != nil {
+				return err
+			}
+			if ok {
+				if base, err = player.apply(cmd); err != nil {
+					return err
+				}
+				begin = time.Now()
+				continue
+			}
+		}
+		if err := player.send(tag); err != nil {
+			return err
+		}
+	}
+}
+
+// sleep 等待delay，期间收到命令时返回命令
+func (player *vodPlayer) sleep(delay time.Duration) (vodCommand, bool, error) {
+	if delay <= 0 {
+		select {
+		case cmd := <-player.commands:
+			return cmd, true, nil
+		case <-player.stop:
+			return vodCommand{}, false, errors.New("VOD stopped")
+		default:
+			return vodCommand{}, false, nil
+		}
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case cmd := <-player.commands:
+		return cmd, true, nil
+	case <-player.stop:
+		return vodCommand{}, false, errors.New("VOD stopped")
+	case <-timer.C:
+		return vodCommand{}, false, nil
+	}
+}
+
+// wait 等待下一个命令
+func (player *vodPlayer) wait() (vodCommand, error) {
+	select {
+	case cmd := <-player.commands:
+		return cmd, nil
+	case <-player.stop:
+		return vodCommand{}, errors.New("VOD stopped")
+	}
+}
+
+// apply 执行跳转与暂停命令，暂停时等待继续播放，返回新的起始时间戳
+func (player *vodPlayer) apply(cmd vodCommand) (uint32, error) {
+	conn := player.conn
+	for cmd.pause {
+		if err := conn.SendStatus(conn.StreamID, "status", "NetStream.Pause.Notify", "Paused "+conn.FullName); err != nil {
+			return 0, errors.WithStack(err)
+		}
+		var err error
+		if cmd, err = player.wait(); err != nil {
+			return 0, err
+		}
+	}
+
+	if cmd.seek {
+		if err := conn.SendStatus(conn.StreamID, "status", "NetStream.Seek.Notify", "Seeking "+conn.FullName); err != nil {
+			return 0, errors.WithStack(err)
+		}
+		if err := conn.SendStatus(conn.StreamID, "status", "NetStream.Play.Start", "Started playing "+conn.FullName); err != nil {
+			return 0, errors.WithStack(err)
+		}
+	} else {
+		if err := conn.SendStatus(conn.StreamID, "status", "NetStream.Unpause.Notify", "Unpaused "+conn.FullName); err != nil {
+			return 0, errors.WithStack(err)
+		}
+	}
+	if player.end > 0 && cmd.position >= player.end {
+		player.end = 0
+	}
+	return player.seek(cmd.position)
+}
+
+// seek 移动到position之前最近的关键帧并发送元数据与序列头，返回该关键帧的时间戳
+func (player *vodPlayer) seek(position uint32) (uint32, error) {
+	index := player.file.seek(position)
+	if _, err := player.file.f.Seek(index.offset, io.SeekStart); err != nil {
+		return 0, errors.WithStack(err)
+	}
+	player.buffer.Reset(player.file.f)
+
+	for _, header := range player.file.headers {
+		header.Timestamp = index.timestamp
+		if err := player.send(&header); err != nil {
+			return 0, err
+		}
+	}
+	return index.timestamp, nil
+}
+
+// send 将一个标签加入连接的发送队列，发送队列满时阻塞
+func (player *vodPlayer) send(tag *flv.Tag) error {
+	conn := player.conn
+	csid := conn.AudioChunkID
+	if tag.Type == flv.TagTypeVideo {
+		csid = conn.VideoChunkID
+	}
+	msg := MessageFromTag(tag, conn.StreamID, csid)
+
+	w := conn.Writer
+	select {
+	case w.ControlChannel <- msg:
+		player.sent += int64(len(tag.Data))
+		return nil
+	case <-w.done:
+		return errors.New("Writer stopped")
+	case <-player.stop:
+		return errors.New("VOD stopped")
+	}
+}
+
+// complete 播放结束，发送 onPlayStatus 与 StreamEOF
+func (player *vodPlayer) complete() error {
+	conn := player.conn
+	data := amf.NewString("onPlayStatus").Bytes()
+	status, err := amf.MakeAMF(map[string]interface{}{
+		"level":    "status",
+		"code":     "NetStream.Play.Complete",
+		"duration": float64(player.file.duration) / 1000,
+		"bytes":    float64(player.sent),
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	msg, err := MakeMessage(RTMPTypeAMFData, append(data, status.Bytes()...), conn.StreamID, conn.AudioChunkID, 0)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err := conn.WriteMessage(msg); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := conn.SendStatus(conn.StreamID, "status", "NetStream.Play.Stop", "Stopped playing "+conn.FullName); err != nil {
+		return errors.WithStack(err)
+	}
+	log.Println(c.Front("VOD %s complete", c.G, conn.FullName))
+	return errors.WithStack(conn.SendUserControlMessage(UserControlMessageStreamEOF, conn.StreamID))
+}
@@ -0,0 +1,326 @@
+package rtmp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"log"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"../lib"
+	c "../lib/colorful"
+	"github.com/pkg/errors"
+)
+
+/*
+
+连接的写出线程
+
+连接上所有发送的数据都经过该线程，避免多个协程交错写出破坏chunk流
+协议控制与命令消息优先于音视频帧发送，每批消息写出后刷新一次缓冲区
+音视频帧队列满时丢弃直到下一个关键帧，慢速的拉流端不会阻塞推流端与其他拉流端
+
+*/
+
+// WriteBufferSize 连接写缓冲区大小
+const WriteBufferSize = 64 * 1024
+
+// Writer 连接的写出线程
+type Writer struct {
+	Conn           *Connect
+	ControlChannel chan Message // 协议控制与命令消息
+	MessageChannel chan *Frame  // 音视频帧
+	buffer         *bufio.Writer
+	stop           chan struct{}
+	done           chan struct{}
+	mutex          sync.Mutex
+	isWorking      bool
+	err            error            // 写出错误，出错后不再写出
+	bucket         *lib.TokenBucket // 发送速率限制
+
+	gate     Gate     // 起播与丢帧控制
+	header   [18]byte // 写出音视频帧头部的缓冲区
+	skipping bool     // 队列满后正在丢弃音视频帧，仅在广播时访问
+	resync   int32    // 丢弃过序列头或元数据，下一个关键帧前需要重新发送
+}
+
+// NewWriter 新建一个写出线程，queueSize为待发送音视频帧队列长度
+func NewWriter(conn *Connect, queueSize int) *Writer {
+	return &Writer{
+		Conn:           conn,
+		ControlChannel: make(chan Message, 64),
+		MessageChannel: make(chan *Frame, queueSize),
+		stop:           make(chan struct{}),
+		done:           make(chan struct{}),
+		bucket:         lib.NewTokenBucket(0, 0),
+	}
+}
+
+// Start 启动写出线程
+func (w *Writer) Start() {
+	defer w.mutex.Unlock()
+	w.mutex.Lock()
+
+	if w.isWorking {
+		log.Println("Writer is already working")
+		return
+	}
+	w.isWorking = true
+	w.buffer = bufio.NewWriterSize(w.Conn.Conn, WriteBufferSize)
+	go w.loop()
+}
+
+// Stop 结束写出线程，已排队的控制消息会在结束前写出
+func (w *Writer) Stop() {
+	w.mutex.Lock()
+	if !w.isWorking {
+		w.mutex.Unlock()
+		return
+	}
+	w.isWorking = false
+	close(w.stop)
+	w.mutex.Unlock()
+
+	<-w.done
+}
+
+// SetRate 设置发送速率限制(字节每秒)，允许一秒的突发数据，为0时不限制
+func (w *Writer) SetRate(rate uint32) {
+	w.bucket.SetRate(int(rate), int(rate))
+}
+
+// SelectTracks 按播放参数选择发送的音视频轨道，需要在加入流之前调用
+func (w *Writer) SelectTracks(query url.Values) {
+	w.gate.SelectTracks(query)
+}
+
+// SendMessage 将控制或命令消息加入发送队列
+func (w *Writer) SendMessage(msg Message) error {
+	select {
+	case <-w.done:
+		return errors.New("Writer stopped")
+	default:
+	}
+
+	select {
+	case w.ControlChannel <- msg:
+		return nil
+	case <-w.done:
+		return errors.New("Writer stopped")
+	}
+}
+
+// SendFrame 将音视频帧加入发送队列，队列满时丢弃直到下一个关键帧而不阻塞推流端
+func (w *Writer) SendFrame(frame *Frame) error {
+	select {
+	case <-w.done:
+		return errors.New("Writer stopped")
+	default:
+	}
+
+	if w.skipping && !frame.KeyFrame() {
+		w.drop(frame)
+		return nil
+	}
+	select {
+	case w.MessageChannel <- frame:
+		w.skipping = false
+	default:
+		w.skipping = true
+		w.drop(frame)
+	}
+	return nil
+}
+
+// drop 记录丢弃的音视频帧，丢弃了序列头或元数据时需要在下一个关键帧前重新发送
+func (w *Writer) drop(frame *Frame) {
+	if frame.Type == RTMPTypeAMFData || frame.SequenceHeader() || frame.VideoMetadata() {
+		atomic.StoreInt32(&w.resync, 1)
+	}
+}
+
+// loop 写出循环
+func (w *Writer) loop() {
+	defer close(w.done)
+
+	for {
+		select {
+		case msg := <-w.ControlChannel:
+			w.setDeadline()
+			w.writeMessage(msg)
+		case frame := <-w.MessageChannel:
+			w.setDeadline()
+			w.writeFrame(frame)
+		case <-w.stop:
+			w.setDeadline()
+			w.drainControl()
+			w.flush()
+			return
+		}
+		w.batch()
+		w.flush()
+
+		if w.err != nil {
+			if isTimeout(w.err) && !w.Conn.Closed() {
+				w.Conn.Evict("write timeout")
+			} else {
+				log.Println(c.Front("Write error: %v", c.R, w.err))
+				w.Conn.CloseServer()
+			}
+			return
+		}
+	}
+}
+
+// setDeadline 设置本批消息的写超时
+func (w *Writer) setDeadline() {
+	if timeout := w.Conn.WithinServer.Config.Timeouts.Write.Duration(); timeout > 0 {
+		w.Conn.Conn.SetWriteDeadline(time.Now().Add(timeout))
+	}
+}
+
+// shape 按发送速率限制等待，等待前先刷新缓冲区中已有的数据
+func (w *Writer) shape(size int) {
+	delay := w.bucket.Reserve(size)
+	if delay <= 0 || w.err != nil {
+		return
+	}
+	w.flush()
+	timer := time.NewTimer(delay)
+	select {
+	case <-timer.C:
+	case <-w.stop:
+		timer.Stop()
+	}
+	w.setDeadline()
+}
+
+// batch 写出队列中已有的消息，控制消息优先
+func (w *Writer) batch() {
+	for w.err == nil {
+		select {
+		case msg := <-w.ControlChannel:
+			w.writeMessage(msg)
+			continue
+		default:
+		}
+
+		select {
+		case msg := <-w.ControlChannel:
+			w.writeMessage(msg)
+		case frame := <-w.MessageChannel:
+			w.writeFrame(frame)
+		default:
+			return
+		}
+	}
+}
+
+// drainControl 写出剩余的控制消息
+func (w *Writer) drainControl() {
+	for w.err == nil {
+		select {
+		case msg := <-w.ControlChannel:
+			w.writeMessage(msg)
+		default:
+			return
+		}
+	}
+}
+
+// flush 刷新写缓冲区
+func (w *Writer) flush() {
+	if w.err == nil && w.buffer.Buffered() > 0 {
+		w.err = errors.WithStack(w.buffer.Flush())
+	}
+}
+
+// write 写出数据到缓冲区
+func (w *Writer) write(b []byte) {
+	if w.err != nil {
+		return
+	}
+	_, err := w.buffer.Write(b)
+	if err != nil {
+		w.err = errors.WithStack(err)
+	}
+}
+
+// writeMessage 分块写出Message
+func (w *Writer) writeMessage(msg Message) {
+	conn := w.Conn
+	w.shape(len(msg.Data))
+	for _, chk := range msg.ToChunks(conn) {
+		conn.LastSendChunk[chk.Basic.ChunkStreamID] = chk
+		b, err := chk.Bytes()
+		if err != nil {
+			w.err = errors.WithStack(err)
+			return
+		}
+		w.write(b)
+	}
+
+	if msg.Type == RTMPTypeSetChunkSize && len(msg.Data) >= 4 {
+		// 设置分块大小在消息写出后生效
+		conn.SendChunkSize = binary.BigEndian.Uint32(msg.Data) & 0x7fffffff
+	}
+}
+
+// writeFrame 写出音视频帧，拉流端从关键帧开始接收
+func (w *Writer) writeFrame(frame *Frame) {
+	conn := w.Conn
+	msg := &frame.Message
+
+	congested := w.bucket.Rate() > 0 && len(w.MessageChannel) == cap(w.MessageChannel)
+	headers, ok := w.gate.Pass(conn.WithinStream, msg, congested)
+	if !ok {
+		return
+	}
+	if len(headers) == 0 && isKeyFrame(msg) && atomic.SwapInt32(&w.resync, 0) != 0 {
+		// 队列满时丢弃过序列头或元数据，从流的缓存中重新发送
+		headers = conn.WithinStream.GetTrackHeaders(w.gate.VideoTrack, w.gate.AudioTrack)
+	}
+	for _, header := range headers {
+		header.Timestamp = w.gate.Timestamp(msg)
+		header.ChunkStreamID = w.chunkStreamID(&header)
+		header.StreamID = conn.StreamID
+		w.writeMessage(header)
+	}
+
+	w.writeEncodedFrame(frame, w.chunkStreamID(msg), w.gate.Timestamp(msg))
+}
+
+// chunkStreamID 音视频帧发送时使用的chunk stream id
+func (w *Writer) chunkStreamID(msg *Message) uint32 {
+	if msg.Type == RTMPTypeVideoData {
+		return w.Conn.VideoChunkID
+	}
+	return w.Conn.AudioChunkID
+}
+
+// writeEncodedFrame 写出音视频帧，分块数据使用流内共享的编码缓存，仅为当前连接生成首个chunk的头部
+func (w *Writer) writeEncodedFrame(frame *Frame, csid uint32, timestamp uint32) {
+	conn := w.Conn
+	if timestamp >= 0xffffff {
+		// 扩展时间戳需要在每个chunk中重复，无法共享编码结果
+		msg := frame.Message
+		msg.ChunkStreamID = csid
+		msg.StreamID = conn.StreamID
+		msg.Timestamp = timestamp
+		w.writeMessage(msg)
+		return
+	}
+
+	w.shape(int(frame.Length))
+	header := appendBasicHeader(w.header[:0], 0, csid)
+	header = appendMessageHeader(header, 0, &MessageHeader{
+		Timestamp:       timestamp,
+		MessageLength:   frame.Length,
+		MessageType:     frame.Type,
+		MessageStreamID: conn.StreamID,
+	})
+	w.write(header)
+	w.write(frame.Encoded(conn.SendChunkSize, csid))
+}
@@ -0,0 +1,95 @@
+package rtmp
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+// testFrame 按标记构造测试用的音视频帧，K关键帧，P非关键帧，S视频序列头，A音频帧，M元数据
+func testFrame(kind byte) *Frame {
+	msg := Message{Type: RTMPTypeVideoData}
+	switch kind {
+	case 'K':
+		msg.Data = []byte{0x17, 0x01, 0, 0, 0, kind}
+	case 'P':
+		msg.Data = []byte{0x27, 0x01, 0, 0, 0, kind}
+	case 'S':
+		msg.Data = []byte{0x17, 0x00, 0, 0, 0, kind}
+	case 'A':
+		msg.Type = RTMPTypeAudioData
+		msg.Data = []byte{0xaf, 0x01, kind}
+	case 'M':
+		msg.Type = RTMPTypeAMFData
+		msg.Data = []byte{kind}
+	}
+	msg.Length = uint32(len(msg.Data))
+	return NewFrame(msg)
+}
+
+// frameKind 测试帧的标记
+func frameKind(frame *Frame) byte {
+	return frame.Data[len(frame.Data)-1]
+}
+
+// TestWriterSendFrame 测试队列满时丢弃音视频帧直到下一个关键帧，并在丢弃序列头或元数据后要求重新发送
+func TestWriterSendFrame(t *testing.T) {
+	var tests = []struct {
+		in       string // input: 依次加入长度为3的队列的帧，-表示写出线程取走一帧
+		expected string // expected: 写出线程依次取到的帧
+		resync   bool   // expected: 是否需要重新发送序列头
+	}{
+		{"KPP", "KPP", false},
+		{"KPPP", "KPP", false},
+		{"KPPPP-P", "KPP", false},
+		{"KPPP-PK", "KPPK", false},
+		{"KPPP--PPK", "KPPK", false},
+		{"KPPA-A", "KPP", false},
+		{"KPPS-K", "KPPK", true},
+		{"KPPM-K", "KPPK", true},
+		{"SKP-K", "SKPK", false},
+		{"KPPK--K", "KPPK", false},
+	}
+
+	for _, test := range tests {
+		conn, remote := newTestConnect()
+		w := NewWriter(conn, 3)
+		actual := make([]byte, 0)
+		for _, kind := range []byte(test.in) {
+			if kind == '-' {
+				actual = append(actual, frameKind(<-w.MessageChannel))
+			} else if err := w.SendFrame(testFrame(kind)); err != nil {
+				t.Errorf("[×] in: %s out: %v expected: nil\n", test.in, err)
+			}
+		}
+		for len(w.MessageChannel) > 0 {
+			actual = append(actual, frameKind(<-w.MessageChannel))
+		}
+		resync := atomic.LoadInt32(&w.resync) != 0
+		if string(actual) != test.expected || resync != test.resync {
+			t.Errorf("[×] in: %s out: %s %v expected: %s %v\n", test.in, actual, resync, test.expected, test.resync)
+		} else {
+			t.Logf("[√] in: %s out: %s %v expected: %s %v\n", test.in, actual, resync, test.expected, test.resync)
+		}
+		remote.Close()
+	}
+}
+
+// TestWriterStopped 测试写出线程结束后不再接收消息
+func TestWriterStopped(t *testing.T) {
+	conn, remote := newTestConnect()
+	defer remote.Close()
+	w := NewWriter(conn, 3)
+	w.Start()
+	w.Stop()
+
+	if err := w.SendFrame(testFrame('K')); err == nil {
+		t.Errorf("[×] in: SendFrame out: %v expected: error\n", err)
+	} else {
+		t.Logf("[√] in: SendFrame out: %v expected: error\n", err)
+	}
+	if err := w.SendMessage(Message{Type: RTMPTypeAMF0Command}); err == nil {
+		t.Errorf("[×] in: SendMessage out: %v expected: error\n", err)
+	} else {
+		t.Logf("[√] in: SendMessage out: %v expected: error\n", err)
+	}
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+)
+
+// CORS 为HTTP处理函数增加跨域响应头并处理预检请求，allowOrigins中的 * 表示任意来源
+func CORS(allowOrigins []string, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if allowed := allowOrigin(allowOrigins, origin); allowed != "" {
+			header := w.Header()
+			header.Set("Access-Control-Allow-Origin", allowed)
+			if allowed != "*" {
+				header.Add("Vary", "Origin")
+			}
+			if r.Method == http.MethodOptions {
+				header.Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+				if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+					header.Set("Access-Control-Allow-Headers", headers)
+				}
+				header.Set("Access-Control-Max-Age", "86400")
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// allowOrigin 返回Access-Control-Allow-Origin的值，不允许时返回空
+func allowOrigin(allowOrigins []string, origin string) string {
+	if origin == "" {
+		return ""
+	}
+	for _, allowed := range allowOrigins {
+		if allowed == "*" {
+			return "*"
+		}
+		if allowed == origin {
+			return origin
+		}
+	}
+	return ""
+}
+
+// OriginAllowed 请求的来源是否允许，没有Origin或与请求的Host相同时允许
+func OriginAllowed(allowOrigins []string, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && u.Host == r.Host {
+		return true
+	}
+	return allowOrigin(allowOrigins, origin) != ""
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// HTTPServer HTTP服务，可与Server共用连接数限制
+type HTTPServer struct {
+	Address  string
+	Port     int
+	Handler  http.Handler
+	TLS      *tls.Config         // 为空时不使用TLS
+	Limiter  *Limiter            // 连接数限制，为空时不限制
+	OnReject func(reason string) // 连接因超出限制被拒绝时调用
+
+	server *http.Server
+	mutex  sync.Mutex
+	closed bool
+}
+
+// Listen 监听某个端口，ctx结束或调用Shutdown后停止接受新连接
+func (s *HTTPServer) Listen(ctx context.Context) error {
+	log.Printf("Server start at http://%s:%d (http).\n", s.Address, s.Port)
+	ln, err := net.Listen("tcp", fmt.Sprintf("%s:%d", s.Address, s.Port))
+	if err != nil {
+		log.Println(err)
+		return errors.WithStack(err)
+	}
+	if s.TLS != nil {
+		// TLS握手在第一次读写时进行，上层协议不受影响
+		ln = tls.NewListener(ln, s.TLS)
+	}
+	if s.Limiter != nil {
+		ln = s.Limiter.Listener(ln, s.OnReject)
+	}
+
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		ln.Close()
+		return nil
+	}
+	s.server = &http.Server{Handler: s.Handler}
+	server := s.server
+	s.mutex.Unlock()
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.closeListener()
+		case <-stop:
+		}
+	}()
+
+	err = server.Serve(ln)
+	if err == http.ErrServerClosed {
+		log.Printf("Server stop at http://%s:%d (http).\n", s.Address, s.Port)
+		return nil
+	}
+	return errors.WithStack(err)
+}
+
+// Shutdown 停止接受新连接并等待请求处理完毕，ctx结束时强制关闭剩余连接
+func (s *HTTPServer) Shutdown(ctx context.Context) error {
+	s.mutex.Lock()
+	s.closed = true
+	server := s.server
+	s.mutex.Unlock()
+	if server == nil {
+		return nil
+	}
+
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Server force close at http://%s:%d (http).\n", s.Address, s.Port)
+		server.Close()
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// closeListener 关闭监听，不再接受新连接，已有的请求不受影响
+func (s *HTTPServer) closeListener() {
+	s.mutex.Lock()
+	s.closed = true
+	server := s.server
+	s.mutex.Unlock()
+	if server != nil {
+		// 不等待已有请求，排空由Shutdown负责
+		go server.Shutdown(context.Background())
+	}
+}
@@ -1,18 +1,38 @@
 package server
 
-import "net"
+import (
+	"bufio"
+	"io"
+	"net"
 
-// Connect 对于net中Conn的加强
+	"github.com/pkg/errors"
+)
+
+// Connect 对于net中Conn的加强，读入经过缓冲
 type Connect struct {
 	net.Conn
+	Reader *bufio.Reader
+}
+
+// NewConnect 包装一个连接，readBufferSize为读缓冲区大小
+func NewConnect(conn net.Conn, readBufferSize int) *Connect {
+	return &Connect{
+		Conn:   conn,
+		Reader: bufio.NewReaderSize(conn, readBufferSize),
+	}
+}
+
+// Read 从缓冲区读入数据
+func (conn *Connect) Read(b []byte) (int, error) {
+	return conn.Reader.Read(b)
 }
 
 // ReadLength 读入指定长度的数据
-func (conn Connect) ReadLength(len int) ([]byte, error) {
+func (conn *Connect) ReadLength(len int) ([]byte, error) {
 	data := make([]byte, len)
-	_, err := conn.Read(data)
+	_, err := io.ReadFull(conn.Reader, data)
 	if err != nil {
-		return data, nil
+		return data, errors.WithStack(err)
 	}
 
 	return data, nil
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"net"
+	"sync"
+)
+
+/*
+
+连接数限制，可由多个监听端口共享
+
+*/
+
+// 拒绝连接的原因
+const (
+	RejectMaxConnections      = "max connections"
+	RejectMaxConnectionsPerIP = "max connections per ip"
+)
+
+// Limiter 连接数限制，为0时不限制
+type Limiter struct {
+	MaxConnections      int // 总连接数
+	MaxConnectionsPerIP int // 每个来源IP的连接数
+
+	mutex sync.Mutex
+	total int
+	perIP map[string]int
+}
+
+// NewLimiter 新建连接数限制
+func NewLimiter(maxConnections int, maxConnectionsPerIP int) *Limiter {
+	return &Limiter{
+		MaxConnections:      maxConnections,
+		MaxConnectionsPerIP: maxConnectionsPerIP,
+		perIP:               make(map[string]int),
+	}
+}
+
+// Acquire 占用一个连接名额，超出限制时返回拒绝原因
+func (limiter *Limiter) Acquire(ip string) (string, bool) {
+	defer limiter.mutex.Unlock()
+	limiter.mutex.Lock()
+
+	if limiter.MaxConnections > 0 && limiter.total >= limiter.MaxConnections {
+		return RejectMaxConnections, false
+	}
+	if limiter.MaxConnectionsPerIP > 0 && limiter.perIP[ip] >= limiter.MaxConnectionsPerIP {
+		return RejectMaxConnectionsPerIP, false
+	}
+	limiter.total++
+	limiter.perIP[ip]++
+	return "", true
+}
+
+// Release 释放Acquire占用的连接名额
+func (limiter *Limiter) Release(ip string) {
+	defer limiter.mutex.Unlock()
+	limiter.mutex.Lock()
+
+	limiter.total--
+	if limiter.perIP[ip] <= 1 {
+		delete(limiter.perIP, ip)
+	} else {
+		limiter.perIP[ip]--
+	}
+}
+
+// remoteIP 连接的来源IP
+func remoteIP(conn net.Conn) string {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return conn.RemoteAddr().String()
+	}
+	return host
+}
+
+// Listener 为监听对象增加连接数限制，超出限制的连接在Accept时直接关闭，用于不经过Server处理的服务
+func (limiter *Limiter) Listener(ln net.Listener, onReject func(reason string)) net.Listener {
+	return &limitListener{Listener: ln, limiter: limiter, onReject: onReject}
+}
+
+// limitListener 有连接数限制的监听对象
+type limitListener struct {
+	net.Listener
+	limiter  *Limiter
+	onReject func(reason string)
+}
+
+// Accept 接受一个未超出限制的连接
+func (ln *limitListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := ln.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		ip := remoteIP(conn)
+		reason, ok := ln.limiter.Acquire(ip)
+		if ok {
+			return &limitConn{Conn: conn, limiter: ln.limiter, ip: ip}, nil
+		}
+		conn.Close()
+		if ln.onReject != nil {
+			ln.onReject(reason)
+		}
+	}
+}
+
+// limitConn 关闭时释放连接名额的连接
+type limitConn struct {
+	net.Conn
+	limiter *Limiter
+	ip      string
+	once    sync.Once
+}
+
+// Close 关闭连接并释放连接名额
+func (conn *limitConn) Close() error {
+	err := conn.Conn.Close()
+	conn.once.Do(func() {
+		conn.limiter.Release(conn.ip)
+	})
+	return err
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// TestLimiter 测试总连接数与每个来源IP连接数的限制
+func TestLimiter(t *testing.T) {
+	var tests = []struct {
+		max      int    // input: 总连接数
+		perIP    int    // input: 每个来源IP的连接数
+		in       string // input: +a 来源a占用名额，-a 来源a释放名额
+		expected string // expected: 每次占用的结果，o成功，g超出总数，i超出来源IP限制
+	}{
+		{0, 0, "+a+a+a+b", "oooo"},
+		{2, 0, "+a+b+c", "oog"},
+		{2, 0, "+a+b-a+c+a", "ooog"},
+		{0, 2, "+a+a+a+b", "ooio"},
+		{0, 2, "+a+a-a+a+a", "oooi"},
+		{3, 2, "+a+a+a+b+b", "ooiog"},
+		{3, 1, "+a+b-a-b+a+b+c+d", "ooooog"},
+	}
+
+	for _, test := range tests {
+		limiter := NewLimiter(test.max, test.perIP)
+		actual := ""
+		for i := 0; i+1 < len(test.in); i += 2 {
+			ip := test.in[i+1 : i+2]
+			if test.in[i] == '-' {
+				limiter.Release(ip)
+				continue
+			}
+			reason, ok := limiter.Acquire(ip)
+			switch {
+			case ok:
+				actual += "o"
+			case reason == RejectMaxConnections:
+				actual += "g"
+			case reason == RejectMaxConnectionsPerIP:
+				actual += "i"
+			}
+		}
+		if actual != test.expected {
+			t.Errorf("[×] in: %d %d %s out: %s expected: %s\n", test.max, test.perIP, test.in, actual, test.expected)
+		} else {
+			t.Logf("[√] in: %d %d %s out: %s expected: %s\n", test.max, test.perIP, test.in, actual, test.expected)
+		}
+	}
+}
+
+// TestLimitListener 测试监听对象关闭超出限制的连接，并在连接关闭后释放名额
+func TestLimitListener(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	limiter := NewLimiter(0, 1)
+	rejected := make(chan string, 4)
+	ln = limiter.Listener(ln, func(reason string) {
+		rejected <- reason
+	})
+	defer ln.Close()
+
+	accepted := make(chan net.Conn, 4)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+
+	var tests = []struct {
+		in       string // input: dial 新建连接，close 关闭已接受的连接
+		expected string // expected: accept 接受，reject 拒绝
+	}{
+		{"dial", "accept"},
+		{"dial", "reject"},
+		{"close", ""},
+		{"dial", "accept"},
+	}
+
+	var conn net.Conn
+	for _, test := range tests {
+		if test.in == "close" {
+			conn.Close()
+			continue
+		}
+		client, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+
+		actual := ""
+		select {
+		case conn = <-accepted:
+			actual = "accept"
+		case reason := <-rejected:
+			if reason == RejectMaxConnectionsPerIP {
+				actual = "reject"
+			}
+		case <-time.After(5 * time.Second):
+		}
+		if actual != test.expected {
+			t.Errorf("[×] in: %s out: %s expected: %s\n", test.in, actual, test.expected)
+		} else {
+			t.Logf("[√] in: %s out: %s expected: %s\n", test.in, actual, test.expected)
+		}
+	}
+	conn.Close()
+}
@@ -0,0 +1,271 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+/*
+
+RTMPT，通过HTTP隧道传输RTMP
+
+每个会话对应一个虚拟连接，交给与TCP连接相同的处理函数，握手与分块不区分传输方式
+	POST /fcs/ident2               不支持，返回404
+	POST /open/1                   新建会话，返回会话id
+	POST /send/会话id/序号          请求体为客户端发送的数据，返回轮询间隔与服务端待发送的数据
+	POST /idle/会话id/序号          轮询，返回轮询间隔与服务端待发送的数据
+	POST /close/会话id/序号         关闭会话
+返回的第一个字节为客户端下次轮询前的等待间隔，有数据时为1，连续没有数据时逐渐增大
+
+*/
+
+// RTMPTContentType RTMPT请求与响应的Content-Type
+const RTMPTContentType = "application/x-fcs"
+
+// rtmptMaxInterval 轮询间隔的上限
+const rtmptMaxInterval = 0x21
+
+// RTMPTServer RTMPT服务，通过http监听端口提供，可与Server共用连接数限制
+type RTMPTServer struct {
+	Handle      func(*Connect, interface{}) error
+	Args        interface{}
+	IdleTimeout time.Duration       // 会话两次请求之间的最长间隔
+	MaxBuffer   int                 // 每个会话收发缓冲区与请求体的上限
+	Limiter     *Limiter            // 连接数限制，为空时不限制
+	OnReject    func(reason string) // 会话因超出限制被拒绝时调用
+
+	mutex    sync.Mutex
+	sessions map[string]*rtmptSession
+	wg       sync.WaitGroup
+	closed   bool
+}
+
+// rtmptSession 一个RTMPT会话
+type rtmptSession struct {
+	id       string
+	conn     *TunnelConn
+	ip       string
+	interval byte        // 下次返回的轮询间隔
+	timer    *time.Timer // 会话超时
+	mutex    sync.Mutex  // 同一会话的请求依次处理
+}
+
+// ServeHTTP 处理RTMPT请求
+func (s *RTMPTServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case parts[0] == "open" && len(parts) == 2:
+		s.open(w, r)
+	case (parts[0] == "send" || parts[0] == "idle" || parts[0] == "close") && len(parts) == 3:
+		if _, err := strconv.ParseUint(parts[2], 10, 64); err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		session := s.session(parts[1])
+		if session == nil {
+			http.NotFound(w, r)
+			return
+		}
+		s.exchange(w, r, session, parts[0])
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// Shutdown 停止接受新会话并等待已有会话处理完毕，ctx结束时强制关闭剩余会话
+func (s *RTMPTServer) Shutdown(ctx context.Context) error {
+	s.mutex.Lock()
+	s.closed = true
+	s.mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.mutex.Lock()
+		count := len(s.sessions)
+		for _, session := range s.sessions {
+			session.conn.Close()
+		}
+		s.mutex.Unlock()
+		log.Printf("Server force close %d RTMPT sessions.\n", count)
+		<-done
+		return errors.WithStack(ctx.Err())
+	}
+}
+
+// open 新建会话与对应的虚拟连接，并在新的协程中处理
+func (s *RTMPTServer) open(w http.ResponseWriter, r *http.Request) {
+	if _, err := s.readBody(w, r); err != nil {
+		http.Error(w, "request entity too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	ip := requestIP(r)
+	if s.Limiter != nil {
+		if reason, ok := s.Limiter.Acquire(ip); !ok {
+			log.Printf("Reject RTMPT session from %s: %s.\n", r.RemoteAddr, reason)
+			if s.OnReject != nil {
+				s.OnReject(reason)
+			}
+			http.Error(w, reason, http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	id := make([]byte, 8)
+	rand.Read(id)
+	session := &rtmptSession{
+		id:       hex.EncodeToString(id),
+		conn:     NewTunnelConn("rtmpt", r.Host, r.RemoteAddr, s.MaxBuffer),
+		ip:       ip,
+		interval: 1,
+	}
+
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		s.release(session)
+		http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	if s.sessions == nil {
+		s.sessions = make(map[string]*rtmptSession)
+	}
+	s.sessions[session.id] = session
+	session.timer = time.AfterFunc(s.IdleTimeout, func() {
+		log.Printf("RTMPT session %s timeout.\n", session.id)
+		session.conn.Close()
+		s.remove(session)
+	})
+	s.wg.Add(1)
+	s.mutex.Unlock()
+
+	go func() {
+		defer s.wg.Done()
+		s.Handle(NewConnect(session.conn, ReadBufferSize), s.Args)
+		session.conn.Close()
+	}()
+
+	w.Header().Set("Content-Type", RTMPTContentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write([]byte(session.id + "\n"))
+}
+
+// exchange 处理 send idle close 请求
+func (s *RTMPTServer) exchange(w http.ResponseWriter, r *http.Request, session *rtmptSession, command string) {
+	defer session.mutex.Unlock()
+	session.mutex.Lock()
+
+	session.timer.Reset(s.IdleTimeout)
+	body, err := s.readBody(w, r)
+	if err == nil && len(body) > 0 && command != "close" {
+		err = session.conn.Push(body)
+		if err == io.ErrClosedPipe {
+			// 连接已关闭，取走剩余的数据
+			err = nil
+		}
+	}
+	if err != nil {
+		// 丢弃数据后RTMP数据流不再完整，只能关闭会话
+		log.Printf("RTMPT session %s closed: %v.\n", session.id, err)
+		session.conn.Close()
+		s.remove(session)
+		if _, ok := err.(*http.MaxBytesError); ok || err == ErrTunnelBufferFull {
+			http.Error(w, "request entity too large", http.StatusRequestEntityTooLarge)
+		} else {
+			http.Error(w, "bad request", http.StatusBadRequest)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", RTMPTContentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	if command == "close" {
+		session.conn.Close()
+		s.remove(session)
+		w.Write([]byte{0})
+		return
+	}
+
+	data, err := session.conn.Pull()
+	if err != nil {
+		// 连接已关闭且数据已全部取走
+		s.remove(session)
+		http.NotFound(w, r)
+		return
+	}
+	if len(data) > 0 {
+		session.interval = 1
+	} else if session.interval < rtmptMaxInterval {
+		session.interval = session.interval*2 - 1
+		if session.interval < 3 {
+			session.interval = 3
+		}
+	}
+	w.Write(append([]byte{session.interval}, data...))
+}
+
+// readBody 读入请求体，超过MaxBuffer时返回错误
+func (s *RTMPTServer) readBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	return ioutil.ReadAll(http.MaxBytesReader(w, r.Body, int64(s.MaxBuffer)))
+}
+
+// session 按id查找会话
+func (s *RTMPTServer) session(id string) *rtmptSession {
+	defer s.mutex.Unlock()
+	s.mutex.Lock()
+
+	return s.sessions[id]
+}
+
+// remove 移除会话并释放连接名额
+func (s *RTMPTServer) remove(session *rtmptSession) {
+	s.mutex.Lock()
+	_, ok := s.sessions[session.id]
+	delete(s.sessions, session.id)
+	s.mutex.Unlock()
+
+	if ok {
+		session.timer.Stop()
+		s.release(session)
+	}
+}
+
+// release 释放open占用的连接名额
+func (s *RTMPTServer) release(session *rtmptSession) {
+	if s.Limiter != nil {
+		s.Limiter.Release(session.ip)
+	}
+}
+
+// requestIP 请求的来源IP
+func requestIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rtmptRequest 发送RTMPT请求，返回状态码与响应
+func rtmptRequest(s *RTMPTServer, method string, path string, body string) (int, []byte) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.RemoteAddr = "10.0.0.1:5000"
+	s.ServeHTTP(w, r)
+	return w.Code, w.Body.Bytes()
+}
+
+// TestRTMPTSession 测试RTMPT会话的数据收发、轮询间隔与关闭
+func TestRTMPTSession(t *testing.T) {
+	s := &RTMPTServer{
+		// 读入5字节后转为大写写回，随后结束连接
+		Handle: func(conn *Connect, args interface{}) error {
+			data, err := conn.ReadLength(5)
+			if err != nil {
+				return err
+			}
+			conn.Write(bytes.ToUpper(data))
+			return nil
+		},
+		IdleTimeout: time.Minute,
+		MaxBuffer:   1024,
+		Limiter:     NewLimiter(0, 1),
+	}
+
+	code, body := rtmptRequest(s, http.MethodPost, "/open/1", "")
+	id := strings.TrimSpace(string(body))
+	if code != http.StatusOK || len(id) != 16 {
+		t.Fatalf("[×] in: open out: %d %q expected: %d\n", code, body, http.StatusOK)
+	}
+
+	var tests = []struct {
+		path     string // input
+		body     string // input
+		poll     bool   // input: 重复请求直到响应符合预期
+		code     int    // expected status
+		expected string // expected: 轮询间隔与数据
+	}{
+		{"/open/1", "", false, http.StatusServiceUnavailable, "max connections per ip\n"},
+		{"/idle/" + id + "/0", "", false, http.StatusOK, "\x03"},
+		{"/idle/" + id + "/1", "", false, http.StatusOK, "\x05"},
+		{"/idle/" + id + "/2", "", false, http.StatusOK, "\x09"},
+		{"/send/" + id + "/3", "hel", false, http.StatusOK, "\x11"},
+		{"/send/" + id + "/4", "lo", true, http.StatusOK, "\x01HELLO"},
+		{"/idle/" + id + "/5", "", true, http.StatusNotFound, "404 page not found\n"},
+		{"/idle/" + id + "/6", "", false, http.StatusNotFound, "404 page not found\n"},
+		{"/idle/unknown/0", "", false, http.StatusNotFound, "404 page not found\n"},
+		{"/fcs/ident2", "", false, http.StatusNotFound, "404 page not found\n"},
+	}
+
+	for _, test := range tests {
+		code, body := rtmptRequest(s, http.MethodPost, test.path, test.body)
+		for deadline := time.Now().Add(2 * time.Second); test.poll && (code != test.code || string(body) != test.expected) && time.Now().Before(deadline); {
+			time.Sleep(5 * time.Millisecond)
+			code, body = rtmptRequest(s, http.MethodPost, test.path, "")
+		}
+		if code != test.code || string(body) != test.expected {
+			t.Errorf("[×] in: %s %q out: %d %q expected: %d %q\n", test.path, test.body, code, body, test.code, test.expected)
+		} else {
+			t.Logf("[√] in: %s %q out: %d %q expected: %d %q\n", test.path, test.body, code, body, test.code, test.expected)
+		}
+	}
+
+	// 会话结束后释放连接名额，新会话可以由客户端关闭
+	code, body = rtmptRequest(s, http.MethodPost, "/open/1", "")
+	id = strings.TrimSpace(string(body))
+	closeCode, _ := rtmptRequest(s, http.MethodPost, "/close/"+id+"/0", "")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := s.Shutdown(ctx)
+	if code != http.StatusOK || closeCode != http.StatusOK || err != nil || len(s.sessions) != 0 {
+		t.Errorf("[×] in: reopen out: %d %d %v %d expected: %d\n", code, closeCode, err, len(s.sessions), http.StatusOK)
+	} else {
+		t.Logf("[√] in: reopen out: %d %d %v expected: %d\n", code, closeCode, err, http.StatusOK)
+	}
+}
+
+// TestRTMPTBufferLimit 测试请求体或未读取的数据超过MaxBuffer时拒绝请求并关闭会话
+func TestRTMPTBufferLimit(t *testing.T) {
+	stop := make(chan struct{})
+	s := &RTMPTServer{
+		// 不读取数据，直到测试结束
+		Handle: func(conn *Connect, args interface{}) error {
+			<-stop
+			return nil
+		},
+		IdleTimeout: time.Minute,
+		MaxBuffer:   8,
+	}
+
+	var tests = []struct {
+		command  string // input: open 新建会话，其余为当前会话的请求
+		body     string // input
+		expected int    // expected status
+	}{
+		{"open", "123456789", http.StatusRequestEntityTooLarge},
+		{"open", "", http.StatusOK},
+		{"send", "123456789", http.StatusRequestEntityTooLarge},
+		{"idle", "", http.StatusNotFound},
+		{"open", "", http.StatusOK},
+		{"send", "12345", http.StatusOK},
+		{"send", "6789", http.StatusRequestEntityTooLarge},
+		{"idle", "", http.StatusNotFound},
+	}
+
+	var id string
+	for i, test := range tests {
+		path := "/open/1"
+		if test.command != "open" {
+			path = "/" + test.command + "/" + id + "/" + strconv.Itoa(i)
+		}
+		code, body := rtmptRequest(s, http.MethodPost, path, test.body)
+		if test.command == "open" && code == http.StatusOK {
+			id = strings.TrimSpace(string(body))
+		}
+		if code != test.expected {
+			t.Errorf("[×] in: %s %q out: %d %q expected: %d\n", test.command, test.body, code, body, test.expected)
+		} else {
+			t.Logf("[√] in: %s %q out: %d expected: %d\n", test.command, test.body, code, test.expected)
+		}
+	}
+
+	close(stop)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil || len(s.sessions) != 0 {
+		t.Errorf("[×] in: shutdown out: %v %d expected: nil 0\n", err, len(s.sessions))
+	}
+}
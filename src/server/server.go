@@ -1,13 +1,20 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
 	"log"
 	"net"
+	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 )
 
+// ReadBufferSize 连接读缓冲区大小
+const ReadBufferSize = 64 * 1024
+
 // Server ...
 type Server struct {
 	Protocol string
@@ -15,23 +22,167 @@ type Server struct {
 	Port     int
 	Handle   func(*Connect, interface{}) error
 	Args     interface{}
+	TLS      *tls.Config         // 为空时不使用TLS
+	Limiter  *Limiter            // 连接数限制，为空时不限制
+	OnReject func(reason string) // 连接因超出限制被拒绝时调用
+
+	listener net.Listener
+	conns    map[*Connect]struct{} // 正在处理的连接
+	mutex    sync.Mutex
+	wg       sync.WaitGroup
+	closed   bool // 是否已停止接受新连接
 }
 
-// Listen 监听某个端口
-func (s Server) Listen() error {
+// Listen 监听某个端口，ctx结束或调用Shutdown后停止接受新连接
+func (s *Server) Listen(ctx context.Context) error {
 	log.Printf("Server start at http://%s:%d (%s).\n", s.Address, s.Port, s.Protocol)
-	ln, err := net.Listen(s.Protocol, fmt.Sprintf(":%d", s.Port))
+	ln, err := net.Listen(s.Protocol, fmt.Sprintf("%s:%d", s.Address, s.Port))
 	if err != nil {
 		log.Println(err)
 		return errors.WithStack(err)
 	}
+	if s.TLS != nil {
+		// TLS握手在第一次读写时进行，上层协议不受影响
+		ln = tls.NewListener(ln, s.TLS)
+	}
+
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		ln.Close()
+		return nil
+	}
+	s.listener = ln
+	s.mutex.Unlock()
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.closeListener()
+		case <-stop:
+		}
+	}()
 
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
+			if s.isClosed() {
+				log.Printf("Server stop at http://%s:%d (%s).\n", s.Address, s.Port, s.Protocol)
+				return nil
+			}
 			log.Println(err)
+			time.Sleep(10 * time.Millisecond)
 			continue
 		}
-		go s.Handle(&Connect{conn}, s.Args)
+		if !s.admit(conn) {
+			continue
+		}
+		s.serve(NewConnect(conn, ReadBufferSize))
+	}
+}
+
+// Shutdown 停止接受新连接并等待已有连接处理完毕，ctx结束时强制关闭剩余连接
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.closeListener()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		count := s.closeConns()
+		log.Printf("Server force close %d connections at http://%s:%d (%s).\n", count, s.Address, s.Port, s.Protocol)
+		<-done
+		return errors.WithStack(ctx.Err())
+	}
+}
+
+// admit 按连接数限制决定是否接受连接，拒绝时直接关闭
+func (s *Server) admit(conn net.Conn) bool {
+	if s.Limiter == nil {
+		return true
+	}
+	ip := remoteIP(conn)
+	reason, ok := s.Limiter.Acquire(ip)
+	if ok {
+		return true
+	}
+	log.Printf("Reject connection from %s: %s.\n", conn.RemoteAddr(), reason)
+	conn.Close()
+	if s.OnReject != nil {
+		s.OnReject(reason)
 	}
+	return false
+}
+
+// release 释放admit占用的连接名额
+func (s *Server) release(conn *Connect) {
+	if s.Limiter != nil {
+		s.Limiter.Release(remoteIP(conn))
+	}
+}
+
+// serve 在新的协程中处理连接
+func (s *Server) serve(conn *Connect) {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		conn.Close()
+		s.release(conn)
+		return
+	}
+	if s.conns == nil {
+		s.conns = make(map[*Connect]struct{})
+	}
+	s.conns[conn] = struct{}{}
+	s.wg.Add(1)
+	s.mutex.Unlock()
+
+	go func() {
+		defer func() {
+			s.mutex.Lock()
+			delete(s.conns, conn)
+			s.mutex.Unlock()
+			s.release(conn)
+			s.wg.Done()
+		}()
+		s.Handle(conn, s.Args)
+	}()
+}
+
+// closeListener 关闭监听，不再接受新连接
+func (s *Server) closeListener() {
+	defer s.mutex.Unlock()
+	s.mutex.Lock()
+
+	s.closed = true
+	if s.listener != nil {
+		s.listener.Close()
+	}
+}
+
+// closeConns 强制关闭所有连接，返回关闭的连接数
+func (s *Server) closeConns() int {
+	defer s.mutex.Unlock()
+	s.mutex.Lock()
+
+	for conn := range s.conns {
+		conn.Close()
+	}
+	return len(s.conns)
+}
+
+// isClosed 是否已停止接受新连接
+func (s *Server) isClosed() bool {
+	defer s.mutex.Unlock()
+	s.mutex.Lock()
+
+	return s.closed
 }
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// TestShutdown 测试关闭服务时不再接受新连接并等待已有连接结束，ctx结束时强制关闭剩余连接
+func TestShutdown(t *testing.T) {
+	var tests = []struct {
+		in       string        // input: leave 排空期间客户端断开，stay 客户端一直不断开
+		timeout  time.Duration // input: 等待排空的时间
+		expected error         // expected error
+	}{
+		{"leave", 5 * time.Second, nil},
+		{"stay", 100 * time.Millisecond, context.DeadlineExceeded},
+	}
+
+	for _, test := range tests {
+		s := &Server{
+			Protocol: "tcp",
+			Address:  "127.0.0.1",
+			// 读入数据直到连接断开
+			Handle: func(conn *Connect, args interface{}) error {
+				_, err := io.Copy(ioutil.Discard, conn)
+				return err
+			},
+		}
+		go s.Listen(context.Background())
+
+		// 等待开始监听后建立一个连接
+		var address string
+		for deadline := time.Now().Add(2 * time.Second); address == "" && time.Now().Before(deadline); {
+			time.Sleep(5 * time.Millisecond)
+			s.mutex.Lock()
+			if s.listener != nil {
+				address = s.listener.Addr().String()
+			}
+			s.mutex.Unlock()
+		}
+		client, err := net.Dial("tcp", address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); {
+			s.mutex.Lock()
+			serving := len(s.conns)
+			s.mutex.Unlock()
+			if serving == 1 {
+				break
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), test.timeout)
+		defer cancel()
+		result := make(chan error, 1)
+		go func() {
+			result <- s.Shutdown(ctx)
+		}()
+
+		// 排空期间不再接受新连接
+		for deadline := time.Now().Add(time.Second); !s.isClosed() && time.Now().Before(deadline); {
+			time.Sleep(5 * time.Millisecond)
+		}
+		if other, err := net.DialTimeout("tcp", address, time.Second); err == nil {
+			other.Close()
+			t.Errorf("[×] in: %s dial out: accepted expected: refused\n", test.in)
+		}
+
+		if test.in == "leave" {
+			client.Close()
+		}
+		select {
+		case err = <-result:
+		case <-time.After(5 * time.Second):
+			err = errors.New("blocked")
+		}
+		// 强制关闭后客户端读到连接结束
+		client.SetReadDeadline(time.Now().Add(time.Second))
+		_, readErr := client.Read(make([]byte, 1))
+		if errors.Cause(err) != test.expected || readErr == nil {
+			t.Errorf("[×] in: %s out: %v %v expected: %v\n", test.in, err, readErr, test.expected)
+		} else {
+			t.Logf("[√] in: %s out: %v %v expected: %v\n", test.in, err, readErr, test.expected)
+		}
+	}
+}
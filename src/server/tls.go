@@ -0,0 +1,136 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+/*
+
+TLS证书，供RTMPS与HTTPS监听端口使用
+
+按客户端握手中的SNI选择证书，没有匹配时使用第一个证书
+证书文件变化后重新加载，加载失败时继续使用原有证书
+
+*/
+
+// CertFile 证书与私钥文件路径
+type CertFile struct {
+	Cert string
+	Key  string
+}
+
+// Certificates 可热加载的证书集合
+type Certificates struct {
+	files []CertFile
+
+	mutex   sync.RWMutex
+	certs   []*tls.Certificate
+	modTime time.Time // 已加载文件中最新的修改时间
+}
+
+// NewCertificates 加载证书，files不能为空
+func NewCertificates(files []CertFile) (*Certificates, error) {
+	if len(files) == 0 {
+		return nil, errors.New("no certificate")
+	}
+	certs := &Certificates{files: files}
+	if err := certs.Load(); err != nil {
+		return nil, err
+	}
+	return certs, nil
+}
+
+// Load 重新加载所有证书，任一证书加载失败时保留原有证书
+func (certs *Certificates) Load() error {
+	list := make([]*tls.Certificate, 0, len(certs.files))
+	for _, file := range certs.files {
+		cert, err := tls.LoadX509KeyPair(file.Cert, file.Key)
+		if err != nil {
+			return errors.Wrapf(err, "load certificate %s", file.Cert)
+		}
+		if cert.Leaf == nil {
+			cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+			if err != nil {
+				return errors.Wrapf(err, "parse certificate %s", file.Cert)
+			}
+		}
+		list = append(list, &cert)
+	}
+	modTime := certs.lastModified()
+
+	defer certs.mutex.Unlock()
+	certs.mutex.Lock()
+
+	certs.certs = list
+	certs.modTime = modTime
+	return nil
+}
+
+// GetCertificate 按SNI选择证书，用于tls.Config
+func (certs *Certificates) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	defer certs.mutex.RUnlock()
+	certs.mutex.RLock()
+
+	if hello.ServerName != "" {
+		for _, cert := range certs.certs {
+			if cert.Leaf.VerifyHostname(hello.ServerName) == nil {
+				return cert, nil
+			}
+		}
+	}
+	return certs.certs[0], nil
+}
+
+// Config 使用该证书集合的TLS服务端配置
+func (certs *Certificates) Config() *tls.Config {
+	return &tls.Config{
+		GetCertificate: certs.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+	}
+}
+
+// Watch 每隔interval检查证书文件的修改时间，有变化时重新加载，直到ctx结束
+func (certs *Certificates) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		certs.mutex.RLock()
+		modTime := certs.modTime
+		certs.mutex.RUnlock()
+		if !certs.lastModified().After(modTime) {
+			continue
+		}
+		if err := certs.Load(); err != nil {
+			log.Printf("Reload certificates failed: %v\n", err)
+			continue
+		}
+		log.Printf("Reload certificates %s.\n", certs.files[0].Cert)
+	}
+}
+
+// lastModified 证书与私钥文件中最新的修改时间
+func (certs *Certificates) lastModified() time.Time {
+	var modTime time.Time
+	for _, file := range certs.files {
+		for _, name := range []string{file.Cert, file.Key} {
+			if info, err := os.Stat(name); err == nil && info.ModTime().After(modTime) {
+				modTime = info.ModTime()
+			}
+		}
+	}
+	return modTime
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCert 生成自签名证书，写入dir目录下的name.crt与name.key
+func writeCert(dir string, name string, hosts ...string) (CertFile, *x509.Certificate, error) {
+	file := CertFile{Cert: filepath.Join(dir, name+".crt"), Key: filepath.Join(dir, name+".key")}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return file, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: hosts[0]},
+		DNSNames:              hosts,
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return file, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return file, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return file, nil, err
+	}
+	if err := ioutil.WriteFile(file.Cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		return file, nil, err
+	}
+	err = ioutil.WriteFile(file.Key, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	return file, cert, err
+}
+
+// handshake 使用SNI与服务端握手，返回服务端证书的第一个域名
+func handshake(certs *Certificates, roots *x509.CertPool, serverName string) (string, error) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+
+	go tls.Server(remote, certs.Config()).Handshake()
+	client := tls.Client(local, &tls.Config{ServerName: serverName, RootCAs: roots, InsecureSkipVerify: serverName == ""})
+	if err := client.Handshake(); err != nil {
+		return "", err
+	}
+	return client.ConnectionState().PeerCertificates[0].DNSNames[0], nil
+}
+
+// TestCertificates 测试按SNI选择证书，没有匹配时使用第一个证书
+func TestCertificates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	roots := x509.NewCertPool()
+	files := make([]CertFile, 0, 2)
+	for _, hosts := range [][]string{{"a.example.com"}, {"b.example.com", "*.b.example.com"}} {
+		file, cert, err := writeCert(dir, hosts[0], hosts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		roots.AddCert(cert)
+		files = append(files, file)
+	}
+	certs, err := NewCertificates(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		in       string // input: SNI
+		expected string // expected: 服务端证书的第一个域名
+	}{
+		{"a.example.com", "a.example.com"},
+		{"b.example.com", "b.example.com"},
+		{"live.b.example.com", "b.example.com"},
+		{"", "a.example.com"},
+	}
+
+	for _, test := range tests {
+		actual, err := handshake(certs, roots, test.in)
+		if err != nil || actual != test.expected {
+			t.Errorf("[×] in: %q out: %q %v expected: %q\n", test.in, actual, err, test.expected)
+		} else {
+			t.Logf("[√] in: %q out: %q expected: %q\n", test.in, actual, test.expected)
+		}
+	}
+
+	// 未知的域名使用第一个证书，客户端校验失败
+	if _, err := handshake(certs, roots, "c.example.com"); err == nil {
+		t.Errorf("[×] in: %q out: %v expected: error\n", "c.example.com", err)
+	} else {
+		t.Logf("[√] in: %q out: %v expected: error\n", "c.example.com", err)
+	}
+}
+
+// TestCertificatesReload 测试证书文件变化后重新加载，加载失败时继续使用原有证书
+func TestCertificatesReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file, _, err := writeCert(dir, "a", "old.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs, err := NewCertificates([]CertFile{file})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		in       string // input: 写入的证书域名，为空时写入无效的证书
+		expected string // expected: 重新加载后使用的证书域名
+	}{
+		{"new.example.com", "new.example.com"},
+		{"", "new.example.com"},
+	}
+
+	for _, test := range tests {
+		if test.in == "" {
+			err = ioutil.WriteFile(file.Cert, []byte("invalid"), 0600)
+		} else {
+			_, _, err = writeCert(dir, "a", test.in)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		loadErr := certs.Load()
+		cert, _ := certs.GetCertificate(&tls.ClientHelloInfo{})
+		actual := cert.Leaf.DNSNames[0]
+		if actual != test.expected || (loadErr != nil) != (test.in == "") {
+			t.Errorf("[×] in: %q out: %q %v expected: %q\n", test.in, actual, loadErr, test.expected)
+		} else {
+			t.Logf("[√] in: %q out: %q %v expected: %q\n", test.in, actual, loadErr, test.expected)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+/*
+
+虚拟连接，用于HTTP隧道等不直接对应套接字的传输
+
+请求体写入接收缓冲区供Read读取，Write写出的数据暂存在发送缓冲区，由轮询请求取走
+支持读写超时，发送缓冲区超过上限时Write阻塞直到数据被取走，接收缓冲区超过上限时Push返回错误
+
+*/
+
+// ErrTunnelBufferFull 接收缓冲区已满，对端发送数据的速度超过了读取的速度
+var ErrTunnelBufferFull = errors.New("tunnel receive buffer full")
+
+// tunnelAddr 虚拟连接的地址
+type tunnelAddr struct {
+	network string
+	address string
+}
+
+// Network 网络类型
+func (addr tunnelAddr) Network() string {
+	return addr.network
+}
+
+// String 地址
+func (addr tunnelAddr) String() string {
+	return addr.address
+}
+
+// TunnelConn 虚拟连接
+type TunnelConn struct {
+	local     net.Addr
+	remote    net.Addr
+	maxBuffer int // 收发缓冲区各自的上限
+
+	mutex         sync.Mutex
+	in            bytes.Buffer // 接收缓冲区
+	out           bytes.Buffer // 发送缓冲区
+	readDeadline  time.Time
+	writeDeadline time.Time
+	readable      chan struct{} // 接收缓冲区有数据或读超时改变时通知
+	writable      chan struct{} // 发送缓冲区被取走或写超时改变时通知
+	done          chan struct{} // 连接关闭时关闭
+	closed        bool
+}
+
+// NewTunnelConn 新建虚拟连接，network与remote为对端的网络类型与地址，maxBuffer为收发缓冲区各自的上限
+func NewTunnelConn(network string, local string, remote string, maxBuffer int) *TunnelConn {
+	return &TunnelConn{
+		local:     tunnelAddr{network, local},
+		remote:    tunnelAddr{network, remote},
+		maxBuffer: maxBuffer,
+		readable:  make(chan struct{}, 1),
+		writable:  make(chan struct{}, 1),
+		done:      make(chan struct{}),
+	}
+}
+
+// Push 将对端发送的数据加入接收缓冲区，超过上限时不加入并返回ErrTunnelBufferFull
+func (conn *TunnelConn) Push(data []byte) error {
+	conn.mutex.Lock()
+	if conn.closed {
+		conn.mutex.Unlock()
+		return io.ErrClosedPipe
+	}
+	if conn.in.Len()+len(data) > conn.maxBuffer {
+		conn.mutex.Unlock()
+		return ErrTunnelBufferFull
+	}
+	conn.in.Write(data)
+	conn.mutex.Unlock()
+
+	notify(conn.readable)
+	return nil
+}
+
+// Pull 取走发送缓冲区中的所有数据，连接已关闭且没有剩余数据时返回io.EOF
+func (conn *TunnelConn) Pull() ([]byte, error) {
+	conn.mutex.Lock()
+	if conn.out.Len() == 0 && conn.closed {
+		conn.mutex.Unlock()
+		return nil, io.EOF
+	}
+	data := make([]byte, conn.out.Len())
+	copy(data, conn.out.Bytes())
+	conn.out.Reset()
+	conn.mutex.Unlock()
+
+	notify(conn.writable)
+	return data, nil
+}
+
+// Read 读取接收缓冲区，没有数据时阻塞直到有数据、超时或连接关闭
+func (conn *TunnelConn) Read(b []byte) (int, error) {
+	for {
+		conn.mutex.Lock()
+		if conn.in.Len() > 0 {
+			n, _ := conn.in.Read(b)
+			conn.mutex.Unlock()
+			return n, nil
+		}
+		if conn.closed {
+			conn.mutex.Unlock()
+			return 0, io.EOF
+		}
+		deadline := conn.readDeadline
+		conn.mutex.Unlock()
+
+		if err := wait(conn.readable, conn.done, deadline); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Write 写入发送缓冲区，缓冲区已满时阻塞直到数据被取走、超时或连接关闭
+func (conn *TunnelConn) Write(b []byte) (int, error) {
+	for {
+		conn.mutex.Lock()
+		if conn.closed {
+			conn.mutex.Unlock()
+			return 0, io.ErrClosedPipe
+		}
+		if conn.out.Len() < conn.maxBuffer {
+			conn.out.Write(b)
+			conn.mutex.Unlock()
+			return len(b), nil
+		}
+		deadline := conn.writeDeadline
+		conn.mutex.Unlock()
+
+		if err := wait(conn.writable, conn.done, deadline); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Close 关闭连接，发送缓冲区中的数据仍可通过Pull取走
+func (conn *TunnelConn) Close() error {
+	defer conn.mutex.Unlock()
+	conn.mutex.Lock()
+
+	if !conn.closed {
+		conn.closed = true
+		close(conn.done)
+	}
+	return nil
+}
+
+// Done 连接关闭时关闭的channel
+func (conn *TunnelConn) Done() <-chan struct{} {
+	return conn.done
+}
+
+// LocalAddr 本地地址
+func (conn *TunnelConn) LocalAddr() net.Addr {
+	return conn.local
+}
+
+// RemoteAddr 对端地址
+func (conn *TunnelConn) RemoteAddr() net.Addr {
+	return conn.remote
+}
+
+// SetDeadline 设置读写超时
+func (conn *TunnelConn) SetDeadline(t time.Time) error {
+	conn.SetReadDeadline(t)
+	return conn.SetWriteDeadline(t)
+}
+
+// SetReadDeadline 设置读超时，唤醒阻塞的Read重新计算
+func (conn *TunnelConn) SetReadDeadline(t time.Time) error {
+	conn.mutex.Lock()
+	conn.readDeadline = t
+	conn.mutex.Unlock()
+
+	notify(conn.readable)
+	return nil
+}
+
+// SetWriteDeadline 设置写超时，唤醒阻塞的Write重新计算
+func (conn *TunnelConn) SetWriteDeadline(t time.Time) error {
+	conn.mutex.Lock()
+	conn.writeDeadline = t
+	conn.mutex.Unlock()
+
+	notify(conn.writable)
+	return nil
+}
+
+// notify 非阻塞地发送通知
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// wait 等待通知，超时返回os.ErrDeadlineExceeded，连接关闭时返回nil由调用者重新检查
+func wait(ch chan struct{}, done chan struct{}, deadline time.Time) error {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return os.ErrDeadlineExceeded
+		}
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-ch:
+	case <-done:
+	case <-timeout:
+		return os.ErrDeadlineExceeded
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestTunnelConn 测试虚拟连接的收发缓冲与上限、读写超时与关闭
+func TestTunnelConn(t *testing.T) {
+	conn := NewTunnelConn("rtmpt", "local", "remote", 4)
+
+	var tests = []struct {
+		op       string // input: push 对端发送，read 读取，write 写出，pull 对端取走，close 关闭
+		data     string // input
+		expected string // expected: 读取或取走的数据
+		err      error  // expected error
+	}{
+		{"read", "", "", os.ErrDeadlineExceeded},
+		{"push", "ab", "", nil},
+		{"push", "cd", "", nil},
+		{"push", "e", "", ErrTunnelBufferFull},
+		{"read", "", "abcd", nil},
+		{"pull", "", "", nil},
+		{"write", "12", "", nil},
+		{"write", "345", "", nil},
+		{"write", "6", "", os.ErrDeadlineExceeded},
+		{"pull", "", "12345", nil},
+		{"write", "6", "", nil},
+		{"push", "x", "", nil},
+		{"close", "", "", nil},
+		{"read", "", "x", nil},
+		{"read", "", "", io.EOF},
+		{"push", "y", "", io.ErrClosedPipe},
+		{"write", "7", "", io.ErrClosedPipe},
+		{"pull", "", "6", nil},
+		{"pull", "", "", io.EOF},
+	}
+
+	for _, test := range tests {
+		// 阻塞的读写在超时后返回
+		conn.SetDeadline(time.Now().Add(20 * time.Millisecond))
+		var actual string
+		var err error
+		switch test.op {
+		case "push":
+			err = conn.Push([]byte(test.data))
+		case "read":
+			buf := make([]byte, 16)
+			var n int
+			n, err = conn.Read(buf)
+			actual = string(buf[:n])
+		case "write":
+			_, err = conn.Write([]byte(test.data))
+		case "pull":
+			var data []byte
+			data, err = conn.Pull()
+			actual = string(data)
+		case "close":
+			err = conn.Close()
+		}
+		if actual != test.expected || err != test.err {
+			t.Errorf("[×] in: %s %q out: %q %v expected: %q %v\n", test.op, test.data, actual, err, test.expected, test.err)
+		} else {
+			t.Logf("[√] in: %s %q out: %q %v expected: %q %v\n", test.op, test.data, actual, err, test.expected, test.err)
+		}
+	}
+}
+
+// TestTunnelConnWakeup 测试阻塞的读写在有数据、缓冲区被取走或连接关闭时返回
+func TestTunnelConnWakeup(t *testing.T) {
+	var tests = []struct {
+		op       string // input: 阻塞的操作
+		wake     string // input: 唤醒的操作
+		expected error  // expected error
+	}{
+		{"read", "push", nil},
+		{"read", "close", io.EOF},
+		{"write", "pull", nil},
+		{"write", "close", io.ErrClosedPipe},
+	}
+
+	for _, test := range tests {
+		conn := NewTunnelConn("rtmpt", "local", "remote", 1)
+		conn.Write([]byte("a"))
+		result := make(chan error, 1)
+		go func() {
+			var err error
+			if test.op == "read" {
+				_, err = conn.Read(make([]byte, 1))
+			} else {
+				_, err = conn.Write([]byte("b"))
+			}
+			result <- err
+		}()
+
+		time.Sleep(20 * time.Millisecond)
+		switch test.wake {
+		case "push":
+			conn.Push([]byte("x"))
+		case "pull":
+			conn.Pull()
+		case "close":
+			conn.Close()
+		}
+
+		select {
+		case err := <-result:
+			if err != test.expected {
+				t.Errorf("[×] in: %s %s out: %v expected: %v\n", test.op, test.wake, err, test.expected)
+			} else {
+				t.Logf("[√] in: %s %s out: %v expected: %v\n", test.op, test.wake, err, test.expected)
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("[×] in: %s %s out: blocked expected: %v\n", test.op, test.wake, test.expected)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+
+	"../websocket"
+	"github.com/pkg/errors"
+)
+
+/*
+
+通过WebSocket传输RTMP，握手与分块原样承载在二进制帧中
+
+*/
+
+// WebSocketServer RTMP over WebSocket服务，通过http监听端口提供，连接数由http监听端口限制
+type WebSocketServer struct {
+	Handle func(*Connect, interface{}) error
+	Args   interface{}
+
+	mutex  sync.Mutex
+	conns  map[*websocket.Conn]struct{} // 正在处理的连接
+	wg     sync.WaitGroup
+	closed bool
+}
+
+// ServeHTTP 升级为WebSocket连接后交给RTMP处理函数，直到连接结束
+func (s *WebSocketServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	s.wg.Add(1)
+	s.mutex.Unlock()
+	defer s.wg.Done()
+
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		log.Printf("WebSocket upgrade from %s failed: %v.\n", r.RemoteAddr, err)
+		return
+	}
+	s.mutex.Lock()
+	if s.conns == nil {
+		s.conns = make(map[*websocket.Conn]struct{})
+	}
+	s.conns[conn] = struct{}{}
+	s.mutex.Unlock()
+
+	s.Handle(NewConnect(conn, ReadBufferSize), s.Args)
+
+	s.mutex.Lock()
+	delete(s.conns, conn)
+	s.mutex.Unlock()
+}
+
+// Shutdown 停止接受新连接并等待已有连接处理完毕，ctx结束时强制关闭剩余连接
+func (s *WebSocketServer) Shutdown(ctx context.Context) error {
+	s.mutex.Lock()
+	s.closed = true
+	s.mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.mutex.Lock()
+		count := len(s.conns)
+		for conn := range s.conns {
+			conn.Close()
+		}
+		s.mutex.Unlock()
+		log.Printf("Server force close %d WebSocket connections.\n", count)
+		<-done
+		return errors.WithStack(ctx.Err())
+	}
+}
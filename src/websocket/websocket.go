@@ -0,0 +1,305 @@
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+/*
+
+WebSocket (RFC 6455)
+
+仅实现传输二进制数据需要的部分，连接作为字节流使用
+	读取时拼接数据帧的负载，忽略消息边界，自动回复ping，收到close时返回io.EOF
+	每次写入发送一个完整的二进制帧
+客户端发送的帧必须带掩码，服务端发送的帧不带掩码
+
+*/
+
+// 帧类型
+const (
+	OpContinuation = 0x0
+	OpText         = 0x1
+	OpBinary       = 0x2
+	OpClose        = 0x8
+	OpPing         = 0x9
+	OpPong         = 0xA
+)
+
+// CloseNormal 正常关闭的状态码
+const CloseNormal = 1000
+
+// maxControlPayload 控制帧负载的最大长度
+const maxControlPayload = 125
+
+// closeTimeout 关闭时发送close帧的写超时
+const closeTimeout = time.Second
+
+// acceptGUID 计算Sec-WebSocket-Accept使用的固定字符串
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// IsUpgrade 请求是否为WebSocket升级请求
+func IsUpgrade(r *http.Request) bool {
+	return headerContains(r.Header, "Connection", "upgrade") && headerContains(r.Header, "Upgrade", "websocket")
+}
+
+// AcceptKey 由客户端的Sec-WebSocket-Key计算Sec-WebSocket-Accept
+func AcceptKey(key string) string {
+	hash := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// Upgrade 将HTTP请求升级为WebSocket连接，失败时已向客户端返回错误
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	if r.Method != http.MethodGet || !IsUpgrade(r) {
+		http.Error(w, "websocket upgrade required", http.StatusBadRequest)
+		return nil, errors.New("not a websocket upgrade request")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusUpgradeRequired)
+		return nil, errors.New("unsupported websocket version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "missing Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, errors.New("missing Sec-WebSocket-Key")
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket not supported", http.StatusInternalServerError)
+		return nil, errors.New("response does not support hijacking")
+	}
+	nc, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	// 取消HTTP服务设置的读写超时
+	nc.SetDeadline(time.Time{})
+
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + AcceptKey(key) + "\r\n\r\n"
+	if _, err := nc.Write([]byte(response)); err != nil {
+		nc.Close()
+		return nil, errors.WithStack(err)
+	}
+	return NewConn(nc, rw.Reader, false), nil
+}
+
+// headerContains 逗号分隔的请求头中是否包含token，不区分大小写
+func headerContains(header http.Header, name string, token string) bool {
+	for _, value := range header[http.CanonicalHeaderKey(name)] {
+		for _, item := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(item), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Conn WebSocket连接，实现net.Conn
+type Conn struct {
+	net.Conn
+	reader *bufio.Reader
+	client bool // 客户端发送的帧需要掩码
+
+	readMutex sync.Mutex
+	remaining uint64   // 当前数据帧未读取的负载长度
+	mask      []byte   // 当前数据帧的掩码，为空时没有掩码
+	maskPos   int      // 当前负载在掩码中的位置
+	eof       bool     // 已收到close帧
+	header    [14]byte // 读入帧头的缓冲区
+
+	writeMutex sync.Mutex
+	closed     bool // 已发送close帧
+}
+
+// NewConn 包装已完成握手的连接，reader为空时直接从conn读取，client为true时发送的帧带掩码
+func NewConn(conn net.Conn, reader *bufio.Reader, client bool) *Conn {
+	if reader == nil {
+		reader = bufio.NewReader(conn)
+	}
+	return &Conn{Conn: conn, reader: reader, client: client}
+}
+
+// Read 读取数据帧的负载
+func (conn *Conn) Read(b []byte) (int, error) {
+	defer conn.readMutex.Unlock()
+	conn.readMutex.Lock()
+
+	for conn.remaining == 0 {
+		if conn.eof {
+			return 0, io.EOF
+		}
+		if err := conn.nextFrame(); err != nil {
+			return 0, err
+		}
+	}
+	if uint64(len(b)) > conn.remaining {
+		b = b[:conn.remaining]
+	}
+	n, err := conn.reader.Read(b)
+	conn.unmask(b[:n])
+	conn.remaining -= uint64(n)
+	if err != nil {
+		return n, errors.WithStack(err)
+	}
+	return n, nil
+}
+
+// nextFrame 读入下一个帧头，处理控制帧，数据帧的负载留给Read读取
+func (conn *Conn) nextFrame() error {
+	if _, err := io.ReadFull(conn.reader, conn.header[:2]); err != nil {
+		return errors.WithStack(err)
+	}
+	opcode := conn.header[0] & 0x0f
+	masked := conn.header[1]&0x80 != 0
+	length := uint64(conn.header[1] & 0x7f)
+	switch length {
+	case 126:
+		if _, err := io.ReadFull(conn.reader, conn.header[2:4]); err != nil {
+			return errors.WithStack(err)
+		}
+		length = uint64(binary.BigEndian.Uint16(conn.header[2:4]))
+	case 127:
+		if _, err := io.ReadFull(conn.reader, conn.header[2:10]); err != nil {
+			return errors.WithStack(err)
+		}
+		length = binary.BigEndian.Uint64(conn.header[2:10])
+	}
+	if masked == conn.client {
+		return errors.New("websocket: unexpected frame masking")
+	}
+	conn.mask = nil
+	conn.maskPos = 0
+	if masked {
+		conn.mask = conn.header[10:14]
+		if _, err := io.ReadFull(conn.reader, conn.mask); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	switch opcode {
+	case OpContinuation, OpText, OpBinary:
+		conn.remaining = length
+		return nil
+	case OpClose, OpPing, OpPong:
+		if length > maxControlPayload || conn.header[0]&0x80 == 0 {
+			return errors.New("websocket: invalid control frame")
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(conn.reader, payload); err != nil {
+			return errors.WithStack(err)
+		}
+		conn.unmask(payload)
+		return conn.control(opcode, payload)
+	default:
+		return errors.Errorf("websocket: unknown opcode %d", opcode)
+	}
+}
+
+// control 处理控制帧，ping回复pong，close回复close后结束读取
+func (conn *Conn) control(opcode byte, payload []byte) error {
+	switch opcode {
+	case OpPing:
+		return conn.writeFrame(OpPong, payload)
+	case OpClose:
+		conn.eof = true
+		conn.writeClose()
+	}
+	return nil
+}
+
+// unmask 按当前帧的掩码还原负载
+func (conn *Conn) unmask(b []byte) {
+	if conn.mask == nil {
+		return
+	}
+	for i := range b {
+		b[i] ^= conn.mask[conn.maskPos&3]
+		conn.maskPos++
+	}
+}
+
+// Write 以一个二进制帧发送数据
+func (conn *Conn) Write(b []byte) (int, error) {
+	if err := conn.writeFrame(OpBinary, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Close 发送close帧后关闭连接
+func (conn *Conn) Close() error {
+	conn.Conn.SetWriteDeadline(time.Now().Add(closeTimeout))
+	conn.writeClose()
+	return conn.Conn.Close()
+}
+
+// writeClose 发送状态码为正常关闭的close帧
+func (conn *Conn) writeClose() {
+	code := make([]byte, 2)
+	binary.BigEndian.PutUint16(code, CloseNormal)
+	conn.writeFrame(OpClose, code)
+}
+
+// writeFrame 发送一个完整的帧，已发送close帧后不再发送
+func (conn *Conn) writeFrame(opcode byte, payload []byte) error {
+	defer conn.writeMutex.Unlock()
+	conn.writeMutex.Lock()
+
+	if conn.closed {
+		return errors.New("websocket: connection closed")
+	}
+	if opcode == OpClose {
+		conn.closed = true
+	}
+	frame := AppendFrame(make([]byte, 0, len(payload)+14), opcode, payload, conn.client)
+	_, err := conn.Conn.Write(frame)
+	return errors.WithStack(err)
+}
+
+// AppendFrame 将一个完整的帧追加到buf，masked为true时使用随机掩码
+func AppendFrame(buf []byte, opcode byte, payload []byte, masked bool) []byte {
+	buf = append(buf, 0x80|opcode)
+	var maskBit byte
+	if masked {
+		maskBit = 0x80
+	}
+	length := len(payload)
+	switch {
+	case length < 126:
+		buf = append(buf, maskBit|byte(length))
+	case length <= 0xffff:
+		buf = append(buf, maskBit|126, byte(length>>8), byte(length))
+	default:
+		buf = append(buf, maskBit|127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(buf[len(buf)-8:], uint64(length))
+	}
+	if !masked {
+		return append(buf, payload...)
+	}
+	var mask [4]byte
+	rand.Read(mask[:])
+	buf = append(buf, mask[:]...)
+	start := len(buf)
+	buf = append(buf, payload...)
+	for i := range buf[start:] {
+		buf[start+i] ^= mask[i&3]
+	}
+	return buf
+}
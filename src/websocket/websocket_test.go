@@ -0,0 +1,106 @@
+package websocket
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"testing"
+)
+
+// TestAcceptKey 测试Sec-WebSocket-Accept计算，使用RFC 6455中的示例
+func TestAcceptKey(t *testing.T) {
+	var tests = []struct {
+		in       string // input
+		expected string // expected result
+	}{
+		{"dGhlIHNhbXBsZSBub25jZQ==", "s3pPLMBiTxaQ9kYGzzhZRbK+xOo="},
+	}
+
+	for _, test := range tests {
+		actual := AcceptKey(test.in)
+		if actual != test.expected {
+			t.Errorf("[×] in: %v out: %v expected: %v\n", test.in, actual, test.expected)
+		} else {
+			t.Logf("[√] in: %v out: %v expected: %v\n", test.in, actual, test.expected)
+		}
+	}
+}
+
+// TestAppendFrame 测试不带掩码的帧封装与长度编码
+func TestAppendFrame(t *testing.T) {
+	var tests = []struct {
+		in       int    // payload length
+		expected []byte // expected header
+	}{
+		{0, []byte{0x82, 0}},
+		{125, []byte{0x82, 125}},
+		{126, []byte{0x82, 126, 0, 126}},
+		{0x10000, []byte{0x82, 127, 0, 0, 0, 0, 0, 1, 0, 0}},
+	}
+
+	for _, test := range tests {
+		actual := AppendFrame(nil, OpBinary, make([]byte, test.in), false)
+		header := actual[:len(actual)-test.in]
+		if !bytes.Equal(header, test.expected) {
+			t.Errorf("[×] in: %v out: %v expected: %v\n", test.in, header, test.expected)
+		} else {
+			t.Logf("[√] in: %v out: %v expected: %v\n", test.in, header, test.expected)
+		}
+	}
+}
+
+// TestConn 测试客户端发送分片帧与ping，服务端拼接负载、回复pong，收到close后结束读取
+func TestConn(t *testing.T) {
+	var tests = []struct {
+		in       [][]byte // client frames
+		expected []byte   // payload read by server
+	}{
+		{
+			[][]byte{
+				AppendFrame(nil, OpBinary, []byte{1, 2, 3}, true),
+				AppendFrame(nil, OpPing, []byte("ping"), true),
+				AppendFrame(nil, OpContinuation, bytes.Repeat([]byte{4}, 300), true),
+				AppendFrame(nil, OpClose, []byte{0x03, 0xe8}, true),
+			},
+			append([]byte{1, 2, 3}, bytes.Repeat([]byte{4}, 300)...),
+		},
+	}
+
+	for _, test := range tests {
+		clientSide, serverSide := net.Pipe()
+		server := NewConn(serverSide, nil, false)
+		// 第一帧不是结束帧
+		test.in[0][0] &^= 0x80
+
+		go func() {
+			for _, frame := range test.in {
+				clientSide.Write(frame)
+			}
+		}()
+		replies := make(chan []byte, 1)
+		go func() {
+			// 服务端回复的pong与close不带掩码，按原始字节读取
+			buf := make([]byte, 64)
+			data := []byte{}
+			for len(data) < 10 {
+				n, err := clientSide.Read(buf)
+				data = append(data, buf[:n]...)
+				if err != nil {
+					break
+				}
+			}
+			replies <- data
+		}()
+
+		actual, err := ioutil.ReadAll(server)
+		reply := <-replies
+		expectedReply := append(AppendFrame(nil, OpPong, []byte("ping"), false), AppendFrame(nil, OpClose, []byte{0x03, 0xe8}, false)...)
+		if err != nil || !bytes.Equal(actual, test.expected) || !bytes.Equal(reply, expectedReply) {
+			t.Errorf("[×] out: %v %v %v expected: %v %v\n", len(actual), err, reply, len(test.expected), expectedReply)
+		} else {
+			t.Logf("[√] out: %v bytes, reply %v\n", len(actual), reply)
+		}
+		clientSide.Close()
+		serverSide.Close()
+	}
+}